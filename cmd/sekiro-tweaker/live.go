@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// liveApplyDelay is how long live mode waits for a control to settle, so a
+// spin button that is held down is applied once it is released.
+const liveApplyDelay = 150
+
+// liveChange is a feature to apply or revert in live mode.
+type liveChange struct {
+	control *featureControl
+	setting game.FeatureSetting
+}
+
+// scheduleLive applies a feature shortly after its controls change, if live
+// mode is on.
+func (a *Application) scheduleLive(control *featureControl) {
+	if a.updatingControls || a.liveCheck == nil || !a.liveCheck.Active() {
+		return
+	}
+
+	if control.liveTimer != 0 {
+		glib.SourceRemove(control.liveTimer)
+	}
+	control.liveTimer = glib.TimeoutAdd(liveApplyDelay, func() bool {
+		control.liveTimer = 0
+
+		if err := a.currentConfig().Save(); err != nil {
+			a.showError(fmt.Sprintf("Failed to save config: %v", err))
+		}
+
+		select {
+		case a.liveChanges <- liveChange{control: control, setting: control.setting()}:
+		default:
+			logger.Log.Warn("Dropped live change", zap.String("feature", control.feature.ID()))
+		}
+		return false
+	})
+}
+
+// runLiveChanges applies live changes one at a time, in the order they were
+// made, and shows errors on the feature's row.
+func (a *Application) runLiveChanges() {
+	for change := range a.liveChanges {
+		setting := change.setting
+		err := a.server.ChangeFeature(setting.Feature, setting.Enabled, setting.Params)
+		if err != nil {
+			logger.Log.Warn("Failed to apply feature",
+				zap.String("feature", setting.Feature.ID()),
+				zap.Error(err))
+		}
+
+		glib.IdleAdd(func() { showFeatureError(change.control, err) })
+	}
+}
+
+// showFeatureError shows or clears the error on a feature's row.
+func showFeatureError(control *featureControl, err error) {
+	if err == nil {
+		control.errorLabel.SetVisible(false)
+		return
+	}
+	control.errorLabel.SetText(err.Error())
+	control.errorLabel.SetVisible(true)
+}
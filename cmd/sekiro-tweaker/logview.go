@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// logRefreshInterval is how often the log viewer picks up new lines.
+const logRefreshInterval = 500
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// configureLogging applies a profile's log settings. The environment
+// overrides them, as it does for the CLI.
+func (a *Application) configureLogging(cfg *config.Config) {
+	a.logConfig = cfg.Log
+	if err := logger.Configure(cfg.LogOptions().Merge(logger.EnvOptions())); err != nil {
+		a.showError(fmt.Sprintf("Logging: %v", err))
+	}
+}
+
+// showLog opens a window that follows the recent log lines, so bug reports do
+// not need the app to be started from a terminal.
+func (a *Application) showLog() {
+	if a.logWindow != nil {
+		a.logWindow.Present()
+		return
+	}
+
+	window := gtk.NewWindow()
+	window.SetTitle("Sekiro Tweaker Log")
+	window.SetTransientFor(&a.window.Window)
+	window.SetDefaultSize(800, 500)
+
+	box := gtk.NewBox(gtk.OrientationVertical, 10)
+	box.SetMarginTop(10)
+	box.SetMarginBottom(10)
+	box.SetMarginStart(10)
+	box.SetMarginEnd(10)
+
+	buffer := gtk.NewTextBuffer(nil)
+	view := gtk.NewTextViewWithBuffer(buffer)
+	view.SetEditable(false)
+	view.SetMonospace(true)
+	view.SetWrapMode(gtk.WrapWordChar)
+
+	row := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	row.Append(gtk.NewLabel("Level:"))
+	levels := gtk.NewDropDownFromStrings(logLevels)
+	for i, level := range logLevels {
+		if level == logger.Level() {
+			levels.SetSelected(uint(i))
+		}
+	}
+	levels.SetTooltipText("Kept in the profile the next time it is saved")
+	levels.NotifyProperty("selected", func() {
+		level := logLevels[levels.Selected()]
+		if err := logger.SetLevel(level); err != nil {
+			a.showError(fmt.Sprintf("Logging: %v", err))
+			return
+		}
+		a.logConfig.Level = level
+	})
+	row.Append(levels)
+
+	pathLabel := gtk.NewLabel("")
+	pathLabel.AddCSSClass("dim-label")
+	pathLabel.SetHExpand(true)
+	pathLabel.SetSelectable(true)
+	pathLabel.SetXAlign(0)
+	if path, err := logger.Path(); err == nil {
+		pathLabel.SetText(path)
+	}
+	row.Append(pathLabel)
+
+	copyButton := gtk.NewButtonWithLabel("Copy")
+	copyButton.SetTooltipText("Copy the log to the clipboard, e.g. for a bug report")
+	copyButton.ConnectClicked(func() {
+		view.Clipboard().SetText(buffer.Text(buffer.StartIter(), buffer.EndIter(), false))
+	})
+	row.Append(copyButton)
+	box.Append(row)
+
+	scrolled := gtk.NewScrolledWindow()
+	scrolled.SetVExpand(true)
+	scrolled.SetHExpand(true)
+	scrolled.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyAutomatic)
+	scrolled.SetChild(view)
+	box.Append(scrolled)
+
+	window.SetChild(box)
+
+	end := buffer.CreateMark("end", buffer.EndIter(), false)
+	var seq uint64
+	refresh := func() bool {
+		if a.logWindow != window {
+			return false
+		}
+
+		var lines []string
+		lines, seq = logger.Recent.Since(seq)
+		if len(lines) == 0 {
+			return true
+		}
+
+		// Only follow new lines while the view is scrolled to the end, so
+		// reading older lines is not interrupted.
+		adjustment := scrolled.VAdjustment()
+		following := adjustment.Value()+adjustment.PageSize() >= adjustment.Upper()-1
+
+		buffer.Insert(buffer.EndIter(), strings.Join(lines, "\n")+"\n")
+		if excess := buffer.LineCount() - 1 - logger.RecentLines; excess > 0 {
+			cut, _ := buffer.IterAtLine(excess)
+			buffer.Delete(buffer.StartIter(), cut)
+		}
+
+		if following {
+			view.ScrollToMark(end, 0, false, 0, 0)
+		}
+		return true
+	}
+
+	window.ConnectCloseRequest(func() bool {
+		a.logWindow = nil
+		return false
+	})
+
+	a.logWindow = window
+	refresh()
+	glib.TimeoutAdd(logRefreshInterval, refresh)
+	window.SetVisible(true)
+}
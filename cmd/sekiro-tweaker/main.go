@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
@@ -10,10 +14,11 @@ import (
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"go.uber.org/zap"
 
+	"github.com/amadejkastelic/sekiro-tweaker/internal/api"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/cli"
 	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
 	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
 	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
-	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
 )
 
 const appID = "com.github.amadejkastelic.sekiro-tweaker"
@@ -22,27 +27,42 @@ type Application struct {
 	app    *gtk.Application
 	window *gtk.ApplicationWindow
 
-	statusLabel *gtk.Label
-	pidLabel    *gtk.Label
-	applyButton *gtk.Button
-
-	fpsCheck      *gtk.CheckButton
-	fpsSpin       *gtk.SpinButton
-	resCheck      *gtk.CheckButton
-	widthSpin     *gtk.SpinButton
-	heightSpin    *gtk.SpinButton
-	fovCheck      *gtk.CheckButton
-	fovSpin       *gtk.SpinButton
-	camResetCheck *gtk.CheckButton
-
-	autoLootCheck     *gtk.CheckButton
-	dragonrotCheck    *gtk.CheckButton
-	deathPenaltyCheck *gtk.CheckButton
-
-	gameSpeedCheck   *gtk.CheckButton
-	gameSpeedSpin    *gtk.SpinButton
-	playerSpeedCheck *gtk.CheckButton
-	playerSpeedSpin  *gtk.SpinButton
+	statusLabel  *gtk.Label
+	pidLabel     *gtk.Label
+	stateLabel   *gtk.Label
+	applyButton  *gtk.Button
+	dryRunButton *gtk.Button
+	doctorButton *gtk.Button
+	dumpButton   *gtk.Button
+
+	features []*featureControl
+
+	processRow      *gtk.Box
+	processDropDown *gtk.DropDown
+	processNames    *gtk.StringList
+	// candidates are the processes listed in the picker.
+	candidates []game.Candidate
+	// pickedPID is the process the user chose in the picker, read by
+	// detectGame.
+	pickedPID atomic.Int64
+
+	profileDropDown *gtk.DropDown
+	profileNames    *gtk.StringList
+	profileEntry    *gtk.Entry
+	// updatingProfiles is set while the selector is filled, so that it is
+	// not mistaken for the user picking a profile.
+	updatingProfiles bool
+	// fileDialog keeps the open import or export dialog alive.
+	fileDialog *gtk.FileChooserNative
+
+	autoHealCheck      *gtk.CheckButton
+	applyOnChangeCheck *gtk.CheckButton
+	liveCheck          *gtk.CheckButton
+
+	// updatingControls is set while the controls are filled from a config or
+	// the API, so that live mode does not apply the change again.
+	updatingControls bool
+	liveChanges      chan liveChange
 
 	deathsLabel *gtk.Label
 	killsLabel  *gtk.Label
@@ -51,11 +71,45 @@ type Application struct {
 	errorsView     *gtk.TextView
 	errorsBuffer   *gtk.TextBuffer
 
-	patcher *game.Patcher
-	gamePID int
+	// logConfig keeps the profile's log settings, which have no controls in
+	// the main window, so that saving does not drop them.
+	logConfig config.LogConfig
+	logWindow *gtk.Window
+
+	// session owns the patcher of the attached game. The monitors are only
+	// touched on the UI thread.
+	session       *game.Session
+	watchdog      *game.Watchdog
+	monitor       *game.EventMonitor
+	statusMonitor *game.StatusMonitor
+	stateMonitor  *game.StateMonitor
+	// processMonitor finds the game for detectGame.
+	processMonitor *game.ProcessMonitor
+
+	server        *api.Server
+	configWatcher *config.Watcher
+}
+
+// featureControl holds the widgets generated for one registered feature.
+type featureControl struct {
+	feature game.Feature
+	check   *gtk.CheckButton
+	spins   map[string]*gtk.SpinButton
+	// status shows the feature's live state in the game.
+	status *gtk.Label
+	// errorLabel shows why the feature failed to apply.
+	errorLabel *gtk.Label
+	// liveTimer is the pending live apply, or 0.
+	liveTimer glib.SourceHandle
 }
 
 func main() {
+	// `sekiro-tweaker run -- %command%` is meant as a Steam launch option,
+	// where the GUI would be in the way.
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	app := gtk.NewApplication(appID, gio.ApplicationFlagsNone)
 	appState := &Application{app: app}
 
@@ -85,128 +139,44 @@ func (a *Application) activate() {
 	a.pidLabel.AddCSSClass("dim-label")
 	mainBox.Append(a.pidLabel)
 
+	a.stateLabel = gtk.NewLabel("Game: -")
+	a.stateLabel.AddCSSClass("dim-label")
+	a.stateLabel.SetTooltipText("What the game is doing. Features that need the game world are applied again when it loads.")
+	mainBox.Append(a.stateLabel)
+	mainBox.Append(a.newProcessPicker())
+
 	separator1 := gtk.NewSeparator(gtk.OrientationHorizontal)
 	separator1.SetMarginTop(10)
 	separator1.SetMarginBottom(10)
 	mainBox.Append(separator1)
 
-	graphicsExpander := gtk.NewExpander("Graphics Settings")
-	graphicsExpander.SetExpanded(true)
-	graphicsBox := gtk.NewBox(gtk.OrientationVertical, 10)
-	graphicsBox.SetMarginStart(15)
-	graphicsBox.SetMarginTop(10)
-	graphicsBox.SetMarginBottom(10)
-
-	fpsBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
-	a.fpsCheck = gtk.NewCheckButtonWithLabel("FPS Unlock")
-	a.fpsCheck.SetActive(true)
-	fpsBox.Append(a.fpsCheck)
-	a.fpsSpin = gtk.NewSpinButtonWithRange(30, 300, 1)
-	a.fpsSpin.SetValue(144)
-	fpsBox.Append(a.fpsSpin)
-	graphicsBox.Append(fpsBox)
-
-	resBox := gtk.NewBox(gtk.OrientationVertical, 5)
-	a.resCheck = gtk.NewCheckButtonWithLabel("Custom Resolution")
-	a.resCheck.SetActive(false)
-	resBox.Append(a.resCheck)
-
-	resInputBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
-	resInputBox.SetMarginStart(20)
-	widthLabel := gtk.NewLabel("Width:")
-	resInputBox.Append(widthLabel)
-	a.widthSpin = gtk.NewSpinButtonWithRange(800, 7680, 1)
-	a.widthSpin.SetValue(2560)
-	resInputBox.Append(a.widthSpin)
-	heightLabel := gtk.NewLabel("Height:")
-	resInputBox.Append(heightLabel)
-	a.heightSpin = gtk.NewSpinButtonWithRange(600, 4320, 1)
-	a.heightSpin.SetValue(1440)
-	resInputBox.Append(a.heightSpin)
-	resBox.Append(resInputBox)
-	graphicsBox.Append(resBox)
-
-	fovBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
-	a.fovCheck = gtk.NewCheckButtonWithLabel("Custom FOV")
-	a.fovCheck.SetActive(false)
-	fovBox.Append(a.fovCheck)
-	a.fovSpin = gtk.NewSpinButtonWithRange(0.5, 2.5, 0.05)
-	a.fovSpin.SetValue(1.0)
-	a.fovSpin.SetDigits(2)
-	fovSuffix := gtk.NewLabel("degrees")
-	fovBox.Append(a.fovSpin)
-	fovBox.Append(fovSuffix)
-	graphicsBox.Append(fovBox)
-
-	graphicsExpander.SetChild(graphicsBox)
-	mainBox.Append(graphicsExpander)
-
-	cameraExpander := gtk.NewExpander("Camera Settings")
-	cameraExpander.SetExpanded(false)
-	cameraBox := gtk.NewBox(gtk.OrientationVertical, 10)
-	cameraBox.SetMarginStart(15)
-	cameraBox.SetMarginTop(10)
-	cameraBox.SetMarginBottom(10)
-
-	a.camResetCheck = gtk.NewCheckButtonWithLabel("Disable camera reset on lock-on")
-	a.camResetCheck.SetActive(false)
-	cameraBox.Append(a.camResetCheck)
-
-	cameraExpander.SetChild(cameraBox)
-	mainBox.Append(cameraExpander)
-
-	gameplayExpander := gtk.NewExpander("Gameplay Modifications")
-	gameplayExpander.SetExpanded(false)
-	gameplayBox := gtk.NewBox(gtk.OrientationVertical, 10)
-	gameplayBox.SetMarginStart(15)
-	gameplayBox.SetMarginTop(10)
-	gameplayBox.SetMarginBottom(10)
-
-	a.autoLootCheck = gtk.NewCheckButtonWithLabel("Automatically loot enemies")
-	a.autoLootCheck.SetActive(false)
-	gameplayBox.Append(a.autoLootCheck)
-
-	a.dragonrotCheck = gtk.NewCheckButtonWithLabel("Prevent dragonrot increase on death")
-	a.dragonrotCheck.SetActive(false)
-	gameplayBox.Append(a.dragonrotCheck)
-
-	a.deathPenaltyCheck = gtk.NewCheckButtonWithLabel("Disable death penalties (Sen/XP loss)")
-	a.deathPenaltyCheck.SetActive(false)
-	gameplayBox.Append(a.deathPenaltyCheck)
-
-	gameplayExpander.SetChild(gameplayBox)
-	mainBox.Append(gameplayExpander)
-
-	speedExpander := gtk.NewExpander("Speed Modifiers")
-	speedExpander.SetExpanded(false)
-	speedBox := gtk.NewBox(gtk.OrientationVertical, 10)
-	speedBox.SetMarginStart(15)
-	speedBox.SetMarginTop(10)
-	speedBox.SetMarginBottom(10)
-
-	gameSpeedBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
-	a.gameSpeedCheck = gtk.NewCheckButtonWithLabel("Game Speed")
-	a.gameSpeedCheck.SetActive(false)
-	gameSpeedBox.Append(a.gameSpeedCheck)
-	a.gameSpeedSpin = gtk.NewSpinButtonWithRange(0.1, 5.0, 0.1)
-	a.gameSpeedSpin.SetValue(1.0)
-	a.gameSpeedSpin.SetDigits(1)
-	gameSpeedBox.Append(a.gameSpeedSpin)
-	speedBox.Append(gameSpeedBox)
-
-	playerSpeedBox := gtk.NewBox(gtk.OrientationHorizontal, 10)
-	a.playerSpeedCheck = gtk.NewCheckButtonWithLabel("Player Speed (experimental)")
-	a.playerSpeedCheck.SetActive(false)
-	a.playerSpeedCheck.SetTooltipText("May not work reliably on Linux/Proton. Use at your own risk.")
-	playerSpeedBox.Append(a.playerSpeedCheck)
-	a.playerSpeedSpin = gtk.NewSpinButtonWithRange(0.1, 5.0, 0.1)
-	a.playerSpeedSpin.SetValue(1.0)
-	a.playerSpeedSpin.SetDigits(1)
-	playerSpeedBox.Append(a.playerSpeedSpin)
-	speedBox.Append(playerSpeedBox)
-
-	speedExpander.SetChild(speedBox)
-	mainBox.Append(speedExpander)
+	mainBox.Append(a.newProfileControls())
+
+	for _, category := range game.Categories() {
+		var features []game.Feature
+		for _, feature := range game.Features() {
+			if feature.Category() == category {
+				features = append(features, feature)
+			}
+		}
+		if len(features) == 0 {
+			continue
+		}
+
+		expander := gtk.NewExpander(category.String())
+		expander.SetExpanded(category == game.CategoryGraphics)
+		box := gtk.NewBox(gtk.OrientationVertical, 10)
+		box.SetMarginStart(15)
+		box.SetMarginTop(10)
+		box.SetMarginBottom(10)
+
+		for _, feature := range features {
+			box.Append(a.newFeatureControl(feature))
+		}
+
+		expander.SetChild(box)
+		mainBox.Append(expander)
+	}
 
 	separator2 := gtk.NewSeparator(gtk.OrientationHorizontal)
 	separator2.SetMarginTop(10)
@@ -252,63 +222,309 @@ func (a *Application) activate() {
 	a.errorsExpander.SetChild(errorsScrolled)
 	mainBox.Append(a.errorsExpander)
 
+	a.autoHealCheck = gtk.NewCheckButtonWithLabel("Re-apply patches if the game reverts them")
+	a.autoHealCheck.SetActive(false)
+	a.autoHealCheck.ConnectToggled(func() {
+		if a.watchdog != nil {
+			a.watchdog.SetAutoHeal(a.autoHealCheck.Active())
+		}
+	})
+	mainBox.Append(a.autoHealCheck)
+
+	a.applyOnChangeCheck = gtk.NewCheckButtonWithLabel("Re-apply when the config file changes")
+	a.applyOnChangeCheck.SetTooltipText("Apply the config to the game after it is edited outside the app")
+	mainBox.Append(a.applyOnChangeCheck)
+
+	a.liveCheck = gtk.NewCheckButtonWithLabel("Apply changes immediately")
+	a.liveCheck.SetTooltipText("Apply or revert a feature as soon as its controls change, without \"Apply Patches\"")
+	mainBox.Append(a.liveCheck)
+
 	a.applyButton = gtk.NewButtonWithLabel("Apply Patches")
 	a.applyButton.AddCSSClass("suggested-action")
 	a.applyButton.SetSensitive(false)
 	a.applyButton.ConnectClicked(func() { a.applyPatches() })
 	mainBox.Append(a.applyButton)
 
+	a.dryRunButton = gtk.NewButtonWithLabel("Dry Run")
+	a.dryRunButton.SetTooltipText("Resolve the enabled patches and show what would be written without changing the game")
+	a.dryRunButton.SetSensitive(false)
+	a.dryRunButton.ConnectClicked(func() { a.dryRun() })
+	mainBox.Append(a.dryRunButton)
+
+	a.dumpButton = gtk.NewButtonWithLabel("Save Memory Snapshot")
+	a.dumpButton.SetTooltipText("Dump game memory to a file that can be attached to bug reports")
+	a.dumpButton.SetSensitive(false)
+	a.dumpButton.ConnectClicked(func() { a.dumpSnapshot() })
+	mainBox.Append(a.dumpButton)
+
+	a.doctorButton = gtk.NewButtonWithLabel("Run Diagnostics")
+	a.doctorButton.SetTooltipText("Check permissions, game detection and patch signatures, and save the report for bug reports")
+	a.doctorButton.ConnectClicked(func() { a.runDoctor() })
+	mainBox.Append(a.doctorButton)
+
+	logButton := gtk.NewButtonWithLabel("Show Log")
+	logButton.SetTooltipText("Show the recent log, which is also written to a file for bug reports")
+	logButton.ConnectClicked(func() { a.showLog() })
+	mainBox.Append(logButton)
+
 	a.window.SetChild(mainBox)
 
-	a.loadConfig()
+	cfg := a.loadConfig()
+	a.refreshProfiles()
+	a.loadPatches()
+	a.session = game.NewSession()
+	a.startServer(cfg)
+	a.watchConfig()
+
+	a.liveChanges = make(chan liveChange, 64)
+	go a.runLiveChanges()
 
 	a.window.SetVisible(true)
 
+	a.processMonitor = game.NewProcessMonitor(game.DefaultProcessInterval)
 	go a.detectGame()
 	go a.updateStats()
 }
 
-func (a *Application) detectGame() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+// newFeatureControl builds a check button for a feature followed by a spin
+// button for each parameter. Features with several parameters get them on an
+// indented row of their own.
+func (a *Application) newFeatureControl(feature game.Feature) gtk.Widgetter {
+	control := &featureControl{
+		feature: feature,
+		check:   gtk.NewCheckButtonWithLabel(feature.Name()),
+		spins:   make(map[string]*gtk.SpinButton),
+		status:  gtk.NewLabel(""),
+
+		errorLabel: gtk.NewLabel(""),
+	}
+	control.check.SetActive(feature.DefaultEnabled())
+	control.check.SetTooltipText(feature.Description())
+	control.check.ConnectToggled(func() { a.scheduleLive(control) })
+	control.status.SetHExpand(true)
+	control.status.SetHAlign(gtk.AlignEnd)
+	showLiveStatus(control, nil)
+	a.features = append(a.features, control)
+
+	control.errorLabel.AddCSSClass("error")
+	control.errorLabel.SetMarginStart(20)
+	control.errorLabel.SetXAlign(0)
+	control.errorLabel.SetWrap(true)
+	control.errorLabel.SetVisible(false)
+
+	column := gtk.NewBox(gtk.OrientationVertical, 5)
+	row := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	row.Append(control.check)
+	column.Append(row)
+
+	params := feature.Params()
+	if len(params) <= 1 {
+		a.appendParams(row, control, params)
+		row.Append(control.status)
+	} else {
+		row.Append(control.status)
+		paramsRow := gtk.NewBox(gtk.OrientationHorizontal, 10)
+		paramsRow.SetMarginStart(20)
+		a.appendParams(paramsRow, control, params)
+		column.Append(paramsRow)
+	}
 
-	for range ticker.C {
-		pids, err := memory.FindProcessByName(game.ProcessName)
-		if err != nil || len(pids) == 0 {
-			glib.IdleAdd(func() {
-				a.statusLabel.SetText("Waiting for Sekiro...")
-				a.pidLabel.SetText("PID: -")
-				a.applyButton.SetSensitive(false)
-				a.patcher = nil
-				a.gamePID = 0
-			})
-			continue
+	column.Append(control.errorLabel)
+	return column
+}
+
+func (a *Application) appendParams(box *gtk.Box, control *featureControl, params []game.Param) {
+	for _, param := range params {
+		if param.Label != "" {
+			box.Append(gtk.NewLabel(param.Label))
 		}
 
-		pid := pids[0]
-		if pid == a.gamePID {
-			continue
+		spin := gtk.NewSpinButtonWithRange(param.Min, param.Max, param.Step)
+		spin.SetDigits(uint(param.Digits))
+		spin.SetValue(param.Default)
+		spin.ConnectValueChanged(func() { a.scheduleLive(control) })
+		box.Append(spin)
+		control.spins[param.ID] = spin
+
+		if param.Unit != "" {
+			box.Append(gtk.NewLabel(param.Unit))
 		}
+	}
+}
 
-		patcher, err := game.NewPatcher(pid)
-		if err != nil {
-			logger.Log.Error("Failed to create patcher", zap.Error(err), zap.Int("pid", pid))
-			continue
+// featureSettings reads every feature control. It must run on the UI thread.
+func (a *Application) featureSettings() []game.FeatureSetting {
+	states := make([]game.FeatureSetting, len(a.features))
+	for i, control := range a.features {
+		states[i] = control.setting()
+	}
+	return states
+}
+
+func (control *featureControl) setting() game.FeatureSetting {
+	params := make(game.Params, len(control.spins))
+	for id, spin := range control.spins {
+		params[id] = spin.Value()
+	}
+	return game.FeatureSetting{
+		Feature: control.feature,
+		Enabled: control.check.Active(),
+		Params:  params,
+	}
+}
+
+// detectGame attaches to the game when it starts and detaches as soon as it
+// exits.
+func (a *Application) detectGame() {
+	processes := a.processMonitor
+	processes.Start()
+
+	for {
+		select {
+		case candidates, ok := <-processes.Candidates():
+			if !ok {
+				return
+			}
+			a.attachGame(processes, candidates)
+		case pid, ok := <-processes.Exits():
+			if !ok {
+				return
+			}
+			if a.session.Attached() && pid == a.session.PID() {
+				a.releaseGame()
+			}
 		}
+	}
+}
 
-		a.gamePID = pid
-		a.patcher = patcher
+// attachGame attaches to the most likely candidate, or the one the user
+// picked, unless it is attached already.
+func (a *Application) attachGame(processes *game.ProcessMonitor, candidates []game.Candidate) {
+	if len(candidates) == 0 {
+		if a.session.Attached() {
+			a.releaseGame()
+		}
+		glib.IdleAdd(func() { a.showCandidates(nil) })
+		return
+	}
 
+	glib.IdleAdd(func() { a.showCandidates(candidates) })
+
+	pid, ok := a.chooseProcess(candidates)
+	if !ok {
 		glib.IdleAdd(func() {
-			a.statusLabel.SetText("Sekiro detected!")
-			a.pidLabel.SetText(fmt.Sprintf("PID: %d", pid))
-			a.applyButton.SetSensitive(true)
+			a.statusLabel.SetText("Several Sekiro processes found, pick one")
 		})
+		return
+	}
+	if pid == a.session.PID() {
+		return
+	}
+
+	patcher, err := game.NewPatcher(pid)
+	if err != nil {
+		logger.Log.Error("Failed to create patcher", zap.Error(err), zap.Int("pid", pid))
+		return
+	}
+	processes.Track(pid, patcher.StartTime())
+
+	if err := patcher.EnableJournal(); err != nil {
+		logger.Log.Warn("Patch journal unavailable", zap.Error(err), zap.Int("pid", pid))
+	}
+
+	if err := a.session.Attach(patcher); err != nil {
+		logger.Log.Warn("Failed to release the previous game", zap.Error(err))
+	}
+	a.server.SetAttached(true)
+
+	watchdog := game.NewWatchdog(a.session, game.DefaultWatchdogInterval)
+	watchdog.Start()
+	go a.reportDrift(watchdog)
+
+	monitor := game.NewEventMonitor(a.session, game.DefaultEventMonitorInterval)
+	monitor.Start()
+	go a.reportEvents(monitor)
+
+	statusMonitor := game.NewStatusMonitor(a.session, game.DefaultStatusInterval)
+	statusMonitor.Start()
+	go a.reportStatus(statusMonitor)
+
+	stateMonitor := game.NewStateMonitor(a.session, game.DefaultStateInterval)
+
+	glib.IdleAdd(func() {
+		if a.watchdog != nil {
+			a.watchdog.Stop()
+		}
+		a.watchdog = watchdog
+		a.watchdog.SetAutoHeal(a.autoHealCheck.Active())
+
+		if a.monitor != nil {
+			a.monitor.Stop()
+		}
+		a.monitor = monitor
+
+		if a.statusMonitor != nil {
+			a.statusMonitor.Stop()
+		}
+		a.statusMonitor = statusMonitor
+
+		if a.stateMonitor != nil {
+			a.stateMonitor.Stop()
+		}
+		// Started once assigned, so its first change is not dropped.
+		a.stateMonitor = stateMonitor
+		a.stateMonitor.Start()
+		go a.reportState(stateMonitor)
+
+		a.statusLabel.SetText("Sekiro detected!")
+		a.pidLabel.SetText(fmt.Sprintf("PID: %d", pid))
+		a.applyButton.SetSensitive(true)
+		a.dryRunButton.SetSensitive(true)
+		a.dumpButton.SetSensitive(true)
+	})
+}
+
+// releaseGame detaches the session from a game that exited.
+func (a *Application) releaseGame() {
+	// Nothing is written to the pid from here on.
+	if err := a.session.Detach(true); err != nil {
+		logger.Log.Debug("Failed to release caves of exited game", zap.Error(err))
+	}
+	a.server.SetAttached(false)
+	glib.IdleAdd(a.detachGame)
+}
+
+// detachGame resets the window after the game exited.
+func (a *Application) detachGame() {
+	a.statusLabel.SetText("Waiting for Sekiro...")
+	a.pidLabel.SetText("PID: -")
+	a.stateLabel.SetText("Game: -")
+	a.applyButton.SetSensitive(false)
+	a.dryRunButton.SetSensitive(false)
+	a.dumpButton.SetSensitive(false)
+	if a.watchdog != nil {
+		a.watchdog.Stop()
+		a.watchdog = nil
+	}
+	if a.monitor != nil {
+		a.monitor.Stop()
+		a.monitor = nil
+	}
+	if a.stateMonitor != nil {
+		a.stateMonitor.Stop()
+		a.stateMonitor = nil
+	}
+	if a.statusMonitor != nil {
+		a.statusMonitor.Stop()
+		a.statusMonitor = nil
+		for _, control := range a.features {
+			showLiveStatus(control, nil)
+		}
 	}
 }
 
 func (a *Application) applyPatches() {
-	if a.patcher == nil {
+	if !a.session.Attached() {
 		a.showError("No game detected")
 		return
 	}
@@ -323,93 +539,364 @@ func (a *Application) applyPatches() {
 		a.errorsExpander.SetExpanded(false)
 	})
 
-	a.saveConfig()
+	saveErr := a.currentConfig().Save()
+
+	session := a.session
+	settings := a.featureSettings()
+	for _, setting := range settings {
+		a.server.SetFeatureState(setting.Feature, setting.Enabled, setting.Params)
+	}
 
 	go func() {
 		var errors []string
+		if saveErr != nil {
+			errors = append(errors, fmt.Sprintf("Failed to save config: %v", saveErr))
+		}
 
-		if a.fpsCheck.Active() {
-			fps := int(a.fpsSpin.Value())
-			if err := a.patcher.ApplyFPSPatch(fps); err != nil {
-				errors = append(errors, fmt.Sprintf("FPS: %v", err))
+		featureErrs, err := session.ApplyAll(settings)
+		if err != nil {
+			errors = append(errors, err.Error())
+			featureErrs = make([]error, len(settings))
+		}
+		for i, setting := range settings {
+			if err := featureErrs[i]; err != nil {
+				logger.Log.Warn("Failed to apply feature",
+					zap.String("feature", setting.Feature.ID()),
+					zap.Error(err))
+				errors = append(errors, fmt.Sprintf("%s: %v", setting.Feature.Name(), err))
 			}
 		}
 
-		if a.resCheck.Active() {
-			width := int(a.widthSpin.Value())
-			height := int(a.heightSpin.Value())
-			if err := a.patcher.ApplyResolutionPatch(width, height); err != nil {
-				errors = append(errors, fmt.Sprintf("Resolution: %v", err))
+		glib.IdleAdd(func() {
+			for i, setting := range settings {
+				if control := a.featureControl(setting.Feature.ID()); control != nil {
+					showFeatureError(control, featureErrs[i])
+				}
 			}
-		}
 
-		if a.fovCheck.Active() {
-			fov := float32(a.fovSpin.Value())
-			if err := a.patcher.ApplyFOVPatch(fov); err != nil {
-				errors = append(errors, fmt.Sprintf("FOV: %v", err))
+			if len(errors) > 0 {
+				a.statusLabel.SetText("Some patches failed (see errors below)")
+
+				// Build error text
+				var errorText string
+				for i, err := range errors {
+					errorText += fmt.Sprintf("[%d] %s\n", i+1, err)
+				}
+
+				a.errorsExpander.SetLabel("Errors")
+				a.errorsBuffer.SetText(errorText)
+				a.errorsExpander.SetVisible(true)
+				a.errorsExpander.SetExpanded(true)
+
+				logger.Log.Warn("Patches completed with errors", zap.Int("error_count", len(errors)))
+			} else {
+				a.statusLabel.SetText("Patches applied successfully!")
+				a.errorsExpander.SetVisible(false)
 			}
+			a.applyButton.SetSensitive(true)
+		})
+	}()
+}
+
+// dryRun plans the enabled features and shows what they would write.
+func (a *Application) dryRun() {
+	if !a.session.Attached() {
+		a.showError("No game detected")
+		return
+	}
+
+	var requests []game.FeatureRequest
+	for _, setting := range a.featureSettings() {
+		if setting.Enabled {
+			requests = append(requests, game.FeatureRequest{Feature: setting.Feature, Params: setting.Params})
 		}
+	}
+	session := a.session
 
-		if a.camResetCheck.Active() {
-			if err := a.patcher.ApplyCameraResetPatch(true); err != nil {
-				errors = append(errors, fmt.Sprintf("Camera reset: %v", err))
-			}
+	a.dryRunButton.SetSensitive(false)
+	a.statusLabel.SetText("Resolving patches...")
+
+	go func() {
+		var report strings.Builder
+		var results []game.DryRunResult
+		err := session.Do(func(p *game.Patcher) error {
+			var err error
+			results, err = p.DryRun(requests)
+			return err
+		})
+		if err == nil {
+			err = game.WriteDryRunReport(&report, results)
 		}
 
-		if a.autoLootCheck.Active() {
-			if err := a.patcher.ApplyAutoLootPatch(true); err != nil {
-				errors = append(errors, fmt.Sprintf("Auto-loot: %v", err))
+		failed := 0
+		for _, result := range results {
+			if result.Failed() {
+				failed++
 			}
 		}
 
-		if a.dragonrotCheck.Active() {
-			if err := a.patcher.ApplyDragonrotPatch(true); err != nil {
-				errors = append(errors, fmt.Sprintf("Dragonrot: %v", err))
+		glib.IdleAdd(func() {
+			a.dryRunButton.SetSensitive(true)
+			if err != nil {
+				a.showError(fmt.Sprintf("Dry run: %v", err))
+				return
 			}
-		}
 
-		if a.deathPenaltyCheck.Active() {
-			if err := a.patcher.ApplyDeathPenaltyPatch(true); err != nil {
-				errors = append(errors, fmt.Sprintf("Death penalty: %v", err))
+			if failed > 0 {
+				a.statusLabel.SetText(fmt.Sprintf("Dry run: %d patches would fail", failed))
+			} else {
+				a.statusLabel.SetText("Dry run: all patches resolved")
 			}
-		}
+			a.showReport("Dry Run", report.String())
+		})
+	}()
+}
+
+// runDoctor runs the diagnostics, shows the report and saves it as text and
+// JSON next to the memory snapshots.
+func (a *Application) runDoctor() {
+	pid := a.session.PID()
+
+	a.doctorButton.SetSensitive(false)
+	a.statusLabel.SetText("Running diagnostics...")
 
-		if a.gameSpeedCheck.Active() {
-			speed := float32(a.gameSpeedSpin.Value())
-			if err := a.patcher.SetGameSpeed(speed); err != nil {
-				logger.Log.Warn("Failed to set game speed (may not be available yet)", zap.Error(err))
-				errors = append(errors, fmt.Sprintf("Game speed: %v (try again after loading into game)", err))
+	go func() {
+		report := game.Diagnose(pid)
+
+		var text strings.Builder
+		_ = game.WriteDoctorReport(&text, report)
+		path, err := saveDoctorReport(report, text.String())
+
+		glib.IdleAdd(func() {
+			a.doctorButton.SetSensitive(true)
+			a.showReport("Diagnostics", text.String())
+
+			switch {
+			case err != nil:
+				a.statusLabel.SetText(fmt.Sprintf("Diagnostics: failed to save report: %v", err))
+			case report.Count(game.CheckFailed) > 0:
+				a.statusLabel.SetText("Diagnostics found problems, report saved to " + path)
+			default:
+				a.statusLabel.SetText("Diagnostics passed, report saved to " + path)
 			}
+		})
+	}()
+}
+
+// saveDoctorReport writes the report as .txt and .json and returns the path
+// of the text file.
+func saveDoctorReport(report *game.DoctorReport, text string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	reportDir := filepath.Join(cacheDir, "sekiro-tweaker", "reports")
+	if err := os.MkdirAll(reportDir, 0755); err != nil {
+		return "", err
+	}
+
+	base := filepath.Join(reportDir, fmt.Sprintf("doctor-%s", report.CreatedAt.Format("20060102-150405")))
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".txt", []byte(text), 0644); err != nil {
+		return "", err
+	}
+
+	return base + ".txt", nil
+}
+
+// startServer serves the control API, so scripts can drive the same state
+// the window shows. Changes made through the API update the controls.
+func (a *Application) startServer(cfg *config.Config) {
+	a.server = api.NewServer(cfg, a.session)
+	a.server.OnChange(func(state api.FeatureState) {
+		glib.IdleAdd(func() { a.showFeatureState(state) })
+	})
+	a.server.OnProfileChange(func(name string) {
+		glib.IdleAdd(func() {
+			a.loadConfig()
+			a.refreshProfiles()
+			a.statusLabel.SetText("Switched to profile " + name)
+		})
+	})
+
+	if err := a.server.Listen(api.SocketPath()); err != nil {
+		logger.Log.Warn("Control API unavailable", zap.Error(err))
+		return
+	}
+	go func() {
+		if err := a.server.Serve(); err != nil {
+			logger.Log.Error("Control API stopped", zap.Error(err))
+		}
+	}()
+	a.app.ConnectShutdown(func() { _ = a.server.Close() })
+}
+
+// showFeatureState updates a feature's controls after a change made through
+// the API.
+func (a *Application) showFeatureState(state api.FeatureState) {
+	control := a.featureControl(state.Feature)
+	if control == nil {
+		return
+	}
+
+	a.updatingControls = true
+	defer func() { a.updatingControls = false }()
+
+	control.check.SetActive(state.Enabled)
+	for id, spin := range control.spins {
+		if value, exists := state.Params[id]; exists {
+			spin.SetValue(value)
 		}
+	}
 
-		if a.playerSpeedCheck.Active() {
-			speed := float32(a.playerSpeedSpin.Value())
-			if err := a.patcher.SetPlayerSpeed(speed); err != nil {
-				logger.Log.Warn("Failed to set player speed (may not be available yet)", zap.Error(err))
-				errors = append(errors, "Player speed: not available (load into game first)")
+	var err error
+	if state.Error != "" {
+		err = fmt.Errorf("%s", state.Error)
+	}
+	showFeatureError(control, err)
+}
+
+func (a *Application) featureControl(id string) *featureControl {
+	for _, control := range a.features {
+		if control.feature.ID() == id {
+			return control
+		}
+	}
+	return nil
+}
+
+// showReport shows a report in the expander used for errors.
+func (a *Application) showReport(title, text string) {
+	a.errorsExpander.SetLabel(title)
+	a.errorsBuffer.SetText(text)
+	a.errorsExpander.SetVisible(true)
+	a.errorsExpander.SetExpanded(true)
+}
+
+func (a *Application) reportDrift(watchdog *game.Watchdog) {
+	for event := range watchdog.Events() {
+		a.server.PublishDrift(event)
+
+		var message string
+		switch {
+		case event.Healed:
+			message = fmt.Sprintf("%s patch was reverted by the game and has been re-applied", event.Feature)
+		case event.Err != nil:
+			message = fmt.Sprintf("%s patch was reverted by the game: re-apply failed: %v", event.Feature, event.Err)
+		default:
+			message = fmt.Sprintf("%s patch was reverted by the game (%s)", event.Feature, event.Drift)
+		}
+
+		glib.IdleAdd(func() {
+			if a.watchdog != watchdog {
+				return
 			}
+			a.statusLabel.SetText("Patch drift detected (see errors below)")
+			a.errorsExpander.SetLabel("Errors")
+			a.errorsBuffer.Insert(a.errorsBuffer.EndIter(), message+"\n")
+			a.errorsExpander.SetVisible(true)
+		})
+	}
+}
+
+// reportEvents refreshes the stats as soon as a hook reports a change instead
+// of waiting for the next poll.
+func (a *Application) reportEvents(monitor *game.EventMonitor) {
+	for event := range monitor.Events() {
+		a.server.PublishGameEvent(event)
+
+		switch event.Type {
+		case game.EventDeath, game.EventKill:
+			a.refreshStats()
 		}
+	}
+}
 
+// reportState shows the game's state and lets the server apply the features
+// that need the game world once it loads.
+func (a *Application) reportState(monitor *game.StateMonitor) {
+	for change := range monitor.Changes() {
 		glib.IdleAdd(func() {
-			if len(errors) > 0 {
-				a.statusLabel.SetText("Some patches failed (see errors below)")
+			if a.stateMonitor != monitor {
+				return
+			}
+			a.stateLabel.SetText("Game: " + describeState(change.To))
+		})
 
-				// Build error text
-				var errorText string
-				for i, err := range errors {
-					errorText += fmt.Sprintf("[%d] %s\n", i+1, err)
+		if err := a.server.SetGameState(change); err != nil {
+			logger.Log.Warn("Some features failed to apply", zap.Error(err))
+			glib.IdleAdd(func() {
+				if a.stateMonitor != monitor {
+					return
 				}
-
-				a.errorsBuffer.SetText(errorText)
+				a.statusLabel.SetText("Some patches failed (see errors below)")
+				a.errorsBuffer.Insert(a.errorsBuffer.EndIter(), err.Error()+"\n")
 				a.errorsExpander.SetVisible(true)
-				a.errorsExpander.SetExpanded(true)
+			})
+		}
+	}
+}
 
-				logger.Log.Warn("Patches completed with errors", zap.Int("error_count", len(errors)))
+func describeState(state game.GameState) string {
+	switch state {
+	case game.StateMenu:
+		return "Main menu"
+	case game.StateLoading:
+		return "Loading"
+	case game.StateInWorld:
+		return "In world"
+	case game.StateDead:
+		return "Dead"
+	case game.StateCutscene:
+		return "Cutscene"
+	default:
+		return "Unknown"
+	}
+}
+
+func (a *Application) dumpSnapshot() {
+	if !a.session.Attached() {
+		a.showError("No game detected")
+		return
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		a.showError(fmt.Sprintf("Snapshot: %v", err))
+		return
+	}
+
+	snapshotDir := filepath.Join(cacheDir, "sekiro-tweaker", "snapshots")
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		a.showError(fmt.Sprintf("Snapshot: %v", err))
+		return
+	}
+
+	path := filepath.Join(snapshotDir, fmt.Sprintf("sekiro-%s.snap", time.Now().Format("20060102-150405")))
+	session := a.session
+
+	a.dumpButton.SetSensitive(false)
+	a.statusLabel.SetText("Saving memory snapshot...")
+
+	go func() {
+		err := session.Do(func(p *game.Patcher) error { return p.WriteSnapshot(path) })
+
+		glib.IdleAdd(func() {
+			if err != nil {
+				a.showError(fmt.Sprintf("Snapshot: %v", err))
 			} else {
-				a.statusLabel.SetText("Patches applied successfully!")
-				a.errorsExpander.SetVisible(false)
+				logger.Log.Info("Saved memory snapshot", zap.String("path", path))
+				a.statusLabel.SetText("Snapshot saved to " + path)
 			}
-			a.applyButton.SetSensitive(true)
+			a.dumpButton.SetSensitive(true)
 		})
 	}()
 }
@@ -418,6 +905,7 @@ func (a *Application) showError(message string) {
 	logger.Log.Error("UI error", zap.String("message", message))
 	a.statusLabel.SetText("Error occurred")
 
+	a.errorsExpander.SetLabel("Errors")
 	a.errorsBuffer.SetText(message)
 	a.errorsExpander.SetVisible(true)
 	a.errorsExpander.SetExpanded(true)
@@ -428,70 +916,134 @@ func (a *Application) updateStats() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		if a.patcher == nil {
-			glib.IdleAdd(func() {
-				a.deathsLabel.SetText("Deaths: -")
-				a.killsLabel.SetText("Kills: -")
-			})
-			continue
+		a.refreshStats()
+	}
+}
+
+func (a *Application) refreshStats() {
+	// Without a game the stats are empty and shown as unknown.
+	stats, _ := a.session.ReadStats()
+
+	glib.IdleAdd(func() {
+		if stats.Deaths != nil {
+			a.deathsLabel.SetText(fmt.Sprintf("Deaths: %d", *stats.Deaths))
+		} else {
+			a.deathsLabel.SetText("Deaths: -")
 		}
 
-		deaths, deathsErr := a.patcher.GetPlayerDeaths()
-		kills, killsErr := a.patcher.GetTotalKills()
+		if stats.Kills != nil {
+			a.killsLabel.SetText(fmt.Sprintf("Kills: %d", *stats.Kills))
+		} else {
+			a.killsLabel.SetText("Kills: -")
+		}
+	})
+}
 
-		glib.IdleAdd(func() {
-			if deathsErr == nil {
-				a.deathsLabel.SetText(fmt.Sprintf("Deaths: %d", deaths))
-			} else {
-				a.deathsLabel.SetText("Deaths: -")
-			}
+// loadConfig sets the controls from the active profile and returns it.
+// Settings that could not be read are reported and left at their defaults.
+func (a *Application) loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		a.showError(fmt.Sprintf("Config: %v", err))
+	}
+	a.showConfig(cfg)
+	return cfg
+}
 
-			if killsErr == nil {
-				a.killsLabel.SetText(fmt.Sprintf("Kills: %d", kills))
-			} else {
-				a.killsLabel.SetText("Kills: -")
+// showConfig sets the controls and logging from cfg.
+func (a *Application) showConfig(cfg *config.Config) {
+	a.updatingControls = true
+	defer func() { a.updatingControls = false }()
+
+	for _, control := range a.features {
+		enabled := control.feature.DefaultEnabled()
+		params := game.DefaultParams(control.feature)
+
+		if saved, exists := cfg.Features[control.feature.ID()]; exists {
+			enabled = saved.Enabled
+			for id, value := range saved.Params {
+				params[id] = value
 			}
-		})
+		}
+
+		control.check.SetActive(enabled)
+		for id, spin := range control.spins {
+			spin.SetValue(params[id])
+		}
+	}
+
+	a.autoHealCheck.SetActive(cfg.AutoHeal)
+	a.applyOnChangeCheck.SetActive(cfg.ApplyOnChange)
+	a.liveCheck.SetActive(cfg.LiveApply)
+	a.configureLogging(cfg)
+}
+
+// watchConfig reloads the active profile when it is edited outside the app,
+// e.g. by hand or by dotfile tooling.
+func (a *Application) watchConfig() {
+	watcher, err := config.Watch()
+	if err != nil {
+		logger.Log.Warn("Config changes will not be picked up", zap.Error(err))
+		return
+	}
+	a.configWatcher = watcher
+	a.app.ConnectShutdown(func() { _ = watcher.Close() })
+
+	go func() {
+		for change := range watcher.Changes() {
+			glib.IdleAdd(func() { a.reloadConfig(change) })
+		}
+	}()
+}
+
+// reloadConfig shows a profile that changed on disk and, if the profile asks
+// for it, applies it to the game.
+func (a *Application) reloadConfig(change config.Change) {
+	a.showConfig(change.Config)
+	a.refreshProfiles()
+	if change.Err != nil {
+		a.showError(fmt.Sprintf("Config: %v", change.Err))
+	} else {
+		a.statusLabel.SetText("Reloaded profile " + change.Profile)
+	}
+
+	server := a.server
+	go func() {
+		err := server.ReloadConfig(change.Profile, change.Config, change.Config.ApplyOnChange)
+		if err != nil {
+			glib.IdleAdd(func() { a.showError("Applying changed config: " + err.Error()) })
+		}
+	}()
+}
+
+// loadPatches applies the user's patch definition overrides. Invalid overrides
+// are reported and the built-in definitions are kept.
+func (a *Application) loadPatches() {
+	dir, err := config.PatchesDir()
+	if err != nil {
+		return
 	}
+
+	set, err := game.LoadPatchSet(dir)
+	if err != nil {
+		a.showError(fmt.Sprintf("Patch definitions: %v", err))
+		return
+	}
+	game.SetActivePatches(set)
 }
 
-func (a *Application) loadConfig() {
-	cfg := config.Load()
-
-	a.fpsCheck.SetActive(cfg.FPSUnlock)
-	a.fpsSpin.SetValue(float64(cfg.FPS))
-	a.resCheck.SetActive(cfg.Resolution)
-	a.widthSpin.SetValue(float64(cfg.Width))
-	a.heightSpin.SetValue(float64(cfg.Height))
-	a.fovCheck.SetActive(cfg.FOV)
-	a.fovSpin.SetValue(cfg.FOVValue)
-	a.camResetCheck.SetActive(cfg.CameraReset)
-	a.autoLootCheck.SetActive(cfg.AutoLoot)
-	a.dragonrotCheck.SetActive(cfg.Dragonrot)
-	a.deathPenaltyCheck.SetActive(cfg.DeathPenalty)
-	a.gameSpeedCheck.SetActive(cfg.GameSpeed)
-	a.gameSpeedSpin.SetValue(cfg.GameSpeedVal)
-	a.playerSpeedCheck.SetActive(cfg.PlayerSpeed)
-	a.playerSpeedSpin.SetValue(cfg.PlayerSpeedVal)
-}
-
-func (a *Application) saveConfig() {
-	cfg := &config.Config{
-		FPSUnlock:      a.fpsCheck.Active(),
-		FPS:            int(a.fpsSpin.Value()),
-		Resolution:     a.resCheck.Active(),
-		Width:          int(a.widthSpin.Value()),
-		Height:         int(a.heightSpin.Value()),
-		FOV:            a.fovCheck.Active(),
-		FOVValue:       a.fovSpin.Value(),
-		CameraReset:    a.camResetCheck.Active(),
-		AutoLoot:       a.autoLootCheck.Active(),
-		Dragonrot:      a.dragonrotCheck.Active(),
-		DeathPenalty:   a.deathPenaltyCheck.Active(),
-		GameSpeed:      a.gameSpeedCheck.Active(),
-		GameSpeedVal:   a.gameSpeedSpin.Value(),
-		PlayerSpeed:    a.playerSpeedCheck.Active(),
-		PlayerSpeedVal: a.playerSpeedSpin.Value(),
-	}
-	cfg.Save()
+// currentConfig builds a config from the controls.
+func (a *Application) currentConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	for _, state := range a.featureSettings() {
+		cfg.Features[state.Feature.ID()] = config.FeatureConfig{
+			Enabled: state.Enabled,
+			Params:  state.Params,
+		}
+	}
+	cfg.AutoHeal = a.autoHealCheck.Active()
+	cfg.ApplyOnChange = a.applyOnChangeCheck.Active()
+	cfg.LiveApply = a.liveCheck.Active()
+	cfg.Log = a.logConfig
+	return cfg
 }
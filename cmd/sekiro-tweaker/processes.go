@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+// newProcessPicker builds the row that lets the user choose the game when
+// several processes could be it. It stays hidden otherwise.
+func (a *Application) newProcessPicker() *gtk.Box {
+	row := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	row.SetHAlign(gtk.AlignCenter)
+	row.SetVisible(false)
+
+	row.Append(gtk.NewLabel("Process:"))
+
+	a.processNames = gtk.NewStringList(nil)
+	a.processDropDown = gtk.NewDropDown(a.processNames, nil)
+	a.processDropDown.SetTooltipText("Processes that could be the game, most likely first")
+	row.Append(a.processDropDown)
+
+	attachButton := gtk.NewButtonWithLabel("Attach")
+	attachButton.SetTooltipText("Use this process as the game")
+	attachButton.ConnectClicked(func() {
+		selected := int(a.processDropDown.Selected())
+		if selected >= len(a.candidates) {
+			return
+		}
+		candidate := a.candidates[selected]
+		a.pickedPID.Store(int64(candidate.PID))
+		a.statusLabel.SetText(fmt.Sprintf("Attaching to PID %d...", candidate.PID))
+		a.processMonitor.Rescan()
+	})
+	row.Append(attachButton)
+
+	a.processRow = row
+	return row
+}
+
+// showCandidates lists the processes that could be the game in the picker.
+func (a *Application) showCandidates(candidates []game.Candidate) {
+	a.processRow.SetVisible(len(candidates) > 1)
+
+	if sameCandidates(a.candidates, candidates) {
+		a.candidates = candidates
+		return
+	}
+	a.candidates = candidates
+
+	descriptions := make([]string, len(candidates))
+	selected := 0
+	for i, candidate := range candidates {
+		descriptions[i] = candidate.String()
+		if candidate.PID == a.session.PID() {
+			selected = i
+		}
+	}
+	a.processNames.Splice(0, a.processNames.NItems(), descriptions)
+	a.processDropDown.SetSelected(uint(selected))
+}
+
+// chooseProcess returns the pid to attach to, or false when the candidates
+// are ambiguous and the user has not picked one yet. The process already
+// attached to is kept while it is still a candidate.
+func (a *Application) chooseProcess(candidates []game.Candidate) (int, bool) {
+	picked := int(a.pickedPID.Load())
+	for _, candidate := range candidates {
+		if candidate.PID == picked {
+			return picked, true
+		}
+	}
+
+	if !game.Ambiguous(candidates) {
+		return candidates[0].PID, true
+	}
+	for _, candidate := range candidates {
+		if candidate.PID == a.session.PID() {
+			return candidate.PID, true
+		}
+	}
+	return 0, false
+}
+
+// sameCandidates reports whether the picker already lists the same processes
+// in the same order, so the user's selection is not reset on every poll.
+func sameCandidates(a, b []game.Candidate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].PID != b[i].PID || a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
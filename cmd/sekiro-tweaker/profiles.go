@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+)
+
+// newProfileControls builds the profile selector and an expander with the
+// less frequent profile actions.
+func (a *Application) newProfileControls() gtk.Widgetter {
+	column := gtk.NewBox(gtk.OrientationVertical, 5)
+
+	row := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	row.Append(gtk.NewLabel("Profile:"))
+
+	a.profileNames = gtk.NewStringList(nil)
+	a.profileDropDown = gtk.NewDropDownFromStrings(nil)
+	a.profileDropDown.SetModel(a.profileNames)
+	a.profileDropDown.SetHExpand(true)
+	a.profileDropDown.SetTooltipText("Switching applies the profile and reverts the features it disables")
+	a.profileDropDown.NotifyProperty("selected", func() {
+		if a.updatingProfiles {
+			return
+		}
+		name := a.profileNames.String(a.profileDropDown.Selected())
+		if name != "" && name != config.ActiveProfile() {
+			a.switchProfile(name)
+		}
+	})
+	row.Append(a.profileDropDown)
+	column.Append(row)
+
+	expander := gtk.NewExpander("Manage Profiles")
+	box := gtk.NewBox(gtk.OrientationVertical, 5)
+	box.SetMarginStart(15)
+	box.SetMarginTop(5)
+
+	saveRow := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	a.profileEntry = gtk.NewEntry()
+	a.profileEntry.SetPlaceholderText("New profile name")
+	a.profileEntry.SetHExpand(true)
+	saveRow.Append(a.profileEntry)
+	saveButton := gtk.NewButtonWithLabel("Save As")
+	saveButton.SetTooltipText("Save the current settings as a new profile and switch to it")
+	saveButton.ConnectClicked(func() { a.saveProfileAs() })
+	saveRow.Append(saveButton)
+	box.Append(saveRow)
+
+	actionsRow := gtk.NewBox(gtk.OrientationHorizontal, 10)
+	importButton := gtk.NewButtonWithLabel("Import...")
+	importButton.ConnectClicked(func() { a.importProfile() })
+	actionsRow.Append(importButton)
+	exportButton := gtk.NewButtonWithLabel("Export...")
+	exportButton.ConnectClicked(func() { a.exportProfile() })
+	actionsRow.Append(exportButton)
+	deleteButton := gtk.NewButtonWithLabel("Delete")
+	deleteButton.AddCSSClass("destructive-action")
+	deleteButton.SetTooltipText("Delete the selected profile and switch to the default one")
+	deleteButton.ConnectClicked(func() { a.deleteProfile() })
+	actionsRow.Append(deleteButton)
+	box.Append(actionsRow)
+
+	expander.SetChild(box)
+	column.Append(expander)
+	return column
+}
+
+// refreshProfiles fills the selector and selects the active profile.
+func (a *Application) refreshProfiles() {
+	names, err := config.ProfileNames()
+	if err != nil {
+		a.showError("Profiles: " + err.Error())
+	}
+	active := config.ActiveProfile()
+
+	a.updatingProfiles = true
+	defer func() { a.updatingProfiles = false }()
+
+	a.profileNames.Splice(0, a.profileNames.NItems(), names)
+	for i, name := range names {
+		if name == active {
+			a.profileDropDown.SetSelected(uint(i))
+		}
+	}
+}
+
+// switchProfile makes a profile active and applies it to the game, if one is
+// running. The server reports back through OnProfileChange, which reloads
+// the controls.
+func (a *Application) switchProfile(name string) {
+	server := a.server
+	a.statusLabel.SetText("Switching to profile " + name + "...")
+
+	go func() {
+		err := server.SwitchProfile(name)
+		if err != nil {
+			glib.IdleAdd(func() { a.showError("Switching profile: " + err.Error()) })
+		}
+	}()
+}
+
+func (a *Application) saveProfileAs() {
+	name := a.profileEntry.Text()
+	if err := config.ValidateProfileName(name); err != nil {
+		a.showError(err.Error())
+		return
+	}
+	if config.ProfileExists(name) {
+		a.showError("Profile " + name + " already exists")
+		return
+	}
+
+	if err := a.currentConfig().SaveProfile(name); err != nil {
+		a.showError("Profile " + name + ": " + err.Error())
+		return
+	}
+	if err := config.SetActiveProfile(name); err != nil {
+		a.showError("Profile " + name + ": " + err.Error())
+		return
+	}
+
+	a.profileEntry.SetText("")
+	a.refreshProfiles()
+	a.statusLabel.SetText("Saved profile " + name)
+}
+
+func (a *Application) deleteProfile() {
+	name := config.ActiveProfile()
+	if err := config.DeleteProfile(name); err != nil {
+		a.showError(err.Error())
+		return
+	}
+	a.switchProfile(config.DefaultProfile)
+}
+
+func (a *Application) importProfile() {
+	a.chooseFile("Import Profile", gtk.FileChooserActionOpen, "Import", "", func(path string) {
+		name, err := config.ImportProfile(path, "")
+		if err != nil {
+			a.showError("Import: " + err.Error())
+			return
+		}
+		a.refreshProfiles()
+		a.statusLabel.SetText("Imported profile " + name)
+	})
+}
+
+func (a *Application) exportProfile() {
+	name := config.ActiveProfile()
+	a.chooseFile("Export Profile", gtk.FileChooserActionSave, "Export", name+".yaml", func(path string) {
+		if err := config.ExportProfile(name, path); err != nil {
+			a.showError("Export: " + err.Error())
+			return
+		}
+		a.statusLabel.SetText("Exported profile " + name + " to " + path)
+	})
+}
+
+// chooseFile shows a native file dialog and calls chosen with the selected
+// path if the user accepts.
+func (a *Application) chooseFile(title string, action gtk.FileChooserAction, accept, currentName string, chosen func(path string)) {
+	dialog := gtk.NewFileChooserNative(title, &a.window.Window, action, accept, "Cancel")
+	if currentName != "" {
+		dialog.SetCurrentName(currentName)
+	}
+	dialog.ConnectResponse(func(response int) {
+		a.fileDialog = nil
+		if response != int(gtk.ResponseAccept) {
+			return
+		}
+		if file := dialog.File(); file != nil {
+			chosen(file.Path())
+		}
+	})
+
+	a.fileDialog = dialog
+	dialog.Show()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+var liveStateClasses = []string{"success", "warning", "error", "dim-label"}
+
+// reportStatus shows the live state of every feature on its row.
+func (a *Application) reportStatus(monitor *game.StatusMonitor) {
+	for statuses := range monitor.Updates() {
+		glib.IdleAdd(func() {
+			if a.statusMonitor != monitor {
+				return
+			}
+
+			byID := make(map[string]game.LiveStatus, len(statuses))
+			for _, status := range statuses {
+				byID[status.Feature] = status
+			}
+			for _, control := range a.features {
+				if status, exists := byID[control.feature.ID()]; exists {
+					showLiveStatus(control, &status)
+				}
+			}
+		})
+	}
+}
+
+// showLiveStatus sets a row's status indicator. A nil status means no game is
+// attached. Hovering the indicator shows the memory the feature patches.
+func showLiveStatus(control *featureControl, status *game.LiveStatus) {
+	for _, class := range liveStateClasses {
+		control.status.RemoveCSSClass(class)
+	}
+
+	if status == nil {
+		control.status.SetText("-")
+		control.status.AddCSSClass("dim-label")
+		control.status.SetTooltipText("Game not running")
+		return
+	}
+
+	text, class := "Vanilla", "dim-label"
+	switch status.State {
+	case game.LiveUnavailable:
+		text, class = "Unavailable", "error"
+	case game.LiveApplied:
+		text, class = "Applied", "success"
+	case game.LiveDrifted:
+		text, class = "Drifted", "warning"
+	case game.LiveWaiting:
+		text = "Waiting for world"
+	case game.LiveForeign:
+		text, class = "Changed elsewhere", "warning"
+	}
+	control.status.SetText(text)
+	control.status.AddCSSClass(class)
+
+	var tooltip []string
+	if status.Reason != "" {
+		tooltip = append(tooltip, status.Reason)
+	}
+	for _, region := range status.Regions {
+		line := fmt.Sprintf("0x%X: % X", region.Address, []byte(region.Bytes))
+		if region.Expected != nil && !bytes.Equal(region.Expected, region.Bytes) {
+			line += fmt.Sprintf(" (expected % X)", []byte(region.Expected))
+		}
+		if status.State == game.LiveForeign && region.Patched != nil {
+			line += fmt.Sprintf(" (vanilla % X, patched % X)", []byte(region.Vanilla), []byte(region.Patched))
+		}
+		tooltip = append(tooltip, line)
+	}
+	if len(tooltip) == 0 {
+		tooltip = append(tooltip, "No memory found for this feature")
+	}
+	control.status.SetTooltipText(strings.Join(tooltip, "\n"))
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Client talks to a Server. Calls are made one at a time; events received
+// while waiting for a response are kept for NextEvent.
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+	nextID  int
+	events  []Event
+}
+
+// Dial connects to the socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("no tweaker is listening on %s: %v", path, err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxMessageSize)
+	return &Client{conn: conn, scanner: scanner}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call invokes a method and decodes its result into result, unless result is
+// nil. Errors returned by the server are *Error.
+func (c *Client) Call(method string, params, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	request := message{JSONRPC: jsonrpcVersion, ID: id, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		request.Params = raw
+	}
+
+	if err := json.NewEncoder(c.conn).Encode(request); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "event" {
+			c.queueEvent(msg)
+			continue
+		}
+		if string(msg.ID) != string(id) {
+			continue
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil || len(msg.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// NextEvent blocks until the server sends an event. Subscribe with
+// events.subscribe first. It returns io.EOF once the server closes the
+// connection.
+func (c *Client) NextEvent() (Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.events) == 0 {
+		msg, err := c.read()
+		if err != nil {
+			return Event{}, err
+		}
+		if msg.Method == "event" {
+			c.queueEvent(msg)
+		}
+	}
+
+	event := c.events[0]
+	c.events = c.events[1:]
+	return event, nil
+}
+
+func (c *Client) read() (message, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return message{}, err
+		}
+		return message{}, io.EOF
+	}
+
+	var msg message
+	if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+		return message{}, fmt.Errorf("invalid message from server: %v", err)
+	}
+	return msg, nil
+}
+
+func (c *Client) queueEvent(msg message) {
+	var event Event
+	if err := json.Unmarshal(msg.Params, &event); err == nil {
+		c.events = append(c.events, event)
+	}
+}
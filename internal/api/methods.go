@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+type method func(s *Server, c *conn, params json.RawMessage) (any, error)
+
+// methods lists the API. Feature methods take {"feature": id} and, where it
+// makes sense, {"params": {...}}; parameters that are left out keep their
+// current value.
+var methods = map[string]method{
+	"game.status":        gameStatus,
+	"features.list":      listFeatures,
+	"features.enable":    enableFeature,
+	"features.disable":   disableFeature,
+	"features.toggle":    toggleFeature,
+	"features.set":       setFeatureParams,
+	"profiles.list":      listProfiles,
+	"profiles.switch":    switchProfile,
+	"stats.read":         readStats,
+	"events.subscribe":   subscribe,
+	"events.unsubscribe": unsubscribe,
+}
+
+// call runs a request and builds its response without the ID.
+func (s *Server) call(c *conn, request message) message {
+	response := message{JSONRPC: jsonrpcVersion}
+
+	if request.JSONRPC != jsonrpcVersion || request.Method == "" {
+		response.Error = errorf(CodeInvalidRequest, "expected a JSON-RPC 2.0 request")
+		return response
+	}
+
+	handler, exists := methods[request.Method]
+	if !exists {
+		response.Error = errorf(CodeMethodNotFound, "unknown method %q", request.Method)
+		return response
+	}
+
+	result, err := handler(s, c, request.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = errorf(CodeFeatureFailed, "%v", err)
+		}
+		response.Error = rpcErr
+		return response
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		response.Error = errorf(CodeInvalidRequest, "failed to encode result: %v", err)
+		return response
+	}
+	response.Result = raw
+	return response
+}
+
+func gameStatus(s *Server, c *conn, params json.RawMessage) (any, error) {
+	if !s.session.Attached() {
+		return GameStatus{}, nil
+	}
+	return GameStatus{Attached: true, PID: s.session.PID(), State: s.GameState()}, nil
+}
+
+func listFeatures(s *Server, c *conn, params json.RawMessage) (any, error) {
+	states := []FeatureState{}
+	for _, feature := range game.Features() {
+		states = append(states, s.featureState(feature, nil))
+	}
+	return states, nil
+}
+
+type featureParams struct {
+	Feature string      `json:"feature"`
+	Params  game.Params `json:"params"`
+}
+
+func parseFeatureParams(raw json.RawMessage) (game.Feature, game.Params, error) {
+	var params featureParams
+	if len(raw) == 0 {
+		return nil, nil, errorf(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, nil, errorf(CodeInvalidParams, "%v", err)
+	}
+
+	feature, exists := game.LookupFeature(params.Feature)
+	if !exists {
+		return nil, nil, errorf(CodeInvalidParams, "unknown feature %q", params.Feature)
+	}
+	for id := range params.Params {
+		known := false
+		for _, param := range feature.Params() {
+			known = known || param.ID == id
+		}
+		if !known {
+			return nil, nil, errorf(CodeInvalidParams, "feature %s has no parameter %q", feature.ID(), id)
+		}
+	}
+	return feature, params.Params, nil
+}
+
+func enableFeature(s *Server, c *conn, raw json.RawMessage) (any, error) {
+	feature, params, err := parseFeatureParams(raw)
+	if err != nil {
+		return nil, err
+	}
+	return s.changeFeature(feature, func(enabled bool) bool { return true }, params)
+}
+
+func disableFeature(s *Server, c *conn, raw json.RawMessage) (any, error) {
+	feature, params, err := parseFeatureParams(raw)
+	if err != nil {
+		return nil, err
+	}
+	return s.changeFeature(feature, func(enabled bool) bool { return false }, params)
+}
+
+func toggleFeature(s *Server, c *conn, raw json.RawMessage) (any, error) {
+	feature, params, err := parseFeatureParams(raw)
+	if err != nil {
+		return nil, err
+	}
+	return s.changeFeature(feature, func(enabled bool) bool { return !enabled }, params)
+}
+
+func setFeatureParams(s *Server, c *conn, raw json.RawMessage) (any, error) {
+	feature, params, err := parseFeatureParams(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		return nil, errorf(CodeInvalidParams, "no parameters to set")
+	}
+	return s.changeFeature(feature, func(enabled bool) bool { return enabled }, params)
+}
+
+// changeFeature records a feature's new state and, if a game is attached,
+// applies or reverts it. New parameter values of an enabled feature are
+// written in place where the feature supports it.
+func (s *Server) changeFeature(feature game.Feature, enable func(bool) bool, params game.Params) (any, error) {
+	result, err := s.applyChange(feature, enable, params, true)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyChange implements changeFeature. notify is false for changes made in
+// the owner's controls, which already show them.
+func (s *Server) applyChange(feature game.Feature, enable func(bool) bool, params game.Params, notify bool) (FeatureState, error) {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+
+	enabled, merged := s.requested(feature)
+	for id, value := range params {
+		merged[id] = value
+	}
+	if _, err := game.ResolveParams(feature, merged); err != nil {
+		return FeatureState{}, errorf(CodeInvalidParams, "%v", err)
+	}
+	enabled = enable(enabled)
+
+	s.mu.Lock()
+	state := s.features[feature.ID()]
+	state.enabled = enabled
+	state.params = merged
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	err := s.session.Update(game.FeatureSetting{Feature: feature, Enabled: enabled, Params: merged})
+	if errors.Is(err, game.ErrNotAttached) {
+		err = nil
+	} else {
+		s.setApplied(feature, enabled)
+	}
+
+	result := s.featureState(feature, err)
+	if notify && onChange != nil {
+		onChange(result)
+	}
+	s.Publish(EventFeatureChanged, result)
+
+	if err != nil {
+		return result, errorf(CodeFeatureFailed, "%s: %v", feature.ID(), err)
+	}
+	return result, nil
+}
+
+func listProfiles(s *Server, c *conn, params json.RawMessage) (any, error) {
+	names, err := config.ProfileNames()
+	if err != nil {
+		return nil, err
+	}
+	return ProfilesResult{Active: config.ActiveProfile(), Profiles: names}, nil
+}
+
+func switchProfile(s *Server, c *conn, raw json.RawMessage) (any, error) {
+	var params ProfileData
+	if len(raw) == 0 {
+		return nil, errorf(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, errorf(CodeInvalidParams, "%v", err)
+	}
+	if !config.ProfileExists(params.Name) {
+		return nil, errorf(CodeInvalidParams, "profile %q does not exist", params.Name)
+	}
+
+	if err := s.SwitchProfile(params.Name); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func readStats(s *Server, c *conn, params json.RawMessage) (any, error) {
+	stats, err := s.session.ReadStats()
+	if err != nil {
+		return nil, errorf(CodeNoGame, "%v", err)
+	}
+	return StatsResult{PID: s.session.PID(), Stats: stats}, nil
+}
+
+func subscribe(s *Server, c *conn, params json.RawMessage) (any, error) {
+	c.subscribed.Store(true)
+	return map[string]bool{"subscribed": true}, nil
+}
+
+func unsubscribe(s *Server, c *conn, params json.RawMessage) (any, error) {
+	c.subscribed.Store(false)
+	return map[string]bool{"subscribed": false}, nil
+}
+
+// MethodNames returns the method names in sorted order.
+func MethodNames() []string {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,136 @@
+// Package api serves a JSON-RPC 2.0 control interface on a Unix socket, so
+// hotkey daemons, stream deck scripts and other tools can drive the tweaker
+// with or without the GUI.
+//
+// Every message is a single JSON object terminated by a newline. Events are
+// sent to subscribed connections as "event" notifications.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+const jsonrpcVersion = "2.0"
+
+// Error codes. The first four are defined by JSON-RPC 2.0.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	// CodeNoGame means the method needs a running game.
+	CodeNoGame = -32000
+	// CodeFeatureFailed means a feature could not be applied or reverted.
+	// The feature's new state is still recorded.
+	CodeFeatureFailed = -32001
+)
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+func errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// message is any JSON-RPC message: a request, a notification or a response.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Event types.
+const (
+	EventGameAttached   = "game.attached"
+	EventGameDetached   = "game.detached"
+	EventFeatureChanged = "feature.changed"
+	EventProfileChanged = "profile.changed"
+	EventConfigReloaded = "config.reloaded"
+	EventDrift          = "drift"
+	EventGame           = "game.event"
+	EventGameState      = "game.state"
+)
+
+// Event is the params of an "event" notification.
+type Event struct {
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// FeatureState is a feature's requested state and whether it is applied.
+type FeatureState struct {
+	Feature string             `json:"feature"`
+	Name    string             `json:"name"`
+	Enabled bool               `json:"enabled"`
+	Params  game.Params        `json:"params"`
+	Status  game.FeatureStatus `json:"status"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// GameStatus is the result of game.status and the data of game events.
+type GameStatus struct {
+	Attached bool           `json:"attached"`
+	PID      int            `json:"pid,omitempty"`
+	State    game.GameState `json:"state,omitempty"`
+}
+
+// GameStateData is the data of a game state event.
+type GameStateData struct {
+	State    game.GameState `json:"state"`
+	Previous game.GameState `json:"previous"`
+}
+
+// ProfileData is the data of profile and config events.
+type ProfileData struct {
+	Name string `json:"name"`
+}
+
+// ProfilesResult is the result of profiles.list.
+type ProfilesResult struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+// StatsResult is the result of stats.read.
+type StatsResult struct {
+	PID int `json:"pid"`
+	game.Stats
+}
+
+// DriftData is the data of a drift event.
+type DriftData struct {
+	Feature  string        `json:"feature"`
+	Address  string        `json:"address"`
+	Expected game.HexBytes `json:"expected"`
+	Actual   game.HexBytes `json:"actual"`
+	Healed   bool          `json:"healed"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// GameEventData is the data of an event reported by a hook.
+type GameEventData struct {
+	Type    string   `json:"type"`
+	Seq     uint64   `json:"seq"`
+	Payload []uint64 `json:"payload"`
+}
+
+// SocketPath returns the default socket path.
+func SocketPath() string {
+	return filepath.Join(game.RuntimeDir(), "control.sock")
+}
@@ -0,0 +1,533 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// maxMessageSize bounds a single request line.
+const maxMessageSize = 1 << 20
+
+// outgoingBuffer is how many messages may wait for a slow client before
+// events to it are dropped.
+const outgoingBuffer = 64
+
+// Server holds the requested state of every feature and applies changes to
+// the game attached to its session. The owner (the GUI or a headless command)
+// attaches and detaches the game, reports it with SetAttached and forwards
+// watchdog and hook events.
+type Server struct {
+	mu              sync.Mutex
+	session         *game.Session
+	features        map[string]*requestedState
+	onChange        func(FeatureState)
+	onProfileChange func(string)
+	listener        net.Listener
+	path            string
+	conns           map[*conn]struct{}
+	state           game.GameState
+
+	// opMu keeps patch operations from different clients from interleaving.
+	opMu sync.Mutex
+}
+
+type requestedState struct {
+	enabled bool
+	params  game.Params
+	// applied is set once the feature was applied to the attached game, or
+	// tried to be. Features that need the world are applied again when it
+	// loads.
+	applied bool
+}
+
+// NewServer returns a server whose feature state starts out as cfg and that
+// patches the game attached to session.
+func NewServer(cfg *config.Config, session *game.Session) *Server {
+	s := &Server{
+		session:  session,
+		features: make(map[string]*requestedState),
+		conns:    make(map[*conn]struct{}),
+	}
+	s.LoadConfig(cfg)
+	return s
+}
+
+// LoadConfig replaces the requested state of every feature with cfg without
+// applying it.
+func (s *Server) LoadConfig(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, feature := range game.Features() {
+		state := &requestedState{enabled: feature.DefaultEnabled(), params: game.DefaultParams(feature)}
+		if previous, exists := s.features[feature.ID()]; exists {
+			state.applied = previous.applied
+		}
+		if saved, exists := cfg.Features[feature.ID()]; exists {
+			state.enabled = saved.Enabled
+			for id, value := range saved.Params {
+				state.params[id] = value
+			}
+		}
+		s.features[feature.ID()] = state
+	}
+}
+
+// OnChange registers a function called after a client changes a feature, so
+// the GUI can update its controls. It runs on the connection's goroutine.
+func (s *Server) OnChange(fn func(FeatureState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onChange = fn
+}
+
+// OnProfileChange registers a function called after SwitchProfile changes the
+// active profile. It runs on the goroutine that called SwitchProfile.
+func (s *Server) OnProfileChange(fn func(name string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onProfileChange = fn
+}
+
+// SwitchProfile makes a profile active and, if a game is attached, applies
+// it, reverting the features it disables.
+//
+// Problems reading the profile are returned after it is applied, since the
+// settings that could be read are still used.
+func (s *Server) SwitchProfile(name string) error {
+	cfg, loadErr := config.LoadProfile(name)
+	if cfg == nil {
+		return loadErr
+	}
+	if err := config.SetActiveProfile(name); err != nil {
+		return err
+	}
+	s.LoadConfig(cfg)
+
+	s.mu.Lock()
+	onProfileChange := s.onProfileChange
+	s.mu.Unlock()
+	attached := s.session.Attached()
+
+	if onProfileChange != nil {
+		onProfileChange(name)
+	}
+	s.Publish(EventProfileChanged, ProfileData{Name: name})
+
+	if loadErr != nil {
+		loadErr = fmt.Errorf("profile %s: %v", name, loadErr)
+	}
+	if !attached {
+		return loadErr
+	}
+	return errors.Join(loadErr, s.ApplyAll())
+}
+
+// ReloadConfig replaces the requested state with a profile that changed on
+// disk and, if apply is set and a game is attached, applies it.
+func (s *Server) ReloadConfig(name string, cfg *config.Config, apply bool) error {
+	s.LoadConfig(cfg)
+	s.Publish(EventConfigReloaded, ProfileData{Name: name})
+
+	if !apply || !s.session.Attached() {
+		return nil
+	}
+	return s.ApplyAll()
+}
+
+// SetAttached tells subscribers that the session attached to a game, or
+// detached from it.
+func (s *Server) SetAttached(attached bool) {
+	s.mu.Lock()
+	s.state = game.StateUnknown
+	for _, state := range s.features {
+		state.applied = false
+	}
+	s.mu.Unlock()
+
+	if attached {
+		s.Publish(EventGameAttached, GameStatus{Attached: true, PID: s.session.PID()})
+	} else {
+		s.Publish(EventGameDetached, GameStatus{})
+	}
+}
+
+// GameState returns the state last reported with SetGameState.
+func (s *Server) GameState() game.GameState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// SetGameState tells subscribers that the game's state changed. Once the
+// player is loaded into the world, the features that need it and were
+// applied before are applied again, since applying them at the main menu
+// fails and a loading screen resets them.
+func (s *Server) SetGameState(change game.StateChange) error {
+	s.mu.Lock()
+	s.state = change.To
+	s.mu.Unlock()
+	s.Publish(EventGameState, GameStateData{State: change.To, Previous: change.From})
+
+	if !change.To.InWorld() || change.From.InWorld() {
+		return nil
+	}
+	return s.applyInGame()
+}
+
+// applyInGame applies the features that require being in game and were
+// applied since the game was attached.
+func (s *Server) applyInGame() error {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+
+	var errs []error
+	for _, feature := range game.Features() {
+		if !game.HasRequirement(feature, game.RequiresInGame) {
+			continue
+		}
+		s.mu.Lock()
+		applied := s.features[feature.ID()].applied
+		s.mu.Unlock()
+		enabled, params := s.requested(feature)
+		if !enabled || !applied {
+			continue
+		}
+
+		err := s.session.Apply(game.FeatureSetting{Feature: feature, Enabled: true, Params: params})
+		if errors.Is(err, game.ErrNotAttached) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", feature.ID(), err))
+		}
+		s.Publish(EventFeatureChanged, s.featureState(feature, err))
+	}
+	return errors.Join(errs...)
+}
+
+// SetFeatureState records a change made outside the API, e.g. in the GUI,
+// that the owner applies to the attached game itself.
+func (s *Server) SetFeatureState(feature game.Feature, enabled bool, params game.Params) {
+	s.mu.Lock()
+	state := s.features[feature.ID()]
+	state.enabled = enabled
+	state.applied = enabled
+	for id, value := range params {
+		state.params[id] = value
+	}
+	s.mu.Unlock()
+
+	s.Publish(EventFeatureChanged, s.featureState(feature, nil))
+}
+
+// ChangeFeature records a change made in the owner's controls and applies or
+// reverts the feature right away, as the features.* methods do.
+func (s *Server) ChangeFeature(feature game.Feature, enabled bool, params game.Params) error {
+	_, err := s.applyChange(feature, func(bool) bool { return enabled }, params, false)
+	return err
+}
+
+// ApplyAll applies every enabled feature and reverts the disabled ones that
+// are applied, like the GUI's Apply button.
+func (s *Server) ApplyAll() error {
+	s.opMu.Lock()
+	defer s.opMu.Unlock()
+
+	if !s.session.Attached() {
+		return game.ErrNotAttached
+	}
+
+	var errs []error
+	for _, feature := range game.Features() {
+		enabled, params := s.requested(feature)
+
+		err := s.session.Apply(game.FeatureSetting{Feature: feature, Enabled: enabled, Params: params})
+		if errors.Is(err, game.ErrNotAttached) {
+			return err
+		}
+		s.setApplied(feature, enabled)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", feature.ID(), err))
+		}
+		s.Publish(EventFeatureChanged, s.featureState(feature, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) setApplied(feature game.Feature, applied bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.features[feature.ID()].applied = applied
+}
+
+// requested returns a copy of a feature's requested state.
+func (s *Server) requested(feature game.Feature) (bool, game.Params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.features[feature.ID()]
+	params := make(game.Params, len(state.params))
+	for id, value := range state.params {
+		params[id] = value
+	}
+	return state.enabled, params
+}
+
+func (s *Server) featureState(feature game.Feature, err error) FeatureState {
+	enabled, params := s.requested(feature)
+	state := FeatureState{
+		Feature: feature.ID(),
+		Name:    feature.Name(),
+		Enabled: enabled,
+		Params:  params,
+	}
+
+	if status, statusErr := s.session.Status(feature); !errors.Is(statusErr, game.ErrNotAttached) {
+		state.Status = status
+		if err == nil {
+			err = statusErr
+		}
+	}
+	if err != nil {
+		state.Error = err.Error()
+	}
+	return state
+}
+
+// PublishDrift forwards a watchdog event to subscribers.
+func (s *Server) PublishDrift(event game.DriftEvent) {
+	data := DriftData{
+		Feature:  event.Feature,
+		Address:  fmt.Sprintf("0x%X", event.Address),
+		Expected: event.Expected,
+		Actual:   event.Actual,
+		Healed:   event.Healed,
+	}
+	if event.Err != nil {
+		data.Error = event.Err.Error()
+	}
+	s.Publish(EventDrift, data)
+}
+
+// PublishGameEvent forwards a hook event to subscribers.
+func (s *Server) PublishGameEvent(event game.GameEvent) {
+	s.Publish(EventGame, GameEventData{
+		Type:    event.Type.String(),
+		Seq:     event.Seq,
+		Payload: event.Payload[:],
+	})
+}
+
+// Publish sends an event to every subscribed connection. Connections that
+// are not keeping up miss the event.
+func (s *Server) Publish(eventType string, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		logger.Log.Error("Failed to encode event", zap.String("type", eventType), zap.Error(err))
+		return
+	}
+	params, err := json.Marshal(Event{Type: eventType, Time: time.Now(), Data: raw})
+	if err != nil {
+		return
+	}
+	notification := message{JSONRPC: jsonrpcVersion, Method: "event", Params: params}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if !c.subscribed.Load() {
+			continue
+		}
+		select {
+		case c.out <- notification:
+		default:
+			logger.Log.Debug("Dropped event for slow client", zap.String("type", eventType))
+		}
+	}
+}
+
+// Listen binds the socket at path. A stale socket left by a crashed process
+// is replaced, but one another process is serving is not.
+func (s *Server) Listen(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if existing, err := net.Dial("unix", path); err == nil {
+		_ = existing.Close()
+		return fmt.Errorf("another tweaker is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.path = path
+	s.mu.Unlock()
+
+	logger.Log.Info("Control API listening", zap.String("socket", path))
+	return nil
+}
+
+// Serve accepts connections until Close is called.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+
+	if listener == nil {
+		return fmt.Errorf("Serve called before Listen")
+	}
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		c := &conn{
+			server: s,
+			conn:   netConn,
+			out:    make(chan message, outgoingBuffer),
+			done:   make(chan struct{}),
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go c.handle()
+	}
+}
+
+// Close stops accepting connections, closes the open ones and removes the
+// socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	listener := s.listener
+	path := s.path
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.listener = nil
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+	if listener == nil {
+		return nil
+	}
+
+	err := listener.Close()
+	if removeErr := os.Remove(path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// conn is one client connection. Responses and events are written by a
+// single goroutine in the order they are queued.
+type conn struct {
+	server     *Server
+	conn       net.Conn
+	out        chan message
+	subscribed atomic.Bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *conn) handle() {
+	defer c.close()
+	go c.write()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxMessageSize)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var request message
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			c.send(message{JSONRPC: jsonrpcVersion, ID: json.RawMessage("null"),
+				Error: errorf(CodeParseError, "invalid JSON: %v", err)})
+			continue
+		}
+
+		response := c.server.call(c, request)
+		// Requests without an ID are notifications and get no response.
+		if request.ID != nil {
+			response.ID = request.ID
+			c.send(response)
+		}
+	}
+}
+
+func (c *conn) send(msg message) {
+	select {
+	case c.out <- msg:
+	case <-c.done:
+	}
+}
+
+func (c *conn) write() {
+	encoder := json.NewEncoder(c.conn)
+	for {
+		select {
+		case msg := <-c.out:
+			if err := encoder.Encode(msg); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.conn.Close()
+
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		c.server.mu.Unlock()
+	})
+}
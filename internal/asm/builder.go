@@ -0,0 +1,296 @@
+// Package asm is a small x86-64 assembler for code cave shellcode.
+//
+// Every instruction has a fixed encoding, so the size of a program is known
+// before it is placed. Labels, RIP-relative operands and branches are resolved
+// when the program is assembled against the cave's final address.
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Reg is a 64-bit general purpose register.
+type Reg uint8
+
+const (
+	RAX Reg = iota
+	RCX
+	RDX
+	RBX
+	RSP
+	RBP
+	RSI
+	RDI
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
+)
+
+// XMM is an SSE register.
+type XMM uint8
+
+const (
+	XMM0 XMM = iota
+	XMM1
+	XMM2
+	XMM3
+	XMM4
+	XMM5
+	XMM6
+	XMM7
+	XMM8
+	XMM9
+	XMM10
+	XMM11
+	XMM12
+	XMM13
+	XMM14
+	XMM15
+)
+
+// Cond is the condition of a conditional jump.
+type Cond uint8
+
+const (
+	Overflow     Cond = 0x0
+	Below        Cond = 0x2
+	AboveOrEqual Cond = 0x3
+	Equal        Cond = 0x4
+	NotEqual     Cond = 0x5
+	BelowOrEqual Cond = 0x6
+	Above        Cond = 0x7
+	Sign         Cond = 0x8
+	Less         Cond = 0xC
+	GreaterEqual Cond = 0xD
+	LessEqual    Cond = 0xE
+	Greater      Cond = 0xF
+)
+
+// Mem is a memory operand: [base+disp], [rip+label] or [rip+absolute].
+type Mem struct {
+	base     Reg
+	disp     int32
+	rip      bool
+	label    string
+	absolute int64
+}
+
+// Ptr addresses [base+disp].
+func Ptr(base Reg, disp int32) Mem {
+	return Mem{base: base, disp: disp}
+}
+
+// RIPLabel addresses a label in the same program relative to RIP.
+func RIPLabel(label string) Mem {
+	return Mem{rip: true, label: label}
+}
+
+// RIPAbsolute addresses a fixed location such as a game global relative to
+// RIP. It must be within ±2GB of the cave.
+func RIPAbsolute(address int64) Mem {
+	return Mem{rip: true, absolute: address}
+}
+
+// fixup is a rel32 field patched once the program's base address is known.
+type fixup struct {
+	pos      int // offset of the rel32 field
+	end      int // offset the displacement is relative to
+	label    string
+	absolute int64 // used when label is empty
+}
+
+// Builder accumulates instructions. Methods return the builder so calls can
+// be chained; the first encoding error is reported by Assemble.
+type Builder struct {
+	code   []byte
+	labels map[string]int
+	fixups []fixup
+	err    error
+}
+
+func New() *Builder {
+	return &Builder{labels: make(map[string]int)}
+}
+
+// Len returns the size of the program in bytes.
+func (b *Builder) Len() int {
+	return len(b.code)
+}
+
+// Assemble resolves labels and RIP-relative references for a program placed
+// at base and returns its bytes.
+func (b *Builder) Assemble(base int64) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	code := append([]byte(nil), b.code...)
+	for _, f := range b.fixups {
+		target := f.absolute
+		if f.label != "" {
+			offset, exists := b.labels[f.label]
+			if !exists {
+				return nil, fmt.Errorf("undefined label %q", f.label)
+			}
+			target = base + int64(offset)
+		}
+
+		rel := target - (base + int64(f.end))
+		if rel < math.MinInt32 || rel > math.MaxInt32 {
+			return nil, fmt.Errorf("target 0x%X is out of rel32 range of 0x%X", target, base+int64(f.end))
+		}
+		binary.LittleEndian.PutUint32(code[f.pos:], uint32(int32(rel)))
+	}
+
+	return code, nil
+}
+
+// Bytes assembles a position-independent program. It fails if the program
+// references absolute addresses, which need Assemble with the real base.
+func (b *Builder) Bytes() ([]byte, error) {
+	for _, f := range b.fixups {
+		if f.label == "" {
+			return nil, fmt.Errorf("program references absolute address 0x%X", f.absolute)
+		}
+	}
+	return b.Assemble(0)
+}
+
+// MustBytes is like Bytes but panics on error. It is meant for shellcode
+// defined in package variables.
+func (b *Builder) MustBytes() []byte {
+	code, err := b.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+func (b *Builder) fail(format string, args ...any) *Builder {
+	if b.err == nil {
+		b.err = fmt.Errorf(format, args...)
+	}
+	return b
+}
+
+// Label marks the current position.
+func (b *Builder) Label(name string) *Builder {
+	if _, exists := b.labels[name]; exists {
+		return b.fail("duplicate label %q", name)
+	}
+	b.labels[name] = len(b.code)
+	return b
+}
+
+// Raw emits bytes verbatim.
+func (b *Builder) Raw(data ...byte) *Builder {
+	b.code = append(b.code, data...)
+	return b
+}
+
+func (b *Builder) Float32(value float32) *Builder {
+	return b.Raw(binary.LittleEndian.AppendUint32(nil, math.Float32bits(value))...)
+}
+
+func (b *Builder) Uint32(value uint32) *Builder {
+	return b.Raw(binary.LittleEndian.AppendUint32(nil, value)...)
+}
+
+func (b *Builder) Uint64(value uint64) *Builder {
+	return b.Raw(binary.LittleEndian.AppendUint64(nil, value)...)
+}
+
+// rex returns the REX prefix for the given extension bits, or 0 if none is
+// needed.
+func rex(w bool, reg, base uint8) byte {
+	prefix := byte(0x40)
+	if w {
+		prefix |= 0x08
+	}
+	if reg&8 != 0 {
+		prefix |= 0x04
+	}
+	if base&8 != 0 {
+		prefix |= 0x01
+	}
+	if prefix == 0x40 {
+		return 0
+	}
+	return prefix
+}
+
+// emitMem emits prefix bytes, opcode, ModRM/SIB/displacement for an
+// instruction with a memory operand, followed by trailing immediate bytes.
+func (b *Builder) emitMem(prefix []byte, w bool, opcode []byte, reg uint8, m Mem, imm []byte) *Builder {
+	b.code = append(b.code, prefix...)
+	base := uint8(m.base)
+	if m.rip {
+		base = 0
+	}
+	if r := rex(w, reg, base); r != 0 {
+		b.code = append(b.code, r)
+	}
+	b.code = append(b.code, opcode...)
+
+	if m.rip {
+		b.code = append(b.code, (reg&7)<<3|0x05)
+		pos := len(b.code)
+		b.code = append(b.code, 0, 0, 0, 0)
+		b.fixups = append(b.fixups, fixup{pos: pos, end: pos + 4 + len(imm), label: m.label, absolute: m.absolute})
+		b.code = append(b.code, imm...)
+		return b
+	}
+
+	var mod uint8
+	switch {
+	case m.disp == 0 && base&7 != 5: // [rbp]/[r13] have no mod=00 form
+		mod = 0
+	case m.disp >= math.MinInt8 && m.disp <= math.MaxInt8:
+		mod = 1
+	default:
+		mod = 2
+	}
+
+	b.code = append(b.code, mod<<6|(reg&7)<<3|base&7)
+	if base&7 == 4 { // [rsp]/[r12] need a SIB byte
+		b.code = append(b.code, 0x24)
+	}
+
+	switch mod {
+	case 1:
+		b.code = append(b.code, byte(int8(m.disp)))
+	case 2:
+		b.code = binary.LittleEndian.AppendUint32(b.code, uint32(m.disp))
+	}
+
+	b.code = append(b.code, imm...)
+	return b
+}
+
+// emitReg emits an instruction whose ModRM addresses two registers.
+func (b *Builder) emitReg(prefix []byte, w bool, opcode []byte, reg, rm uint8, imm []byte) *Builder {
+	b.code = append(b.code, prefix...)
+	if r := rex(w, reg, rm); r != 0 {
+		b.code = append(b.code, r)
+	}
+	b.code = append(b.code, opcode...)
+	b.code = append(b.code, 0xC0|(reg&7)<<3|rm&7)
+	b.code = append(b.code, imm...)
+	return b
+}
+
+// emitRel emits an instruction ending in a rel32 to a label or address.
+func (b *Builder) emitRel(opcode []byte, label string, absolute int64) *Builder {
+	b.code = append(b.code, opcode...)
+	pos := len(b.code)
+	b.code = append(b.code, 0, 0, 0, 0)
+	b.fixups = append(b.fixups, fixup{pos: pos, end: pos + 4, label: label, absolute: absolute})
+	return b
+}
@@ -0,0 +1,131 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// caveBases are addresses a cave may be placed at: low memory, next to the
+// game module and high in the address space.
+var caveBases = []int64{0, 0x10000, 0x13FFF0000, 0x7FF6A1230000}
+
+func rel32(code []byte, pos int) int32 {
+	return int32(binary.LittleEndian.Uint32(code[pos:]))
+}
+
+func TestAssembleLabels(t *testing.T) {
+	b := New().
+		Label("top").
+		Jcc(Equal, "done"). // 0: 0F 84 rel32
+		Call("helper").     // 6: E8 rel32
+		Jmp("top").         // 11: E9 rel32
+		Label("helper").
+		Ret(). // 16
+		Label("done").
+		MovssLoad(XMM0, RIPLabel("value")). // 17: F3 0F 10 05 rel32
+		Ret().                              // 25
+		Label("value").
+		Float32(1.5) // 26
+
+	for _, base := range caveBases {
+		code, err := b.Assemble(base)
+		if err != nil {
+			t.Fatalf("Assemble(0x%X): %v", base, err)
+		}
+		if len(code) != b.Len() {
+			t.Fatalf("Assemble(0x%X) returned %d bytes, Len is %d", base, len(code), b.Len())
+		}
+
+		// Label references are relative, so they do not depend on the base.
+		for _, tt := range []struct {
+			name string
+			pos  int
+			want int32
+		}{
+			{"jcc done", 2, 17 - 6},
+			{"call helper", 7, 16 - 11},
+			{"jmp top", 12, 0 - 16},
+			{"movss value", 21, 26 - 25},
+		} {
+			if got := rel32(code, tt.pos); got != tt.want {
+				t.Errorf("base 0x%X: %s rel32 = %d, want %d", base, tt.name, got, tt.want)
+			}
+		}
+		if !bytes.Equal(code[17:21], []byte{0xF3, 0x0F, 0x10, 0x05}) {
+			t.Errorf("base 0x%X: movss [rip] = % X", base, code[17:21])
+		}
+		if got := math.Float32frombits(binary.LittleEndian.Uint32(code[26:])); got != 1.5 {
+			t.Errorf("base 0x%X: value = %v, want 1.5", base, got)
+		}
+	}
+}
+
+func TestAssembleAbsolute(t *testing.T) {
+	const target = 0x140123456
+
+	// Caves before and after the target, up to almost 2GB away.
+	for _, base := range []int64{0x13FFF0000, 0x140200000, 0x1C0000000} {
+		b := New().
+			MovLoad(RAX, RIPAbsolute(target)).              // 0: 48 8B 05 rel32
+			Cmp32MemImm(RIPAbsolute(target+8), 0x7FFFFFFF). // 7: 81 3D rel32 imm32
+			JmpTo(target)                                   // 17: E9 rel32
+
+		code, err := b.Assemble(base)
+		if err != nil {
+			t.Fatalf("Assemble(0x%X): %v", base, err)
+		}
+
+		// RIP points past the whole instruction, including any immediate.
+		if got, want := int64(rel32(code, 3)), target-(base+7); got != want {
+			t.Errorf("base 0x%X: mov rel32 = 0x%X, want 0x%X", base, got, want)
+		}
+		if got, want := int64(rel32(code, 9)), target+8-(base+17); got != want {
+			t.Errorf("base 0x%X: cmp rel32 = 0x%X, want 0x%X", base, got, want)
+		}
+		if got, want := int64(rel32(code, 18)), target-(base+22); got != want {
+			t.Errorf("base 0x%X: jmp rel32 = 0x%X, want 0x%X", base, got, want)
+		}
+	}
+}
+
+func TestAssembleOutOfRange(t *testing.T) {
+	b := New().JmpTo(0x140000000)
+
+	if _, err := b.Assemble(0x7FF6A1230000); err == nil || !strings.Contains(err.Error(), "out of rel32 range") {
+		t.Errorf("Assemble far from the target = %v, want out of range", err)
+	}
+	if _, err := b.Bytes(); err == nil {
+		t.Error("Bytes with an absolute reference succeeded")
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	if _, err := New().Jmp("missing").Assemble(0); err == nil || !strings.Contains(err.Error(), "undefined label") {
+		t.Errorf("undefined label = %v", err)
+	}
+	if _, err := New().Label("a").Nop().Label("a").Bytes(); err == nil || !strings.Contains(err.Error(), "duplicate label") {
+		t.Errorf("duplicate label = %v", err)
+	}
+}
+
+func TestAssembleDoesNotModifyBuilder(t *testing.T) {
+	b := New().Label("self").Jmp("self")
+
+	first, err := b.Assemble(0x10000)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	second, err := b.Assemble(0x7FF6A1230000)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("assembling at two bases differs: % X and % X", first, second)
+	}
+	if !bytes.Equal(b.code, []byte{0xE9, 0, 0, 0, 0}) {
+		t.Errorf("builder code changed to % X", b.code)
+	}
+}
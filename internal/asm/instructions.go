@@ -0,0 +1,240 @@
+package asm
+
+import "encoding/binary"
+
+// MovImm loads a 64-bit immediate: mov dst,imm64.
+func (b *Builder) MovImm(dst Reg, imm uint64) *Builder {
+	if r := rex(true, 0, uint8(dst)); r != 0 {
+		b.code = append(b.code, r)
+	}
+	b.code = append(b.code, 0xB8+uint8(dst)&7)
+	b.code = binary.LittleEndian.AppendUint64(b.code, imm)
+	return b
+}
+
+// Mov copies a register: mov dst,src.
+func (b *Builder) Mov(dst, src Reg) *Builder {
+	return b.emitReg(nil, true, []byte{0x89}, uint8(src), uint8(dst), nil)
+}
+
+// MovLoad reads 64 bits from memory: mov dst,qword ptr [src].
+func (b *Builder) MovLoad(dst Reg, src Mem) *Builder {
+	return b.emitMem(nil, true, []byte{0x8B}, uint8(dst), src, nil)
+}
+
+// MovStore writes 64 bits to memory: mov qword ptr [dst],src.
+func (b *Builder) MovStore(dst Mem, src Reg) *Builder {
+	return b.emitMem(nil, true, []byte{0x89}, uint8(src), dst, nil)
+}
+
+// MovssLoad: movss dst,dword ptr [src].
+func (b *Builder) MovssLoad(dst XMM, src Mem) *Builder {
+	return b.emitMem([]byte{0xF3}, false, []byte{0x0F, 0x10}, uint8(dst), src, nil)
+}
+
+// MovssStore: movss dword ptr [dst],src.
+func (b *Builder) MovssStore(dst Mem, src XMM) *Builder {
+	return b.emitMem([]byte{0xF3}, false, []byte{0x0F, 0x11}, uint8(src), dst, nil)
+}
+
+// MovapsLoad: movaps dst,xmmword ptr [src]. The operand must be 16-byte aligned.
+func (b *Builder) MovapsLoad(dst XMM, src Mem) *Builder {
+	return b.emitMem(nil, false, []byte{0x0F, 0x28}, uint8(dst), src, nil)
+}
+
+// MovapsStore: movaps xmmword ptr [dst],src. The operand must be 16-byte aligned.
+func (b *Builder) MovapsStore(dst Mem, src XMM) *Builder {
+	return b.emitMem(nil, false, []byte{0x0F, 0x29}, uint8(src), dst, nil)
+}
+
+// MovupsLoad: movups dst,xmmword ptr [src].
+func (b *Builder) MovupsLoad(dst XMM, src Mem) *Builder {
+	return b.emitMem(nil, false, []byte{0x0F, 0x10}, uint8(dst), src, nil)
+}
+
+// MovupsStore: movups xmmword ptr [dst],src.
+func (b *Builder) MovupsStore(dst Mem, src XMM) *Builder {
+	return b.emitMem(nil, false, []byte{0x0F, 0x11}, uint8(src), dst, nil)
+}
+
+func (b *Builder) Push(r Reg) *Builder {
+	if r >= R8 {
+		b.code = append(b.code, 0x41)
+	}
+	b.code = append(b.code, 0x50+uint8(r)&7)
+	return b
+}
+
+func (b *Builder) Pop(r Reg) *Builder {
+	if r >= R8 {
+		b.code = append(b.code, 0x41)
+	}
+	b.code = append(b.code, 0x58+uint8(r)&7)
+	return b
+}
+
+func (b *Builder) Pushfq() *Builder {
+	return b.Raw(0x9C)
+}
+
+func (b *Builder) Popfq() *Builder {
+	return b.Raw(0x9D)
+}
+
+func (b *Builder) Ret() *Builder {
+	return b.Raw(0xC3)
+}
+
+func (b *Builder) Nop() *Builder {
+	return b.Raw(0x90)
+}
+
+// AddImm: add dst,imm32.
+func (b *Builder) AddImm(dst Reg, imm int32) *Builder {
+	return b.emitReg(nil, true, []byte{0x81}, 0, uint8(dst), binary.LittleEndian.AppendUint32(nil, uint32(imm)))
+}
+
+// SubImm: sub dst,imm32.
+func (b *Builder) SubImm(dst Reg, imm int32) *Builder {
+	return b.emitReg(nil, true, []byte{0x81}, 5, uint8(dst), binary.LittleEndian.AppendUint32(nil, uint32(imm)))
+}
+
+// AndImm: and dst,imm32.
+func (b *Builder) AndImm(dst Reg, imm int32) *Builder {
+	return b.emitReg(nil, true, []byte{0x81}, 4, uint8(dst), binary.LittleEndian.AppendUint32(nil, uint32(imm)))
+}
+
+// CmpImm: cmp dst,imm32.
+func (b *Builder) CmpImm(dst Reg, imm int32) *Builder {
+	return b.emitReg(nil, true, []byte{0x81}, 7, uint8(dst), binary.LittleEndian.AppendUint32(nil, uint32(imm)))
+}
+
+// Add: add dst,src.
+func (b *Builder) Add(dst, src Reg) *Builder {
+	return b.emitReg(nil, true, []byte{0x01}, uint8(src), uint8(dst), nil)
+}
+
+// Or: or dst,src.
+func (b *Builder) Or(dst, src Reg) *Builder {
+	return b.emitReg(nil, true, []byte{0x09}, uint8(src), uint8(dst), nil)
+}
+
+// ShlImm: shl dst,imm8.
+func (b *Builder) ShlImm(dst Reg, imm uint8) *Builder {
+	return b.emitReg(nil, true, []byte{0xC1}, 4, uint8(dst), []byte{imm})
+}
+
+// LockXadd atomically adds src to memory and loads the previous value into
+// src: lock xadd qword ptr [dst],src.
+func (b *Builder) LockXadd(dst Mem, src Reg) *Builder {
+	return b.emitMem([]byte{0xF0}, true, []byte{0x0F, 0xC1}, uint8(src), dst, nil)
+}
+
+// Mov32MemImm: mov dword ptr [dst],imm32.
+func (b *Builder) Mov32MemImm(dst Mem, imm uint32) *Builder {
+	return b.emitMem(nil, false, []byte{0xC7}, 0, dst, binary.LittleEndian.AppendUint32(nil, imm))
+}
+
+// Rdtsc reads the time stamp counter into EDX:EAX.
+func (b *Builder) Rdtsc() *Builder {
+	return b.Raw(0x0F, 0x31)
+}
+
+// Cmp32MemImm: cmp dword ptr [dst],imm32.
+func (b *Builder) Cmp32MemImm(dst Mem, imm int32) *Builder {
+	return b.emitMem(nil, false, []byte{0x81}, 7, dst, binary.LittleEndian.AppendUint32(nil, uint32(imm)))
+}
+
+// Jmp jumps to a label: jmp rel32.
+func (b *Builder) Jmp(label string) *Builder {
+	return b.emitRel([]byte{0xE9}, label, 0)
+}
+
+// JmpTo jumps to an absolute address within ±2GB: jmp rel32.
+func (b *Builder) JmpTo(address int64) *Builder {
+	return b.emitRel([]byte{0xE9}, "", address)
+}
+
+// Jcc jumps to a label if cond holds: jcc rel32.
+func (b *Builder) Jcc(cond Cond, label string) *Builder {
+	return b.emitRel([]byte{0x0F, 0x80 | byte(cond)}, label, 0)
+}
+
+// Call calls a label: call rel32.
+func (b *Builder) Call(label string) *Builder {
+	return b.emitRel([]byte{0xE8}, label, 0)
+}
+
+// CallAbs calls an absolute address anywhere in the address space through an
+// inline pointer: call qword ptr [rip+2]; jmp +8; dq address.
+func (b *Builder) CallAbs(address uint64) *Builder {
+	b.Raw(0xFF, 0x15, 0x02, 0x00, 0x00, 0x00)
+	b.Raw(0xEB, 0x08)
+	return b.Uint64(address)
+}
+
+// volatileGPRs are the registers a Windows x64 callee may clobber.
+var volatileGPRs = []Reg{RAX, RCX, RDX, R8, R9, R10, R11}
+
+const volatileXMMs = 6 // XMM0-XMM5
+
+// Preserved emits body between a prologue that saves RFLAGS, the volatile
+// general purpose registers and XMM0-XMM5, and the matching epilogue, so a
+// hook can run without disturbing the code it interrupts.
+func (b *Builder) Preserved(body func(b *Builder)) *Builder {
+	b.Pushfq()
+	for _, r := range volatileGPRs {
+		b.Push(r)
+	}
+	b.SubImm(RSP, volatileXMMs*16)
+	for i := 0; i < volatileXMMs; i++ {
+		b.MovupsStore(Ptr(RSP, int32(i*16)), XMM(i))
+	}
+
+	body(b)
+
+	for i := 0; i < volatileXMMs; i++ {
+		b.MovupsLoad(XMM(i), Ptr(RSP, int32(i*16)))
+	}
+	b.AddImm(RSP, volatileXMMs*16)
+	for i := len(volatileGPRs) - 1; i >= 0; i-- {
+		b.Pop(volatileGPRs[i])
+	}
+	return b.Popfq()
+}
+
+// LoadOriginal loads the value r had before the Preserved prologue into dst.
+// It is only valid inside a Preserved body and before the body moves RSP.
+func (b *Builder) LoadOriginal(dst, r Reg) *Builder {
+	// The prologue pushed RFLAGS and then volatileGPRs in order, and reserved
+	// the XMM save area below them.
+	saved := volatileXMMs * 16
+	for i := len(volatileGPRs) - 1; i >= 0; i-- {
+		if volatileGPRs[i] == r {
+			return b.MovLoad(dst, Ptr(RSP, int32(saved)))
+		}
+		saved += 8
+	}
+
+	if r == RSP {
+		// Skip the saved RFLAGS as well.
+		return b.Mov(dst, RSP).AddImm(dst, int32(saved+8))
+	}
+	if dst == r {
+		return b
+	}
+	return b.Mov(dst, r)
+}
+
+// AlignedCallAbs calls an absolute address with a 16-byte aligned stack and
+// shadow space as the Windows x64 ABI requires. RBX is preserved and used to
+// restore the stack pointer afterwards.
+func (b *Builder) AlignedCallAbs(address uint64) *Builder {
+	b.Push(RBX)
+	b.Mov(RBX, RSP)
+	b.AndImm(RSP, -16)
+	b.SubImm(RSP, 0x20)
+	b.CallAbs(address)
+	b.Mov(RSP, RBX)
+	return b.Pop(RBX)
+}
@@ -0,0 +1,182 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// decodeHex parses bytes written like "48 8B 45 00".
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return data
+}
+
+// Encodings were checked against GNU as. Immediate arithmetic always uses the
+// imm32 form so a program's size never depends on its operands.
+func TestInstructionEncodings(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder)
+		want  string
+	}{
+		{"mov rax,imm64", func(b *Builder) { b.MovImm(RAX, 0x1122334455667788) }, "48 B8 88 77 66 55 44 33 22 11"},
+		{"mov r11,imm64", func(b *Builder) { b.MovImm(R11, 0x1122334455667788) }, "49 BB 88 77 66 55 44 33 22 11"},
+		{"mov rbx,rsp", func(b *Builder) { b.Mov(RBX, RSP) }, "48 89 E3"},
+		{"mov r10,rax", func(b *Builder) { b.Mov(R10, RAX) }, "49 89 C2"},
+		{"mov rax,r15", func(b *Builder) { b.Mov(RAX, R15) }, "4C 89 F8"},
+		{"mov rcx,[rax]", func(b *Builder) { b.MovLoad(RCX, Ptr(RAX, 0)) }, "48 8B 08"},
+		{"mov rdx,[rsp+60]", func(b *Builder) { b.MovLoad(RDX, Ptr(RSP, 0x60)) }, "48 8B 54 24 60"},
+		{"mov rax,[rbp]", func(b *Builder) { b.MovLoad(RAX, Ptr(RBP, 0)) }, "48 8B 45 00"},
+		{"mov r9,[r12+200]", func(b *Builder) { b.MovLoad(R9, Ptr(R12, 0x200)) }, "4D 8B 8C 24 00 02 00 00"},
+		{"mov r8,[r13]", func(b *Builder) { b.MovLoad(R8, Ptr(R13, 0)) }, "4D 8B 45 00"},
+		{"mov [rax+18],r10", func(b *Builder) { b.MovStore(Ptr(RAX, 0x18), R10) }, "4C 89 50 18"},
+		{"movss xmm0,[rsi+174]", func(b *Builder) { b.MovssLoad(XMM0, Ptr(RSI, 0x174)) }, "F3 0F 10 86 74 01 00 00"},
+		{"movss [rax],xmm0", func(b *Builder) { b.MovssStore(Ptr(RAX, 0), XMM0) }, "F3 0F 11 00"},
+		{"movss xmm9,[r14-8]", func(b *Builder) { b.MovssLoad(XMM9, Ptr(R14, -8)) }, "F3 45 0F 10 4E F8"},
+		{"movaps xmm4,[rsi+170]", func(b *Builder) { b.MovapsLoad(XMM4, Ptr(RSI, 0x170)) }, "0F 28 A6 70 01 00 00"},
+		{"movaps [rbp+870],xmm4", func(b *Builder) { b.MovapsStore(Ptr(RBP, 0x870), XMM4) }, "0F 29 A5 70 08 00 00"},
+		{"movups xmm5,[rsp+50]", func(b *Builder) { b.MovupsLoad(XMM5, Ptr(RSP, 0x50)) }, "0F 10 6C 24 50"},
+		{"movups [rsp],xmm0", func(b *Builder) { b.MovupsStore(Ptr(RSP, 0), XMM0) }, "0F 11 04 24"},
+		{"push rax", func(b *Builder) { b.Push(RAX) }, "50"},
+		{"push r11", func(b *Builder) { b.Push(R11) }, "41 53"},
+		{"pop rbx", func(b *Builder) { b.Pop(RBX) }, "5B"},
+		{"pop r15", func(b *Builder) { b.Pop(R15) }, "41 5F"},
+		{"pushfq", func(b *Builder) { b.Pushfq() }, "9C"},
+		{"popfq", func(b *Builder) { b.Popfq() }, "9D"},
+		{"ret", func(b *Builder) { b.Ret() }, "C3"},
+		{"nop", func(b *Builder) { b.Nop() }, "90"},
+		{"add rsp,60", func(b *Builder) { b.AddImm(RSP, 0x60) }, "48 81 C4 60 00 00 00"},
+		{"sub rsp,20", func(b *Builder) { b.SubImm(RSP, 0x20) }, "48 81 EC 20 00 00 00"},
+		{"and rsp,-16", func(b *Builder) { b.AndImm(RSP, -16) }, "48 81 E4 F0 FF FF FF"},
+		{"cmp r9,7FFFFFFF", func(b *Builder) { b.CmpImm(R9, 0x7FFFFFFF) }, "49 81 F9 FF FF FF 7F"},
+		{"add rax,r11", func(b *Builder) { b.Add(RAX, R11) }, "4C 01 D8"},
+		{"or rax,rdx", func(b *Builder) { b.Or(RAX, RDX) }, "48 09 D0"},
+		{"shl rdx,32", func(b *Builder) { b.ShlImm(RDX, 32) }, "48 C1 E2 20"},
+		{"lock xadd [r11],rcx", func(b *Builder) { b.LockXadd(Ptr(R11, 0), RCX) }, "F0 49 0F C1 0B"},
+		{"mov dword [rbx+10],imm32", func(b *Builder) { b.Mov32MemImm(Ptr(RBX, 0x10), 0xDEADBEEF) }, "C7 43 10 EF BE AD DE"},
+		{"rdtsc", func(b *Builder) { b.Rdtsc() }, "0F 31"},
+		{"cmp dword [rcx+4],-1", func(b *Builder) { b.Cmp32MemImm(Ptr(RCX, 4), -1) }, "81 79 04 FF FF FF FF"},
+		{"call qword [abs]", func(b *Builder) { b.CallAbs(0x7FF612345678) }, "FF 15 02 00 00 00 EB 08 78 56 34 12 F6 7F 00 00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New()
+			tt.build(b)
+			got, err := b.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes: %v", err)
+			}
+			if want := decodeHex(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("got % X, want % X", got, want)
+			}
+		})
+	}
+}
+
+// The camera shellcode was hand-assembled before the builder existed; these
+// are its bytes.
+func TestCameraShellcode(t *testing.T) {
+	tests := []struct {
+		name  string
+		build *Builder
+		want  []byte
+	}{
+		{
+			"pitch",
+			New().
+				MovapsLoad(XMM4, Ptr(RSI, 0x170)).
+				MovapsStore(Ptr(RBP, 0x870), XMM4),
+			[]byte{
+				0x0F, 0x28, 0xA6, 0x70, 0x01, 0x00, 0x00, // movaps xmm4,xmmword ptr ds:[rsi+170]
+				0x0F, 0x29, 0xA5, 0x70, 0x08, 0x00, 0x00, // movaps xmmword ptr ss:[rbp+870],xmm4
+			},
+		},
+		{
+			"yaw z",
+			New().
+				MovssLoad(XMM0, Ptr(RSI, 0x174)).
+				MovssStore(Ptr(RSI, 0x174), XMM0),
+			[]byte{
+				0xF3, 0x0F, 0x10, 0x86, 0x74, 0x01, 0x00, 0x00, // movss xmm0,dword ptr ds:[rsi+174]
+				0xF3, 0x0F, 0x11, 0x86, 0x74, 0x01, 0x00, 0x00, // movss dword ptr ds:[rsi+174],xmm0
+			},
+		},
+		{
+			"pitch xy",
+			New().
+				MovssLoad(XMM0, Ptr(RSI, 0x170)).
+				MovssStore(Ptr(RAX, 0), XMM0).
+				MovssLoad(XMM0, Ptr(RAX, 0)).
+				MovssStore(Ptr(RSI, 0x170), XMM0),
+			[]byte{
+				0xF3, 0x0F, 0x10, 0x86, 0x70, 0x01, 0x00, 0x00, // movss xmm0,dword ptr ds:[rsi+170]
+				0xF3, 0x0F, 0x11, 0x00, // movss dword ptr ds:[rax],xmm0
+				0xF3, 0x0F, 0x10, 0x00, // movss xmm0,dword ptr ds:[rax]
+				0xF3, 0x0F, 0x11, 0x86, 0x70, 0x01, 0x00, 0x00, // movss dword ptr ds:[rsi+170],xmm0
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.build.MustBytes()
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreserved(t *testing.T) {
+	got := New().Preserved(func(b *Builder) { b.Nop() }).MustBytes()
+
+	want := decodeHex(t, "9C 50 51 52 41 50 41 51 41 52 41 53"+ // pushfq; push rax,rcx,rdx,r8-r11
+		"48 81 EC 60 00 00 00"+ // sub rsp,60
+		"0F 11 04 24 0F 11 4C 24 10 0F 11 54 24 20"+ // movups [rsp+i*10],xmm0-xmm5
+		"0F 11 5C 24 30 0F 11 64 24 40 0F 11 6C 24 50"+
+		"90"+
+		"0F 10 04 24 0F 10 4C 24 10 0F 10 54 24 20"+ // movups xmm0-xmm5,[rsp+i*10]
+		"0F 10 5C 24 30 0F 10 64 24 40 0F 10 6C 24 50"+
+		"48 81 C4 60 00 00 00"+ // add rsp,60
+		"41 5B 41 5A 41 59 41 58 5A 59 58 9D") // pop r11-r8,rdx,rcx,rax; popfq
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % X\nwant % X", got, want)
+	}
+}
+
+func TestLoadOriginal(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  Reg
+		reg  Reg
+		want string
+	}{
+		// R11 was pushed last, right above the 0x60 byte XMM save area.
+		{"r11", RDX, R11, "48 8B 54 24 60"},
+		{"rax", RDX, RAX, "48 8B 94 24 90 00 00 00"},
+		{"rcx", RCX, RCX, "48 8B 8C 24 88 00 00 00"},
+		// Above the seven registers and RFLAGS.
+		{"rsp", RDX, RSP, "48 89 E2 48 81 C2 A0 00 00 00"},
+		{"nonvolatile", RDX, RBX, "48 89 DA"},
+		{"same nonvolatile", RBX, RBX, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			New().Preserved(func(b *Builder) {
+				start := b.Len()
+				b.LoadOriginal(tt.dst, tt.reg)
+				got = append([]byte(nil), b.code[start:]...)
+			})
+			if want := decodeHex(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("got % X, want % X", got, want)
+			}
+		})
+	}
+}
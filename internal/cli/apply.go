@@ -0,0 +1,264 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+// paramFlags collects repeated --set feature.param=value flags.
+type paramFlags map[string]game.Params
+
+func (pf paramFlags) String() string {
+	var values []string
+	for feature, params := range pf {
+		for param, value := range params {
+			values = append(values, fmt.Sprintf("%s.%s=%g", feature, param, value))
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func (pf paramFlags) Set(text string) error {
+	key, rawValue, found := strings.Cut(text, "=")
+	featureID, paramID, dotted := strings.Cut(key, ".")
+	if !found || !dotted {
+		return fmt.Errorf("expected feature.param=value, got %q", text)
+	}
+
+	feature, exists := game.LookupFeature(featureID)
+	if !exists {
+		return fmt.Errorf("unknown feature %q", featureID)
+	}
+	known := false
+	for _, param := range feature.Params() {
+		known = known || param.ID == paramID
+	}
+	if !known {
+		return fmt.Errorf("feature %s has no parameter %q", featureID, paramID)
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+
+	if pf[featureID] == nil {
+		pf[featureID] = make(game.Params)
+	}
+	pf[featureID][paramID] = value
+	return nil
+}
+
+// FeatureResult is the outcome of applying or reverting one feature.
+type FeatureResult struct {
+	Feature string `json:"feature"`
+	Name    string `json:"name"`
+	// Action is "applied" or "reverted".
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+func runApply(env *environment, args []string) int {
+	flags := env.newFlagSet("apply")
+	var t target
+	t.register(flags)
+	configPath := flags.String("config", "", "apply this config file instead of the active profile")
+	profile := flags.String("profile", "", "apply this profile without making it active")
+	only := flags.String("features", "", "comma separated feature IDs to apply; other features are left alone")
+	set := paramFlags{}
+	flags.Var(set, "set", "override a parameter, e.g. --set fps_unlock.fps=144 (repeatable, enables the feature)")
+	keep := flags.Bool("keep", false, "do not revert features the config disables")
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	var cfg *config.Config
+	loaded := true
+	switch {
+	case *configPath != "" && *profile != "":
+		env.errorf("--config and --profile are mutually exclusive")
+		return ExitUsage
+	case *configPath != "":
+		cfg, loaded = env.loadConfigFile(*configPath)
+	case *profile != "":
+		cfg, loaded = env.loadProfile(*profile)
+	default:
+		cfg = env.loadConfig()
+	}
+	if !loaded {
+		return ExitUsage
+	}
+
+	requests := configRequests(cfg)
+	if *only != "" {
+		features, err := parseFeatures(*only)
+		if err != nil {
+			env.errorf("%v", err)
+			return ExitUsage
+		}
+		requests = requestsFor(features, cfg)
+	}
+
+	for featureID, params := range set {
+		feature, _ := game.LookupFeature(featureID)
+		if !hasRequest(requests, feature) {
+			requests = append(requests, requestsFor([]game.Feature{feature}, cfg)...)
+		}
+		for i := range requests {
+			if requests[i].Feature.ID() == featureID {
+				for id, value := range params {
+					requests[i].Params[id] = value
+				}
+			}
+		}
+	}
+
+	// An explicit feature list leaves every other feature alone.
+	var disabled []game.Feature
+	if *only == "" && !*keep {
+		disabled = disabledFeatures(requests)
+	}
+
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	results := applyRequests(patcher, requests)
+	results = append(results, revertFeatures(patcher, disabled)...)
+
+	return env.writeResults(results, *asJSON)
+}
+
+// applyRequests applies every requested feature.
+func applyRequests(patcher *game.Patcher, requests []game.FeatureRequest) []FeatureResult {
+	var results []FeatureResult
+	for _, request := range requests {
+		result := FeatureResult{Feature: request.Feature.ID(), Name: request.Feature.Name(), Action: "applied"}
+		if err := patcher.ApplyFeature(request.Feature, request.Params); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// disabledFeatures returns the features without a request.
+func disabledFeatures(requests []game.FeatureRequest) []game.Feature {
+	var disabled []game.Feature
+	for _, feature := range game.Features() {
+		if !hasRequest(requests, feature) {
+			disabled = append(disabled, feature)
+		}
+	}
+	return disabled
+}
+
+func hasRequest(requests []game.FeatureRequest, feature game.Feature) bool {
+	for _, request := range requests {
+		if request.Feature.ID() == feature.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// revertFeatures reverts every feature that is applied.
+func revertFeatures(patcher *game.Patcher, features []game.Feature) []FeatureResult {
+	var results []FeatureResult
+	for _, feature := range features {
+		status, err := feature.Status(patcher)
+		if err == nil && status == game.StatusInactive {
+			continue
+		}
+
+		result := FeatureResult{Feature: feature.ID(), Name: feature.Name(), Action: "reverted"}
+		if err := patcher.RevertFeature(feature); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// writeResults prints apply or revert results and returns the exit code.
+func (env *environment) writeResults(results []FeatureResult, asJSON bool) int {
+	if results == nil {
+		results = []FeatureResult{}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	if asJSON {
+		if err := env.writeJSON(results); err != nil {
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+	} else {
+		table := env.table()
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Fprintf(table, "%s\tfailed: %s\n", result.Name, result.Error)
+			} else {
+				fmt.Fprintf(table, "%s\t%s\n", result.Name, result.Action)
+			}
+		}
+		_ = table.Flush()
+	}
+
+	if failed > 0 {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func runRevert(env *environment, args []string) int {
+	flags := env.newFlagSet("revert")
+	var t target
+	t.register(flags)
+	only := flags.String("features", "", "comma separated feature IDs to revert (default: all)")
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	features := game.Features()
+	if *only != "" {
+		var err error
+		if features, err = parseFeatures(*only); err != nil {
+			env.errorf("%v", err)
+			return ExitUsage
+		}
+	}
+
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	return env.writeResults(revertFeatures(patcher, features), *asJSON)
+}
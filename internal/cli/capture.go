@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// DiffMatch is one line of diff output. Values holds the value in each
+// capture, in the order the captures were given, as the type that was diffed.
+// Interpretations reads the same bytes as every type of that width.
+type DiffMatch struct {
+	Address         string               `json:"address"`
+	Values          []string             `json:"values"`
+	Interpretations []DiffInterpretation `json:"interpretations"`
+}
+
+type DiffInterpretation struct {
+	Type   string   `json:"type"`
+	Values []string `json:"values"`
+}
+
+func runCapture(env *environment, args []string) int {
+	flags := env.newFlagSet("capture")
+	var t target
+	t.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(env.stderr, "Usage: sekiro-tweaker-cli capture [flags] <path>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	if err := patcher.WriteStateCapture(flags.Arg(0)); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func runDiff(env *environment, args []string) int {
+	flags := env.newFlagSet("diff")
+	condition := flags.String("condition", "changed", "changed, unchanged, increased, decreased, increased-by or decreased-by")
+	valueType := flags.String("type", "i32", "value type: u8, i16, i32, i64, f32 or f64")
+	delta := flags.Float64("delta", 0, "step for increased-by and decreased-by")
+	alignment := flags.Int("align", 0, "alignment of candidate addresses (default: the size of the type)")
+	limit := flags.Int("limit", 1000, "stop after this many matches, 0 for no limit")
+	asJSON := flags.Bool("json", false, "print one JSON object per line")
+	flags.Usage = func() {
+		fmt.Fprintln(env.stderr, "Usage: sekiro-tweaker-cli diff [flags] <capture> <capture>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	opts := memory.DiffOptions{Delta: *delta, Alignment: *alignment, Limit: *limit}
+	var err error
+	if opts.Condition, err = memory.ParseDiffCondition(*condition); err != nil {
+		env.errorf("%v", err)
+		return ExitUsage
+	}
+	if opts.Type, err = memory.ParseValueType(*valueType); err != nil {
+		env.errorf("%v", err)
+		return ExitUsage
+	}
+
+	var snapshots []*memory.Snapshot
+	defer func() {
+		for _, snapshot := range snapshots {
+			_ = snapshot.Close()
+		}
+	}()
+	for _, path := range flags.Args() {
+		snapshot, err := memory.OpenSnapshot(path)
+		if err != nil {
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	err = memory.DiffSnapshots(snapshots, opts, func(match memory.DiffMatch) error {
+		line := newDiffMatch(match, opts.Type)
+		if *asJSON {
+			return env.writeJSONLine(line)
+		}
+
+		if _, err := fmt.Fprintf(env.stdout, "%s  %s\n", line.Address, strings.Join(line.Values, " -> ")); err != nil {
+			return err
+		}
+		for _, interpretation := range line.Interpretations {
+			if _, err := fmt.Fprintf(env.stdout, "    %-4s %s\n", interpretation.Type, strings.Join(interpretation.Values, " -> ")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// newDiffMatch renders each captured value as the type it was diffed as, and
+// as every other interpretation of its bytes.
+func newDiffMatch(match memory.DiffMatch, valueType memory.ValueType) DiffMatch {
+	line := DiffMatch{Address: fmt.Sprintf("0x%X", match.Address)}
+	for i, value := range match.Values {
+		line.Values = append(line.Values, fmt.Sprintf("% X", value))
+		for j, interpretation := range memory.Interpret(value) {
+			if i == 0 {
+				line.Interpretations = append(line.Interpretations, DiffInterpretation{Type: interpretation.Type})
+			}
+			line.Interpretations[j].Values = append(line.Interpretations[j].Values, interpretation.Value)
+			if interpretation.Type == valueType.String() {
+				line.Values[i] = interpretation.Value
+			}
+		}
+	}
+	return line
+}
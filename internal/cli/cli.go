@@ -0,0 +1,331 @@
+// Package cli implements the headless sekiro-tweaker-cli commands on top of
+// the game and config packages.
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitOK = 0
+	// ExitFailure means the command ran but at least one feature failed.
+	ExitFailure = 1
+	ExitUsage   = 2
+	// ExitNoGame means the game is not running or could not be attached to.
+	ExitNoGame = 3
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(env *environment, args []string) int
+}
+
+var commands = []command{
+	{"apply", "apply the saved config, a config file or individual features", runApply},
+	{"revert", "revert applied features", runRevert},
+	{"status", "show which features are applied and the current stats", runStatus},
+	{"watch", "stream stats until the game exits", runWatch},
+	{"wait-for-game", "wait until the game is running and print its pid", runWaitForGame},
+	{"run", "launch the game through a command (e.g. Steam's %command%) and keep it patched", runRun},
+	{"profile", "list, switch, save, delete, import and export profiles", runProfile},
+	{"serve", "serve the control API on a Unix socket without the GUI", runServe},
+	{"call", "call a control API method of the GUI or serve", runCall},
+	{"dry-run", "resolve the enabled features and print what they would write", runDryRun},
+	{"doctor", "check permissions, the game module and every patch signature", runDoctor},
+	{"capture", "dump the game's writable memory to a capture file", runCapture},
+	{"diff", "list addresses whose value changed as given across captures", runDiff},
+}
+
+// environment is what every command writes to.
+type environment struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (env *environment) errorf(format string, args ...any) {
+	fmt.Fprintf(env.stderr, "sekiro-tweaker-cli: "+format+"\n", args...)
+}
+
+func (env *environment) writeJSON(value any) error {
+	encoder := json.NewEncoder(env.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// table returns a writer that aligns tab separated columns on stdout.
+func (env *environment) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(env.stdout, 0, 0, 2, ' ', 0)
+}
+
+// writeJSONLine writes value as a single line, for streamed output.
+func (env *environment) writeJSONLine(value any) error {
+	return json.NewEncoder(env.stdout).Encode(value)
+}
+
+// Run runs the command named by args[0] and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	env := &environment{stdout: stdout, stderr: stderr}
+
+	global := flag.NewFlagSet("sekiro-tweaker-cli", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	verbose := global.Bool("verbose", false, "log debug output to stderr")
+	var logFlags logger.Options
+	global.StringVar(&logFlags.Level, "log-level", "", "log level: debug, info, warn or error (default info)")
+	global.StringVar(&logFlags.Format, "log-format", "", "log format: console or json (default console)")
+	global.StringVar(&logFlags.Output, "log-output", "", "log to stderr, the log file or both (default both)")
+	global.Usage = func() { env.usage(global) }
+	if err := global.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if err := configureLogging(logFlags, *verbose); err != nil {
+		env.errorf("%v", err)
+		return ExitUsage
+	}
+	defer logger.Sync()
+
+	if global.NArg() == 0 {
+		env.usage(global)
+		return ExitUsage
+	}
+
+	name := global.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(env, global.Args()[1:])
+		}
+	}
+
+	env.errorf("unknown command %q", name)
+	env.usage(global)
+	return ExitUsage
+}
+
+// configureLogging sets up logging from the config, the environment and the
+// flags, each overriding the one before.
+func configureLogging(flags logger.Options, verbose bool) error {
+	// Problems with the config are reported by the commands that use it.
+	cfg, _ := config.Load()
+	env := logger.EnvOptions()
+	opts := cfg.LogOptions().Merge(env).Merge(flags)
+
+	// Logs on stderr would bury the command's output, so only warnings are
+	// shown there unless asked for. The log file gets everything.
+	switch {
+	case verbose:
+		opts.Level = "debug"
+	case flags.Level == "" && env.Level == "":
+		opts.Quiet = true
+	}
+	return logger.Configure(opts)
+}
+
+func (env *environment) usage(global *flag.FlagSet) {
+	fmt.Fprintln(env.stderr, "Usage: sekiro-tweaker-cli [global flags] <command> [flags]")
+	fmt.Fprintln(env.stderr)
+	fmt.Fprintln(env.stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(env.stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(env.stderr)
+	global.PrintDefaults()
+}
+
+// newFlagSet returns a flag set for a command that reports errors to stderr.
+func (env *environment) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(env.stderr)
+	return flags
+}
+
+// loadConfig reads the active profile. Problems with it are reported, but
+// the settings that could be read are still used.
+func (env *environment) loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		env.errorf("config: %v", err)
+	}
+	return cfg
+}
+
+// loadConfigFile reads a config given on the command line. Unlike the saved
+// config, an unreadable file is an error.
+func (env *environment) loadConfigFile(path string) (*config.Config, bool) {
+	cfg, err := config.LoadFile(path)
+	if cfg == nil {
+		env.errorf("%v", err)
+		return nil, false
+	}
+	if err != nil {
+		env.errorf("config: %v", err)
+	}
+	return cfg, true
+}
+
+// loadProfile reads a profile, reporting problems like loadConfig.
+func (env *environment) loadProfile(name string) (*config.Config, bool) {
+	cfg, err := config.LoadProfile(name)
+	if cfg == nil {
+		env.errorf("%v", err)
+		return nil, false
+	}
+	if err != nil {
+		env.errorf("profile %s: %v", name, err)
+	}
+	return cfg, true
+}
+
+// loadPatches applies the user's patch definition overrides, as the GUI does
+// on start.
+func loadPatches() error {
+	dir, err := config.PatchesDir()
+	if err != nil {
+		return nil
+	}
+
+	set, err := game.LoadPatchSet(dir)
+	if err != nil {
+		return fmt.Errorf("patch definitions: %v", err)
+	}
+	game.SetActivePatches(set)
+	return nil
+}
+
+// target selects the address space a command works on.
+type target struct {
+	pid      int
+	snapshot string
+}
+
+func (t *target) register(flags *flag.FlagSet) {
+	flags.IntVar(&t.pid, "pid", 0, "game process ID (default: detect)")
+	flags.StringVar(&t.snapshot, "snapshot", "", "use a memory snapshot instead of the running game")
+}
+
+// attach creates a patcher for the target. The returned function releases
+// the snapshot, if one was opened.
+func (t *target) attach() (*game.Patcher, func(), error) {
+	if t.snapshot != "" {
+		snapshot, err := memory.OpenSnapshot(t.snapshot)
+		if err != nil {
+			return nil, nil, err
+		}
+		patcher, err := game.NewPatcherWithMemory(snapshot)
+		if err != nil {
+			_ = snapshot.Close()
+			return nil, nil, err
+		}
+		return patcher, func() { _ = snapshot.Close() }, nil
+	}
+
+	pid := t.pid
+	if pid == 0 {
+		var err error
+		if pid, err = findGame(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	patcher, err := game.NewPatcher(pid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Without the journal, revert and status would not know what an earlier
+	// invocation patched.
+	if err := patcher.EnableJournal(); err != nil {
+		logger.Log.Warn("Patch journal unavailable", zap.Error(err))
+	}
+	return patcher, func() {}, nil
+}
+
+// findGame returns the pid of the process most likely to be the running
+// game.
+func findGame() (int, error) {
+	candidates, err := game.FindCandidates()
+	if err != nil {
+		return 0, err
+	}
+	if len(candidates) == 0 {
+		return 0, fmt.Errorf("%s is not running", game.ProcessName)
+	}
+
+	if game.Ambiguous(candidates) {
+		descriptions := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			descriptions[i] = candidate.String()
+		}
+		logger.Log.Warn("Several processes could be the game, using the first; commands with --pid can pick another",
+			zap.Strings("candidates", descriptions))
+	}
+	return candidates[0].PID, nil
+}
+
+// configRequests returns a request for every feature the config enables,
+// falling back to each feature's default for features the config omits.
+func configRequests(cfg *config.Config) []game.FeatureRequest {
+	var features []game.Feature
+	for _, feature := range game.Features() {
+		enabled := feature.DefaultEnabled()
+		if saved, exists := cfg.Features[feature.ID()]; exists {
+			enabled = saved.Enabled
+		}
+		if enabled {
+			features = append(features, feature)
+		}
+	}
+	return requestsFor(features, cfg)
+}
+
+// requestsFor returns a request for each feature with its saved parameters,
+// whether or not the config enables it.
+func requestsFor(features []game.Feature, cfg *config.Config) []game.FeatureRequest {
+	requests := make([]game.FeatureRequest, len(features))
+	for i, feature := range features {
+		params := game.DefaultParams(feature)
+		for id, value := range cfg.Features[feature.ID()].Params {
+			params[id] = value
+		}
+		requests[i] = game.FeatureRequest{Feature: feature, Params: params}
+	}
+	return requests
+}
+
+// parseFeatures looks up a comma separated list of feature IDs.
+func parseFeatures(list string) ([]game.Feature, error) {
+	var features []game.Feature
+	for _, id := range strings.Split(list, ",") {
+		id = strings.TrimSpace(id)
+		feature, exists := game.LookupFeature(id)
+		if !exists {
+			return nil, fmt.Errorf("unknown feature %q (known: %s)", id, strings.Join(featureIDs(), ", "))
+		}
+		features = append(features, feature)
+	}
+	return features, nil
+}
+
+// featureIDs lists the registered feature IDs for error messages.
+func featureIDs() []string {
+	var ids []string
+	for _, feature := range game.Features() {
+		ids = append(ids, feature.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
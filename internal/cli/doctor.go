@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+func runDoctor(env *environment, args []string) int {
+	flags := env.newFlagSet("doctor")
+	var t target
+	t.register(flags)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	// Broken overrides are a finding, not a reason to stop; the built-in
+	// definitions are checked instead.
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+	}
+
+	var report *game.DoctorReport
+	if t.snapshot != "" {
+		snapshot, err := memory.OpenSnapshot(t.snapshot)
+		if err != nil {
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+		defer func() { _ = snapshot.Close() }()
+		report = game.DiagnoseMemory(snapshot)
+	} else {
+		report = game.Diagnose(t.pid)
+	}
+
+	var err error
+	if *asJSON {
+		err = env.writeJSON(report)
+	} else {
+		err = game.WriteDoctorReport(env.stdout, report)
+	}
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	if report.Count(game.CheckFailed) > 0 {
+		return ExitFailure
+	}
+	return ExitOK
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+func runDryRun(env *environment, args []string) int {
+	flags := env.newFlagSet("dry-run")
+	var t target
+	t.register(flags)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	all := flags.Bool("all", false, "plan every feature, not just the enabled ones")
+	only := flags.String("features", "", "comma separated feature IDs to plan instead of the enabled ones")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	cfg := env.loadConfig()
+	requests := configRequests(cfg)
+	switch {
+	case *all:
+		requests = requestsFor(game.Features(), cfg)
+	case *only != "":
+		features, err := parseFeatures(*only)
+		if err != nil {
+			env.errorf("%v", err)
+			return ExitUsage
+		}
+		requests = requestsFor(features, cfg)
+	}
+
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	results, err := patcher.DryRun(requests)
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	if *asJSON {
+		err = env.writeJSON(results)
+	} else {
+		err = game.WriteDryRunReport(env.stdout, results)
+	}
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	for _, result := range results {
+		if result.Failed() {
+			return ExitFailure
+		}
+	}
+	return ExitOK
+}
@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/api"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+)
+
+var profileCommands = []command{
+	{"list", "list profiles, marking the active one", runProfileList},
+	{"switch", "make a profile active and apply it to the running game", runProfileSwitch},
+	{"save", "copy the active profile to a new one", runProfileSave},
+	{"delete", "delete a profile", runProfileDelete},
+	{"import", "add a config file as a profile", runProfileImport},
+	{"export", "write a profile to a file, or - for stdout", runProfileExport},
+}
+
+func runProfile(env *environment, args []string) int {
+	if len(args) > 0 {
+		for _, cmd := range profileCommands {
+			if cmd.name == args[0] {
+				return cmd.run(env, args[1:])
+			}
+		}
+		env.errorf("unknown profile command %q", args[0])
+	}
+
+	fmt.Fprintln(env.stderr, "Usage: sekiro-tweaker-cli profile <command> [flags] [args]")
+	fmt.Fprintln(env.stderr)
+	for _, cmd := range profileCommands {
+		fmt.Fprintf(env.stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	return ExitUsage
+}
+
+// profileArgs parses a profile command's flags and checks it got n arguments.
+func profileArgs(env *environment, name, usage string, n int, args []string, register func(*flag.FlagSet)) ([]string, bool) {
+	flags := env.newFlagSet("profile " + name)
+	flags.Usage = func() {
+		env.errorf("usage: profile %s [flags] %s", name, usage)
+		flags.PrintDefaults()
+	}
+	if register != nil {
+		register(flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, false
+	}
+	if flags.NArg() != n {
+		flags.Usage()
+		return nil, false
+	}
+	return flags.Args(), true
+}
+
+func runProfileList(env *environment, args []string) int {
+	var asJSON *bool
+	if _, ok := profileArgs(env, "list", "", 0, args, func(flags *flag.FlagSet) {
+		asJSON = flags.Bool("json", false, "print the profiles as JSON")
+	}); !ok {
+		return ExitUsage
+	}
+
+	names, err := config.ProfileNames()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	active := config.ActiveProfile()
+
+	if *asJSON {
+		if err := env.writeJSON(api.ProfilesResult{Active: active, Profiles: names}); err != nil {
+			return ExitFailure
+		}
+		return ExitOK
+	}
+
+	for _, name := range names {
+		marker := " "
+		if name == active {
+			marker = "*"
+		}
+		fmt.Fprintf(env.stdout, "%s %s\n", marker, name)
+	}
+	return ExitOK
+}
+
+func runProfileSwitch(env *environment, args []string) int {
+	var noApply, asJSON *bool
+	positional, ok := profileArgs(env, "switch", "name", 1, args, func(flags *flag.FlagSet) {
+		noApply = flags.Bool("no-apply", false, "only make the profile active")
+		asJSON = flags.Bool("json", false, "print the results as JSON")
+	})
+	if !ok {
+		return ExitUsage
+	}
+	name := positional[0]
+
+	if !config.ProfileExists(name) {
+		env.errorf("profile %q does not exist", name)
+		return ExitUsage
+	}
+
+	// A running GUI or serve owns the game and its controls, so it switches
+	// the profile itself.
+	if !*noApply {
+		if client, err := api.Dial(api.SocketPath()); err == nil {
+			defer func() { _ = client.Close() }()
+			if err := client.Call("profiles.switch", api.ProfileData{Name: name}, nil); err != nil {
+				env.errorf("%v", err)
+				return ExitFailure
+			}
+			fmt.Fprintf(env.stderr, "switched to %s\n", name)
+			return ExitOK
+		}
+	}
+
+	if err := config.SetActiveProfile(name); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	fmt.Fprintf(env.stderr, "switched to %s\n", name)
+
+	if *noApply {
+		return ExitOK
+	}
+	if _, err := findGame(); err != nil {
+		return ExitOK
+	}
+
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	var t target
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	requests := configRequests(env.loadConfig())
+	results := applyRequests(patcher, requests)
+	results = append(results, revertFeatures(patcher, disabledFeatures(requests))...)
+	return env.writeResults(results, *asJSON)
+}
+
+func runProfileSave(env *environment, args []string) int {
+	var from *string
+	var force *bool
+	positional, ok := profileArgs(env, "save", "name", 1, args, func(flags *flag.FlagSet) {
+		from = flags.String("from", "", "copy this profile instead of the active one")
+		force = flags.Bool("force", false, "overwrite an existing profile")
+	})
+	if !ok {
+		return ExitUsage
+	}
+	name := positional[0]
+
+	source := config.ActiveProfile()
+	if *from != "" {
+		source = *from
+	}
+
+	if err := config.ValidateProfileName(name); err != nil {
+		env.errorf("%v", err)
+		return ExitUsage
+	}
+	if config.ProfileExists(name) && !*force {
+		env.errorf("profile %q already exists (use --force to overwrite it)", name)
+		return ExitUsage
+	}
+
+	cfg, loaded := env.loadProfile(source)
+	if !loaded {
+		return ExitFailure
+	}
+	if err := cfg.SaveProfile(name); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func runProfileDelete(env *environment, args []string) int {
+	positional, ok := profileArgs(env, "delete", "name", 1, args, nil)
+	if !ok {
+		return ExitUsage
+	}
+
+	if err := config.DeleteProfile(positional[0]); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func runProfileImport(env *environment, args []string) int {
+	var name *string
+	positional, ok := profileArgs(env, "import", "file", 1, args, func(flags *flag.FlagSet) {
+		name = flags.String("name", "", "profile name (default: the file name)")
+	})
+	if !ok {
+		return ExitUsage
+	}
+
+	imported, err := config.ImportProfile(positional[0], *name)
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	fmt.Fprintln(env.stdout, imported)
+	return ExitOK
+}
+
+func runProfileExport(env *environment, args []string) int {
+	positional, ok := profileArgs(env, "export", "name file", 2, args, nil)
+	if !ok {
+		return ExitUsage
+	}
+	name, path := positional[0], positional[1]
+
+	if path != "-" {
+		if err := config.ExportProfile(name, path); err != nil {
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+		return ExitOK
+	}
+
+	cfg, loaded := env.loadProfile(name)
+	if !loaded {
+		return ExitFailure
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	if _, err := env.stdout.Write(data); err != nil {
+		return ExitFailure
+	}
+	return ExitOK
+}
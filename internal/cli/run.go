@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// runRun launches the game through its launcher command line, as Steam does
+// with `sekiro-tweaker run -- %command%`, and patches it once it starts.
+// Since the game is a descendant, ptrace_scope=1 does not get in the way.
+func runRun(env *environment, args []string) int {
+	flags := env.newFlagSet("run")
+	flags.Usage = func() {
+		env.errorf("usage: run [flags] -- command [args...]")
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "apply this config file instead of the saved config")
+	interval := flags.Duration("interval", game.DefaultWatchdogInterval,
+		"how often to look for the game, retry pending features and re-apply values")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitUsage
+	}
+	if *interval <= 0 {
+		env.errorf("interval must be positive")
+		return ExitUsage
+	}
+
+	var cfg *config.Config
+	if *configPath != "" {
+		var loaded bool
+		if cfg, loaded = env.loadConfigFile(*configPath); !loaded {
+			return ExitUsage
+		}
+	} else {
+		cfg = env.loadConfig()
+	}
+	requests := configRequests(cfg)
+
+	if err := loadPatches(); err != nil {
+		// The launcher still starts; features fail individually instead.
+		env.errorf("%v", err)
+	}
+
+	cmd := exec.Command(flags.Arg(0), flags.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = env.stdout
+	cmd.Stderr = env.stderr
+	if err := cmd.Start(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	// Steam stops games by signalling the launch command, which is us.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	session := &runSession{root: cmd.Process.Pid, requests: requests, session: game.NewSession()}
+	defer session.close()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-exited:
+			return exitCode(err)
+		case sig := <-signals:
+			_ = cmd.Process.Signal(sig)
+		case <-ticker.C:
+			session.poll()
+		}
+	}
+}
+
+// exitCode returns the exit code of a finished command.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+	return ExitFailure
+}
+
+// runSession follows the launcher's process tree and patches the game it
+// starts. A game restarted by the same launcher is patched again.
+type runSession struct {
+	root     int
+	requests []game.FeatureRequest
+	// session owns the patcher of the game found, shared with the watchdog.
+	session *game.Session
+
+	pid      int
+	pending  []game.FeatureRequest
+	watchdog *game.Watchdog
+}
+
+func (s *runSession) poll() {
+	if s.pid != 0 && !s.running() {
+		logger.Log.Info("Game exited", zap.Int("pid", s.pid))
+		for _, request := range s.pending {
+			logger.Log.Warn("Feature was never applied", zap.String("feature", request.Feature.ID()))
+		}
+		s.detach()
+	}
+
+	if s.pid == 0 && !s.attach() {
+		return
+	}
+
+	s.applyPending()
+}
+
+// attach looks for the game among the launcher's descendants. The patcher
+// cannot be created until the module is mapped, so this is retried.
+func (s *runSession) attach() bool {
+	candidates, err := game.FindCandidates()
+	if err != nil {
+		return false
+	}
+
+	// Wine's wrappers are descendants too, but rank below the game.
+	for _, candidate := range candidates {
+		pid := candidate.PID
+		if !memory.IsDescendant(pid, s.root) {
+			continue
+		}
+
+		patcher, err := game.NewPatcher(pid)
+		if err != nil {
+			logger.Log.Debug("Game module not ready", zap.Int("pid", pid), zap.Error(err))
+			return false
+		}
+		if err := patcher.EnableJournal(); err != nil {
+			logger.Log.Warn("Patch journal unavailable", zap.Error(err))
+		}
+
+		if err := s.session.Attach(patcher); err != nil {
+			logger.Log.Warn("Failed to release the previous game", zap.Error(err))
+		}
+
+		logger.Log.Info("Found game", zap.Int("pid", pid))
+		s.pid = pid
+		s.pending = append([]game.FeatureRequest(nil), s.requests...)
+
+		// Values behind pointer chains, such as game speed, are reset when
+		// the game loads a save or an area. Healing their drift re-applies
+		// them at the new address.
+		s.watchdog = game.NewWatchdog(s.session, game.DefaultWatchdogInterval)
+		s.watchdog.SetAutoHeal(true)
+		s.watchdog.Start()
+		return true
+	}
+	return false
+}
+
+// applyPending retries every feature that has not been applied yet. Code
+// patches fail until the executable is unpacked and in-game features until a
+// save is loaded.
+func (s *runSession) applyPending() {
+	var pending []game.FeatureRequest
+	for _, request := range s.pending {
+		setting := game.FeatureSetting{Feature: request.Feature, Enabled: true, Params: request.Params}
+		if err := s.session.Apply(setting); err != nil {
+			logger.Log.Debug("Feature not applied yet",
+				zap.String("feature", request.Feature.ID()),
+				zap.Error(err))
+			pending = append(pending, request)
+			continue
+		}
+		logger.Log.Info("Applied feature", zap.String("feature", request.Feature.ID()))
+	}
+	s.pending = pending
+}
+
+// running reports whether the game found still runs.
+func (s *runSession) running() bool {
+	running := false
+	_ = s.session.Do(func(p *game.Patcher) error {
+		running = p.Running()
+		return nil
+	})
+	return running
+}
+
+// detach releases a game that exited.
+func (s *runSession) detach() {
+	if s.watchdog != nil {
+		s.watchdog.Stop()
+	}
+	if err := s.session.Detach(true); err != nil {
+		logger.Log.Debug("Failed to release caves of exited game", zap.Error(err))
+	}
+	s.pid = 0
+	s.pending = nil
+	s.watchdog = nil
+}
+
+// close stops following the game. Applied features stay in place.
+func (s *runSession) close() {
+	if s.watchdog != nil {
+		s.watchdog.Stop()
+	}
+	s.session.Close()
+}
@@ -0,0 +1,286 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/api"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/config"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// runServe serves the control API without the GUI, attaching to the game
+// whenever it runs.
+func runServe(env *environment, args []string) int {
+	flags := env.newFlagSet("serve")
+	socket := flags.String("socket", api.SocketPath(), "path of the control socket")
+	apply := flags.Bool("apply", false, "apply the saved config whenever the game starts")
+	interval := flags.Duration("interval", game.DefaultProcessInterval, "how often to look for the game when process events are unavailable")
+	snapshot := flags.String("snapshot", "", "serve a memory snapshot instead of the game; changes are kept in memory")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *interval <= 0 {
+		env.errorf("interval must be positive")
+		return ExitUsage
+	}
+
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	cfg := env.loadConfig()
+	session := game.NewSession()
+	defer session.Close()
+	server := api.NewServer(cfg, session)
+	if err := server.Listen(*socket); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	defer func() { _ = server.Close() }()
+	go func() {
+		if err := server.Serve(); err != nil {
+			logger.Log.Error("Control API stopped", zap.Error(err))
+		}
+	}()
+
+	// The config is reloaded when it is edited, like in the GUI.
+	var changes <-chan config.Change
+	if watcher, err := config.Watch(); err != nil {
+		logger.Log.Warn("Config changes will not be picked up", zap.Error(err))
+	} else {
+		defer func() { _ = watcher.Close() }()
+		changes = watcher.Changes()
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	processes := game.NewProcessMonitor(*interval)
+	candidates, exits := processes.Candidates(), processes.Exits()
+	if *snapshot != "" {
+		release, err := serveSnapshot(session, *snapshot)
+		if err != nil {
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+		defer release()
+		server.SetAttached(true)
+		candidates, exits = nil, nil
+	} else {
+		processes.Start()
+		defer processes.Stop()
+	}
+
+	var (
+		watchdog *game.Watchdog
+		monitor  *game.EventMonitor
+		states   *game.StateMonitor
+	)
+	stopMonitors := func() {
+		if watchdog != nil {
+			watchdog.Stop()
+			monitor.Stop()
+			states.Stop()
+		}
+		watchdog, monitor, states = nil, nil, nil
+	}
+	defer stopMonitors()
+
+	attach := func(candidates []game.Candidate) {
+		if session.Attached() || len(candidates) == 0 {
+			return
+		}
+		if game.Ambiguous(candidates) {
+			logger.Log.Warn("Several processes could be the game, using the first", zap.Int("pid", candidates[0].PID))
+		}
+
+		pid := candidates[0].PID
+		patcher, err := game.NewPatcher(pid)
+		if err != nil {
+			logger.Log.Debug("Game module not ready", zap.Int("pid", pid), zap.Error(err))
+			return
+		}
+		processes.Track(pid, patcher.StartTime())
+		if err := patcher.EnableJournal(); err != nil {
+			logger.Log.Warn("Patch journal unavailable", zap.Error(err))
+		}
+
+		if err := session.Attach(patcher); err != nil {
+			logger.Log.Warn("Failed to release the previous game", zap.Error(err))
+		}
+		server.SetAttached(true)
+		logger.Log.Info("Attached to game", zap.Int("pid", pid))
+
+		watchdog = game.NewWatchdog(session, game.DefaultWatchdogInterval)
+		watchdog.SetAutoHeal(cfg.AutoHeal)
+		watchdog.Start()
+		monitor = game.NewEventMonitor(session, game.DefaultEventMonitorInterval)
+		monitor.Start()
+		go forwardEvents(server, watchdog, monitor)
+		states = game.NewStateMonitor(session, game.DefaultStateInterval)
+		states.Start()
+		go forwardStates(server, states)
+
+		if *apply {
+			if err := server.ApplyAll(); err != nil {
+				logger.Log.Warn("Some features failed to apply", zap.Error(err))
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ExitOK
+		case found, ok := <-candidates:
+			if !ok {
+				return ExitFailure
+			}
+			attach(found)
+		case exited := <-exits:
+			if !session.Attached() || exited != session.PID() {
+				continue
+			}
+			logger.Log.Info("Game exited", zap.Int("pid", exited))
+			if err := session.Detach(true); err != nil {
+				logger.Log.Debug("Failed to release caves of exited game", zap.Error(err))
+			}
+			server.SetAttached(false)
+			stopMonitors()
+		case change, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			if change.Err != nil {
+				env.errorf("%v", change.Err)
+			}
+			cfg = change.Config
+			if watchdog != nil {
+				watchdog.SetAutoHeal(cfg.AutoHeal)
+			}
+			if err := server.ReloadConfig(change.Profile, cfg, cfg.ApplyOnChange); err != nil {
+				logger.Log.Warn("Some features failed to apply", zap.Error(err))
+			}
+		}
+	}
+}
+
+// serveSnapshot attaches session to a snapshot. Writes go to an overlay, so
+// clients can apply and revert features as if the game were running.
+func serveSnapshot(session *game.Session, path string) (func(), error) {
+	snapshot, err := memory.OpenSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	patcher, err := game.NewPatcherWithMemory(memory.NewOverlay(snapshot))
+	if err != nil {
+		_ = snapshot.Close()
+		return nil, err
+	}
+	if err := session.Attach(patcher); err != nil {
+		_ = snapshot.Close()
+		return nil, err
+	}
+	logger.Log.Info("Serving snapshot", zap.String("path", path))
+	return func() { _ = snapshot.Close() }, nil
+}
+
+// forwardEvents publishes drift and hook events until both sources stop.
+func forwardEvents(server *api.Server, watchdog *game.Watchdog, monitor *game.EventMonitor) {
+	go func() {
+		for event := range watchdog.Events() {
+			server.PublishDrift(event)
+		}
+	}()
+	for event := range monitor.Events() {
+		server.PublishGameEvent(event)
+	}
+}
+
+// forwardStates publishes game state changes until the monitor stops.
+func forwardStates(server *api.Server, states *game.StateMonitor) {
+	for change := range states.Changes() {
+		logger.Log.Info("Game state changed", zap.Stringer("state", change.To))
+		if err := server.SetGameState(change); err != nil {
+			logger.Log.Warn("Some features failed to apply", zap.Error(err))
+		}
+	}
+}
+
+func runCall(env *environment, args []string) int {
+	flags := env.newFlagSet("call")
+	flags.Usage = func() {
+		env.errorf("usage: call [flags] method [params-json]\n\nmethods: %s", strings.Join(api.MethodNames(), ", "))
+		flags.PrintDefaults()
+	}
+	socket := flags.String("socket", api.SocketPath(), "path of the control socket")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if flags.NArg() == 0 || flags.NArg() > 2 {
+		flags.Usage()
+		return ExitUsage
+	}
+
+	method := flags.Arg(0)
+	var params any
+	if flags.NArg() == 2 {
+		raw := json.RawMessage(flags.Arg(1))
+		if !json.Valid(raw) {
+			env.errorf("params are not valid JSON")
+			return ExitUsage
+		}
+		params = raw
+	}
+
+	client, err := api.Dial(*socket)
+	if err != nil {
+		env.errorf("%v (start the GUI or `sekiro-tweaker-cli serve`)", err)
+		return ExitFailure
+	}
+	defer func() { _ = client.Close() }()
+
+	var result json.RawMessage
+	if err := client.Call(method, params, &result); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+	if err := env.writeJSON(result); err != nil {
+		return ExitFailure
+	}
+
+	if method != "events.subscribe" {
+		return ExitOK
+	}
+
+	// Stream events until interrupted or the tweaker exits.
+	ctx, cancel := interruptContext()
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = client.Close()
+	}()
+
+	for {
+		event, err := client.NextEvent()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return ExitOK
+			}
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+		if err := env.writeJSONLine(event); err != nil {
+			return ExitFailure
+		}
+	}
+}
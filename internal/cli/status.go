@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+// FeatureStatus is the status of one feature in the status output.
+type FeatureStatus struct {
+	Feature string             `json:"feature"`
+	Name    string             `json:"name"`
+	Status  game.FeatureStatus `json:"status"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// Status is the output of the status command.
+type Status struct {
+	PID      int             `json:"pid"`
+	Features []FeatureStatus `json:"features"`
+	Stats    game.Stats      `json:"stats"`
+}
+
+func runStatus(env *environment, args []string) int {
+	flags := env.newFlagSet("status")
+	var t target
+	t.register(flags)
+	asJSON := flags.Bool("json", false, "print the status as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if err := loadPatches(); err != nil {
+		env.errorf("%v", err)
+		return ExitFailure
+	}
+
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	status := Status{PID: patcher.PID(), Stats: patcher.ReadStats()}
+	for _, feature := range game.Features() {
+		entry := FeatureStatus{Feature: feature.ID(), Name: feature.Name()}
+		if entry.Status, err = feature.Status(patcher); err != nil {
+			entry.Error = err.Error()
+		}
+		status.Features = append(status.Features, entry)
+	}
+
+	if *asJSON {
+		if err := env.writeJSON(status); err != nil {
+			env.errorf("%v", err)
+			return ExitFailure
+		}
+		return ExitOK
+	}
+
+	fmt.Fprintf(env.stdout, "pid: %d\n\n", status.PID)
+	table := env.table()
+	for _, entry := range status.Features {
+		if entry.Error != "" {
+			fmt.Fprintf(table, "%s\t%s (%s)\n", entry.Name, entry.Status, entry.Error)
+		} else {
+			fmt.Fprintf(table, "%s\t%s\n", entry.Name, entry.Status)
+		}
+	}
+	_ = table.Flush()
+	fmt.Fprintf(env.stdout, "\n%s\n", formatStats(status.Stats))
+	return ExitOK
+}
+
+func formatStats(stats game.Stats) string {
+	return fmt.Sprintf("deaths: %s  kills: %s  game speed: %s  player speed: %s",
+		formatValue(stats.Deaths, "%d"),
+		formatValue(stats.Kills, "%d"),
+		formatValue(stats.GameSpeed, "%.2f"),
+		formatValue(stats.PlayerSpeed, "%.2f"))
+}
+
+func formatValue[T any](value *T, format string) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf(format, *value)
+}
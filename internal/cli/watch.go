@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+)
+
+// errTimeout is returned by waitForGame when the deadline passes.
+var errTimeout = errors.New("timed out waiting for the game")
+
+// StatsSample is one line of watch output.
+type StatsSample struct {
+	Time time.Time `json:"time"`
+	game.Stats
+}
+
+// interruptContext returns a context cancelled by SIGINT or SIGTERM.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func runWatch(env *environment, args []string) int {
+	flags := env.newFlagSet("watch")
+	var t target
+	t.register(flags)
+	interval := flags.Duration("interval", time.Second, "how often to read the stats")
+	all := flags.Bool("all", false, "print every sample, not just changes")
+	asJSON := flags.Bool("json", false, "print one JSON object per line")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if *interval <= 0 {
+		env.errorf("interval must be positive")
+		return ExitUsage
+	}
+
+	patcher, release, err := t.attach()
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+	defer release()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	var last *game.Stats
+	for {
+		if !patcher.Running() {
+			env.errorf("game exited")
+			return ExitNoGame
+		}
+
+		stats := patcher.ReadStats()
+		if *all || last == nil || !stats.Equal(*last) {
+			sample := StatsSample{Time: time.Now(), Stats: stats}
+			if *asJSON {
+				err = env.writeJSONLine(sample)
+			} else {
+				_, err = fmt.Fprintf(env.stdout, "%s  %s\n", sample.Time.Format("15:04:05"), formatStats(stats))
+			}
+			if err != nil {
+				return ExitFailure
+			}
+			last = &stats
+		}
+
+		select {
+		case <-ctx.Done():
+			return ExitOK
+		case <-ticker.C:
+		}
+	}
+}
+
+func runWaitForGame(env *environment, args []string) int {
+	flags := env.newFlagSet("wait-for-game")
+	timeout := flags.Duration("timeout", 0, "give up after this long (default: wait forever)")
+	inGame := flags.Bool("in-game", false, "also wait until a save is loaded and the stat pointers resolve")
+	interval := flags.Duration("interval", time.Second, "how often to check")
+	asJSON := flags.Bool("json", false, "print the result as JSON")
+	if err := flags.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	pid, err := waitForGame(ctx, *interval, *inGame)
+	if err != nil {
+		env.errorf("%v", err)
+		return ExitNoGame
+	}
+
+	if *asJSON {
+		if err := env.writeJSON(map[string]int{"pid": pid}); err != nil {
+			return ExitFailure
+		}
+	} else {
+		fmt.Fprintln(env.stdout, pid)
+	}
+	return ExitOK
+}
+
+// waitForGame polls until the game module is mapped and, if inGame is set,
+// until the player is loaded.
+func waitForGame(ctx context.Context, interval time.Duration, inGame bool) (int, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if pid, err := findGame(); err == nil {
+			if patcher, err := game.NewPatcher(pid); err == nil {
+				if _, err := patcher.GetPlayerSpeedAddress(); !inGame || err == nil {
+					return pid, nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return 0, errTimeout
+			}
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
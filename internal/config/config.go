@@ -1,57 +1,67 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
 )
 
+// FeatureConfig is the saved state of one game.Feature, keyed by its ID.
+type FeatureConfig struct {
+	Enabled bool               `yaml:"enabled"`
+	Params  map[string]float64 `yaml:"params,omitempty"`
+}
+
 type Config struct {
-	FPSUnlock      bool    `yaml:"fps_unlock"`
-	FPS            int     `yaml:"fps"`
-	Resolution     bool    `yaml:"resolution"`
-	Width          int     `yaml:"width"`
-	Height         int     `yaml:"height"`
-	FOV            bool    `yaml:"fov"`
-	FOVValue       float64 `yaml:"fov_value"`
-	CameraReset    bool    `yaml:"camera_reset"`
-	AutoLoot       bool    `yaml:"auto_loot"`
-	Dragonrot      bool    `yaml:"dragonrot"`
-	DeathPenalty   bool    `yaml:"death_penalty"`
-	GameSpeed      bool    `yaml:"game_speed"`
-	GameSpeedVal   float64 `yaml:"game_speed_value"`
-	PlayerSpeed    bool    `yaml:"player_speed"`
-	PlayerSpeedVal float64 `yaml:"player_speed_value"`
+	// Version is the schema version the config was written with. Older
+	// configs are migrated on load.
+	Version int `yaml:"version"`
+	// Features holds the state of each feature. Features missing from the map
+	// use the defaults declared by the feature.
+	Features map[string]FeatureConfig `yaml:"features"`
+	AutoHeal bool                     `yaml:"auto_heal"`
+	// ApplyOnChange re-applies the config to the attached game when its file
+	// is changed outside the app.
+	ApplyOnChange bool `yaml:"apply_on_change"`
+	// LiveApply applies each change to a feature's controls right away
+	// instead of waiting for "Apply Patches".
+	LiveApply bool `yaml:"live_apply"`
+	// Log overrides the logging defaults. Environment variables and command
+	// line flags override it in turn.
+	Log LogConfig `yaml:"log,omitempty"`
+}
+
+// LogConfig is the saved logging setup. Empty fields use the defaults.
+type LogConfig struct {
+	Level  string `yaml:"level,omitempty"`
+	Format string `yaml:"format,omitempty"`
+	Output string `yaml:"output,omitempty"`
+}
+
+// LogOptions returns the logging options the config sets.
+func (c *Config) LogOptions() logger.Options {
+	return logger.Options{Level: c.Log.Level, Format: c.Log.Format, Output: c.Log.Output}
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		FPSUnlock:      true,
-		FPS:            144,
-		Resolution:     false,
-		Width:          1920,
-		Height:         1080,
-		FOV:            false,
-		FOVValue:       1.0,
-		CameraReset:    false,
-		AutoLoot:       false,
-		Dragonrot:      false,
-		DeathPenalty:   false,
-		GameSpeed:      false,
-		GameSpeedVal:   1.0,
-		PlayerSpeed:    false,
-		PlayerSpeedVal: 1.0,
+		Version:  CurrentVersion,
+		Features: make(map[string]FeatureConfig),
+		AutoHeal: false,
 	}
 }
 
 func getConfigPath() (string, error) {
-	configDir, err := os.UserConfigDir()
+	appConfigDir, err := appConfigDir()
 	if err != nil {
 		return "", err
 	}
 
-	appConfigDir := filepath.Join(configDir, "sekiro-tweaker")
 	if err := os.MkdirAll(appConfigDir, 0755); err != nil {
 		return "", err
 	}
@@ -59,35 +69,85 @@ func getConfigPath() (string, error) {
 	return filepath.Join(appConfigDir, "config.yaml"), nil
 }
 
-func Load() *Config {
-	configPath, err := getConfigPath()
+// PatchesDir returns the directory holding patch definition overrides. It is
+// not created, since most users never need one.
+func PatchesDir() (string, error) {
+	appConfigDir, err := appConfigDir()
 	if err != nil {
-		return DefaultConfig()
+		return "", err
 	}
 
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return DefaultConfig()
-	}
+	return filepath.Join(appConfigDir, "patches.d"), nil
+}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return DefaultConfig()
+// Load reads the active profile. It always returns a usable config; the
+// error describes settings that were reset to their defaults or a file that
+// could not be read.
+func Load() (*Config, error) {
+	config, err := LoadProfile(ActiveProfile())
+	if config == nil {
+		return DefaultConfig(), err
 	}
-
-	return &config
+	return config, err
 }
 
-func (c *Config) Save() {
-	configPath, err := getConfigPath()
+// LoadFile reads a config from path, migrating older schema versions.
+//
+// If the file cannot be parsed, the config is nil. Otherwise invalid values
+// are reset to their defaults and reported as ValidationErrors along with the
+// config.
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if raw == nil {
+		// An empty file holds no settings rather than broken ones.
+		raw = make(map[string]any)
 	}
 
-	data, err := yaml.Marshal(c)
+	raw, migrated, err := migrate(raw)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// Unchanged files are decoded as written, so errors point at their lines.
+	if migrated {
+		if data, err = yaml.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
 	}
 
-	_ = os.WriteFile(configPath, data, 0644)
+	config := DefaultConfig()
+	var errs ValidationErrors
+	if err := yaml.Unmarshal(data, config); err != nil {
+		// Values of the wrong type are skipped and the rest of the file is
+		// still decoded.
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, message := range typeErr.Errors {
+			errs = append(errs, FieldError{Field: filepath.Base(path), Message: message})
+		}
+	}
+	if config.Features == nil {
+		config.Features = make(map[string]FeatureConfig)
+	}
+	config.Version = CurrentVersion
+
+	errs = append(errs, config.validate(true)...)
+	if len(errs) > 0 {
+		return config, errs
+	}
+	return config, nil
+}
+
+// Save writes the active profile.
+func (c *Config) Save() error {
+	return c.SaveProfile(ActiveProfile())
 }
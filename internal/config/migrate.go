@@ -0,0 +1,110 @@
+package config
+
+import "fmt"
+
+// CurrentVersion is the schema version Save writes.
+//
+// Schema versions:
+//
+//	0  one flat key per setting (fps_unlock, fps, fov_value, ...), written
+//	   before features were generic
+//	1  a features map keyed by feature ID
+const CurrentVersion = 1
+
+// migrations upgrade a decoded config from the version they are keyed by to
+// the next one.
+var migrations = map[int]func(raw map[string]any) map[string]any{
+	0: migrateFlat,
+}
+
+// schemaVersion returns the version a decoded config was written with.
+// Configs with a features map but no version predate the version field.
+func schemaVersion(raw map[string]any) (int, error) {
+	value, exists := raw["version"]
+	if !exists {
+		if _, hasFeatures := raw["features"]; hasFeatures {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	version, isInt := value.(int)
+	if !isInt || version < 0 {
+		return 0, fmt.Errorf("version: expected a schema version, got %v", value)
+	}
+	return version, nil
+}
+
+// migrate upgrades a decoded config to CurrentVersion and reports whether it
+// had to be changed.
+func migrate(raw map[string]any) (map[string]any, bool, error) {
+	version, err := schemaVersion(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	if version == CurrentVersion {
+		return raw, false, nil
+	}
+	if version > CurrentVersion {
+		return nil, false, fmt.Errorf("written by a newer version of the tweaker (schema %d, this version reads up to %d)",
+			version, CurrentVersion)
+	}
+
+	for ; version < CurrentVersion; version++ {
+		raw = migrations[version](raw)
+	}
+	raw["version"] = CurrentVersion
+	return raw, true, nil
+}
+
+// migrateFlat moves the flat keys of a version 0 config into the features map.
+func migrateFlat(raw map[string]any) map[string]any {
+	migrated := map[string]any{"features": convertLegacy(raw)}
+	if autoHeal, exists := raw["auto_heal"]; exists {
+		migrated["auto_heal"] = autoHeal
+	}
+	return migrated
+}
+
+// legacyFeatures maps the flat keys of configs written before features were
+// generic to feature and parameter IDs.
+var legacyFeatures = []struct {
+	id     string
+	params map[string]string
+}{
+	{"fps_unlock", map[string]string{"fps": "fps"}},
+	{"resolution", map[string]string{"width": "width", "height": "height"}},
+	{"fov", map[string]string{"fov": "fov_value"}},
+	{"camera_reset", nil},
+	{"auto_loot", nil},
+	{"dragonrot", nil},
+	{"death_penalty", nil},
+	{"game_speed", map[string]string{"speed": "game_speed_value"}},
+	{"player_speed", map[string]string{"speed": "player_speed_value"}},
+}
+
+// convertLegacy builds the feature map from a flat legacy config. Each
+// feature's enabled flag was stored under its ID.
+func convertLegacy(raw map[string]any) map[string]FeatureConfig {
+	features := make(map[string]FeatureConfig)
+
+	for _, legacy := range legacyFeatures {
+		enabled, exists := raw[legacy.id].(bool)
+		if !exists {
+			continue
+		}
+
+		feature := FeatureConfig{Enabled: enabled, Params: make(map[string]float64)}
+		for param, key := range legacy.params {
+			switch value := raw[key].(type) {
+			case int:
+				feature.Params[param] = float64(value)
+			case float64:
+				feature.Params[param] = value
+			}
+		}
+		features[legacy.id] = feature
+	}
+
+	return features
+}
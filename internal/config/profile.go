@@ -0,0 +1,355 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfile is the profile kept in config.yaml, which is what every
+// config was before profiles existed. Other profiles live in profiles/.
+const DefaultProfile = "default"
+
+const profileExtension = ".yaml"
+
+func appConfigDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "sekiro-tweaker"), nil
+}
+
+func profilesDir() (string, error) {
+	dir, err := appConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "profiles"), nil
+}
+
+// ValidateProfileName rejects names that cannot be used as file names.
+func ValidateProfileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("profile name is empty")
+	}
+	if strings.HasPrefix(name, ".") {
+		return fmt.Errorf("profile name %q must not start with a dot", name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == ' ', r == '-', r == '_', r == '.', r == '+':
+		default:
+			return fmt.Errorf("profile name %q may only contain letters, digits, spaces and - _ . +", name)
+		}
+	}
+	return nil
+}
+
+func profilePath(name string) (string, error) {
+	if err := ValidateProfileName(name); err != nil {
+		return "", err
+	}
+	if name == DefaultProfile {
+		return getConfigPath()
+	}
+
+	dir, err := profilesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+profileExtension), nil
+}
+
+// ProfileNames returns the default profile followed by the others in
+// alphabetical order.
+func ProfileNames() ([]string, error) {
+	names := []string{DefaultProfile}
+
+	dir, err := profilesDir()
+	if err != nil {
+		return names, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return names, err
+	}
+
+	var others []string
+	for _, entry := range entries {
+		name, isProfile := strings.CutSuffix(entry.Name(), profileExtension)
+		if !isProfile || entry.IsDir() || name == DefaultProfile || ValidateProfileName(name) != nil {
+			continue
+		}
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	return append(names, others...), nil
+}
+
+// ProfileExists reports whether a profile has been saved. The default
+// profile always exists.
+func ProfileExists(name string) bool {
+	if name == DefaultProfile {
+		return true
+	}
+	path, err := profilePath(name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func activeProfilePath() (string, error) {
+	dir, err := appConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "active_profile"), nil
+}
+
+// ActiveProfile returns the profile Load and Save use. A missing or deleted
+// profile falls back to the default one.
+func ActiveProfile() string {
+	path, err := activeProfilePath()
+	if err != nil {
+		return DefaultProfile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return DefaultProfile
+	}
+
+	name := strings.TrimSpace(string(data))
+	if !ProfileExists(name) {
+		return DefaultProfile
+	}
+	return name
+}
+
+// SetActiveProfile makes Load and Save use a saved profile.
+func SetActiveProfile(name string) error {
+	if err := ValidateProfileName(name); err != nil {
+		return err
+	}
+	if !ProfileExists(name) {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+
+	path, err := activeProfilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(name+"\n"), 0644); err != nil {
+		return err
+	}
+
+	// The caller switched profiles itself and loads the new one.
+	if profile, err := profilePath(name); err == nil {
+		if data, err := os.ReadFile(profile); err == nil {
+			recordWrite(profile, data)
+		}
+	}
+	return nil
+}
+
+// written holds what this process last wrote to each profile, so a Watcher
+// does not report the app's own saves back to it as changes.
+var written = struct {
+	sync.Mutex
+	data map[string][]byte
+}{data: make(map[string][]byte)}
+
+func recordWrite(path string, data []byte) {
+	written.Lock()
+	defer written.Unlock()
+	written.data[path] = data
+}
+
+// consumeWrite reports whether data is what this process last wrote to path.
+func consumeWrite(path string, data []byte) bool {
+	written.Lock()
+	defer written.Unlock()
+	if saved, ok := written.data[path]; ok && bytes.Equal(saved, data) {
+		delete(written.data, path)
+		return true
+	}
+	return false
+}
+
+// LoadProfile reads a profile. The default profile reads as the default
+// config until it is first saved.
+//
+// A config is returned unless the profile does not exist. A file that cannot
+// be parsed is copied to a backup next to it and the default config is
+// returned with an error saying so, since the next save replaces it. Invalid
+// values are reset and reported as ValidationErrors.
+func LoadProfile(name string) (*Config, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadFile(path)
+	switch {
+	case err == nil:
+		return config, nil
+	case errors.Is(err, fs.ErrNotExist):
+		if name == DefaultProfile {
+			return DefaultConfig(), nil
+		}
+		return nil, fmt.Errorf("profile %q does not exist", name)
+	case config == nil:
+		backup, backupErr := backupFile(path)
+		if backupErr != nil {
+			return DefaultConfig(), fmt.Errorf("%v; using the default settings", err)
+		}
+		return DefaultConfig(), fmt.Errorf("%v; using the default settings, the unreadable file was copied to %s",
+			err, backup)
+	default:
+		return config, err
+	}
+}
+
+// backupFile copies a config that could not be read next to it, so saving
+// over it does not lose the user's settings.
+func backupFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	// The same file is backed up once, however often it is loaded.
+	backups, _ := filepath.Glob(path + ".broken*")
+	for _, backup := range backups {
+		if existing, err := os.ReadFile(backup); err == nil && bytes.Equal(existing, data) {
+			return backup, nil
+		}
+	}
+
+	backup := path + ".broken"
+	if len(backups) > 0 {
+		backup = fmt.Sprintf("%s.broken-%s", path, time.Now().Format("20060102-150405"))
+	}
+	if err := os.WriteFile(backup, data, 0644); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// SaveProfile writes c as the named profile, creating it if needed.
+func (c *Config) SaveProfile(name string) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	return c.SaveFile(path)
+}
+
+// SaveFile writes c to path. The file is written next to path and renamed
+// over it, so a crash never leaves a half written config.
+func (c *Config) SaveFile(path string) error {
+	saved := *c
+	saved.Version = CurrentVersion
+	data, err := yaml.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(temp.Name()) }()
+
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	recordWrite(path, data)
+	return nil
+}
+
+// DeleteProfile removes a profile. The default profile cannot be deleted.
+func DeleteProfile(name string) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("the default profile cannot be deleted")
+	}
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("profile %q does not exist", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// ImportProfile copies a config file into a profile, named after the file
+// unless name is set, and returns the profile name. Files with invalid values
+// are rejected and existing profiles are not overwritten.
+func ImportProfile(path, name string) (string, error) {
+	config, err := LoadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := ValidateProfileName(name); err != nil {
+		return "", err
+	}
+	if ProfileExists(name) {
+		return "", fmt.Errorf("profile %q already exists", name)
+	}
+
+	return name, config.SaveProfile(name)
+}
+
+// ExportProfile writes a profile to path so it can be shared.
+func ExportProfile(name, path string) error {
+	config, err := LoadProfile(name)
+	if err != nil {
+		return err
+	}
+	return config.SaveFile(path)
+}
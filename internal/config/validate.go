@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/game"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// FieldError is a problem with one config field, named by its YAML path,
+// e.g. "features.fps_unlock.params.fps".
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors lists every invalid field of a config.
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks that every feature and parameter exists, that values are
+// within the ranges the features declare and that the log settings are known.
+func (c *Config) Validate() ValidationErrors {
+	return c.validate(false)
+}
+
+// validate reports invalid fields and, if reset is set, removes unknown
+// features and parameters and resets invalid values to their defaults.
+func (c *Config) validate(reset bool) ValidationErrors {
+	ids := make([]string, 0, len(c.Features))
+	for id := range c.Features {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs ValidationErrors
+	for _, id := range ids {
+		feature, known := game.LookupFeature(id)
+		if !known {
+			errs = append(errs, FieldError{Field: "features." + id, Message: "unknown feature"})
+			if reset {
+				delete(c.Features, id)
+			}
+			continue
+		}
+
+		saved := c.Features[id]
+		names := make([]string, 0, len(saved.Params))
+		for name := range saved.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			field := fmt.Sprintf("features.%s.params.%s", id, name)
+			param, known := lookupParam(feature, name)
+			if !known {
+				errs = append(errs, FieldError{Field: field, Message: "unknown parameter"})
+				if reset {
+					delete(saved.Params, name)
+				}
+				continue
+			}
+
+			value := saved.Params[name]
+			switch {
+			case math.IsNaN(value) || value < param.Min || value > param.Max:
+				errs = append(errs, FieldError{
+					Field:   field,
+					Message: fmt.Sprintf("must be between %g and %g, got %g", param.Min, param.Max, value),
+				})
+				if reset {
+					saved.Params[name] = param.Default
+				}
+			case param.Kind == game.ParamInt && value != math.Trunc(value):
+				errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("must be a whole number, got %g", value)})
+				if reset {
+					saved.Params[name] = math.Round(value)
+				}
+			}
+		}
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *string
+		check func(string) error
+	}{
+		{"log.level", &c.Log.Level, logger.ValidateLevel},
+		{"log.format", &c.Log.Format, logger.ValidateFormat},
+		{"log.output", &c.Log.Output, logger.ValidateOutput},
+	} {
+		if err := field.check(*field.value); err != nil {
+			errs = append(errs, FieldError{Field: field.name, Message: err.Error()})
+			if reset {
+				*field.value = ""
+			}
+		}
+	}
+	return errs
+}
+
+func lookupParam(feature game.Feature, id string) (game.Param, bool) {
+	for _, param := range feature.Params() {
+		if param.ID == id {
+			return param, true
+		}
+	}
+	return game.Param{}, false
+}
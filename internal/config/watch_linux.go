@@ -0,0 +1,222 @@
+//go:build linux
+// +build linux
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// reloadDelay is how long the watcher waits for a burst of events to end,
+// e.g. an editor writing a temporary file, renaming it and fixing modes.
+const reloadDelay = 200 * time.Millisecond
+
+// Change is a reload of the active profile after its file changed on disk.
+type Change struct {
+	Profile string
+	Config  *Config
+	// Err describes invalid settings, which were reset, or a file that could
+	// not be read, as returned by Load.
+	Err error
+}
+
+// Watcher reloads the active profile whenever its file or the active profile
+// selection changes on disk.
+//
+// Directories are watched rather than files, since editors and Save replace
+// a file by renaming a new one over it, which a watch on the file would not
+// survive.
+type Watcher struct {
+	file    *os.File
+	dirs    map[int32]string
+	changes chan Change
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+
+// Watch starts watching the config directory.
+func Watch() (*Watcher, error) {
+	configDir, err := appConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := profilesDir()
+	if err != nil {
+		return nil, err
+	}
+	// Watching profiles/ before it exists would miss the first profile.
+	if err := os.MkdirAll(profiles, 0755); err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %v", err)
+	}
+
+	w := &Watcher{
+		// A non-blocking descriptor lets the runtime poller wake the reader
+		// when the file is closed.
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dirs:    make(map[int32]string),
+		changes: make(chan Change, 1),
+		stop:    make(chan struct{}),
+	}
+
+	for _, dir := range []string{configDir, profiles} {
+		wd, err := syscall.InotifyAddWatch(fd, dir, watchMask)
+		if err != nil {
+			_ = w.file.Close()
+			return nil, fmt.Errorf("inotify %s: %v", dir, err)
+		}
+		w.dirs[int32(wd)] = dir
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Changes returns the reloads. The channel is closed once the watcher stops.
+func (w *Watcher) Changes() <-chan Change {
+	return w.changes
+}
+
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		err = w.file.Close()
+	})
+	return err
+}
+
+func (w *Watcher) run() {
+	defer close(w.changes)
+
+	events := make(chan string, 16)
+	go w.read(events)
+
+	// The content last seen is kept so that touching the file, or an event
+	// for another file, does not count as a change.
+	last := readActive()
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-w.stop:
+			return
+		case path, ok := <-events:
+			if !ok {
+				return
+			}
+			if !w.relevant(path) {
+				continue
+			}
+			timer = time.After(reloadDelay)
+		case <-timer:
+			timer = nil
+
+			data := readActive()
+			// A file that is missing, e.g. between an editor removing and
+			// recreating it, is not a change to the defaults.
+			if data == nil || bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+
+			if consumeWrite(activePath(), data) {
+				continue
+			}
+
+			change := Change{Profile: ActiveProfile()}
+			change.Config, change.Err = Load()
+			logger.Log.Info("Config changed on disk", zap.String("profile", change.Profile), zap.Error(change.Err))
+
+			select {
+			case w.changes <- change:
+			case <-w.stop:
+				return
+			}
+		}
+	}
+}
+
+// relevant reports whether path is the active profile or the file selecting
+// it.
+func (w *Watcher) relevant(path string) bool {
+	if selection, err := activeProfilePath(); err == nil && path == selection {
+		return true
+	}
+	return path == activePath()
+}
+
+// read turns inotify events into the paths they name.
+func (w *Watcher) read(events chan<- string) {
+	defer close(events)
+
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			select {
+			case <-w.stop:
+			default:
+				logger.Log.Warn("Config watcher stopped", zap.Error(err))
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			dir, watched := w.dirs[event.Wd]
+			if !watched || event.Len == 0 || offset > n {
+				continue
+			}
+			name := string(bytes.TrimRight(buffer[nameStart:offset], "\x00"))
+
+			select {
+			case events <- filepath.Join(dir, name):
+			case <-w.stop:
+				return
+			}
+		}
+	}
+}
+
+func activePath() string {
+	path, err := profilePath(ActiveProfile())
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// readActive returns the active profile's file, or nil if it is missing.
+func readActive() []byte {
+	path := activePath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,286 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// CheckResult is the outcome of a diagnostic check.
+type CheckResult string
+
+const (
+	CheckOK      CheckResult = "ok"
+	CheckWarning CheckResult = "warning"
+	CheckFailed  CheckResult = "failed"
+)
+
+// DiagnosticCheck is one line of a DoctorReport.
+type DiagnosticCheck struct {
+	Group  string      `json:"group"`
+	Name   string      `json:"name"`
+	Result CheckResult `json:"result"`
+	Detail string      `json:"detail,omitempty"`
+	// Hint suggests how to fix a failed or suspicious check.
+	Hint string `json:"hint,omitempty"`
+}
+
+// GameBuild identifies the game executable by its PE headers.
+type GameBuild struct {
+	LinkTime    time.Time `json:"link_time"`
+	SizeOfImage uint32    `json:"size_of_image"`
+}
+
+func (b GameBuild) String() string {
+	return fmt.Sprintf("linked %s, image size 0x%X", b.LinkTime.Format(time.RFC3339), b.SizeOfImage)
+}
+
+// DoctorReport collects everything that can go wrong between finding the game
+// and patching it.
+type DoctorReport struct {
+	CreatedAt time.Time         `json:"created_at"`
+	PID       int               `json:"pid,omitempty"`
+	Build     *GameBuild        `json:"build,omitempty"`
+	Checks    []DiagnosticCheck `json:"checks"`
+}
+
+func (r *DoctorReport) add(group, name string, result CheckResult, detail, hint string) {
+	r.Checks = append(r.Checks, DiagnosticCheck{
+		Group:  group,
+		Name:   name,
+		Result: result,
+		Detail: detail,
+		Hint:   hint,
+	})
+}
+
+// Count returns how many checks had the given result.
+func (r *DoctorReport) Count(result CheckResult) int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Result == result {
+			count++
+		}
+	}
+	return count
+}
+
+// describeCandidates lists the processes that matched, most likely first.
+func describeCandidates(candidates []Candidate) string {
+	descriptions := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		descriptions[i] = candidate.String()
+	}
+	return fmt.Sprintf("%d processes matched: %s", len(candidates), strings.Join(descriptions, "; "))
+}
+
+const ptraceHint = "run `sudo sysctl kernel.yama.ptrace_scope=0` or `sudo setcap cap_sys_ptrace=ep` on the tweaker binary"
+
+// Diagnose checks ptrace permissions, process detection, the game module and
+// every patch definition. A pid of 0 uses the process FindCandidates ranks
+// first.
+func Diagnose(pid int) *DoctorReport {
+	report := &DoctorReport{CreatedAt: time.Now()}
+
+	report.checkPtrace()
+
+	candidates, err := FindCandidates()
+	switch {
+	case err != nil:
+		report.add("process", "detection", CheckFailed, err.Error(), "")
+	case len(candidates) == 0:
+		report.add("process", "detection", CheckFailed, ProcessName+".exe is not running", "start the game first")
+	case len(candidates) == 1:
+		report.add("process", "detection", CheckOK, candidates[0].String(), "")
+	case Ambiguous(candidates):
+		report.add("process", "detection", CheckWarning, describeCandidates(candidates),
+			"the first one is used; pick the game in the GUI, pass --pid or close the other instance")
+	default:
+		report.add("process", "detection", CheckOK, describeCandidates(candidates), "")
+	}
+
+	if pid == 0 {
+		if len(candidates) == 0 {
+			return report
+		}
+		pid = candidates[0].PID
+	}
+	report.PID = pid
+
+	if err := memory.CheckProcMemWritable(pid); err != nil {
+		report.add("process", fmt.Sprintf("/proc/%d/mem", pid), CheckFailed, err.Error(), ptraceHint)
+	} else {
+		report.add("process", fmt.Sprintf("/proc/%d/mem", pid), CheckOK, "writable", "")
+	}
+
+	report.checkGame(memory.NewProcessMemory(pid))
+	return report
+}
+
+// DiagnoseMemory runs the module and patch checks against any address space,
+// such as a snapshot.
+func DiagnoseMemory(mem memory.ReadWriter) *DoctorReport {
+	report := &DoctorReport{CreatedAt: time.Now()}
+	report.checkGame(mem)
+	return report
+}
+
+func (r *DoctorReport) checkPtrace() {
+	scope, err := memory.PtraceScope()
+	if err != nil {
+		r.add("system", "ptrace_scope", CheckWarning, err.Error(), "")
+		return
+	}
+
+	privileged, err := memory.HasCapability(memory.CapSysPtrace)
+	switch {
+	case err != nil:
+		r.add("system", "CAP_SYS_PTRACE", CheckWarning, err.Error(), "")
+	case privileged:
+		r.add("system", "CAP_SYS_PTRACE", CheckOK, "present", "")
+	default:
+		r.add("system", "CAP_SYS_PTRACE", CheckOK, "not present", "")
+	}
+
+	switch {
+	case scope == -1:
+		r.add("system", "ptrace_scope", CheckOK, "Yama is not enabled", "")
+	case scope == 0:
+		r.add("system", "ptrace_scope", CheckOK, "0 (classic)", "")
+	case scope == 3:
+		r.add("system", "ptrace_scope", CheckFailed, "3 (attaching is disabled until reboot)",
+			"set kernel.yama.ptrace_scope to 0 in sysctl.conf and reboot")
+	case privileged:
+		r.add("system", "ptrace_scope", CheckOK, fmt.Sprintf("%d (allowed by CAP_SYS_PTRACE)", scope), "")
+	case scope == 1:
+		r.add("system", "ptrace_scope", CheckFailed, "1 (only the game's parent may access its memory)",
+			ptraceHint+", or launch the game with `sekiro-tweaker run -- %command%` as its Steam launch option")
+	default:
+		r.add("system", "ptrace_scope", CheckFailed, fmt.Sprintf("%d (CAP_SYS_PTRACE is required)", scope),
+			"run `sudo setcap cap_sys_ptrace=ep` on the tweaker binary")
+	}
+}
+
+func (r *DoctorReport) checkGame(mem memory.ReadWriter) {
+	baseAddress, err := memory.FindModuleBaseAddress(mem, ProcessName)
+	if err != nil {
+		r.add("module", "base address", CheckFailed, err.Error(), "")
+		return
+	}
+	r.add("module", "base address", CheckOK, fmt.Sprintf("0x%X", baseAddress), "")
+
+	header, err := memory.NewPEParser(mem, baseAddress).Header()
+	if err != nil {
+		r.add("module", "PE header", CheckFailed, err.Error(),
+			"memory could not be read or the module is not the game executable; check ptrace permissions")
+		return
+	}
+	if header.Machine != 0x8664 {
+		r.add("module", "PE header", CheckWarning, fmt.Sprintf("unexpected machine 0x%X", header.Machine), "")
+	} else {
+		r.add("module", "PE header", CheckOK, fmt.Sprintf("x86-64, %d sections", header.NumberOfSections), "")
+	}
+
+	build := GameBuild{
+		LinkTime:    time.Unix(int64(header.TimeDateStamp), 0).UTC(),
+		SizeOfImage: header.SizeOfImage,
+	}
+	r.Build = &build
+	r.add("module", "game build", CheckOK, build.String(), "")
+
+	patcher, err := NewPatcherWithMemory(mem)
+	if err != nil {
+		r.add("module", "patcher", CheckFailed, err.Error(), "")
+		return
+	}
+
+	for _, id := range patcher.patches.IDs() {
+		r.checkPatch(patcher, id)
+	}
+
+	pointers := []struct {
+		name    string
+		resolve func() (int64, error)
+	}{
+		{"game speed", patcher.GetGameSpeedAddress},
+		{"player speed", patcher.GetPlayerSpeedAddress},
+		{"player deaths", patcher.GetPlayerDeathsAddress},
+		{"total kills", patcher.GetTotalKillsAddress},
+	}
+	for _, pointer := range pointers {
+		address, err := pointer.resolve()
+		if err != nil {
+			r.add("pointers", pointer.name, CheckWarning, err.Error(),
+				"pointer chains only resolve once a save is loaded")
+			continue
+		}
+		r.add("pointers", pointer.name, CheckOK, fmt.Sprintf("0x%X", address), "")
+	}
+}
+
+// checkPatch looks up a definition's signature and, for byte patches, whether
+// the game holds the vanilla or the patched bytes.
+func (r *DoctorReport) checkPatch(patcher *Patcher, id string) {
+	definition, address, err := patcher.findPatch(id)
+	if err != nil {
+		r.add("signatures", id, CheckFailed, err.Error(), fmt.Sprintf(
+			"the game build may not be supported; the definition comes from %s and can be overridden in patches.d",
+			patcher.patches.Source(id)))
+		return
+	}
+
+	detail := fmt.Sprintf("0x%X", address)
+	if len(definition.Patched) == 0 || len(definition.Vanilla) == 0 {
+		r.add("signatures", id, CheckOK, detail, "")
+		return
+	}
+
+	current, err := patcher.mem.ReadMemory(address, len(definition.Patched))
+	switch {
+	case err != nil:
+		r.add("signatures", id, CheckWarning, fmt.Sprintf("%s, unreadable: %v", detail, err), "")
+	case bytes.Equal(current, definition.Vanilla):
+		r.add("signatures", id, CheckOK, detail+", vanilla", "")
+	case bytes.Equal(current, definition.Patched):
+		r.add("signatures", id, CheckOK, detail+", patched", "")
+	default:
+		r.add("signatures", id, CheckWarning,
+			fmt.Sprintf("%s, unexpected bytes % X", detail, current),
+			"another tool may have patched this code")
+	}
+}
+
+// WriteDoctorReport writes a human readable report.
+func WriteDoctorReport(w io.Writer, report *DoctorReport) error {
+	var text strings.Builder
+
+	fmt.Fprintf(&text, "Sekiro Tweaker diagnostics, %s\n", report.CreatedAt.Format(time.RFC3339))
+	if report.PID != 0 {
+		fmt.Fprintf(&text, "pid: %d\n", report.PID)
+	}
+	if report.Build != nil {
+		fmt.Fprintf(&text, "build: %s\n", report.Build)
+	}
+
+	group := ""
+	for _, check := range report.Checks {
+		if check.Group != group {
+			group = check.Group
+			fmt.Fprintf(&text, "\n%s\n", group)
+		}
+		fmt.Fprintf(&text, "  [%s] %s: %s\n", check.Result, check.Name, check.Detail)
+		if check.Hint != "" && check.Result != CheckOK {
+			fmt.Fprintf(&text, "      hint: %s\n", check.Hint)
+		}
+	}
+
+	fmt.Fprintf(&text, "\n%d ok, %d warnings, %d failed\n",
+		report.Count(CheckOK), report.Count(CheckWarning), report.Count(CheckFailed))
+
+	_, err := io.WriteString(w, text.String())
+	return err
+}
@@ -0,0 +1,171 @@
+package game
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// FeatureRequest is a feature to apply with the given parameters.
+type FeatureRequest struct {
+	Feature Feature
+	Params  Params
+}
+
+// PlannedWrite is a write a feature would make.
+type PlannedWrite struct {
+	Address  int64    `json:"address"`
+	Current  HexBytes `json:"current"`
+	Intended HexBytes `json:"intended"`
+	// Cave is set for writes into cave memory the dry run allocated.
+	Cave bool `json:"cave,omitempty"`
+}
+
+// PlannedAllocation is cave memory a feature would allocate.
+type PlannedAllocation struct {
+	Address int64 `json:"address"`
+	Size    int   `json:"size"`
+}
+
+// DryRunResult is the outcome of planning one feature.
+type DryRunResult struct {
+	Feature string `json:"feature"`
+	Name    string `json:"name"`
+	Params  Params `json:"params,omitempty"`
+	// Status is the feature's status in the live game. Toggles that are
+	// already active are not planned again, as ApplyFeature skips them.
+	Status      FeatureStatus       `json:"status"`
+	Writes      []PlannedWrite      `json:"writes"`
+	Allocations []PlannedAllocation `json:"allocations,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// Failed reports whether the feature could not be planned, i.e. whether a
+// real apply would fail too.
+func (r DryRunResult) Failed() bool {
+	return r.Error != ""
+}
+
+// DryRun runs every signature lookup, pointer chain and cave allocation the
+// requested features need and reports what they would write, without
+// modifying the game. Features are planned in order on top of each other's
+// writes, as they would be applied.
+func (p *Patcher) DryRun(requests []FeatureRequest) ([]DryRunResult, error) {
+	recorder := memory.NewRecorder(p.mem)
+
+	planner, err := NewPatcherWithMemory(recorder)
+	if err != nil {
+		return nil, err
+	}
+	planner.patches = p.patches
+
+	// The planner starts from what the patcher already applied, so caves are
+	// updated in place and active toggles are skipped as they would be.
+	planner.restoreJournal(p.journalState())
+
+	results := make([]DryRunResult, 0, len(requests))
+	for _, request := range requests {
+		feature := request.Feature
+		result := DryRunResult{
+			Feature: feature.ID(),
+			Name:    feature.Name(),
+			Writes:  []PlannedWrite{},
+		}
+
+		status, err := feature.Status(p)
+		if err != nil {
+			result.Error = fmt.Sprintf("status: %v", err)
+			results = append(results, result)
+			continue
+		}
+		result.Status = status
+
+		params, err := ResolveParams(feature, request.Params)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		if len(params) > 0 {
+			result.Params = params
+		}
+
+		if err := planner.ApplyFeature(feature, params); err != nil {
+			result.Error = err.Error()
+		}
+
+		writes, allocations := recorder.Take()
+		for _, allocation := range allocations {
+			result.Allocations = append(result.Allocations, PlannedAllocation{
+				Address: allocation.Address,
+				Size:    allocation.Size,
+			})
+		}
+		sort.SliceStable(writes, func(i, j int) bool {
+			return writes[i].Address < writes[j].Address
+		})
+		for _, write := range writes {
+			// Rewriting the same range, e.g. updating and then activating a
+			// cave, is reported once with the final bytes.
+			if n := len(result.Writes); n > 0 &&
+				result.Writes[n-1].Address == write.Address &&
+				len(result.Writes[n-1].Intended) == len(write.Intended) {
+				result.Writes[n-1].Intended = write.Intended
+				continue
+			}
+
+			result.Writes = append(result.Writes, PlannedWrite{
+				Address:  write.Address,
+				Current:  write.Current,
+				Intended: write.Intended,
+				Cave:     write.Cave,
+			})
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// WriteDryRunReport writes a human readable dry-run report.
+func WriteDryRunReport(w io.Writer, results []DryRunResult) error {
+	var report strings.Builder
+
+	failed := 0
+	for _, result := range results {
+		state := "ok"
+		switch {
+		case result.Failed():
+			state = "FAIL"
+			failed++
+		case result.Status == StatusActive && len(result.Writes) == 0:
+			state = "already applied"
+		}
+
+		fmt.Fprintf(&report, "%s [%s]\n", result.Name, state)
+		if result.Failed() {
+			fmt.Fprintf(&report, "  error: %s\n", result.Error)
+		}
+		for _, allocation := range result.Allocations {
+			fmt.Fprintf(&report, "  allocate 0x%X (%d bytes)\n", allocation.Address, allocation.Size)
+		}
+		for _, write := range result.Writes {
+			current := fmt.Sprintf("% X", []byte(write.Current))
+			switch {
+			case write.Cave:
+				current = "(cave)"
+			case write.Current == nil:
+				current = "(unreadable)"
+			}
+			fmt.Fprintf(&report, "  0x%X: %s -> % X\n", write.Address, current, []byte(write.Intended))
+		}
+	}
+	fmt.Fprintf(&report, "%d of %d features would apply\n", len(results)-failed, len(results))
+
+	_, err := io.WriteString(w, report.String())
+	return err
+}
@@ -0,0 +1,243 @@
+package game
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// Layout of the fake game. The module holds the code the built-in signatures
+// match and the static pointers they load. The writable region next to it is
+// where caves are allocated, and the heap holds what the pointers point to.
+const (
+	fakeModuleBase = 0x140000000
+	fakeModuleSize = 0x10000
+	fakeCaveBase   = fakeModuleBase + fakeModuleSize
+	fakeCaveSize   = 0x10000
+	fakeHeapBase   = 0x10000000
+	fakeHeapSize   = 0x10000
+
+	fakeAutoLoot    = fakeModuleBase + 0x1000
+	fakeGameSpeed   = fakeModuleBase + 0x1100
+	fakePlayerSpeed = fakeModuleBase + 0x1200
+	fakeDeaths      = fakeModuleBase + 0x1300
+	fakeKills       = fakeModuleBase + 0x1400
+	fakeFOV         = fakeModuleBase + 0x1500
+	fakeCameraReset = fakeModuleBase + 0x1600
+	fakeStateFlags  = fakeModuleBase + 0x1700
+
+	fakeTimescaleSlot = fakeModuleBase + 0x8000
+	fakePlayerSlot    = fakeModuleBase + 0x8008
+	fakeGameDataSlot  = fakeModuleBase + 0x8010
+	fakeKillsSlot     = fakeModuleBase + 0x8018
+	fakeStateSlot     = fakeModuleBase + 0x8020
+	fakeFOVConstant   = fakeModuleBase + 0x8100
+
+	fakeTimescaleManager = fakeHeapBase
+	fakeTimescaleOffset  = 0x360
+	fakePlayer           = fakeHeapBase + 0x1000
+	fakePlayerSpeedValue = fakeHeapBase + 0x5000 + 0xD00
+	fakeGameData         = fakeHeapBase + 0x6000
+	fakeDeathsOffset     = 0x90
+	fakeKillsValue       = fakeHeapBase + 0x8000 + 0xDC
+
+	// fakeStateFlags loads the game state, which no built-in definition
+	// reads. Tests add definitions for its flags and the player's health.
+	fakeState        = fakeHeapBase + 0x9000
+	fakeLoadingFlag  = fakeState + 0x10
+	fakeCutsceneFlag = fakeState + 0x11
+	fakeHP           = fakeHeapBase + 0x9100 + 0x130
+)
+
+// fakeImage is an address space made of a few regions, readable like a
+// snapshot.
+type fakeImage struct {
+	regions []memory.MemoryRegion
+	data    map[int64][]byte
+}
+
+func (f *fakeImage) addRegion(start, size int64, permissions, path string, fill byte) {
+	f.regions = append(f.regions, memory.MemoryRegion{Start: start, End: start + size, Permissions: permissions, Path: path})
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = fill
+	}
+	f.data[start] = data
+}
+
+func (f *fakeImage) region(address int64, size int) ([]byte, error) {
+	for _, region := range f.regions {
+		if address >= region.Start && address+int64(size) <= region.End {
+			return f.data[region.Start][address-region.Start : address-region.Start+int64(size)], nil
+		}
+	}
+	return nil, fmt.Errorf("0x%X is not mapped", address)
+}
+
+func (f *fakeImage) ReadMemory(address int64, size int) ([]byte, error) {
+	data, err := f.region(address, size)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (f *fakeImage) ParseMemoryMaps() ([]memory.MemoryRegion, error) {
+	return append([]memory.MemoryRegion(nil), f.regions...), nil
+}
+
+func (f *fakeImage) put(address int64, data []byte) {
+	region, err := f.region(address, len(data))
+	if err != nil {
+		panic(err)
+	}
+	copy(region, data)
+}
+
+func (f *fakeImage) putHex(address int64, pattern string) {
+	var data []byte
+	for _, field := range strings.Fields(pattern) {
+		var b byte
+		if field != "??" {
+			if _, err := fmt.Sscanf(field, "%02X", &b); err != nil {
+				panic(err)
+			}
+		}
+		data = append(data, b)
+	}
+	f.put(address, data)
+}
+
+func (f *fakeImage) putInt32(address int64, value int32) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(value))
+	f.put(address, data)
+}
+
+func (f *fakeImage) putInt64(address, value int64) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(value))
+	f.put(address, data)
+}
+
+func (f *fakeImage) putFloat32(address int64, value float32) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, math.Float32bits(value))
+	f.put(address, data)
+}
+
+// putStaticLoad writes the rel32 of a RIP-relative load at instruction so
+// that it loads slot.
+func (f *fakeImage) putStaticLoad(instruction int64, length int, slot int64) {
+	f.putInt32(instruction+3, int32(slot-(instruction+int64(length))))
+}
+
+// newFakeImage builds a game whose signatures match the built-in definitions
+// for auto loot, camera reset, FOV, game speed, player speed, deaths and
+// kills, with a save loaded and the player in the world.
+func newFakeImage() *fakeImage {
+	f := &fakeImage{data: make(map[int64][]byte)}
+	f.addRegion(fakeModuleBase, fakeModuleSize, "r-xp", "/games/Sekiro/sekiro.exe", 0xCC)
+	f.addRegion(fakeCaveBase, fakeCaveSize, "rw-p", "", 0)
+	f.addRegion(fakeHeapBase, fakeHeapSize, "rw-p", "", 0)
+
+	f.putHex(fakeAutoLoot, "C6 85 01 02 03 04 05 B0 01 EB 10 C6 85 01 02 03 04 05 32 C0")
+
+	f.putHex(fakeCameraReset, "C6 86 10 02 00 00 01 F3 0F 10 8E 20 02 00 00")
+
+	// mulss xmm1,[rip+constant], whose displacement the FOV cave redirects.
+	f.putHex(fakeFOV, "F3 0F 10 08 F3 0F 59 0D ?? ?? ?? ?? F3 0F 5C 4E")
+	f.putInt32(fakeFOV+8, int32(fakeFOVConstant-(fakeFOV+12)))
+	f.putFloat32(fakeFOVConstant, 1)
+
+	f.putHex(fakeGameSpeed, "48 8B 05 ?? ?? ?? ?? F3 0F 10 88 ?? ?? ?? ?? F3 0F")
+	f.putStaticLoad(fakeGameSpeed, 7, fakeTimescaleSlot)
+	f.putInt32(fakeGameSpeed+11, fakeTimescaleOffset)
+	f.putInt64(fakeTimescaleSlot, fakeTimescaleManager)
+	f.putFloat32(fakeTimescaleManager+fakeTimescaleOffset, 1)
+
+	f.putHex(fakePlayerSpeed, "48 8B 1D ?? ?? ?? ?? 48 85 DB 74 10 8B 00 81 FA")
+	f.putStaticLoad(fakePlayerSpeed, 7, fakePlayerSlot)
+	f.putInt64(fakePlayerSlot, fakePlayer)
+	f.putInt64(fakePlayer, fakeHeapBase+0x1100)
+	f.putInt64(fakeHeapBase+0x1100+0x88, fakeHeapBase+0x2000)
+	f.putInt64(fakeHeapBase+0x2000+0x1FF8, fakeHeapBase+0x4000)
+	f.putInt64(fakeHeapBase+0x4000+0x28, fakeHeapBase+0x5000)
+	f.putFloat32(fakePlayerSpeedValue, 1)
+
+	f.putHex(fakeDeaths, "0F B6 48 00 88 8B 00 00 00 00 48 8B 05 00 00 00 00 8B 88 00 00 00 00 "+
+		"89 8B 00 00 00 00 48 8B 05 ?? ?? ?? ?? 8B 88 ?? ?? 00 00")
+	f.putStaticLoad(fakeDeaths+29, 7, fakeGameDataSlot)
+	f.putInt32(fakeDeaths+29+9, fakeDeathsOffset)
+	f.putInt64(fakeGameDataSlot, fakeGameData)
+	f.putInt32(fakeGameData+fakeDeathsOffset, 3)
+
+	f.putHex(fakeKills, "48 00 D8 00 00 00 00 48 8B 05 ?? ?? ?? ?? 48 00 00 48 89 00 00 00 48 8B 00 08")
+	f.putStaticLoad(fakeKills+7, 7, fakeKillsSlot)
+	f.putInt64(fakeKillsSlot, fakeHeapBase+0x7000)
+	f.putInt64(fakeHeapBase+0x7000, fakeHeapBase+0x7100)
+	f.putInt64(fakeHeapBase+0x7100+8, fakeHeapBase+0x8000)
+	f.putInt32(fakeKillsValue, 42)
+
+	f.putHex(fakeStateFlags, "48 8B 05 ?? ?? ?? ?? 0F B6 40 10")
+	f.putStaticLoad(fakeStateFlags, 7, fakeStateSlot)
+	f.putInt64(fakeStateSlot, fakeState)
+	f.putInt64(fakeState+0x20, fakeHeapBase+0x9100)
+	f.putInt32(fakeHP, 800)
+
+	return f
+}
+
+// newFakeGame returns a patcher on an overlay of a fake game, and the
+// overlay, through which tests act as the game.
+func newFakeGame(t *testing.T) (*Patcher, *memory.Overlay) {
+	t.Helper()
+
+	mem := memory.NewOverlay(newFakeImage())
+	patcher, err := NewPatcherWithMemory(mem)
+	if err != nil {
+		t.Fatalf("NewPatcherWithMemory: %v", err)
+	}
+	return patcher, mem
+}
+
+func readBytes(t *testing.T, mem memory.Reader, address int64, size int) []byte {
+	t.Helper()
+
+	data, err := mem.ReadMemory(address, size)
+	if err != nil {
+		t.Fatalf("read 0x%X: %v", address, err)
+	}
+	return data
+}
+
+func writeBytes(t *testing.T, mem memory.ReadWriter, address int64, data []byte) {
+	t.Helper()
+
+	if err := mem.WriteMemory(address, data); err != nil {
+		t.Fatalf("write 0x%X: %v", address, err)
+	}
+}
+
+func mustFeature(t *testing.T, id string) Feature {
+	t.Helper()
+
+	feature, exists := LookupFeature(id)
+	if !exists {
+		t.Fatalf("feature %s is not registered", id)
+	}
+	return feature
+}
@@ -0,0 +1,326 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// Category groups features in the UI.
+type Category int
+
+const (
+	CategoryGraphics Category = iota
+	CategoryCamera
+	CategoryGameplay
+	CategorySpeed
+)
+
+func (c Category) String() string {
+	switch c {
+	case CategoryGraphics:
+		return "Graphics Settings"
+	case CategoryCamera:
+		return "Camera Settings"
+	case CategoryGameplay:
+		return "Gameplay Modifications"
+	case CategorySpeed:
+		return "Speed Modifiers"
+	default:
+		return fmt.Sprintf("Category %d", int(c))
+	}
+}
+
+// Categories returns every category in display order.
+func Categories() []Category {
+	return []Category{CategoryGraphics, CategoryCamera, CategoryGameplay, CategorySpeed}
+}
+
+// Requirement is a condition a feature needs before it can be applied.
+type Requirement string
+
+const (
+	// RequiresInGame means the feature writes to state that only exists once
+	// a save has been loaded.
+	RequiresInGame Requirement = "in_game"
+)
+
+func (r Requirement) String() string {
+	switch r {
+	case RequiresInGame:
+		return "load into the game first"
+	default:
+		return string(r)
+	}
+}
+
+// HasRequirement reports whether a feature needs requirement.
+func HasRequirement(feature Feature, requirement Requirement) bool {
+	for _, r := range feature.Requirements() {
+		if r == requirement {
+			return true
+		}
+	}
+	return false
+}
+
+type ParamKind int
+
+const (
+	ParamInt ParamKind = iota
+	ParamFloat
+)
+
+// Param describes a numeric feature parameter and the range a control for it
+// accepts.
+type Param struct {
+	ID      string
+	Label   string
+	Unit    string
+	Kind    ParamKind
+	Min     float64
+	Max     float64
+	Step    float64
+	Digits  int
+	Default float64
+}
+
+// Params holds parameter values by Param.ID.
+type Params map[string]float64
+
+func (ps Params) Float(id string) float64 {
+	return ps[id]
+}
+
+func (ps Params) Int(id string) int {
+	return int(math.Round(ps[id]))
+}
+
+// FeatureStatus is whether a feature is currently applied to the game.
+type FeatureStatus int
+
+const (
+	StatusInactive FeatureStatus = iota
+	StatusActive
+	// StatusDrifted means the feature was applied but the game has since
+	// overwritten some of its bytes.
+	StatusDrifted
+)
+
+func (s FeatureStatus) String() string {
+	switch s {
+	case StatusInactive:
+		return "inactive"
+	case StatusActive:
+		return "active"
+	case StatusDrifted:
+		return "drifted"
+	default:
+		return fmt.Sprintf("status_%d", int(s))
+	}
+}
+
+func (s FeatureStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Feature is a single tweak. Each feature lives in its own feature_*.go file
+// and registers itself in init, so the GUI, config and CLI pick it up without
+// further changes.
+type Feature interface {
+	ID() string
+	Name() string
+	Description() string
+	Category() Category
+	Params() []Param
+	Requirements() []Requirement
+	// DefaultEnabled reports whether the feature is enabled in a fresh config.
+	DefaultEnabled() bool
+
+	Apply(p *Patcher, params Params) error
+	Revert(p *Patcher) error
+	Status(p *Patcher) (FeatureStatus, error)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []registeredFeature
+)
+
+type registeredFeature struct {
+	feature Feature
+	order   int
+}
+
+// Register adds a feature to the registry. Order sorts features within their
+// category.
+func Register(feature Feature, order int) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, registered := range registry {
+		if registered.feature.ID() == feature.ID() {
+			panic(fmt.Sprintf("feature %s registered twice", feature.ID()))
+		}
+	}
+
+	registry = append(registry, registeredFeature{feature: feature, order: order})
+	sort.SliceStable(registry, func(i, j int) bool {
+		if registry[i].feature.Category() != registry[j].feature.Category() {
+			return registry[i].feature.Category() < registry[j].feature.Category()
+		}
+		return registry[i].order < registry[j].order
+	})
+}
+
+// Features returns every registered feature in display order.
+func Features() []Feature {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	features := make([]Feature, len(registry))
+	for i, registered := range registry {
+		features[i] = registered.feature
+	}
+	return features
+}
+
+// LookupFeature finds a registered feature by ID.
+func LookupFeature(id string) (Feature, bool) {
+	for _, feature := range Features() {
+		if feature.ID() == id {
+			return feature, true
+		}
+	}
+	return nil, false
+}
+
+// DefaultParams returns the default value of every parameter of a feature.
+func DefaultParams(feature Feature) Params {
+	params := make(Params, len(feature.Params()))
+	for _, param := range feature.Params() {
+		params[param.ID] = param.Default
+	}
+	return params
+}
+
+// ResolveParams fills in defaults for missing parameters and rejects values
+// outside a parameter's range.
+func ResolveParams(feature Feature, params Params) (Params, error) {
+	resolved := DefaultParams(feature)
+	for _, param := range feature.Params() {
+		value, exists := params[param.ID]
+		if !exists {
+			continue
+		}
+		if value < param.Min || value > param.Max {
+			return nil, fmt.Errorf("%s must be between %g and %g, got %g", param.ID, param.Min, param.Max, value)
+		}
+		resolved[param.ID] = value
+	}
+	return resolved, nil
+}
+
+// ApplyFeature validates params and applies a feature.
+func (p *Patcher) ApplyFeature(feature Feature, params Params) error {
+	resolved, err := ResolveParams(feature, params)
+	if err != nil {
+		return err
+	}
+
+	// Byte patches often overwrite part of their own signature, so a toggle
+	// that is already in place is left alone rather than scanned for again.
+	if len(feature.Params()) == 0 {
+		if status, err := feature.Status(p); err == nil && status == StatusActive {
+			return nil
+		}
+	}
+
+	err = feature.Apply(p, resolved)
+	if journalErr := p.saveJournal(); journalErr != nil {
+		logger.Log.Warn("Failed to save patch journal", zap.Error(journalErr))
+	}
+
+	if err != nil && requiresInGame(feature) {
+		return fmt.Errorf("%v (%s)", err, RequiresInGame)
+	}
+	return err
+}
+
+// Updater is implemented by features that can change their parameters while
+// applied by rewriting values they already placed, without finding their
+// memory again.
+type Updater interface {
+	Update(p *Patcher, params Params) error
+}
+
+// UpdateFeature changes the parameters of a feature. Applied features that
+// implement Updater are changed in place; the others are applied again.
+func (p *Patcher) UpdateFeature(feature Feature, params Params) error {
+	updater, canUpdate := feature.(Updater)
+	if !canUpdate {
+		return p.ApplyFeature(feature, params)
+	}
+	if status, err := feature.Status(p); err != nil || status == StatusInactive {
+		return p.ApplyFeature(feature, params)
+	}
+
+	resolved, err := ResolveParams(feature, params)
+	if err != nil {
+		return err
+	}
+
+	err = updater.Update(p, resolved)
+	if journalErr := p.saveJournal(); journalErr != nil {
+		logger.Log.Warn("Failed to save patch journal", zap.Error(journalErr))
+	}
+	return err
+}
+
+// RevertFeature reverts a feature if it is applied.
+func (p *Patcher) RevertFeature(feature Feature) error {
+	status, err := feature.Status(p)
+	if err != nil || status == StatusInactive {
+		return err
+	}
+
+	err = feature.Revert(p)
+	if journalErr := p.saveJournal(); journalErr != nil {
+		logger.Log.Warn("Failed to save patch journal", zap.Error(journalErr))
+	}
+	return err
+}
+
+// baseFeature implements the descriptive part of Feature. Features embed it
+// and supply Apply; Revert and Status default to undoing and inspecting the
+// writes and caves the patcher recorded for the feature's ID.
+type baseFeature struct {
+	id             string
+	name           string
+	description    string
+	category       Category
+	params         []Param
+	requirements   []Requirement
+	defaultEnabled bool
+}
+
+func (f baseFeature) ID() string                  { return f.id }
+func (f baseFeature) Name() string                { return f.name }
+func (f baseFeature) Description() string         { return f.description }
+func (f baseFeature) Category() Category          { return f.category }
+func (f baseFeature) Params() []Param             { return f.params }
+func (f baseFeature) Requirements() []Requirement { return f.requirements }
+func (f baseFeature) DefaultEnabled() bool        { return f.defaultEnabled }
+
+func (f baseFeature) Revert(p *Patcher) error {
+	return p.revertFeature(f.id)
+}
+
+func (f baseFeature) Status(p *Patcher) (FeatureStatus, error) {
+	return p.featureStatus(f.id)
+}
@@ -0,0 +1,18 @@
+package game
+
+type autoLootFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(autoLootFeature{baseFeature{
+		id:          FeatureAutoLoot,
+		name:        "Automatically loot enemies",
+		description: "Pick up items dropped by defeated enemies automatically",
+		category:    CategoryGameplay,
+	}}, 0)
+}
+
+func (f autoLootFeature) Apply(p *Patcher, params Params) error {
+	return p.applyBytePatch(FeatureAutoLoot, "auto_loot")
+}
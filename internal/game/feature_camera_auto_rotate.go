@@ -0,0 +1,70 @@
+package game
+
+import "fmt"
+
+type cameraAutoRotateFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(cameraAutoRotateFeature{baseFeature{
+		id:          FeatureCameraAutoRotate,
+		name:        "Disable camera auto-rotation",
+		description: "Stop the camera from turning on its own while moving",
+		category:    CategoryCamera,
+	}}, 1)
+}
+
+// cameraCaves are the IDs of the patch definitions of the camera code caves.
+var cameraCaves = []string{"camera_pitch", "camera_yaw_z", "camera_pitch_xy", "camera_yaw_xy"}
+
+func (f cameraAutoRotateFeature) Apply(p *Patcher, params Params) error {
+	// Create all 4 code caves first (without activating)
+	// This validates patterns and allocates memory without modifying game code.
+	// Caves from an earlier apply are reused: their injection points no longer
+	// match the patterns once the JMP has been written.
+	errors := []error{}
+	successCount := 0
+
+	for _, cave := range cameraCaves {
+		if p.caveManager.CodeCaveExists(cave) {
+			successCount++
+			continue
+		}
+
+		definition, address, err := p.findPatch(cave)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+
+		if err := p.caveManager.CreateCodeCave(cave, address, definition.Overwrite, definition.Shellcode); err != nil {
+			errors = append(errors, fmt.Errorf("create %s: %v", cave, err))
+			continue
+		}
+
+		p.trackCave(cave, FeatureCameraAutoRotate)
+		successCount++
+	}
+
+	// If we couldn't create any caves, fail early
+	if successCount == 0 {
+		return fmt.Errorf("camera auto-rotate: failed to create any code caves: %v", errors)
+	}
+
+	// Now activate the caves that were successfully created
+	for _, cave := range cameraCaves {
+		if !p.caveManager.CodeCaveExists(cave) {
+			continue
+		}
+		if err := p.caveManager.ActivateCodeCave(cave); err != nil {
+			errors = append(errors, fmt.Errorf("activate %s: %v", cave, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("camera auto-rotate partial success (%d/%d caves): %v", successCount, len(cameraCaves), errors)
+	}
+
+	return nil
+}
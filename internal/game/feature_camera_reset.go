@@ -0,0 +1,18 @@
+package game
+
+type cameraResetFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(cameraResetFeature{baseFeature{
+		id:          FeatureCameraReset,
+		name:        "Disable camera reset on lock-on",
+		description: "Keep the camera where it is when lock-on is pressed without a target",
+		category:    CategoryCamera,
+	}}, 0)
+}
+
+func (f cameraResetFeature) Apply(p *Patcher, params Params) error {
+	return p.applyBytePatch(FeatureCameraReset, "camera_reset_lockon")
+}
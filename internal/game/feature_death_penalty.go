@@ -0,0 +1,57 @@
+package game
+
+type deathPenaltyFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(deathPenaltyFeature{baseFeature{
+		id:          FeatureDeathPenalty,
+		name:        "Disable death penalties (Sen/XP loss)",
+		description: "Keep Sen and experience when dying",
+		category:    CategoryGameplay,
+	}}, 2)
+}
+
+func (f deathPenaltyFeature) Apply(p *Patcher, params Params) error {
+	// Patch 1: Disable Sen loss function call
+	definition1, address1, err := p.findPatch("death_penalty_1")
+	if err != nil {
+		return err
+	}
+
+	// Patch 2: Try modern pattern first, then legacy. Patch 3 only exists in
+	// the modern version and shares its signature, so every address is found
+	// before anything is written.
+	var sites []patchSite
+	definition2, address2, err := p.findPatch("death_penalty_2")
+	if err == nil {
+		sites = append(sites, patchSite{definition2, address2})
+
+		definition3, address3, err := p.findPatch("death_penalty_3")
+		if err != nil {
+			return err
+		}
+		sites = append(sites, patchSite{definition3, address3})
+	} else if definition2, address2, err = p.findPatch("death_penalty_2_legacy"); err == nil {
+		sites = append(sites, patchSite{definition2, address2})
+	}
+	// Pattern 2 not found - not critical, pattern 1 is still applied
+
+	if err := p.writeDefinition(FeatureDeathPenalty, definition1, address1); err != nil {
+		return err
+	}
+
+	for _, site := range sites {
+		if err := p.writeDefinition(FeatureDeathPenalty, site.definition, site.address); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type patchSite struct {
+	definition PatchDefinition
+	address    int64
+}
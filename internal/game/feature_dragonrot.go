@@ -0,0 +1,18 @@
+package game
+
+type dragonrotFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(dragonrotFeature{baseFeature{
+		id:          FeatureDragonrot,
+		name:        "Prevent dragonrot increase on death",
+		description: "Dying no longer spreads dragonrot to NPCs",
+		category:    CategoryGameplay,
+	}}, 1)
+}
+
+func (f dragonrotFeature) Apply(p *Patcher, params Params) error {
+	return p.applyBytePatch(FeatureDragonrot, "dragonrot_effect")
+}
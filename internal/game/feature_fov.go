@@ -0,0 +1,52 @@
+package game
+
+import (
+	"fmt"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+type fovFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(fovFeature{baseFeature{
+		id:          FeatureFOV,
+		name:        "Custom FOV",
+		description: "Change the camera field of view",
+		category:    CategoryGraphics,
+		params: []Param{
+			{ID: "fov", Unit: "×", Kind: ParamFloat, Min: 0.5, Max: 2.5, Step: 0.05, Digits: 2, Default: 1.0},
+		},
+	}}, 2)
+}
+
+func (f fovFeature) Apply(p *Patcher, params Params) error {
+	_, fovPointer, err := p.findPatch("fov_setting")
+	if err != nil {
+		return err
+	}
+
+	fovRadians := float32(params.Float("fov")) * DegreesToRadians
+
+	if err := p.ensureDataCave("fov", FeatureFOV, fovPointer, float32Bytes(fovRadians), memory.DWordRelative); err != nil {
+		return fmt.Errorf("failed to create FOV cave: %v", err)
+	}
+
+	if err := p.caveManager.ActivateDataCave("fov"); err != nil {
+		return fmt.Errorf("failed to activate FOV cave: %v", err)
+	}
+
+	return nil
+}
+
+// Update rewrites the FOV cave of an applied feature.
+func (f fovFeature) Update(p *Patcher, params Params) error {
+	fovRadians := float32(params.Float("fov")) * DegreesToRadians
+
+	if err := p.caveManager.UpdateDataCave("fov", float32Bytes(fovRadians)); err != nil {
+		return fmt.Errorf("failed to write FOV value: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package game
+
+import (
+	"fmt"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+type fpsUnlockFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(fpsUnlockFeature{baseFeature{
+		id:          FeatureFPSUnlock,
+		name:        "FPS Unlock",
+		description: "Raise the 60 FPS frame limit and correct the game speed for the new frame rate",
+		category:    CategoryGraphics,
+		params: []Param{
+			{ID: "fps", Kind: ParamInt, Min: 30, Max: 300, Step: 1, Default: 144},
+		},
+		defaultEnabled: true,
+	}}, 0)
+}
+
+func (f fpsUnlockFeature) Apply(p *Patcher, params Params) error {
+	targetFPS := params.Int("fps")
+
+	_, targetAddress, err := p.findPatch("framelock")
+	if err != nil {
+		return err
+	}
+
+	fpsValue := 1.0 / float32(targetFPS)
+
+	if err := p.ensureDataCave("framelock", FeatureFPSUnlock, targetAddress, float32Bytes(fpsValue), memory.DWordImmediate); err != nil {
+		return fmt.Errorf("failed to create FPS cave: %v", err)
+	}
+
+	if err := p.caveManager.ActivateDataCave("framelock"); err != nil {
+		return fmt.Errorf("failed to write FPS value: %v", err)
+	}
+
+	_, speedFixPointer, err := p.findPatch("speedfix")
+	if err != nil {
+		return nil
+	}
+
+	speedFixValue := FindSpeedFixForFrameRate(targetFPS)
+
+	if err := p.ensureDataCave("speedfix", FeatureFPSUnlock, speedFixPointer, float32Bytes(speedFixValue), memory.DWordRelative); err != nil {
+		return fmt.Errorf("failed to create speed fix cave: %v", err)
+	}
+
+	if err := p.caveManager.ActivateDataCave("speedfix"); err != nil {
+		return fmt.Errorf("failed to activate speed fix cave: %v", err)
+	}
+
+	return nil
+}
+
+// Update rewrites the frame time and speed fix of an applied unlock without
+// scanning for them again.
+func (f fpsUnlockFeature) Update(p *Patcher, params Params) error {
+	targetFPS := params.Int("fps")
+
+	if err := p.caveManager.UpdateDataCave("framelock", float32Bytes(1.0/float32(targetFPS))); err != nil {
+		return fmt.Errorf("failed to write FPS value: %v", err)
+	}
+	if !p.caveManager.DataCaveExists("speedfix") {
+		return nil
+	}
+	if err := p.caveManager.UpdateDataCave("speedfix", float32Bytes(FindSpeedFixForFrameRate(targetFPS))); err != nil {
+		return fmt.Errorf("failed to write speed fix value: %v", err)
+	}
+	return nil
+}
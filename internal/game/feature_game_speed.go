@@ -0,0 +1,22 @@
+package game
+
+type gameSpeedFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(gameSpeedFeature{baseFeature{
+		id:          FeatureGameSpeed,
+		name:        "Game Speed",
+		description: "Scale the speed of the whole game world",
+		category:    CategorySpeed,
+		params: []Param{
+			{ID: "speed", Kind: ParamFloat, Min: 0.1, Max: 5.0, Step: 0.1, Digits: 1, Default: 1.0},
+		},
+		requirements: []Requirement{RequiresInGame},
+	}}, 0)
+}
+
+func (f gameSpeedFeature) Apply(p *Patcher, params Params) error {
+	return p.writeValue(FeatureGameSpeed, "game_speed", float32Bytes(float32(params.Float("speed"))))
+}
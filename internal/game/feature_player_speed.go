@@ -0,0 +1,22 @@
+package game
+
+type playerSpeedFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(playerSpeedFeature{baseFeature{
+		id:          FeaturePlayerSpeed,
+		name:        "Player Speed (experimental)",
+		description: "May not work reliably on Linux/Proton. Use at your own risk.",
+		category:    CategorySpeed,
+		params: []Param{
+			{ID: "speed", Kind: ParamFloat, Min: 0.1, Max: 5.0, Step: 0.1, Digits: 1, Default: 1.0},
+		},
+		requirements: []Requirement{RequiresInGame},
+	}}, 1)
+}
+
+func (f playerSpeedFeature) Apply(p *Patcher, params Params) error {
+	return p.writeValue(FeaturePlayerSpeed, "player_speed", float32Bytes(float32(params.Float("speed"))))
+}
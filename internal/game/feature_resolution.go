@@ -0,0 +1,48 @@
+package game
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type resolutionFeature struct {
+	baseFeature
+}
+
+func init() {
+	Register(resolutionFeature{baseFeature{
+		id:          FeatureResolution,
+		name:        "Custom Resolution",
+		description: "Replace the default resolution with a custom one, including widescreen aspect ratios",
+		category:    CategoryGraphics,
+		params: []Param{
+			{ID: "width", Label: "Width:", Kind: ParamInt, Min: 800, Max: 7680, Step: 1, Default: 1920},
+			{ID: "height", Label: "Height:", Kind: ParamInt, Min: 600, Max: 4320, Step: 1, Default: 1080},
+		},
+	}}, 1)
+}
+
+func (f resolutionFeature) Apply(p *Patcher, params Params) error {
+	_, address, err := p.findPatch("resolution_default")
+	if err != nil {
+		_, address, err = p.findPatch("resolution_default_720")
+		if err != nil {
+			return err
+		}
+	}
+
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data[0:], uint32(params.Int("width")))
+	binary.LittleEndian.PutUint32(data[4:], uint32(params.Int("height")))
+
+	if err := p.writePatch(FeatureResolution, address, data); err != nil {
+		return fmt.Errorf("failed to write resolution: %v", err)
+	}
+
+	definition, widescreenAddress, err := p.findPatch("resolution_scaling_fix")
+	if err == nil {
+		return p.writeDefinition(FeatureResolution, definition, widescreenAddress)
+	}
+
+	return nil
+}
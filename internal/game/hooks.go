@@ -0,0 +1,282 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/asm"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+const (
+	EventRingCapacity           = 256
+	DefaultEventMonitorInterval = 50 * time.Millisecond
+)
+
+// EventType identifies what a hook reports.
+type EventType uint32
+
+const (
+	EventDeath EventType = iota + 1
+	EventKill
+	EventItemPickup
+)
+
+func (et EventType) String() string {
+	switch et {
+	case EventDeath:
+		return "death"
+	case EventKill:
+		return "kill"
+	case EventItemPickup:
+		return "item_pickup"
+	default:
+		return fmt.Sprintf("event_%d", uint32(et))
+	}
+}
+
+// Hook describes an injection point that reports an event every time the game
+// executes it. Payload lists the registers to capture, e.g. the arguments of
+// the hooked function.
+type Hook struct {
+	Name      string
+	Pattern   string
+	Offset    int64
+	Overwrite int
+	Event     EventType
+	Payload   []asm.Reg
+}
+
+// GameEvent is an event reported by an injected hook.
+type GameEvent struct {
+	Type     EventType
+	Seq      uint64
+	TSC      uint64
+	Payload  [memory.EventPayloadSlots]uint64
+	Received time.Time
+}
+
+// InstallHook injects a hook that appends events to the patcher's event ring,
+// which is allocated on first use. Installing a hook twice is a no-op.
+func (p *Patcher) InstallHook(hook Hook) error {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+
+	if p.caveManager.CodeCaveExists(hook.Name) {
+		return nil
+	}
+
+	address, err := p.scanner.FindPattern(hook.Pattern)
+	if err != nil {
+		return fmt.Errorf("failed to find %s pattern: %v", hook.Name, err)
+	}
+
+	if p.events == nil {
+		ring, err := memory.NewEventRing(p.mem, p.baseAddress, EventRingCapacity)
+		if err != nil {
+			return err
+		}
+		p.events = ring
+	}
+
+	code, err := p.events.Hook(uint32(hook.Event), hook.Payload...)
+	if err != nil {
+		return fmt.Errorf("failed to build %s hook: %v", hook.Name, err)
+	}
+
+	if err := p.caveManager.CreateAssembledCodeCave(hook.Name, address+hook.Offset, hook.Overwrite, code); err != nil {
+		return fmt.Errorf("failed to create %s hook: %v", hook.Name, err)
+	}
+	p.trackCave(hook.Name, hook.Name)
+
+	if err := p.caveManager.ActivateCodeCave(hook.Name); err != nil {
+		_ = p.caveManager.ReleaseCodeCave(hook.Name)
+		return fmt.Errorf("failed to activate %s hook: %v", hook.Name, err)
+	}
+
+	logger.Log.Info("Installed hook",
+		zap.String("hook", hook.Name),
+		zap.Stringer("event", hook.Event),
+		zap.String("address", fmt.Sprintf("0x%X", address+hook.Offset)))
+
+	return nil
+}
+
+// RemoveHook restores the instructions a hook replaced. Events it already
+// recorded can still be drained.
+func (p *Patcher) RemoveHook(name string) error {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+
+	if !p.caveManager.CodeCaveExists(name) {
+		return nil
+	}
+	return p.caveManager.ReleaseCodeCave(name)
+}
+
+// DrainEvents returns the events recorded since the last call.
+func (p *Patcher) DrainEvents() ([]GameEvent, error) {
+	p.hookMu.Lock()
+	ring := p.events
+	p.hookMu.Unlock()
+
+	if ring == nil {
+		return nil, nil
+	}
+
+	records, err := ring.Drain()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	events := make([]GameEvent, len(records))
+	for i, record := range records {
+		events[i] = GameEvent{
+			Type:     EventType(record.Type),
+			Seq:      record.Seq,
+			TSC:      record.TSC,
+			Payload:  record.Payload,
+			Received: now,
+		}
+	}
+	return events, nil
+}
+
+// hasEvents reports whether the event ring was allocated, which happens when
+// the first hook is installed.
+func (p *Patcher) hasEvents() bool {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+	return p.events != nil
+}
+
+// closeEvents frees the event ring. Hook caves must already be gone.
+func (p *Patcher) closeEvents() error {
+	p.hookMu.Lock()
+	defer p.hookMu.Unlock()
+
+	if p.events == nil {
+		return nil
+	}
+
+	err := p.events.Free()
+	p.events = nil
+	return err
+}
+
+// EventMonitor drains the event ring of the session's patcher and publishes
+// the events on a channel, so hooks report every occurrence rather than what a
+// once a second poll happens to see. It stays idle until a hook is installed.
+type EventMonitor struct {
+	session  *Session
+	interval time.Duration
+
+	// patcher and dropped are only used in session commands. dropped counts
+	// the events patcher lost so far.
+	patcher *Patcher
+	dropped uint64
+
+	events   chan GameEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewEventMonitor(session *Session, interval time.Duration) *EventMonitor {
+	return &EventMonitor{
+		session:  session,
+		interval: interval,
+		events:   make(chan GameEvent, EventRingCapacity),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Events returns the game events. Events are dropped if nobody is reading,
+// and the channel is closed once the monitor stops.
+func (m *EventMonitor) Events() <-chan GameEvent {
+	return m.events
+}
+
+func (m *EventMonitor) Start() {
+	go m.run()
+}
+
+func (m *EventMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *EventMonitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	defer close(m.events)
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.poll()
+		}
+	}
+}
+
+func (m *EventMonitor) poll() {
+	if !m.session.Hooked() {
+		return
+	}
+
+	var events []GameEvent
+	err := m.session.Do(func(p *Patcher) error {
+		if p != m.patcher {
+			m.patcher, m.dropped = p, 0
+		}
+
+		var err error
+		if events, err = p.DrainEvents(); err != nil {
+			return err
+		}
+
+		if dropped := p.DroppedEvents(); dropped != m.dropped {
+			logger.Log.Warn("Game events overwritten before they were read",
+				zap.Uint64("dropped", dropped-m.dropped))
+			m.dropped = dropped
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, ErrNotAttached) && !errors.Is(err, ErrSessionClosed) {
+			logger.Log.Debug("Failed to drain game events", zap.Error(err))
+		}
+		return
+	}
+
+	for _, event := range events {
+		logger.Log.Debug("Game event",
+			zap.Stringer("type", event.Type),
+			zap.Uint64("seq", event.Seq),
+			zap.Uint64s("payload", event.Payload[:]))
+
+		select {
+		case m.events <- event:
+		default:
+		}
+	}
+}
+
+// DroppedEvents returns how many events were lost because the ring wrapped
+// before it was drained.
+func (p *Patcher) DroppedEvents() uint64 {
+	p.hookMu.Lock()
+	ring := p.events
+	p.hookMu.Unlock()
+
+	if ring == nil {
+		return 0
+	}
+	return ring.Dropped()
+}
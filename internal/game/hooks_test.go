@@ -0,0 +1,66 @@
+package game
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/asm"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+func TestEventMonitorWaitsForHook(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	monitor := NewEventMonitor(session, time.Hour)
+
+	// Without a hook no command is sent.
+	monitor.poll()
+	if session.Hooked() || monitor.patcher != nil {
+		t.Fatalf("Hooked, polled patcher = %v, %v before any hook", session.Hooked(), monitor.patcher)
+	}
+
+	hook := Hook{
+		Name:      "test_hook",
+		Pattern:   "C6 85 ?? ?? ?? ?? ?? B0 01 EB",
+		Overwrite: 7,
+		Event:     EventKill,
+		Payload:   []asm.Reg{asm.RCX},
+	}
+	if err := session.Do(func(p *Patcher) error { return p.InstallHook(hook) }); err != nil {
+		t.Fatalf("InstallHook: %v", err)
+	}
+	if !session.Hooked() {
+		t.Fatal("Hooked() = false after InstallHook")
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot, 1); got[0] != 0xE9 {
+		t.Errorf("hook site starts with %X, want a jump", got[0])
+	}
+
+	// The game runs the hook once.
+	ring := patcher.events.Address()
+	record := make([]byte, memory.EventRecordSize)
+	binary.LittleEndian.PutUint64(record, 1)
+	binary.LittleEndian.PutUint32(record[8:], uint32(EventKill))
+	binary.LittleEndian.PutUint64(record[24:], 0x1234)
+	writeBytes(t, patcher.mem, ring+64, record)
+	writeBytes(t, patcher.mem, ring, binary.LittleEndian.AppendUint64(nil, 1))
+
+	monitor.poll()
+	select {
+	case event := <-monitor.Events():
+		if event.Type != EventKill || event.Seq != 1 || event.Payload[0] != 0x1234 {
+			t.Errorf("event = %+v, want the kill", event)
+		}
+	default:
+		t.Fatal("no event")
+	}
+
+	// A new game has no hooks until they are installed again.
+	second, _ := newFakeGame(t)
+	if err := session.Attach(second); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if session.Hooked() {
+		t.Error("Hooked() = true after attaching another game")
+	}
+}
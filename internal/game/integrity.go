@@ -0,0 +1,281 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// trackedWrite remembers bytes the patcher wrote so they can be verified and
+// re-applied later.
+type trackedWrite struct {
+	feature string
+	address int64
+	data    []byte
+	// original holds the bytes found before the first write, which Revert
+	// puts back.
+	original []byte
+	// value names an entry of valueAddresses for writes behind pointer
+	// chains, which move when the game reloads the world. Static code patches
+	// leave it empty.
+	value string
+}
+
+// valueAddresses resolves values behind pointer chains, keyed by the ID of
+// the patch definition the chain starts at.
+var valueAddresses = map[string]func(*Patcher) (int64, error){
+	"game_speed":   (*Patcher).GetGameSpeedAddress,
+	"player_speed": (*Patcher).GetPlayerSpeedAddress,
+}
+
+// address returns where a tracked write currently lives.
+func (p *Patcher) address(w trackedWrite) (int64, error) {
+	if w.value == "" {
+		return w.address, nil
+	}
+
+	resolve, exists := valueAddresses[w.value]
+	if !exists {
+		return 0, fmt.Errorf("unknown value %s", w.value)
+	}
+	return resolve(p)
+}
+
+// Drift describes a patched region whose contents no longer match what the
+// patcher wrote.
+type Drift struct {
+	Feature  string
+	Address  int64
+	Expected []byte
+	Actual   []byte
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s at 0x%X: expected % X, found % X", d.Feature, d.Address, d.Expected, d.Actual)
+}
+
+func float32Bytes(value float32) []byte {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, math.Float32bits(value))
+	return data
+}
+
+func (p *Patcher) writePatch(feature string, address int64, data []byte) error {
+	original, err := p.mem.ReadMemory(address, len(data))
+	if err != nil {
+		return err
+	}
+
+	if err := p.mem.WriteMemory(address, data); err != nil {
+		return err
+	}
+
+	p.track(trackedWrite{feature: feature, address: address, data: data, original: original})
+	return nil
+}
+
+// writeValue writes data to a value from valueAddresses, whose address is
+// re-resolved on every check.
+func (p *Patcher) writeValue(feature, value string, data []byte) error {
+	address, err := p.address(trackedWrite{value: value})
+	if err != nil {
+		return err
+	}
+
+	original, err := p.mem.ReadMemory(address, len(data))
+	if err != nil {
+		return err
+	}
+
+	if err := p.mem.WriteMemory(address, data); err != nil {
+		return err
+	}
+
+	p.track(trackedWrite{feature: feature, address: address, data: data, original: original, value: value})
+	return nil
+}
+
+func (p *Patcher) track(write trackedWrite) {
+	p.trackMu.Lock()
+	defer p.trackMu.Unlock()
+
+	for i, w := range p.tracked {
+		if w.feature == write.feature && (w.address == write.address || write.value != "") {
+			// Re-applying must not mistake our own bytes for the original.
+			write.original = w.original
+			p.tracked[i] = write
+			return
+		}
+	}
+
+	p.tracked = append(p.tracked, write)
+}
+
+func (p *Patcher) trackCave(name, feature string) {
+	p.trackMu.Lock()
+	defer p.trackMu.Unlock()
+
+	p.caveOwners[name] = feature
+}
+
+// activeWrites returns every tracked write and active cave region.
+func (p *Patcher) activeWrites() []trackedWrite {
+	p.trackMu.Lock()
+	defer p.trackMu.Unlock()
+
+	writes := append([]trackedWrite(nil), p.tracked...)
+	for _, region := range p.caveManager.ActiveRegions() {
+		writes = append(writes, trackedWrite{
+			feature: p.caveOwners[region.Owner],
+			address: region.Address,
+			data:    region.Data,
+		})
+	}
+	return writes
+}
+
+// VerifyPatches reads back every tracked patch and active cave and reports
+// the ones whose bytes changed since they were written. Values behind pointer
+// chains that cannot be resolved right now (e.g. at the main menu) are skipped.
+func (p *Patcher) VerifyPatches() ([]Drift, error) {
+	writes := p.activeWrites()
+
+	var drifts []Drift
+	for _, w := range writes {
+		address, err := p.address(w)
+		if err != nil {
+			continue
+		}
+
+		actual, err := p.mem.ReadMemory(address, len(w.data))
+		if err != nil {
+			return drifts, fmt.Errorf("failed to read back %s at 0x%X: %v", w.feature, address, err)
+		}
+
+		if !bytes.Equal(actual, w.data) {
+			drifts = append(drifts, Drift{
+				Feature:  w.feature,
+				Address:  address,
+				Expected: w.data,
+				Actual:   actual,
+			})
+		}
+	}
+
+	return drifts, nil
+}
+
+// HealDrift writes the expected bytes back over a drifted region. A drift
+// whose write is no longer active, e.g. because its feature was reverted since
+// it was found, is not healed.
+func (p *Patcher) HealDrift(drift Drift) error {
+	for _, w := range p.activeWrites() {
+		if w.feature != drift.Feature || !bytes.Equal(w.data, drift.Expected) {
+			continue
+		}
+		if address, err := p.address(w); err == nil && address == drift.Address {
+			return p.mem.WriteMemory(drift.Address, drift.Expected)
+		}
+	}
+	return fmt.Errorf("%s is no longer applied at 0x%X", drift.Feature, drift.Address)
+}
+
+// revertFeature releases the data caves owned by a feature, deactivates its
+// code caves and restores the original bytes of its tracked writes, newest
+// first. Code caves are only deactivated and are reused by the next apply.
+func (p *Patcher) revertFeature(feature string) error {
+	p.trackMu.Lock()
+	var writes []trackedWrite
+	kept := p.tracked[:0]
+	for _, w := range p.tracked {
+		if w.feature == feature {
+			writes = append(writes, w)
+		} else {
+			kept = append(kept, w)
+		}
+	}
+	p.tracked = kept
+
+	var caves []string
+	for name, owner := range p.caveOwners {
+		if owner == feature {
+			caves = append(caves, name)
+		}
+	}
+	p.trackMu.Unlock()
+
+	var errs []error
+	for _, name := range caves {
+		var err error
+		switch {
+		case p.caveManager.DataCaveExists(name):
+			if err = p.caveManager.ReleaseDataCave(name); err == nil {
+				p.trackMu.Lock()
+				delete(p.caveOwners, name)
+				p.trackMu.Unlock()
+			}
+		case p.caveManager.CodeCaveExists(name):
+			err = p.caveManager.DeactivateCodeCave(name)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+
+	for i := len(writes) - 1; i >= 0; i-- {
+		w := writes[i]
+		address, err := p.address(w)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := p.mem.WriteMemory(address, w.original); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore 0x%X: %v", address, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// featureStatus reports a feature as active while it has tracked writes or
+// active caves, and as drifted if any of them no longer match.
+func (p *Patcher) featureStatus(feature string) (FeatureStatus, error) {
+	active := false
+
+	p.trackMu.Lock()
+	for _, w := range p.tracked {
+		if w.feature == feature {
+			active = true
+			break
+		}
+	}
+	p.trackMu.Unlock()
+
+	if !active {
+		for _, region := range p.caveManager.ActiveRegions() {
+			p.trackMu.Lock()
+			owner := p.caveOwners[region.Owner]
+			p.trackMu.Unlock()
+
+			if owner == feature {
+				active = true
+				break
+			}
+		}
+	}
+
+	if !active {
+		return StatusInactive, nil
+	}
+
+	drifts, err := p.VerifyPatches()
+	for _, drift := range drifts {
+		if drift.Feature == feature {
+			return StatusDrifted, err
+		}
+	}
+	return StatusActive, err
+}
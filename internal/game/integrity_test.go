@@ -0,0 +1,154 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func driftedFeatures(drifts []Drift) []string {
+	var features []string
+	for _, drift := range drifts {
+		features = append(features, drift.Feature)
+	}
+	slices.Sort(features)
+	return features
+}
+
+func readFloat32(t *testing.T, p *Patcher, address int64) float32 {
+	t.Helper()
+	return math.Float32frombits(binary.LittleEndian.Uint32(readBytes(t, p.mem, address, 4)))
+}
+
+func TestVerifyAndHealDrift(t *testing.T) {
+	patcher, _ := newFakeGame(t)
+	requests := []struct {
+		feature string
+		params  Params
+	}{
+		{FeatureAutoLoot, nil},
+		{FeatureGameSpeed, Params{"speed": 2}},
+		{FeatureFOV, Params{"fov": 1.5}},
+	}
+	for _, request := range requests {
+		if err := patcher.ApplyFeature(mustFeature(t, request.feature), request.params); err != nil {
+			t.Fatalf("ApplyFeature(%s): %v", request.feature, err)
+		}
+	}
+	fovPointer := readBytes(t, patcher.mem, fakeFOV+8, 4)
+
+	drifts, err := patcher.VerifyPatches()
+	if err != nil || len(drifts) != 0 {
+		t.Fatalf("VerifyPatches after applying = %v, %v, want no drift", drifts, err)
+	}
+
+	// The game puts back its own code, speed and FOV pointer.
+	gameSpeed := int64(fakeTimescaleManager + fakeTimescaleOffset)
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, autoLootVanilla)
+	writeBytes(t, patcher.mem, gameSpeed, float32Bytes(1))
+	writeBytes(t, patcher.mem, fakeFOV+8, binary.LittleEndian.AppendUint32(nil, uint32(fakeFOVConstant-(fakeFOV+12))))
+
+	drifts, err = patcher.VerifyPatches()
+	if err != nil {
+		t.Fatalf("VerifyPatches: %v", err)
+	}
+	want := []string{FeatureAutoLoot, FeatureFOV, FeatureGameSpeed}
+	if got := driftedFeatures(drifts); !slices.Equal(got, want) {
+		t.Fatalf("drifted features = %v, want %v", got, want)
+	}
+	for _, drift := range drifts {
+		if drift.Feature == FeatureAutoLoot && (drift.Address != fakeAutoLoot+18 || !bytes.Equal(drift.Actual, autoLootVanilla)) {
+			t.Errorf("auto_loot drift = %v", drift)
+		}
+		if status, err := mustFeature(t, drift.Feature).Status(patcher); err != nil || status != StatusDrifted {
+			t.Errorf("Status(%s) = %v, %v, want drifted", drift.Feature, status, err)
+		}
+	}
+
+	for _, drift := range drifts {
+		if err := patcher.HealDrift(drift); err != nil {
+			t.Errorf("HealDrift(%s): %v", drift.Feature, err)
+		}
+	}
+	if drifts, err := patcher.VerifyPatches(); err != nil || len(drifts) != 0 {
+		t.Errorf("VerifyPatches after healing = %v, %v, want no drift", drifts, err)
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootPatched) {
+		t.Errorf("auto_loot bytes = % X, want % X", got, autoLootPatched)
+	}
+	if got := readFloat32(t, patcher, gameSpeed); got != 2 {
+		t.Errorf("game speed = %v, want 2", got)
+	}
+	if got := readBytes(t, patcher.mem, fakeFOV+8, 4); !bytes.Equal(got, fovPointer) {
+		t.Errorf("fov pointer = % X, want % X", got, fovPointer)
+	}
+}
+
+func TestRevertedFeatureIsNotHealed(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	autoLoot := mustFeature(t, FeatureAutoLoot)
+	if err := session.Apply(FeatureSetting{Feature: autoLoot, Enabled: true}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, []byte{0x90, 0x90})
+	drifts, err := patcher.VerifyPatches()
+	if err != nil || len(drifts) != 1 {
+		t.Fatalf("VerifyPatches = %v, %v, want one drift", drifts, err)
+	}
+
+	// A drift found before the revert must not patch the game again.
+	if err := session.Revert(autoLoot); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	if err := patcher.HealDrift(drifts[0]); err == nil {
+		t.Error("HealDrift of a reverted feature succeeded")
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootVanilla) {
+		t.Errorf("auto_loot bytes = % X, want % X", got, autoLootVanilla)
+	}
+
+	// Nor does the watchdog find anything to heal.
+	watchdog := NewWatchdog(session, time.Hour)
+	watchdog.SetAutoHeal(true)
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, []byte{0x90, 0x90})
+	watchdog.check()
+	select {
+	case event := <-watchdog.Events():
+		t.Errorf("drift event after revert: %+v", event)
+	default:
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, []byte{0x90, 0x90}) {
+		t.Errorf("auto_loot bytes = % X, want the game's", got)
+	}
+}
+
+func TestVerifySkipsUnresolvedValues(t *testing.T) {
+	patcher, _ := newFakeGame(t)
+	if err := patcher.ApplyFeature(mustFeature(t, FeaturePlayerSpeed), Params{"speed": 2}); err != nil {
+		t.Fatalf("ApplyFeature: %v", err)
+	}
+
+	// The world is unloaded, so the player speed cannot be read or healed.
+	writeBytes(t, patcher.mem, fakePlayerSlot, make([]byte, 8))
+	if drifts, err := patcher.VerifyPatches(); err != nil || len(drifts) != 0 {
+		t.Errorf("VerifyPatches = %v, %v, want nothing while unloaded", drifts, err)
+	}
+
+	// Once it is back, the reloaded default is drift.
+	writeBytes(t, patcher.mem, fakePlayerSlot, binary.LittleEndian.AppendUint64(nil, fakePlayer))
+	writeBytes(t, patcher.mem, fakePlayerSpeedValue, float32Bytes(1))
+	drifts, err := patcher.VerifyPatches()
+	if err != nil || len(drifts) != 1 || drifts[0].Feature != FeaturePlayerSpeed {
+		t.Fatalf("VerifyPatches = %v, %v, want player speed drift", drifts, err)
+	}
+	if err := patcher.HealDrift(drifts[0]); err != nil {
+		t.Fatalf("HealDrift: %v", err)
+	}
+	if got := readFloat32(t, patcher, fakePlayerSpeedValue); got != 2 {
+		t.Errorf("player speed = %v, want 2", got)
+	}
+}
@@ -0,0 +1,190 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// The journal records what a patcher wrote to a game process, so that a later
+// tweaker process (another CLI invocation, or the GUI after a restart) can
+// report, verify and revert those patches instead of mistaking patched bytes
+// for the originals.
+const journalVersion = 1
+
+type journal struct {
+	Version int       `json:"version"`
+	PID     int       `json:"pid"`
+	Started uint64    `json:"started"`
+	SavedAt time.Time `json:"saved_at"`
+
+	Writes     []journalWrite         `json:"writes"`
+	Caves      []memory.CaveRecord    `json:"caves"`
+	CaveOwners map[string]string      `json:"cave_owners"`
+	Allocator  *memory.AllocatorState `json:"allocator,omitempty"`
+}
+
+type journalWrite struct {
+	Feature  string   `json:"feature"`
+	Address  int64    `json:"address"`
+	Data     HexBytes `json:"data"`
+	Original HexBytes `json:"original"`
+	Value    string   `json:"value,omitempty"`
+}
+
+// RuntimeDir returns the per-user directory for files that only live as long
+// as the session, such as journals and the control socket.
+func RuntimeDir() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("sekiro-tweaker-%d", os.Getuid()))
+	}
+	return filepath.Join(dir, "sekiro-tweaker")
+}
+
+// JournalPath returns where the journal of a game process is kept.
+func JournalPath(pid int) string {
+	return filepath.Join(RuntimeDir(), fmt.Sprintf("patches-%d.json", pid))
+}
+
+// EnableJournal loads the journal a previous tweaker process left for this
+// game process and keeps it up to date as features are applied and reverted.
+// A journal left by an earlier process with the same pid is discarded.
+func (p *Patcher) EnableJournal() error {
+	if p.pid == 0 {
+		return fmt.Errorf("journals need a live game process")
+	}
+
+	started := p.StartTime()
+	if started == 0 {
+		return fmt.Errorf("start time of process %d is unknown", p.pid)
+	}
+
+	path := JournalPath(p.pid)
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		var saved journal
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("invalid journal %s: %v", path, err)
+		}
+		if saved.Version == journalVersion && saved.PID == p.pid && saved.Started == started {
+			p.restoreJournal(saved)
+			logger.Log.Info("Restored patch journal",
+				zap.String("path", path),
+				zap.Int("writes", len(saved.Writes)),
+				zap.Int("caves", len(saved.Caves)))
+		}
+	}
+
+	p.trackMu.Lock()
+	p.journalPath = path
+	p.started = started
+	p.trackMu.Unlock()
+
+	return p.saveJournal()
+}
+
+// allocatorStater is implemented by allocators whose bookkeeping can be
+// saved and restored, i.e. memory.ProcessMemory and memory.Recorder.
+type allocatorStater interface {
+	AllocatorState() memory.AllocatorState
+	RestoreAllocatorState(state memory.AllocatorState)
+}
+
+func (p *Patcher) restoreJournal(saved journal) {
+	p.trackMu.Lock()
+	defer p.trackMu.Unlock()
+
+	for _, w := range saved.Writes {
+		p.tracked = append(p.tracked, trackedWrite{
+			feature:  w.Feature,
+			address:  w.Address,
+			data:     w.Data,
+			original: w.Original,
+			value:    w.Value,
+		})
+	}
+	for name, owner := range saved.CaveOwners {
+		p.caveOwners[name] = owner
+	}
+	p.caveManager.Restore(saved.Caves)
+
+	if allocator, ok := p.mem.(allocatorStater); ok && saved.Allocator != nil {
+		allocator.RestoreAllocatorState(*saved.Allocator)
+	}
+}
+
+// journalState captures the patcher's writes, feature caves and allocator.
+// Hooks are left out since their event ring belongs to the process that
+// installed them.
+func (p *Patcher) journalState() journal {
+	p.trackMu.Lock()
+	defer p.trackMu.Unlock()
+
+	state := journal{
+		Version:    journalVersion,
+		PID:        p.pid,
+		Started:    p.started,
+		SavedAt:    time.Now(),
+		CaveOwners: make(map[string]string),
+	}
+	for _, w := range p.tracked {
+		state.Writes = append(state.Writes, journalWrite{
+			Feature:  w.feature,
+			Address:  w.address,
+			Data:     w.data,
+			Original: w.original,
+			Value:    w.value,
+		})
+	}
+	for _, record := range p.caveManager.Records() {
+		owner := p.caveOwners[record.Name]
+		if _, isFeature := LookupFeature(owner); !isFeature {
+			continue
+		}
+		state.Caves = append(state.Caves, record)
+		state.CaveOwners[record.Name] = owner
+	}
+	if allocator, ok := p.mem.(allocatorStater); ok {
+		allocatorState := allocator.AllocatorState()
+		state.Allocator = &allocatorState
+	}
+
+	return state
+}
+
+// saveJournal writes the journal if it is enabled.
+func (p *Patcher) saveJournal() error {
+	p.trackMu.Lock()
+	path := p.journalPath
+	p.trackMu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(p.journalState(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
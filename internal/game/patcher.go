@@ -1,25 +1,55 @@
 package game
 
 import (
-	"encoding/binary"
 	"fmt"
-	"math"
+	"sync"
 
 	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
 )
 
 type Patcher struct {
-	mem         *memory.ProcessMemory
+	pid int
+	// process is the game's memory, or nil for other address spaces.
+	process     *memory.ProcessMemory
+	mem         memory.ReadWriter
 	scanner     *memory.PatternScanner
 	peParser    *memory.PEParser
 	caveManager *memory.CaveManager
+	patches     *PatchSet
 	baseAddress int64
+
+	trackMu    sync.Mutex
+	tracked    []trackedWrite
+	caveOwners map[string]string
+
+	// journalPath and started are set by EnableJournal.
+	journalPath string
+	started     uint64
+
+	hookMu sync.Mutex
+	events *memory.EventRing
+
+	// gameState is the state the state monitor last read, or StateUnknown
+	// if none runs. It is only used in session commands.
+	gameState GameState
 }
 
 func NewPatcher(pid int) (*Patcher, error) {
-	mem := memory.NewProcessMemory(pid)
+	process := memory.NewProcessMemory(pid)
+	patcher, err := NewPatcherWithMemory(process)
+	if err != nil {
+		return nil, err
+	}
+
+	patcher.pid = pid
+	patcher.process = process
+	return patcher, nil
+}
 
-	baseAddress, err := mem.GetModuleBaseAddress(ProcessName)
+// NewPatcherWithMemory creates a patcher on top of any address space, such as
+// a snapshot opened with memory.OpenSnapshot for offline debugging.
+func NewPatcherWithMemory(mem memory.ReadWriter) (*Patcher, error) {
+	baseAddress, err := memory.FindModuleBaseAddress(mem, ProcessName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find module: %v", err)
 	}
@@ -33,244 +63,101 @@ func NewPatcher(pid int) (*Patcher, error) {
 		scanner:     scanner,
 		peParser:    peParser,
 		caveManager: caveManager,
+		patches:     ActivePatches(),
 		baseAddress: baseAddress,
+		caveOwners:  make(map[string]string),
 	}, nil
 }
 
-func (p *Patcher) ApplyFPSPatch(targetFPS int) error {
-	address, err := p.scanner.FindPattern(PatternFramelockFuzzy)
-	if err != nil {
-		return fmt.Errorf("failed to find FPS pattern: %v", err)
-	}
-
-	targetAddress := address + PatternFramelockFuzzyOffset
-	fpsValue := 1.0 / float32(targetFPS)
-
-	data := make([]byte, 4)
-	binary.LittleEndian.PutUint32(data, math.Float32bits(fpsValue))
-
-	if err := p.mem.WriteMemory(targetAddress, data); err != nil {
-		return fmt.Errorf("failed to write FPS value: %v", err)
-	}
-
-	speedFixAddress, err := p.scanner.FindPattern(PatternFramelockSpeedFix)
-	if err != nil {
-		return nil
-	}
-
-	speedFixValue := FindSpeedFixForFrameRate(targetFPS)
-	speedFixPointer := speedFixAddress + PatternFramelockSpeedFixOffset
-
-	speedFixData := make([]byte, 4)
-	binary.LittleEndian.PutUint32(speedFixData, math.Float32bits(speedFixValue))
-
-	if err := p.caveManager.CreateDataCave("speedfix", speedFixPointer, speedFixData, memory.DWordRelative); err != nil {
-		return fmt.Errorf("failed to create speed fix cave: %v", err)
-	}
-
-	if err := p.caveManager.ActivateDataCave("speedfix"); err != nil {
-		return fmt.Errorf("failed to activate speed fix cave: %v", err)
-	}
-
-	return nil
+// PID returns the game process ID, or 0 for patchers on other address spaces.
+func (p *Patcher) PID() int {
+	return p.pid
 }
 
-func (p *Patcher) ApplyResolutionPatch(width, height int) error {
-	dataAddress, dataSize, err := p.peParser.FindSection(".data")
-	if err != nil {
-		return fmt.Errorf("failed to find .data section: %v", err)
+// StartTime returns when the game process started, in clock ticks since
+// boot, or 0 for patchers on other address spaces.
+func (p *Patcher) StartTime() uint64 {
+	if p.process == nil {
+		return 0
 	}
-
-	address, err := p.scanner.FindPatternInRegion(PatternResolutionDefault, dataAddress, dataSize)
-	if err != nil {
-		address, err = p.scanner.FindPatternInRegion(PatternResolutionDefault720, dataAddress, dataSize)
-		if err != nil {
-			return fmt.Errorf("failed to find resolution pattern: %v", err)
-		}
-	}
-
-	data := make([]byte, 8)
-	binary.LittleEndian.PutUint32(data[0:], uint32(width))
-	binary.LittleEndian.PutUint32(data[4:], uint32(height))
-
-	if err := p.mem.WriteMemory(address, data); err != nil {
-		return fmt.Errorf("failed to write resolution: %v", err)
-	}
-
-	widescreenAddress, err := p.scanner.FindPattern(PatternResolutionScalingFix)
-	if err == nil {
-		return p.mem.WriteMemory(widescreenAddress, PatchResolutionScalingFixEnable)
-	}
-
-	return nil
+	return p.process.StartTime()
 }
 
-func (p *Patcher) ApplyFOVPatch(fovDegrees float32) error {
-	address, err := p.scanner.FindPattern(PatternFovSetting)
-	if err != nil {
-		return fmt.Errorf("failed to find FOV pattern: %v", err)
-	}
-
-	fovPointer := address + PatternFovSettingOffset
-
-	fovRadians := fovDegrees * DegreesToRadians
-	fovData := make([]byte, 4)
-	binary.LittleEndian.PutUint32(fovData, math.Float32bits(fovRadians))
-
-	if err := p.caveManager.CreateDataCave("fov", fovPointer, fovData, memory.DWordRelative); err != nil {
-		return fmt.Errorf("failed to create FOV cave: %v", err)
-	}
-
-	if err := p.caveManager.ActivateDataCave("fov"); err != nil {
-		return fmt.Errorf("failed to activate FOV cave: %v", err)
-	}
-
-	return nil
+// Running reports whether the game process still runs. It is false once the
+// pid belongs to another process, which the patcher never writes to.
+func (p *Patcher) Running() bool {
+	return p.process == nil || p.process.Alive()
 }
 
-func (p *Patcher) RemoveFPSPatch() error {
-	if err := p.caveManager.DeactivateDataCave("speedfix"); err != nil {
-		return err
+// Exited tells the patcher that the game exited, so that nothing is written
+// to its pid any more.
+func (p *Patcher) Exited() {
+	if p.process != nil {
+		p.process.MarkGone()
 	}
+}
 
-	address, err := p.scanner.FindPattern(PatternFramelockFuzzy)
-	if err != nil {
+// Close reverts every cave the patcher created, including hooks, and releases
+// its memory. Plain byte patches are left in place.
+func (p *Patcher) Close() error {
+	if err := p.caveManager.Close(); err != nil {
 		return err
 	}
-
-	targetAddress := address + PatternFramelockFuzzyOffset
-	fpsValue := float32(1.0 / 60.0)
-
-	data := make([]byte, 4)
-	binary.LittleEndian.PutUint32(data, math.Float32bits(fpsValue))
-
-	return p.mem.WriteMemory(targetAddress, data)
+	return p.closeEvents()
 }
 
-func (p *Patcher) RemoveFOVPatch() error {
-	return p.caveManager.DeactivateDataCave("fov")
-}
+// ensureDataCave takes feature's reference to a data cave, creating the cave
+// on first use. A feature holds one reference however often it is applied, so
+// applying it again only rewrites the data and never leaks cave memory.
+// Reverting the feature releases the reference.
+func (p *Patcher) ensureDataCave(name, feature string, pointerAddress int64, data []byte, pointerStyle memory.PointerStyle) error {
+	p.trackMu.Lock()
+	owned := p.caveOwners[name] == feature
+	p.trackMu.Unlock()
 
-func (p *Patcher) ApplyCameraResetPatch(disable bool) error {
-	address, err := p.scanner.FindPattern(PatternCameraResetLockOn)
-	if err != nil {
-		return fmt.Errorf("failed to find camera reset pattern: %v", err)
+	if owned && p.caveManager.DataCaveExists(name) {
+		return p.caveManager.UpdateDataCave(name, data)
 	}
 
-	targetAddress := address + PatternCameraResetLockOnOffset
-
-	var value byte
-	if disable {
-		value = 0x00
-	} else {
-		value = 0x01
+	if err := p.caveManager.CreateDataCave(name, pointerAddress, data, pointerStyle); err != nil {
+		return err
 	}
-
-	return p.mem.WriteMemory(targetAddress, []byte{value})
+	p.trackCave(name, feature)
+	return nil
 }
 
-func (p *Patcher) ApplyAutoLootPatch(enable bool) error {
-	address, err := p.scanner.FindPattern(PatternAutoLoot)
-	if err != nil {
-		return fmt.Errorf("failed to find auto-loot pattern: %v", err)
-	}
-
-	targetAddress := address + PatternAutoLootOffset
-
-	var patch []byte
-	if enable {
-		patch = PatchAutoLootEnable
-	} else {
-		patch = PatchAutoLootDisable
-	}
-
-	return p.mem.WriteMemory(targetAddress, patch)
+// WriteSnapshot dumps the game's readable regions and module layout to path.
+func (p *Patcher) WriteSnapshot(path string) error {
+	return memory.WriteSnapshotFile(path, p.mem, memory.SnapshotOptions{
+		PID:        p.pid,
+		ModuleName: ProcessName,
+	})
 }
 
-func (p *Patcher) ApplyDragonrotPatch(disable bool) error {
-	address, err := p.scanner.FindPattern(PatternDragonrotEffect)
-	if err != nil {
-		return fmt.Errorf("failed to find dragonrot pattern: %v", err)
-	}
-
-	targetAddress := address + PatternDragonrotEffectOffset
-
-	var patch []byte
-	if disable {
-		patch = PatchDragonrotEffectDisable
-	} else {
-		patch = PatchDragonrotEffectEnable
-	}
-
-	return p.mem.WriteMemory(targetAddress, patch)
-}
-
-func (p *Patcher) ApplyDeathPenaltyPatch(disable bool) error {
-	if !disable {
-		// Enabling requires restoring original bytes
-		// Game restart required to restore
-		return nil
-	}
-
-	// Patch 1: Disable Sen loss function call (5 bytes)
-	address1, err := p.scanner.FindPattern(PatternDeathPenalties1)
-	if err != nil {
-		return fmt.Errorf("failed to find death penalty pattern 1: %v", err)
-	}
-	targetAddress1 := address1 + PatternDeathPenalties1Offset
-
-	if err := p.mem.WriteMemory(targetAddress1, PatchDeathPenalties1Disable); err != nil {
-		return fmt.Errorf("failed to apply death penalty patch 1: %v", err)
-	}
-
-	// Patch 2: Try modern pattern first, then legacy
-	address2, err := p.scanner.FindPattern(PatternDeathPenalties2)
-	isLegacy := false
-	if err != nil {
-		// Try legacy pattern
-		address2, err = p.scanner.FindPattern(PatternDeathPenalties2Legacy)
-		if err != nil {
-			// Pattern 2 not found - not critical, pattern 1 already applied
-			return nil
-		}
-		isLegacy = true
-	}
-
-	if isLegacy {
-		targetAddress2 := address2 + PatternDeathPenalties2OffsetLegacy
-		if err := p.mem.WriteMemory(targetAddress2, PatchDeathPenalties2DisableLegacy); err != nil {
-			return fmt.Errorf("failed to apply death penalty patch 2 (legacy): %v", err)
-		}
-	} else {
-		targetAddress2 := address2 + PatternDeathPenalties2Offset
-		if err := p.mem.WriteMemory(targetAddress2, PatchDeathPenalties2Disable); err != nil {
-			return fmt.Errorf("failed to apply death penalty patch 2: %v", err)
-		}
-
-		// Patch 3: Only exists in modern version (offset from pattern 2)
-		targetAddress3 := address2 + PatternDeathPenalties3Offset
-		if err := p.mem.WriteMemory(targetAddress3, PatchDeathPenalties3Disable); err != nil {
-			return fmt.Errorf("failed to apply death penalty patch 3: %v", err)
-		}
-	}
-
-	return nil
+// WriteStateCapture dumps only the game's writable memory to path. Captures
+// taken before and after an in-game event can be compared with
+// memory.DiffSnapshots to locate new stats.
+func (p *Patcher) WriteStateCapture(path string) error {
+	return memory.WriteSnapshotFile(path, p.mem, memory.SnapshotOptions{
+		PID:    p.pid,
+		Filter: memory.WritableSnapshotFilter,
+	})
 }
 
 func (p *Patcher) GetGameSpeedAddress() (int64, error) {
-	refAddress, err := p.scanner.FindPattern(PatternGameSpeed)
+	definition, refAddress, err := p.findPatch("game_speed")
 	if err != nil {
-		return 0, fmt.Errorf("failed to find game speed pattern: %v", err)
+		return 0, err
 	}
 
 	// Dereference the static pointer
-	timescaleManager, err := p.mem.DereferenceStaticPointer(refAddress, PatternGameSpeedInstructionLength)
+	timescaleManager, err := memory.DereferenceStaticPointer(p.mem, refAddress, int(definition.Params["instruction_length"]))
 	if err != nil {
 		return 0, fmt.Errorf("failed to dereference timescale manager: %v", err)
 	}
 
 	// Read the offset to the actual timescale value
-	offset, err := p.mem.ReadInt32(refAddress + PatternGameSpeedPointerOffsetOffset)
+	offset, err := memory.ReadInt32(p.mem, refAddress+definition.Params["pointer_offset_offset"])
 	if err != nil {
 		return 0, fmt.Errorf("failed to read timescale offset: %v", err)
 	}
@@ -278,33 +165,29 @@ func (p *Patcher) GetGameSpeedAddress() (int64, error) {
 	return timescaleManager + int64(offset), nil
 }
 
-func (p *Patcher) SetGameSpeed(speed float32) error {
-	address, err := p.GetGameSpeedAddress()
-	if err != nil {
-		return err
-	}
-
-	return p.mem.WriteFloat32(address, speed)
-}
-
 func (p *Patcher) GetGameSpeed() (float32, error) {
 	address, err := p.GetGameSpeedAddress()
 	if err != nil {
 		return 0, err
 	}
 
-	return p.mem.ReadFloat32(address)
+	return memory.ReadFloat32(p.mem, address)
 }
 
 func (p *Patcher) GetPlayerSpeedAddress() (int64, error) {
 	// Find the pattern for the first pointer
-	lpPlayerStructRelated1, err := p.scanner.FindPattern(PatternPlayerSpeed)
+	definition, lpPlayerStructRelated1, err := p.findPatch("player_speed")
 	if err != nil {
-		return 0, fmt.Errorf("failed to find player speed pattern: %v", err)
+		return 0, err
 	}
+	return p.playerSpeedAddress(definition, lpPlayerStructRelated1)
+}
 
+// playerSpeedAddress follows the player pointer chain from the instruction
+// found for the player_speed definition.
+func (p *Patcher) playerSpeedAddress(definition PatchDefinition, lpPlayerStructRelated1 int64) (int64, error) {
 	// Dereference pointer 1 -> pointer 2
-	lpPlayerStructRelated2, err := p.mem.DereferenceStaticPointer(lpPlayerStructRelated1, PatternPlayerSpeedInstructionLength)
+	lpPlayerStructRelated2, err := memory.DereferenceStaticPointer(p.mem, lpPlayerStructRelated1, int(definition.Params["instruction_length"]))
 	if err != nil {
 		return 0, fmt.Errorf("failed to dereference player struct pointer 1: %v", err)
 	}
@@ -315,37 +198,37 @@ func (p *Patcher) GetPlayerSpeedAddress() (int64, error) {
 	}
 
 	// Follow pointer chain: 2 -> 3
-	lpPlayerStructRelated3, err := p.mem.ReadInt64(lpPlayerStructRelated2)
+	lpPlayerStructRelated3, err := memory.ReadInt64(p.mem, lpPlayerStructRelated2)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read player struct pointer 2: %v", err)
 	}
 	if lpPlayerStructRelated3 == 0 {
 		return 0, fmt.Errorf("player not loaded (pointer 3 is null)")
 	}
-	lpPlayerStructRelated3 += PatternPlayerSpeedPointer2Offset
+	lpPlayerStructRelated3 += definition.Params["pointer2_offset"]
 
 	// 3 -> 4
-	lpPlayerStructRelated4, err := p.mem.ReadInt64(lpPlayerStructRelated3)
+	lpPlayerStructRelated4, err := memory.ReadInt64(p.mem, lpPlayerStructRelated3)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read player struct pointer 3: %v", err)
 	}
 	if lpPlayerStructRelated4 == 0 {
 		return 0, fmt.Errorf("player not loaded (pointer 4 is null)")
 	}
-	lpPlayerStructRelated4 += PatternPlayerSpeedPointer3Offset
+	lpPlayerStructRelated4 += definition.Params["pointer3_offset"]
 
 	// 4 -> 5
-	lpPlayerStructRelated5, err := p.mem.ReadInt64(lpPlayerStructRelated4)
+	lpPlayerStructRelated5, err := memory.ReadInt64(p.mem, lpPlayerStructRelated4)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read player struct pointer 4: %v", err)
 	}
 	if lpPlayerStructRelated5 == 0 {
 		return 0, fmt.Errorf("player not loaded (pointer 5 is null)")
 	}
-	lpPlayerStructRelated5 += PatternPlayerSpeedPointer4Offset
+	lpPlayerStructRelated5 += definition.Params["pointer4_offset"]
 
 	// 5 -> final address
-	playerSpeedBase, err := p.mem.ReadInt64(lpPlayerStructRelated5)
+	playerSpeedBase, err := memory.ReadInt64(p.mem, lpPlayerStructRelated5)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read player struct pointer 5: %v", err)
 	}
@@ -353,16 +236,7 @@ func (p *Patcher) GetPlayerSpeedAddress() (int64, error) {
 		return 0, fmt.Errorf("player not loaded (final pointer is null)")
 	}
 
-	return playerSpeedBase + PatternPlayerSpeedPointer5Offset, nil
-}
-
-func (p *Patcher) SetPlayerSpeed(speed float32) error {
-	address, err := p.GetPlayerSpeedAddress()
-	if err != nil {
-		return err
-	}
-
-	return p.mem.WriteFloat32(address, speed)
+	return playerSpeedBase + definition.Params["pointer5_offset"], nil
 }
 
 func (p *Patcher) GetPlayerSpeed() (float32, error) {
@@ -371,24 +245,31 @@ func (p *Patcher) GetPlayerSpeed() (float32, error) {
 		return 0, err
 	}
 
-	return p.mem.ReadFloat32(address)
+	return memory.ReadFloat32(p.mem, address)
 }
 
 func (p *Patcher) GetPlayerDeathsAddress() (int64, error) {
-	refAddress, err := p.scanner.FindPattern(PatternPlayerDeaths)
+	definition, refAddress, err := p.findPatch("player_deaths")
 	if err != nil {
-		return 0, fmt.Errorf("failed to find player deaths pattern: %v", err)
+		return 0, err
 	}
-	refAddress += PatternPlayerDeathsOffset
+	return p.playerDeathsAddress(definition, refAddress)
+}
 
+// playerDeathsAddress resolves the death counter from the instruction found
+// for the player_deaths definition.
+func (p *Patcher) playerDeathsAddress(definition PatchDefinition, refAddress int64) (int64, error) {
 	// Dereference the static pointer
-	lpPlayerStatsRelated, err := p.mem.DereferenceStaticPointer(refAddress, PatternPlayerDeathsInstructionLength)
+	lpPlayerStatsRelated, err := memory.DereferenceStaticPointer(p.mem, refAddress, int(definition.Params["instruction_length"]))
 	if err != nil {
 		return 0, fmt.Errorf("failed to dereference player stats: %v", err)
 	}
+	if lpPlayerStatsRelated == 0 {
+		return 0, fmt.Errorf("no save loaded (player stats pointer is null)")
+	}
 
 	// Read the offset to the death counter
-	offset, err := p.mem.ReadInt32(refAddress + PatternPlayerDeathsPointerOffsetOffset)
+	offset, err := memory.ReadInt32(p.mem, refAddress+definition.Params["pointer_offset_offset"])
 	if err != nil {
 		return 0, fmt.Errorf("failed to read deaths offset: %v", err)
 	}
@@ -402,36 +283,35 @@ func (p *Patcher) GetPlayerDeaths() (int32, error) {
 		return 0, err
 	}
 
-	return p.mem.ReadInt32(address)
+	return memory.ReadInt32(p.mem, address)
 }
 
 func (p *Patcher) GetTotalKillsAddress() (int64, error) {
-	refAddress, err := p.scanner.FindPattern(PatternTotalKills)
+	definition, refAddress, err := p.findPatch("total_kills")
 	if err != nil {
-		return 0, fmt.Errorf("failed to find total kills pattern: %v", err)
+		return 0, err
 	}
-	refAddress += PatternTotalKillsOffset
 
 	// Dereference pointer 1
-	lpPlayerStatsRelatedKills1, err := p.mem.DereferenceStaticPointer(refAddress, PatternTotalKillsInstructionLength)
+	lpPlayerStatsRelatedKills1, err := memory.DereferenceStaticPointer(p.mem, refAddress, int(definition.Params["instruction_length"]))
 	if err != nil {
 		return 0, fmt.Errorf("failed to dereference kills pointer 1: %v", err)
 	}
 
 	// Follow pointer chain: pointer 1 -> 2
-	lpPlayerStructRelatedKills2, err := p.mem.ReadInt64(lpPlayerStatsRelatedKills1)
+	lpPlayerStructRelatedKills2, err := memory.ReadInt64(p.mem, lpPlayerStatsRelatedKills1)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read kills pointer 1: %v", err)
 	}
-	lpPlayerStructRelatedKills2 += PatternTotalKillsPointer1Offset
+	lpPlayerStructRelatedKills2 += definition.Params["pointer1_offset"]
 
 	// 2 -> final address
-	totalKillsBase, err := p.mem.ReadInt64(lpPlayerStructRelatedKills2)
+	totalKillsBase, err := memory.ReadInt64(p.mem, lpPlayerStructRelatedKills2)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read kills pointer 2: %v", err)
 	}
 
-	return totalKillsBase + PatternTotalKillsPointer2Offset, nil
+	return totalKillsBase + definition.Params["pointer2_offset"], nil
 }
 
 func (p *Patcher) GetTotalKills() (int32, error) {
@@ -440,125 +320,5 @@ func (p *Patcher) GetTotalKills() (int32, error) {
 		return 0, err
 	}
 
-	return p.mem.ReadInt32(address)
-}
-
-func (p *Patcher) ApplyCameraAutoRotatePatch(disable bool) error {
-	if !disable {
-		// Restore original behavior by deactivating code caves
-		errors := []error{}
-		if err := p.caveManager.DeactivateCodeCave("camera_pitch"); err != nil {
-			errors = append(errors, fmt.Errorf("pitch: %v", err))
-		}
-		if err := p.caveManager.DeactivateCodeCave("camera_yaw_z"); err != nil {
-			errors = append(errors, fmt.Errorf("yaw_z: %v", err))
-		}
-		if err := p.caveManager.DeactivateCodeCave("camera_pitch_xy"); err != nil {
-			errors = append(errors, fmt.Errorf("pitch_xy: %v", err))
-		}
-		if err := p.caveManager.DeactivateCodeCave("camera_yaw_xy"); err != nil {
-			errors = append(errors, fmt.Errorf("yaw_xy: %v", err))
-		}
-
-		if len(errors) > 0 {
-			return fmt.Errorf("failed to deactivate camera caves: %v", errors)
-		}
-		return nil
-	}
-
-	// Create all 4 code caves first (without activating)
-	// This validates patterns and allocates memory without modifying game code
-	errors := []error{}
-	successCount := 0
-
-	// 1. Camera Pitch
-	pitchAddr, err := p.scanner.FindPattern(PatternCameraAdjustPitch)
-	if err == nil {
-		if err := p.caveManager.CreateCodeCave("camera_pitch", pitchAddr, PatternCameraAdjustPitchOverwrite, ShellcodeCameraAdjustPitch); err != nil {
-			errors = append(errors, fmt.Errorf("create camera_pitch: %v", err))
-		} else {
-			successCount++
-		}
-	} else {
-		errors = append(errors, fmt.Errorf("find camera_pitch pattern: %v", err))
-	}
-
-	// 2. Camera Yaw Z
-	yawZAddr, err := p.scanner.FindPattern(PatternCameraAdjustYawZ)
-	if err == nil {
-		yawZAddr += PatternCameraAdjustYawZOffset
-		if err := p.caveManager.CreateCodeCave("camera_yaw_z", yawZAddr, PatternCameraAdjustYawZOverwrite, ShellcodeCameraAdjustYawZ); err != nil {
-			errors = append(errors, fmt.Errorf("create camera_yaw_z: %v", err))
-		} else {
-			successCount++
-		}
-	} else {
-		errors = append(errors, fmt.Errorf("find camera_yaw_z pattern: %v", err))
-	}
-
-	// 3. Camera Pitch XY
-	pitchXYAddr, err := p.scanner.FindPattern(PatternCameraAdjustPitchXY)
-	if err == nil {
-		if err := p.caveManager.CreateCodeCave("camera_pitch_xy", pitchXYAddr, PatternCameraAdjustPitchXYOverwrite, ShellcodeCameraAdjustPitchXY); err != nil {
-			errors = append(errors, fmt.Errorf("create camera_pitch_xy: %v", err))
-		} else {
-			successCount++
-		}
-	} else {
-		errors = append(errors, fmt.Errorf("find camera_pitch_xy pattern: %v", err))
-	}
-
-	// 4. Camera Yaw XY
-	yawXYAddr, err := p.scanner.FindPattern(PatternCameraAdjustYawXY)
-	if err == nil {
-		yawXYAddr += PatternCameraAdjustYawXYOffset
-		if err := p.caveManager.CreateCodeCave("camera_yaw_xy", yawXYAddr, PatternCameraAdjustYawXYOverwrite, ShellcodeCameraAdjustYawXY); err != nil {
-			errors = append(errors, fmt.Errorf("create camera_yaw_xy: %v", err))
-		} else {
-			successCount++
-		}
-	} else {
-		errors = append(errors, fmt.Errorf("find camera_yaw_xy pattern: %v", err))
-	}
-
-	// If we couldn't create any caves, fail early
-	if successCount == 0 {
-		return fmt.Errorf("camera auto-rotate: failed to create any code caves: %v", errors)
-	}
-
-	// Now activate the caves that were successfully created
-	// Do this carefully and check for process validity between activations
-	activationErrors := []error{}
-
-	if p.caveManager.CodeCaveExists("camera_pitch") {
-		if err := p.caveManager.ActivateCodeCave("camera_pitch"); err != nil {
-			activationErrors = append(activationErrors, fmt.Errorf("activate camera_pitch: %v", err))
-		}
-	}
-
-	if p.caveManager.CodeCaveExists("camera_yaw_z") {
-		if err := p.caveManager.ActivateCodeCave("camera_yaw_z"); err != nil {
-			activationErrors = append(activationErrors, fmt.Errorf("activate camera_yaw_z: %v", err))
-		}
-	}
-
-	if p.caveManager.CodeCaveExists("camera_pitch_xy") {
-		if err := p.caveManager.ActivateCodeCave("camera_pitch_xy"); err != nil {
-			activationErrors = append(activationErrors, fmt.Errorf("activate camera_pitch_xy: %v", err))
-		}
-	}
-
-	if p.caveManager.CodeCaveExists("camera_yaw_xy") {
-		if err := p.caveManager.ActivateCodeCave("camera_yaw_xy"); err != nil {
-			activationErrors = append(activationErrors, fmt.Errorf("activate camera_yaw_xy: %v", err))
-		}
-	}
-
-	// Combine all errors for reporting
-	allErrors := append(errors, activationErrors...)
-	if len(allErrors) > 0 {
-		return fmt.Errorf("camera auto-rotate partial success (%d/4 caves): %v", successCount, allErrors)
-	}
-
-	return nil
+	return memory.ReadInt32(p.mem, address)
 }
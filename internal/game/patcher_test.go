@@ -0,0 +1,54 @@
+package game
+
+import (
+	"bytes"
+	"testing"
+)
+
+func caveRecord(t *testing.T, p *Patcher, name string) (refs int, address int64, exists bool) {
+	t.Helper()
+
+	for _, record := range p.caveManager.Records() {
+		if record.Name == name {
+			return record.Refs, record.Address, true
+		}
+	}
+	return 0, 0, false
+}
+
+func TestDataCaveReferences(t *testing.T) {
+	patcher, _ := newFakeGame(t)
+	fov := mustFeature(t, FeatureFOV)
+	original := readBytes(t, patcher.mem, fakeFOV+8, 4)
+
+	for _, value := range []float64{1.2, 1.5, 2} {
+		if err := patcher.ApplyFeature(fov, Params{"fov": value}); err != nil {
+			t.Fatalf("ApplyFeature(fov=%v): %v", value, err)
+		}
+	}
+	refs, address, exists := caveRecord(t, patcher, "fov")
+	if !exists || refs != 1 {
+		t.Fatalf("fov cave exists, refs = %v, %d after applying three times, want one reference", exists, refs)
+	}
+
+	if err := patcher.RevertFeature(fov); err != nil {
+		t.Fatalf("RevertFeature: %v", err)
+	}
+	if _, _, exists := caveRecord(t, patcher, "fov"); exists {
+		t.Error("fov cave still exists after revert")
+	}
+	if got := readBytes(t, patcher.mem, fakeFOV+8, 4); !bytes.Equal(got, original) {
+		t.Errorf("fov pointer after revert = % X, want % X", got, original)
+	}
+
+	// The freed cave memory is handed out again.
+	if err := patcher.ApplyFeature(fov, Params{"fov": 1.5}); err != nil {
+		t.Fatalf("ApplyFeature after revert: %v", err)
+	}
+	if refs, again, _ := caveRecord(t, patcher, "fov"); refs != 1 || again != address {
+		t.Errorf("fov cave after re-apply has %d references at 0x%X, want 1 at 0x%X", refs, again, address)
+	}
+	if status, err := fov.Status(patcher); err != nil || status != StatusActive {
+		t.Errorf("Status = %v, %v, want active", status, err)
+	}
+}
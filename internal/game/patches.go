@@ -0,0 +1,405 @@
+package game
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+const PatchDefinitionsVersion = 1
+
+//go:embed patches.yaml
+var defaultPatchDefinitions []byte
+
+// HexBytes is a byte string written as space separated hex, e.g. "90 90 EB".
+type HexBytes []byte
+
+func (h *HexBytes) UnmarshalYAML(value *yaml.Node) error {
+	var text string
+	if err := value.Decode(&text); err != nil {
+		return err
+	}
+
+	if err := h.UnmarshalText([]byte(text)); err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	return nil
+}
+
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	decoded := HexBytes{}
+	for _, part := range strings.Fields(string(text)) {
+		b, err := hex.DecodeString(part)
+		if err != nil || len(b) != 1 {
+			return fmt.Errorf("invalid hex byte %q", part)
+		}
+		decoded = append(decoded, b[0])
+	}
+
+	*h = decoded
+	return nil
+}
+
+func (h HexBytes) MarshalYAML() (any, error) {
+	return fmt.Sprintf("% X", []byte(h)), nil
+}
+
+// MarshalText encodes the bytes in the same form for JSON.
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "% X", []byte(h)), nil
+}
+
+// PatchDefinition locates and describes one patch site in the game.
+type PatchDefinition struct {
+	ID        string           `yaml:"-"`
+	Feature   string           `yaml:"feature,omitempty"`
+	Signature string           `yaml:"signature"`
+	Section   string           `yaml:"section,omitempty"`
+	Matches   int              `yaml:"matches,omitempty"`
+	Offset    int64            `yaml:"offset,omitempty"`
+	Vanilla   HexBytes         `yaml:"vanilla,omitempty"`
+	Patched   HexBytes         `yaml:"patched,omitempty"`
+	Shellcode HexBytes         `yaml:"shellcode,omitempty"`
+	Overwrite int              `yaml:"overwrite,omitempty"`
+	Params    map[string]int64 `yaml:"params,omitempty"`
+}
+
+// patchRequirement is what the code using a built-in definition relies on.
+type patchRequirement struct {
+	patched   bool
+	shellcode bool
+	params    []string
+	// optional definitions are not built in and are used when an override
+	// adds them.
+	optional bool
+}
+
+// patchRequirements lists every definition the features and stat readers
+// use, so an override that breaks one is rejected when it is loaded rather
+// than when the patch is applied.
+var patchRequirements = map[string]patchRequirement{
+	"framelock":              {},
+	"speedfix":               {},
+	"resolution_default":     {},
+	"resolution_default_720": {},
+	"resolution_scaling_fix": {patched: true},
+	"fov_setting":            {},
+	"camera_reset_lockon":    {patched: true},
+	"camera_pitch":           {shellcode: true},
+	"camera_yaw_z":           {shellcode: true},
+	"camera_pitch_xy":        {shellcode: true},
+	"camera_yaw_xy":          {shellcode: true},
+	"auto_loot":              {patched: true},
+	"dragonrot_effect":       {patched: true},
+	"death_penalty_1":        {patched: true},
+	"death_penalty_2":        {patched: true},
+	"death_penalty_3":        {patched: true},
+	"death_penalty_2_legacy": {patched: true},
+	"game_speed":             {params: []string{"instruction_length", "pointer_offset_offset"}},
+	"player_speed": {params: []string{
+		"instruction_length", "pointer2_offset", "pointer3_offset", "pointer4_offset", "pointer5_offset",
+	}},
+	"player_deaths": {params: []string{"instruction_length", "pointer_offset_offset"}},
+	"total_kills":   {params: []string{"instruction_length", "pointer1_offset", "pointer2_offset"}},
+	// The state monitor reads these through the static pointer an
+	// instruction loads, see stateValueAddress.
+	"loading_screen": {optional: true, params: []string{"instruction_length", "flag_offset"}},
+	"cutscene":       {optional: true, params: []string{"instruction_length", "flag_offset"}},
+	"player_hp":      {optional: true, params: []string{"instruction_length", "hp_offset"}},
+}
+
+func (d PatchDefinition) validate() error {
+	if err := memory.ValidatePattern(d.Signature); err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+	if d.Matches < 0 {
+		return fmt.Errorf("matches must not be negative")
+	}
+	if d.Feature != "" {
+		if _, exists := LookupFeature(d.Feature); !exists {
+			return fmt.Errorf("unknown feature %q", d.Feature)
+		}
+	}
+	if len(d.Vanilla) > 0 && len(d.Patched) > 0 && len(d.Vanilla) != len(d.Patched) {
+		return fmt.Errorf("vanilla is %d bytes but patched is %d", len(d.Vanilla), len(d.Patched))
+	}
+	if len(d.Patched) > 0 && len(d.Shellcode) > 0 {
+		return fmt.Errorf("patched and shellcode are mutually exclusive")
+	}
+	if len(d.Shellcode) > 0 && d.Overwrite < 5 {
+		return fmt.Errorf("overwrite must be at least 5 bytes for the cave jump, got %d", d.Overwrite)
+	}
+	if len(d.Shellcode) == 0 && d.Overwrite != 0 {
+		return fmt.Errorf("overwrite is only used with shellcode")
+	}
+
+	requirement := patchRequirements[d.ID]
+	if requirement.patched && len(d.Patched) == 0 {
+		return fmt.Errorf("patched bytes are required")
+	}
+	if requirement.shellcode && len(d.Shellcode) == 0 {
+		return fmt.Errorf("shellcode is required")
+	}
+	for _, param := range requirement.params {
+		if _, exists := d.Params[param]; !exists {
+			return fmt.Errorf("param %s is required", param)
+		}
+	}
+
+	return nil
+}
+
+// PatchSet is a validated collection of patch definitions.
+type PatchSet struct {
+	definitions map[string]PatchDefinition
+	sources     map[string]string
+}
+
+type patchFile struct {
+	Version int                  `yaml:"version"`
+	Patches map[string]yaml.Node `yaml:"patches"`
+}
+
+// LoadPatchSet loads the built-in definitions and merges every *.yaml file in
+// overrideDir over them in name order. Fields present in an override replace
+// the built-in ones; other fields are kept. An empty or missing overrideDir
+// yields the built-in definitions.
+func LoadPatchSet(overrideDir string) (*PatchSet, error) {
+	set := &PatchSet{
+		definitions: make(map[string]PatchDefinition),
+		sources:     make(map[string]string),
+	}
+
+	if err := set.merge("built-in", defaultPatchDefinitions); err != nil {
+		return nil, err
+	}
+
+	if overrideDir != "" {
+		paths, err := filepath.Glob(filepath.Join(overrideDir, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := set.merge(path, data); err != nil {
+				return nil, err
+			}
+			logger.Log.Info("Loaded patch overrides", zap.String("path", path))
+		}
+	}
+
+	for id, requirement := range patchRequirements {
+		if _, exists := set.definitions[id]; !exists && !requirement.optional {
+			return nil, fmt.Errorf("patch %s is not defined", id)
+		}
+	}
+
+	for _, id := range set.IDs() {
+		if err := set.definitions[id].validate(); err != nil {
+			return nil, fmt.Errorf("%s: patch %s: %v", set.sources[id], id, err)
+		}
+	}
+
+	return set, nil
+}
+
+func (ps *PatchSet) merge(source string, data []byte) error {
+	var file patchFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return fmt.Errorf("%s: %v", source, err)
+	}
+	if file.Version != PatchDefinitionsVersion {
+		return fmt.Errorf("%s: unsupported version %d", source, file.Version)
+	}
+
+	for id, node := range file.Patches {
+		definition := ps.definitions[id]
+		definition.ID = id
+
+		// Decoding into the existing definition only overwrites the fields
+		// the file sets. The params map is copied so the merge cannot leak
+		// into the definition it overrides.
+		params := definition.Params
+		definition.Params = make(map[string]int64, len(params))
+		for name, value := range params {
+			definition.Params[name] = value
+		}
+
+		// Node.Decode ignores unknown fields, so the node is decoded through
+		// a strict decoder to catch typos in overrides.
+		encoded, err := yaml.Marshal(&node)
+		if err != nil {
+			return fmt.Errorf("%s: patch %s: %v", source, id, err)
+		}
+		strict := yaml.NewDecoder(bytes.NewReader(encoded))
+		strict.KnownFields(true)
+		if err := strict.Decode(&definition); err != nil {
+			return fmt.Errorf("%s: patch %s: %v", source, id, err)
+		}
+
+		ps.definitions[id] = definition
+		ps.sources[id] = source
+	}
+
+	return nil
+}
+
+// IDs returns the IDs of every definition in sorted order.
+func (ps *PatchSet) IDs() []string {
+	ids := make([]string, 0, len(ps.definitions))
+	for id := range ps.definitions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (ps *PatchSet) Get(id string) (PatchDefinition, error) {
+	definition, exists := ps.definitions[id]
+	if !exists {
+		return PatchDefinition{}, fmt.Errorf("patch %s is not defined", id)
+	}
+	return definition, nil
+}
+
+// Source returns the file a definition was last set by.
+func (ps *PatchSet) Source(id string) string {
+	return ps.sources[id]
+}
+
+var (
+	activePatchesMu sync.Mutex
+	activePatches   *PatchSet
+)
+
+// ActivePatches returns the definitions new patchers use. It defaults to the
+// built-in definitions.
+func ActivePatches() *PatchSet {
+	activePatchesMu.Lock()
+	defer activePatchesMu.Unlock()
+
+	if activePatches == nil {
+		set, err := LoadPatchSet("")
+		if err != nil {
+			panic(fmt.Sprintf("built-in patch definitions are invalid: %v", err))
+		}
+		activePatches = set
+	}
+	return activePatches
+}
+
+// SetActivePatches replaces the definitions used by patchers created later.
+func SetActivePatches(set *PatchSet) {
+	activePatchesMu.Lock()
+	defer activePatchesMu.Unlock()
+
+	activePatches = set
+}
+
+// findPatch scans for a definition's signature and returns the definition and
+// the patch address, i.e. the match plus the definition's offset.
+func (p *Patcher) findPatch(id string) (PatchDefinition, int64, error) {
+	definition, err := p.patches.Get(id)
+	if err != nil {
+		return definition, 0, err
+	}
+
+	limit := 1
+	if definition.Matches > 0 {
+		limit = 0
+	}
+
+	scan := func(signature string) ([]int64, error) {
+		if definition.Section == "" {
+			return p.scanner.FindPatternMatches(signature, limit)
+		}
+		address, size, err := p.peParser.FindSection(definition.Section)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find %s section: %v", definition.Section, err)
+		}
+		return p.scanner.FindPatternMatchesInRegion(signature, address, size, limit)
+	}
+
+	matches, err := scan(definition.Signature)
+	if err != nil {
+		// A signature covering the vanilla bytes no longer matches once they
+		// are patched, e.g. by an earlier run that left no journal.
+		if signature, ok := patchedSignature(definition); ok {
+			if patched, patchedErr := scan(signature); patchedErr == nil {
+				matches, err = patched, nil
+			}
+		}
+	}
+	if err != nil {
+		return definition, 0, fmt.Errorf("failed to find %s pattern: %v", id, err)
+	}
+
+	if definition.Matches > 0 && len(matches) != definition.Matches {
+		return definition, 0, fmt.Errorf("%s pattern matched %d times, expected %d", id, len(matches), definition.Matches)
+	}
+
+	return definition, matches[0] + definition.Offset, nil
+}
+
+// patchedSignature returns the definition's signature with the vanilla bytes
+// it covers replaced by the patched ones. ok is false if the signature does
+// not cover them.
+func patchedSignature(definition PatchDefinition) (signature string, ok bool) {
+	fields := strings.Fields(definition.Signature)
+	if len(definition.Vanilla) == 0 || len(definition.Vanilla) != len(definition.Patched) ||
+		definition.Offset < 0 || definition.Offset+int64(len(definition.Patched)) > int64(len(fields)) {
+		return "", false
+	}
+
+	for i, b := range definition.Patched {
+		if field := &fields[definition.Offset+int64(i)]; *field != "??" {
+			*field = fmt.Sprintf("%02X", b)
+			ok = true
+		}
+	}
+	return strings.Join(fields, " "), ok
+}
+
+// writeDefinition writes a definition's patched bytes at address after
+// checking that the game still has the expected vanilla bytes there.
+func (p *Patcher) writeDefinition(feature string, definition PatchDefinition, address int64) error {
+	if len(definition.Vanilla) > 0 {
+		current, err := p.mem.ReadMemory(address, len(definition.Vanilla))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, definition.Vanilla) && !bytes.Equal(current, definition.Patched) {
+			return fmt.Errorf("%s: unexpected bytes % X at 0x%X, expected % X", definition.ID, current, address, []byte(definition.Vanilla))
+		}
+	}
+
+	return p.writePatch(feature, address, definition.Patched)
+}
+
+// applyBytePatch finds a definition and writes its patched bytes.
+func (p *Patcher) applyBytePatch(feature, id string) error {
+	definition, address, err := p.findPatch(id)
+	if err != nil {
+		return err
+	}
+	return p.writeDefinition(feature, definition, address)
+}
@@ -0,0 +1,200 @@
+package game
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeOverrides writes each file into a new patches.d directory.
+func writeOverrides(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadPatchSetBuiltIn(t *testing.T) {
+	set, err := LoadPatchSet("")
+	if err != nil {
+		t.Fatalf("LoadPatchSet: %v", err)
+	}
+	for id, requirement := range patchRequirements {
+		if requirement.optional {
+			if _, err := set.Get(id); err == nil {
+				t.Errorf("optional %s is built in", id)
+			}
+			continue
+		}
+		if _, err := set.Get(id); err != nil {
+			t.Errorf("Get(%s): %v", id, err)
+		}
+		if source := set.Source(id); source != "built-in" {
+			t.Errorf("Source(%s) = %q, want built-in", id, source)
+		}
+	}
+	if _, err := set.Get("no_such_patch"); err == nil {
+		t.Error("Get of an undefined patch succeeded")
+	}
+}
+
+func TestLoadPatchSetOverride(t *testing.T) {
+	dir := writeOverrides(t, map[string]string{
+		"10-auto-loot.yaml": `version: 1
+patches:
+  auto_loot:
+    signature: "C6 85 ?? ?? ?? ?? ?? B0 01 EB ?? C6 85 ?? ?? ?? ?? ?? 32 C0 90"
+    matches: 1
+  game_speed:
+    params:
+      pointer_offset_offset: 12
+`,
+		"20-later.yaml": `version: 1
+patches:
+  auto_loot:
+    offset: 19
+  custom_flag:
+    feature: auto_loot
+    signature: "48 8B 05 ?? ?? ?? ??"
+`,
+		"notes.txt": "not a definition file",
+	})
+
+	set, err := LoadPatchSet(dir)
+	if err != nil {
+		t.Fatalf("LoadPatchSet: %v", err)
+	}
+
+	autoLoot, _ := set.Get("auto_loot")
+	if !strings.HasSuffix(autoLoot.Signature, "32 C0 90") || autoLoot.Matches != 1 {
+		t.Errorf("auto_loot signature, matches = %q, %d, want the first override's", autoLoot.Signature, autoLoot.Matches)
+	}
+	// Files are merged in name order, so the later file's offset wins.
+	if autoLoot.Offset != 19 {
+		t.Errorf("auto_loot offset = %d, want 19", autoLoot.Offset)
+	}
+	// Fields no override sets are kept.
+	if !bytes.Equal(autoLoot.Patched, []byte{0xB0, 0x01}) || !bytes.Equal(autoLoot.Vanilla, []byte{0x32, 0xC0}) {
+		t.Errorf("auto_loot bytes = % X -> % X, want the built-in ones", autoLoot.Vanilla, autoLoot.Patched)
+	}
+	if source := set.Source("auto_loot"); filepath.Base(source) != "20-later.yaml" {
+		t.Errorf("Source(auto_loot) = %q, want the later file", source)
+	}
+
+	gameSpeed, _ := set.Get("game_speed")
+	if gameSpeed.Params["pointer_offset_offset"] != 12 || gameSpeed.Params["instruction_length"] != 7 {
+		t.Errorf("game_speed params = %v, want the override merged into the built-in ones", gameSpeed.Params)
+	}
+	if builtIn, _ := ActivePatches().Get("game_speed"); builtIn.Params["pointer_offset_offset"] == 12 {
+		t.Error("override changed the built-in params")
+	}
+
+	if _, err := set.Get("custom_flag"); err != nil {
+		t.Errorf("new definition: %v", err)
+	}
+}
+
+func TestLoadPatchSetInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown field", `version: 1
+patches:
+  auto_loot:
+    signatrue: "C6 85"
+`, "signatrue"},
+		{"unknown top-level field", `version: 1
+overrides: {}
+`, "overrides"},
+		{"duplicate definition", `version: 1
+patches:
+  auto_loot:
+    offset: 18
+  auto_loot:
+    offset: 19
+`, "already defined"},
+		{"duplicate field", `version: 1
+patches:
+  auto_loot:
+    offset: 18
+    offset: 19
+`, "already defined"},
+		{"malformed signature", `version: 1
+patches:
+  auto_loot:
+    signature: "C6 8G ?? B0"
+`, "signature: invalid pattern byte \"8G\""},
+		{"empty signature", `version: 1
+patches:
+  auto_loot:
+    signature: ""
+`, "signature: empty pattern"},
+		{"malformed bytes", `version: 1
+patches:
+  auto_loot:
+    patched: "B0 1"
+`, "invalid hex byte \"1\""},
+		{"conflicting bytes", `version: 1
+patches:
+  auto_loot:
+    patched: "B0 01 90"
+`, "vanilla is 2 bytes but patched is 3"},
+		{"patched and shellcode", `version: 1
+patches:
+  auto_loot:
+    shellcode: "90 90 90 90 90"
+    overwrite: 5
+`, "mutually exclusive"},
+		{"short overwrite", `version: 1
+patches:
+  camera_pitch:
+    overwrite: 4
+`, "at least 5 bytes"},
+		{"required bytes removed", `version: 1
+patches:
+  auto_loot:
+    patched: ""
+    vanilla: ""
+`, "patched bytes are required"},
+		{"optional definition without its params", `version: 1
+patches:
+  loading_screen:
+    signature: "48 8B 05 ?? ?? ?? ??"
+    params:
+      instruction_length: 7
+`, "param flag_offset is required"},
+		{"unknown feature", `version: 1
+patches:
+  auto_loot:
+    feature: auto_lot
+`, "unknown feature \"auto_lot\""},
+		{"unsupported version", `version: 2
+patches: {}
+`, "unsupported version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeOverrides(t, map[string]string{"override.yaml": tt.content})
+
+			_, err := LoadPatchSet(dir)
+			if err == nil {
+				t.Fatal("LoadPatchSet succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), "override.yaml") {
+				t.Errorf("error = %v, want it to name the file", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package game
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// Candidate is a process that may be the game. Under Proton the processes
+// matching the game's name include wrappers such as wine-preloader, and
+// another prefix may run a second instance.
+type Candidate struct {
+	PID  int    `json:"pid"`
+	Comm string `json:"comm"`
+	// StartedAt is zero if the start time could not be read.
+	StartedAt  time.Time `json:"started_at"`
+	WinePrefix string    `json:"wine_prefix,omitempty"`
+	SteamAppID string    `json:"steam_app_id,omitempty"`
+	// ValidPE reports whether the game module is mapped and starts with a
+	// valid PE image, which wrappers do not have.
+	ValidPE bool `json:"valid_pe"`
+	// Problem explains why ValidPE is false.
+	Problem string `json:"problem,omitempty"`
+}
+
+func (c Candidate) String() string {
+	var details []string
+	if !c.StartedAt.IsZero() {
+		details = append(details, "started "+c.StartedAt.Format("15:04:05"))
+	}
+	if c.WinePrefix != "" {
+		details = append(details, "prefix "+c.WinePrefix)
+	}
+	if c.SteamAppID != "" {
+		details = append(details, "app "+c.SteamAppID)
+	}
+	if c.Problem != "" {
+		details = append(details, c.Problem)
+	}
+	return fmt.Sprintf("%d %s (%s)", c.PID, c.Comm, strings.Join(details, ", "))
+}
+
+// rank orders candidates; higher is more likely to be the game.
+func (c Candidate) rank() int {
+	rank := 0
+	if c.ValidPE {
+		rank += 4
+	}
+	if strings.EqualFold(c.Comm, ProcessName+".exe") || strings.EqualFold(c.Comm, ProcessName) {
+		rank += 2
+	}
+	if c.SteamAppID == SteamAppID {
+		rank++
+	}
+	return rank
+}
+
+// FindCandidates returns the processes that may be the game, most likely
+// first. Candidates that rank the same are ordered by start time.
+func FindCandidates() ([]Candidate, error) {
+	pids, err := memory.FindProcessByName(ProcessName)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]Candidate, 0, len(pids))
+	for _, pid := range pids {
+		candidates = append(candidates, inspectCandidate(pid))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if ri, rj := candidates[i].rank(), candidates[j].rank(); ri != rj {
+			return ri > rj
+		}
+		return candidates[i].StartedAt.Before(candidates[j].StartedAt)
+	})
+	return candidates, nil
+}
+
+func inspectCandidate(pid int) Candidate {
+	candidate := Candidate{PID: pid}
+	candidate.Comm, _ = memory.ProcessComm(pid)
+	candidate.StartedAt, _ = memory.ProcessStartedAt(pid)
+
+	if environ, err := memory.ProcessEnviron(pid); err == nil {
+		candidate.WinePrefix = environ["WINEPREFIX"]
+		if candidate.WinePrefix == "" && environ["STEAM_COMPAT_DATA_PATH"] != "" {
+			candidate.WinePrefix = filepath.Join(environ["STEAM_COMPAT_DATA_PATH"], "pfx")
+		}
+		for _, key := range []string{"SteamAppId", "SteamGameId", "STEAM_COMPAT_APP_ID"} {
+			if environ[key] != "" {
+				candidate.SteamAppID = environ[key]
+				break
+			}
+		}
+	}
+
+	mem := memory.NewProcessMemory(pid)
+	base, err := memory.FindModuleBaseAddress(mem, ProcessName)
+	if err != nil {
+		candidate.Problem = "game module not mapped"
+		return candidate
+	}
+	if _, err := memory.NewPEParser(mem, base).Header(); err != nil {
+		candidate.Problem = fmt.Sprintf("no PE image at module base: %v", err)
+		return candidate
+	}
+	candidate.ValidPE = true
+	return candidate
+}
+
+// Ambiguous reports whether the first candidates rank the same, so the game
+// cannot be told apart from another process without asking the user.
+func Ambiguous(candidates []Candidate) bool {
+	return len(candidates) > 1 && candidates[0].rank() == candidates[1].rank()
+}
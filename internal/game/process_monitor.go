@@ -0,0 +1,237 @@
+package game
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// DefaultProcessInterval is how often the process monitor scans /proc when
+// process events are unavailable, and how often it retries a candidate that
+// has not been attached to yet, e.g. because its module is not mapped.
+const DefaultProcessInterval = 2 * time.Second
+
+// processEventDelay lets a burst of events settle, such as Wine executing its
+// preloader and then renaming the process, before /proc is scanned.
+const processEventDelay = 100 * time.Millisecond
+
+// ProcessMonitor finds the processes that may be the game and reports when
+// the one attached to exits.
+//
+// It subscribes to the kernel's process events when allowed and only scans
+// /proc when an event could have changed the candidates. Otherwise it scans
+// every interval. The exit of the attached process is noticed through a
+// pidfd, so detaching is immediate either way.
+type ProcessMonitor struct {
+	interval time.Duration
+
+	candidates chan []Candidate
+	exits      chan int
+
+	// tracked is the process Track asked to watch, picked up by run when
+	// wake is signalled.
+	trackMu sync.Mutex
+	tracked trackRequest
+	wake    chan struct{}
+	rescan  chan struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type trackRequest struct {
+	pid       int
+	startTime uint64
+}
+
+func NewProcessMonitor(interval time.Duration) *ProcessMonitor {
+	return &ProcessMonitor{
+		interval:   interval,
+		candidates: make(chan []Candidate, 1),
+		exits:      make(chan int, 1),
+		wake:       make(chan struct{}, 1),
+		rescan:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Candidates returns the processes that may be the game after each scan,
+// most likely first. Only the latest scan is kept if nobody is reading, and
+// the channel is closed once the monitor stops.
+func (m *ProcessMonitor) Candidates() <-chan []Candidate {
+	return m.candidates
+}
+
+// Exits returns the pid of the tracked process once it exits. The channel is
+// closed once the monitor stops.
+func (m *ProcessMonitor) Exits() <-chan int {
+	return m.exits
+}
+
+// Track watches the process attached to, identified by its pid and start
+// time, for its exit. A pid of 0 stops watching. Track never blocks.
+func (m *ProcessMonitor) Track(pid int, startTime uint64) {
+	m.trackMu.Lock()
+	m.tracked = trackRequest{pid: pid, startTime: startTime}
+	m.trackMu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Rescan asks for the candidates to be scanned again soon, e.g. after the
+// user picked another process.
+func (m *ProcessMonitor) Rescan() {
+	select {
+	case m.rescan <- struct{}{}:
+	default:
+	}
+}
+
+func (m *ProcessMonitor) Start() {
+	go m.run()
+}
+
+func (m *ProcessMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *ProcessMonitor) run() {
+	defer close(m.candidates)
+	defer close(m.exits)
+
+	var events <-chan memory.ProcEvent
+	if connector, err := memory.OpenProcConnector(); err != nil {
+		logger.Log.Debug("Process events unavailable, scanning /proc instead", zap.Error(err))
+	} else {
+		defer func() { _ = connector.Close() }()
+		events = connector.Events()
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	var (
+		last     []Candidate
+		tracked  trackRequest
+		watcher  *memory.ExitWatcher
+		exited   <-chan struct{}
+		debounce <-chan time.Time
+	)
+	defer func() {
+		if watcher != nil {
+			watcher.Close()
+		}
+	}()
+
+	scan := func() {
+		candidates, err := FindCandidates()
+		if err != nil {
+			logger.Log.Debug("Failed to scan processes", zap.Error(err))
+			return
+		}
+		last = candidates
+
+		// Only this goroutine sends, so the stale scan can be replaced.
+		select {
+		case <-m.candidates:
+		default:
+		}
+		m.candidates <- candidates
+	}
+	scan()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				logger.Log.Warn("Process events stopped, scanning /proc instead")
+				events = nil
+				continue
+			}
+			if debounce == nil && relevantProcEvent(event, last) {
+				debounce = time.After(processEventDelay)
+			}
+
+		case <-debounce:
+			debounce = nil
+			scan()
+
+		case <-m.rescan:
+			scan()
+
+		case <-ticker.C:
+			if events == nil || (tracked.pid == 0 && len(last) > 0) {
+				scan()
+			}
+
+		case <-m.wake:
+			m.trackMu.Lock()
+			request := m.tracked
+			m.trackMu.Unlock()
+			if request == tracked {
+				continue
+			}
+
+			if watcher != nil {
+				watcher.Close()
+				watcher, exited = nil, nil
+			}
+			tracked = request
+			if tracked.pid == 0 {
+				continue
+			}
+
+			w, err := memory.WatchExit(tracked.pid, tracked.startTime)
+			if err != nil {
+				logger.Log.Warn("Cannot watch game process for exit", zap.Int("pid", tracked.pid), zap.Error(err))
+				continue
+			}
+			watcher, exited = w, w.Done()
+
+		case <-exited:
+			watcher.Close()
+			pid := tracked.pid
+			watcher, exited, tracked = nil, nil, trackRequest{}
+			logger.Log.Debug("Game process exited", zap.Int("pid", pid))
+
+			select {
+			case m.exits <- pid:
+			case <-m.stop:
+				return
+			}
+			scan()
+		}
+	}
+}
+
+// relevantProcEvent reports whether an event may have changed the
+// candidates. Forks are not, since Wine starts the game through an exec and
+// renames it afterwards.
+func relevantProcEvent(event memory.ProcEvent, candidates []Candidate) bool {
+	switch event.Kind {
+	case memory.ProcEventLost:
+		return true
+	case memory.ProcEventExec, memory.ProcEventComm:
+		return event.PID == event.TID && memory.ProcessMatches(event.PID, ProcessName)
+	case memory.ProcEventExit:
+		if event.PID != event.TID {
+			return false
+		}
+		for _, candidate := range candidates {
+			if candidate.PID == event.PID {
+				return true
+			}
+		}
+	}
+	return false
+}
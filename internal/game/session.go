@@ -0,0 +1,238 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNotAttached is returned by session commands while no game is attached.
+var ErrNotAttached = fmt.Errorf("%s is not running", ProcessName)
+
+// ErrSessionClosed is returned by commands sent after Close.
+var ErrSessionClosed = errors.New("session closed")
+
+// FeatureSetting is a snapshot of what the user asked for one feature, taken
+// wherever the setting is edited and handed to the session.
+type FeatureSetting struct {
+	Feature Feature
+	Enabled bool
+	Params  Params
+}
+
+// Session owns the patcher of the attached game. Its commands run one at a
+// time on the session's goroutine, so the UI, the control API, the watchdog
+// and the monitors never use the patcher at once, and nobody holds on to a
+// patcher after the game is detached.
+type Session struct {
+	commands chan func()
+	stop     chan struct{}
+	done     chan struct{}
+
+	closeOnce sync.Once
+
+	// patcher is only used on the session's goroutine.
+	patcher *Patcher
+	// attached and pid mirror the patcher, for callers that only need to
+	// know which game is attached.
+	attached atomic.Bool
+	pid      atomic.Int64
+	// hooked mirrors whether the patcher has an event ring, i.e. a hook was
+	// installed, so the event monitor only sends commands once there can be
+	// events to drain.
+	hooked atomic.Bool
+}
+
+func NewSession() *Session {
+	s := &Session{
+		commands: make(chan func()),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Session) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case command := <-s.commands:
+			command()
+		}
+	}
+}
+
+// call runs fn on the session's goroutine and waits for it to return.
+func (s *Session) call(fn func()) error {
+	finished := make(chan struct{})
+	select {
+	case s.commands <- func() {
+		defer close(finished)
+		fn()
+	}:
+	case <-s.done:
+		return ErrSessionClosed
+	}
+	<-finished
+	return nil
+}
+
+// release closes the attached patcher. exited marks the game as gone first,
+// so no cave is restored into a pid that may belong to another process.
+func (s *Session) release(exited bool) error {
+	if s.patcher == nil {
+		return nil
+	}
+
+	patcher := s.patcher
+	s.patcher = nil
+	s.attached.Store(false)
+	s.pid.Store(0)
+	s.hooked.Store(false)
+
+	if exited {
+		patcher.Exited()
+	}
+	return patcher.Close()
+}
+
+// Attach hands a patcher to the session, which closes it on Detach. A patcher
+// that was attached before is closed.
+func (s *Session) Attach(patcher *Patcher) error {
+	var err error
+	if callErr := s.call(func() {
+		if s.patcher != patcher {
+			err = s.release(false)
+		}
+		s.patcher = patcher
+		s.attached.Store(true)
+		s.pid.Store(int64(patcher.PID()))
+		s.hooked.Store(patcher.hasEvents())
+	}); callErr != nil {
+		return callErr
+	}
+	return err
+}
+
+// Detach closes the attached patcher, if any. exited tells the session the
+// game exited, so nothing is written to it any more.
+func (s *Session) Detach(exited bool) error {
+	var err error
+	if callErr := s.call(func() { err = s.release(exited) }); callErr != nil {
+		return callErr
+	}
+	return err
+}
+
+// PID returns the pid of the attached game, or 0 if none is attached or the
+// patcher is not on a live process.
+func (s *Session) PID() int {
+	return int(s.pid.Load())
+}
+
+// Attached reports whether a game is attached.
+func (s *Session) Attached() bool {
+	return s.attached.Load()
+}
+
+// Hooked reports whether a hook was installed in the attached game.
+func (s *Session) Hooked() bool {
+	return s.hooked.Load()
+}
+
+// Do runs fn with the attached patcher on the session's goroutine. fn must
+// not keep the patcher or call back into the session.
+func (s *Session) Do(fn func(*Patcher) error) error {
+	var err error
+	if callErr := s.call(func() {
+		if s.patcher == nil {
+			err = ErrNotAttached
+			return
+		}
+		err = fn(s.patcher)
+		s.hooked.Store(s.patcher.hasEvents())
+	}); callErr != nil {
+		return callErr
+	}
+	return err
+}
+
+// Apply applies an enabled feature and reverts a disabled one that is still
+// applied.
+func (s *Session) Apply(setting FeatureSetting) error {
+	return s.Do(func(p *Patcher) error {
+		if setting.Enabled {
+			return p.ApplyFeature(setting.Feature, setting.Params)
+		}
+		return p.RevertFeature(setting.Feature)
+	})
+}
+
+// ApplyAll applies settings in order, as one command, and returns the error
+// of each.
+func (s *Session) ApplyAll(settings []FeatureSetting) ([]error, error) {
+	errs := make([]error, len(settings))
+	err := s.Do(func(p *Patcher) error {
+		for i, setting := range settings {
+			if setting.Enabled {
+				errs[i] = p.ApplyFeature(setting.Feature, setting.Params)
+			} else {
+				errs[i] = p.RevertFeature(setting.Feature)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// Update is Apply, except that new parameters of an applied feature are
+// written in place where the feature supports it.
+func (s *Session) Update(setting FeatureSetting) error {
+	return s.Do(func(p *Patcher) error {
+		if setting.Enabled {
+			return p.UpdateFeature(setting.Feature, setting.Params)
+		}
+		return p.RevertFeature(setting.Feature)
+	})
+}
+
+// Revert reverts a feature.
+func (s *Session) Revert(feature Feature) error {
+	return s.Do(func(p *Patcher) error { return p.RevertFeature(feature) })
+}
+
+// Status returns a feature's status in the game.
+func (s *Session) Status(feature Feature) (FeatureStatus, error) {
+	var status FeatureStatus
+	err := s.Do(func(p *Patcher) error {
+		var err error
+		status, err = feature.Status(p)
+		return err
+	})
+	return status, err
+}
+
+// ReadStats reads the stats of the attached game.
+func (s *Session) ReadStats() (Stats, error) {
+	var stats Stats
+	err := s.Do(func(p *Patcher) error {
+		stats = p.ReadStats()
+		return nil
+	})
+	return stats, err
+}
+
+// Close stops the session. The attached patcher is not closed, so features
+// stay applied after the tweaker exits, as the journal expects.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
@@ -0,0 +1,268 @@
+package game
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	autoLootVanilla = []byte{0x32, 0xC0}
+	autoLootPatched = []byte{0xB0, 0x01}
+)
+
+func newFakeSession(t *testing.T) (*Session, *Patcher) {
+	t.Helper()
+
+	patcher, _ := newFakeGame(t)
+	session := NewSession()
+	t.Cleanup(session.Close)
+	if err := session.Attach(patcher); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	return session, patcher
+}
+
+func TestSessionNotAttached(t *testing.T) {
+	session := NewSession()
+	defer session.Close()
+
+	if session.Attached() {
+		t.Error("Attached() = true before Attach")
+	}
+	if err := session.Apply(FeatureSetting{Feature: mustFeature(t, FeatureAutoLoot), Enabled: true}); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("Apply = %v, want ErrNotAttached", err)
+	}
+	if _, err := session.ReadStats(); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("ReadStats = %v, want ErrNotAttached", err)
+	}
+	if err := session.Detach(false); err != nil {
+		t.Errorf("Detach without a game = %v", err)
+	}
+}
+
+func TestSessionApplyRevert(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	autoLoot := mustFeature(t, FeatureAutoLoot)
+	gameSpeed := mustFeature(t, FeatureGameSpeed)
+
+	if err := session.Apply(FeatureSetting{Feature: autoLoot, Enabled: true}); err != nil {
+		t.Fatalf("Apply auto_loot: %v", err)
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootPatched) {
+		t.Errorf("auto_loot bytes = % X, want % X", got, autoLootPatched)
+	}
+	if status, err := session.Status(autoLoot); err != nil || status != StatusActive {
+		t.Errorf("Status(auto_loot) = %v, %v, want active", status, err)
+	}
+
+	if err := session.Apply(FeatureSetting{Feature: gameSpeed, Enabled: true, Params: Params{"speed": 2}}); err != nil {
+		t.Fatalf("Apply game_speed: %v", err)
+	}
+	stats, err := session.ReadStats()
+	if err != nil {
+		t.Fatalf("ReadStats: %v", err)
+	}
+	if stats.GameSpeed == nil || *stats.GameSpeed != 2 {
+		t.Errorf("game speed = %v, want 2", stats.GameSpeed)
+	}
+	if stats.Deaths == nil || *stats.Deaths != 3 || stats.Kills == nil || *stats.Kills != 42 {
+		t.Errorf("deaths, kills = %v, %v, want 3, 42", stats.Deaths, stats.Kills)
+	}
+
+	if err := session.Apply(FeatureSetting{Feature: autoLoot, Enabled: false}); err != nil {
+		t.Fatalf("Apply disabled auto_loot: %v", err)
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootVanilla) {
+		t.Errorf("auto_loot bytes after revert = % X, want % X", got, autoLootVanilla)
+	}
+	if err := session.Revert(gameSpeed); err != nil {
+		t.Fatalf("Revert game_speed: %v", err)
+	}
+	if stats, _ := session.ReadStats(); stats.GameSpeed == nil || *stats.GameSpeed != 1 {
+		t.Errorf("game speed after revert = %v, want 1", stats.GameSpeed)
+	}
+}
+
+func TestSessionAttachDetach(t *testing.T) {
+	session, first := newFakeSession(t)
+	fov := mustFeature(t, FeatureFOV)
+
+	original := readBytes(t, first.mem, fakeFOV+8, 4)
+	if err := session.Apply(FeatureSetting{Feature: fov, Enabled: true, Params: Params{"fov": 1.5}}); err != nil {
+		t.Fatalf("Apply fov: %v", err)
+	}
+	if got := readBytes(t, first.mem, fakeFOV+8, 4); bytes.Equal(got, original) {
+		t.Fatal("fov cave did not redirect the instruction")
+	}
+
+	// Attaching the same patcher again keeps it.
+	if err := session.Attach(first); err != nil {
+		t.Fatalf("Attach same patcher: %v", err)
+	}
+	if got := readBytes(t, first.mem, fakeFOV+8, 4); bytes.Equal(got, original) {
+		t.Error("attaching the same patcher again closed it")
+	}
+
+	// Attaching another closes the first, which restores its caves.
+	second, _ := newFakeGame(t)
+	if err := session.Attach(second); err != nil {
+		t.Fatalf("Attach second patcher: %v", err)
+	}
+	if got := readBytes(t, first.mem, fakeFOV+8, 4); !bytes.Equal(got, original) {
+		t.Errorf("first patcher's cave pointer = % X after attaching another, want % X", got, original)
+	}
+
+	if err := session.Apply(FeatureSetting{Feature: fov, Enabled: true, Params: Params{"fov": 1.5}}); err != nil {
+		t.Fatalf("Apply fov on second: %v", err)
+	}
+	if err := session.Detach(true); err != nil {
+		t.Fatalf("Detach: %v", err)
+	}
+	if session.Attached() || session.PID() != 0 {
+		t.Errorf("Attached, PID = %v, %d after Detach", session.Attached(), session.PID())
+	}
+	if err := session.Do(func(*Patcher) error { return nil }); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("Do after Detach = %v, want ErrNotAttached", err)
+	}
+}
+
+func TestSessionClosed(t *testing.T) {
+	patcher, _ := newFakeGame(t)
+	session := NewSession()
+	if err := session.Attach(patcher); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	if err := session.Apply(FeatureSetting{Feature: mustFeature(t, FeatureAutoLoot), Enabled: true}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	session.Close()
+	session.Close()
+
+	if err := session.Apply(FeatureSetting{Feature: mustFeature(t, FeatureAutoLoot)}); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Apply after Close = %v, want ErrSessionClosed", err)
+	}
+	if err := session.Attach(patcher); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Attach after Close = %v, want ErrSessionClosed", err)
+	}
+	// Features stay applied when the tweaker exits.
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootPatched) {
+		t.Errorf("auto_loot bytes after Close = % X, want % X", got, autoLootPatched)
+	}
+}
+
+func TestSessionWatchdogHeals(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	autoLoot := mustFeature(t, FeatureAutoLoot)
+
+	if err := session.Apply(FeatureSetting{Feature: autoLoot, Enabled: true}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	watchdog := NewWatchdog(session, time.Hour)
+	watchdog.SetAutoHeal(true)
+
+	// The game puts its own bytes back.
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, autoLootVanilla)
+	watchdog.check()
+
+	select {
+	case event := <-watchdog.Events():
+		if event.Feature != FeatureAutoLoot || !event.Healed || event.Err != nil {
+			t.Errorf("drift event = %+v, want healed auto_loot", event)
+		}
+	default:
+		t.Fatal("no drift event")
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootPatched) {
+		t.Errorf("auto_loot bytes after heal = % X, want % X", got, autoLootPatched)
+	}
+}
+
+// TestSessionConcurrent runs commands from several goroutines while the
+// watchdog and monitors tick, as the UI, the API and the timers do. Run it
+// with -race.
+func TestSessionConcurrent(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	autoLoot := mustFeature(t, FeatureAutoLoot)
+	gameSpeed := mustFeature(t, FeatureGameSpeed)
+
+	watchdog := NewWatchdog(session, time.Millisecond)
+	watchdog.SetAutoHeal(true)
+	watchdog.Start()
+	events := NewEventMonitor(session, time.Millisecond)
+	events.Start()
+	status := NewStatusMonitor(session, time.Millisecond)
+	status.Start()
+	states := NewStateMonitor(session, time.Millisecond)
+	states.Start()
+
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				fn(i)
+			}
+		}()
+	}
+	errs := make(chan error, 1000)
+	run(func(i int) {
+		errs <- session.Apply(FeatureSetting{Feature: autoLoot, Enabled: i%2 == 0})
+	})
+	run(func(i int) {
+		errs <- session.Update(FeatureSetting{Feature: gameSpeed, Enabled: true, Params: Params{"speed": 1 + float64(i%4)/2}})
+	})
+	run(func(int) {
+		_, err := session.ReadStats()
+		errs <- err
+	})
+	run(func(int) {
+		_, err := session.Status(autoLoot)
+		errs <- err
+	})
+	run(func(int) {
+		_ = session.PID()
+		_ = session.Attached()
+	})
+	wg.Wait()
+
+	// The last command leaves auto loot reverted. A watchdog check that read
+	// the patches before the revert must not write them back afterwards.
+	if err := session.Revert(autoLoot); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	for range 5 {
+		watchdog.check()
+	}
+
+	watchdog.Stop()
+	events.Stop()
+	status.Stop()
+	states.Stop()
+	for range watchdog.Events() {
+	}
+	for range status.Updates() {
+	}
+	for range states.Changes() {
+	}
+	for range events.Events() {
+	}
+
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("command failed: %v", err)
+		}
+	}
+	if got := readBytes(t, patcher.mem, fakeAutoLoot+18, 2); !bytes.Equal(got, autoLootVanilla) {
+		t.Errorf("auto_loot bytes after revert = % X, want % X", got, autoLootVanilla)
+	}
+	if got, err := session.Status(autoLoot); err != nil || got != StatusInactive {
+		t.Errorf("Status(auto_loot) = %v, %v, want inactive", got, err)
+	}
+}
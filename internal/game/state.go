@@ -0,0 +1,347 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+	"github.com/amadejkastelic/sekiro-tweaker/internal/memory"
+)
+
+// DefaultStateInterval is how often the state monitor reads the game's state.
+// A reading only follows a few pointers, so it can run often enough to catch
+// short loading screens.
+const DefaultStateInterval = 250 * time.Millisecond
+
+// stateProbeRetry is how often the state monitor scans for its signatures
+// again after they were not found.
+const stateProbeRetry = 10 * time.Second
+
+// GameState is what the game is doing, as far as its memory tells.
+type GameState int
+
+const (
+	// StateUnknown means the state cannot be read, e.g. because a signature
+	// it is read through does not match this version of the game.
+	StateUnknown GameState = iota
+	// StateMenu means no save is loaded, e.g. at the title screen.
+	StateMenu
+	// StateLoading means a save is loaded but the player is not in the world,
+	// or the loading_screen definition reports a loading screen.
+	StateLoading
+	StateInWorld
+	// StateDead means the player_hp definition reads no health left. Without
+	// it, it means the death counter went up and the world has not been
+	// reloaded since, which also covers a resurrection.
+	StateDead
+	// StateCutscene is only reported when a cutscene definition is added in
+	// patches.d, since none is built in.
+	StateCutscene
+)
+
+func (s GameState) String() string {
+	switch s {
+	case StateUnknown:
+		return "unknown"
+	case StateMenu:
+		return "menu"
+	case StateLoading:
+		return "loading"
+	case StateInWorld:
+		return "in_world"
+	case StateDead:
+		return "dead"
+	case StateCutscene:
+		return "cutscene"
+	default:
+		return fmt.Sprintf("state_%d", int(s))
+	}
+}
+
+func (s GameState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// InWorld reports whether the player is loaded, so features that require
+// being in game can be applied.
+func (s GameState) InWorld() bool {
+	return s == StateInWorld || s == StateDead || s == StateCutscene
+}
+
+// StateChange is published by the state monitor when the state changes.
+type StateChange struct {
+	From GameState
+	To   GameState
+	Time time.Time
+}
+
+// stateReading is one reading of the values the state is derived from.
+type stateReading struct {
+	saveLoaded   bool
+	playerLoaded bool
+	deaths       int32
+	// The fields below are read through optional definitions, and are false
+	// when they are not defined or cannot be read.
+	loading  bool
+	cutscene bool
+	hpRead   bool
+	hp       int32
+}
+
+// nextState derives the state from a reading, the reading before it and the
+// current state.
+func nextState(current GameState, previous, reading stateReading) GameState {
+	switch {
+	case reading.loading:
+		return StateLoading
+	case !reading.playerLoaded && !reading.saveLoaded:
+		return StateMenu
+	case !reading.playerLoaded:
+		return StateLoading
+	case reading.hpRead:
+		// Health tells death from resurrection, so the counter is not used.
+		if reading.hp <= 0 {
+			return StateDead
+		}
+	case previous.playerLoaded && previous.saveLoaded && reading.saveLoaded && reading.deaths > previous.deaths:
+		return StateDead
+	case current == StateDead && reading.saveLoaded && reading.deaths == previous.deaths:
+		// The player stays loaded on the death screen, until the world is
+		// reloaded at the last idol. Without player_hp a resurrection looks
+		// the same, so it is reported as dead until the next loading screen.
+		return StateDead
+	}
+	if reading.cutscene {
+		return StateCutscene
+	}
+	return StateInWorld
+}
+
+// stateProbe is an instruction found for a definition the state is read
+// through.
+type stateProbe struct {
+	definition PatchDefinition
+	address    int64
+}
+
+// stateProbes are found once per monitor. They point into code, which does
+// not move, so later readings only follow pointers instead of scanning.
+type stateProbes struct {
+	player stateProbe
+	deaths stateProbe
+	// loading, cutscene and hp are nil unless an override defines them.
+	loading  *stateProbe
+	cutscene *stateProbe
+	hp       *stateProbe
+}
+
+func (p *Patcher) findStateProbes() (*stateProbes, error) {
+	probes := &stateProbes{}
+
+	var err error
+	if probes.player.definition, probes.player.address, err = p.findPatch("player_speed"); err != nil {
+		return nil, err
+	}
+	if probes.deaths.definition, probes.deaths.address, err = p.findPatch("player_deaths"); err != nil {
+		return nil, err
+	}
+	probes.loading = p.findOptionalProbe("loading_screen")
+	probes.cutscene = p.findOptionalProbe("cutscene")
+	probes.hp = p.findOptionalProbe("player_hp")
+	return probes, nil
+}
+
+func (p *Patcher) findOptionalProbe(id string) *stateProbe {
+	if _, err := p.patches.Get(id); err != nil {
+		return nil
+	}
+	definition, address, err := p.findPatch(id)
+	if err != nil {
+		logger.Log.Warn("State definition not found, ignoring it", zap.String("patch", id), zap.Error(err))
+		return nil
+	}
+	return &stateProbe{definition: definition, address: address}
+}
+
+// stateValueAddress resolves the value an optional definition points at: the
+// static pointer its instruction loads, followed through pointer1_offset,
+// pointer2_offset and so on for as many as are defined, plus the offset in
+// the param named value.
+func (p *Patcher) stateValueAddress(probe *stateProbe, value string) (int64, error) {
+	params := probe.definition.Params
+	address, err := memory.DereferenceStaticPointer(p.mem, probe.address, int(params["instruction_length"]))
+	if err != nil {
+		return 0, err
+	}
+	for i := 1; ; i++ {
+		if address == 0 {
+			return 0, fmt.Errorf("%s: null pointer %d", probe.definition.ID, i)
+		}
+		offset, exists := params["pointer"+strconv.Itoa(i)+"_offset"]
+		if !exists {
+			break
+		}
+		if address, err = memory.ReadInt64(p.mem, address+offset); err != nil {
+			return 0, err
+		}
+	}
+	return address + params[value], nil
+}
+
+// readFlag reports whether the byte at flag_offset is set. A null pointer
+// reads as unset.
+func (p *Patcher) readFlag(probe *stateProbe) bool {
+	if probe == nil {
+		return false
+	}
+	address, err := p.stateValueAddress(probe, "flag_offset")
+	if err != nil {
+		return false
+	}
+	flag, err := p.mem.ReadMemory(address, 1)
+	return err == nil && flag[0] != 0
+}
+
+func (p *Patcher) readState(probes *stateProbes) stateReading {
+	var reading stateReading
+	if address, err := p.playerDeathsAddress(probes.deaths.definition, probes.deaths.address); err == nil {
+		if deaths, err := memory.ReadInt32(p.mem, address); err == nil {
+			reading.saveLoaded = true
+			reading.deaths = deaths
+		}
+	}
+	if _, err := p.playerSpeedAddress(probes.player.definition, probes.player.address); err == nil {
+		reading.playerLoaded = true
+	}
+	reading.loading = p.readFlag(probes.loading)
+	reading.cutscene = p.readFlag(probes.cutscene)
+	if probes.hp != nil {
+		if address, err := p.stateValueAddress(probes.hp, "hp_offset"); err == nil {
+			if hp, err := memory.ReadInt32(p.mem, address); err == nil {
+				reading.hpRead, reading.hp = true, hp
+			}
+		}
+	}
+	return reading
+}
+
+// StateMonitor tracks the state of the session's game and publishes its
+// changes. Each reading runs as a session command.
+type StateMonitor struct {
+	session  *Session
+	interval time.Duration
+
+	// The fields below are only used in session commands. probes were found
+	// in patcher's game.
+	patcher   *Patcher
+	probes    *stateProbes
+	nextProbe time.Time
+	last      stateReading
+	current   GameState
+
+	state    atomic.Int64
+	changes  chan StateChange
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewStateMonitor(session *Session, interval time.Duration) *StateMonitor {
+	return &StateMonitor{
+		session:  session,
+		interval: interval,
+		changes:  make(chan StateChange, 16),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Changes returns every change of the state. Changes are dropped if nobody
+// is reading, and the channel is closed once the monitor stops.
+func (m *StateMonitor) Changes() <-chan StateChange {
+	return m.changes
+}
+
+// State returns the state of the last reading.
+func (m *StateMonitor) State() GameState {
+	return GameState(m.state.Load())
+}
+
+func (m *StateMonitor) Start() {
+	go m.run()
+}
+
+func (m *StateMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *StateMonitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	defer close(m.changes)
+
+	for {
+		m.check()
+
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *StateMonitor) check() {
+	var change *StateChange
+	err := m.session.Do(func(p *Patcher) error {
+		if p != m.patcher {
+			m.patcher, m.probes, m.nextProbe = p, nil, time.Time{}
+		}
+		if m.probes == nil {
+			if time.Now().Before(m.nextProbe) {
+				return nil
+			}
+			probes, err := p.findStateProbes()
+			if err != nil {
+				if m.nextProbe.IsZero() {
+					logger.Log.Warn("Game state unavailable", zap.Error(err))
+				}
+				m.nextProbe = time.Now().Add(stateProbeRetry)
+				return nil
+			}
+			m.probes = probes
+		}
+
+		reading := p.readState(m.probes)
+		// Reads fail once the game exits, which is not the main menu. The
+		// process monitor detaches from it instead.
+		if !p.Running() {
+			return nil
+		}
+
+		state := nextState(m.current, m.last, reading)
+		m.last = reading
+		p.gameState = state
+		if state != m.current {
+			change = &StateChange{From: m.current, To: state, Time: time.Now()}
+			m.current = state
+		}
+		return nil
+	})
+	if err != nil || change == nil {
+		return
+	}
+
+	m.state.Store(int64(change.To))
+	logger.Log.Debug("Game state changed",
+		zap.Stringer("from", change.From),
+		zap.Stringer("to", change.To))
+
+	select {
+	case m.changes <- *change:
+	default:
+	}
+}
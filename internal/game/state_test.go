@@ -0,0 +1,176 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextState(t *testing.T) {
+	var (
+		menu    = stateReading{}
+		loading = stateReading{saveLoaded: true, deaths: 3}
+		world   = stateReading{saveLoaded: true, playerLoaded: true, deaths: 3}
+		died    = stateReading{saveLoaded: true, playerLoaded: true, deaths: 4}
+		// The player stats pointer is null while the world is loaded, e.g.
+		// right after quitting to the menu.
+		noSave = stateReading{playerLoaded: true}
+
+		// Readings through the optional definitions.
+		loadingScreen = stateReading{saveLoaded: true, playerLoaded: true, deaths: 3, loading: true}
+		cutscene      = stateReading{saveLoaded: true, playerLoaded: true, deaths: 3, cutscene: true}
+		alive         = stateReading{saveLoaded: true, playerLoaded: true, deaths: 3, hpRead: true, hp: 800}
+		noHP          = stateReading{saveLoaded: true, playerLoaded: true, deaths: 3, hpRead: true}
+		noHPCounted   = stateReading{saveLoaded: true, playerLoaded: true, deaths: 4, hpRead: true}
+		resurrected   = stateReading{saveLoaded: true, playerLoaded: true, deaths: 4, hpRead: true, hp: 400}
+	)
+
+	tests := []struct {
+		name     string
+		current  GameState
+		previous stateReading
+		reading  stateReading
+		want     GameState
+	}{
+		{"first reading at the menu", StateUnknown, menu, menu, StateMenu},
+		{"first reading in the world", StateUnknown, menu, world, StateInWorld},
+		{"save loading", StateMenu, menu, loading, StateLoading},
+		{"world loaded", StateLoading, loading, world, StateInWorld},
+		{"quit to menu", StateInWorld, world, menu, StateMenu},
+		{"loading screen", StateInWorld, world, loading, StateLoading},
+		{"player loaded without a save", StateMenu, menu, noSave, StateInWorld},
+		{"died", StateInWorld, world, died, StateDead},
+		{"death counted while loading", StateLoading, loading, died, StateInWorld},
+		{"first reading after a death", StateUnknown, menu, died, StateInWorld},
+		{"death screen", StateDead, died, died, StateDead},
+		{"reloaded at the idol", StateDead, died, stateReading{saveLoaded: true, deaths: 4}, StateLoading},
+		{"quit from the death screen", StateDead, died, menu, StateMenu},
+		{"another save loaded", StateDead, died, world, StateInWorld},
+		{"died again", StateDead, world, died, StateDead},
+
+		{"loading flag with the player loaded", StateInWorld, world, loadingScreen, StateLoading},
+		{"loading flag cleared", StateLoading, loadingScreen, world, StateInWorld},
+		{"cutscene", StateInWorld, world, cutscene, StateCutscene},
+		{"cutscene ended", StateCutscene, cutscene, world, StateInWorld},
+		{"cutscene flag at the menu", StateMenu, menu, stateReading{cutscene: true}, StateMenu},
+		{"no health left", StateInWorld, alive, noHP, StateDead},
+		{"death screen by health", StateDead, noHP, noHPCounted, StateDead},
+		{"resurrected", StateDead, noHPCounted, resurrected, StateInWorld},
+		{"counter up with health left", StateInWorld, alive, resurrected, StateInWorld},
+		{"dead flag ignored while loading", StateDead, noHP, stateReading{saveLoaded: true, deaths: 3, hpRead: true}, StateLoading},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextState(tt.current, tt.previous, tt.reading); got != tt.want {
+				t.Errorf("nextState = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateMonitor(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	monitor := NewStateMonitor(session, time.Hour)
+
+	expect := func(want GameState) {
+		t.Helper()
+		monitor.check()
+		if got := monitor.State(); got != want {
+			t.Fatalf("state = %v, want %v", got, want)
+		}
+	}
+
+	expect(StateInWorld)
+
+	writeBytes(t, patcher.mem, fakeGameData+fakeDeathsOffset, []byte{4, 0, 0, 0})
+	expect(StateDead)
+
+	// The world is reloaded at the last idol.
+	writeBytes(t, patcher.mem, fakePlayerSlot, make([]byte, 8))
+	expect(StateLoading)
+
+	writeBytes(t, patcher.mem, fakeGameDataSlot, make([]byte, 8))
+	expect(StateMenu)
+
+	changes := []GameState{StateInWorld, StateDead, StateLoading, StateMenu}
+	for i, want := range changes {
+		select {
+		case change := <-monitor.Changes():
+			if change.To != want {
+				t.Errorf("change %d = %v, want %v", i, change.To, want)
+			}
+		default:
+			t.Fatalf("missing change %d to %v", i, want)
+		}
+	}
+}
+
+// stateDefinitions are the optional definitions reading the fake game's
+// state.
+const stateDefinitions = `version: 1
+patches:
+  loading_screen:
+    signature: "48 8B 05 ?? ?? ?? ?? 0F B6 40 10"
+    params:
+      instruction_length: 7
+      flag_offset: 0x10
+  cutscene:
+    signature: "48 8B 05 ?? ?? ?? ?? 0F B6 40 10"
+    params:
+      instruction_length: 7
+      flag_offset: 0x11
+  player_hp:
+    signature: "48 8B 05 ?? ?? ?? ?? 0F B6 40 10"
+    params:
+      instruction_length: 7
+      pointer1_offset: 0x20
+      hp_offset: 0x130
+`
+
+func TestStateMonitorDefinitions(t *testing.T) {
+	set, err := LoadPatchSet(writeOverrides(t, map[string]string{"state.yaml": stateDefinitions}))
+	if err != nil {
+		t.Fatalf("LoadPatchSet: %v", err)
+	}
+	session, patcher := newFakeSession(t)
+	if err := session.Do(func(p *Patcher) error {
+		p.patches = set
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewStateMonitor(session, time.Hour)
+
+	expect := func(want GameState) {
+		t.Helper()
+		monitor.check()
+		if got := monitor.State(); got != want {
+			t.Fatalf("state = %v, want %v", got, want)
+		}
+	}
+
+	expect(StateInWorld)
+
+	// A loading screen that keeps the player loaded.
+	writeBytes(t, patcher.mem, fakeLoadingFlag, []byte{1})
+	expect(StateLoading)
+	writeBytes(t, patcher.mem, fakeLoadingFlag, []byte{0})
+	expect(StateInWorld)
+
+	writeBytes(t, patcher.mem, fakeCutsceneFlag, []byte{1})
+	expect(StateCutscene)
+	writeBytes(t, patcher.mem, fakeCutsceneFlag, []byte{0})
+	expect(StateInWorld)
+
+	// Death and resurrection, read from health with the counter up.
+	writeBytes(t, patcher.mem, fakeHP, make([]byte, 4))
+	writeBytes(t, patcher.mem, fakeGameData+fakeDeathsOffset, []byte{4, 0, 0, 0})
+	expect(StateDead)
+	writeBytes(t, patcher.mem, fakeHP, []byte{0x90, 0x01, 0, 0})
+	expect(StateInWorld)
+
+	// A null pointer in the health chain falls back to the counter.
+	writeBytes(t, patcher.mem, fakeState+0x20, make([]byte, 8))
+	expect(StateInWorld)
+	writeBytes(t, patcher.mem, fakeGameData+fakeDeathsOffset, []byte{5, 0, 0, 0})
+	expect(StateDead)
+}
@@ -0,0 +1,42 @@
+package game
+
+// Stats is a reading of the values the game package can read. Values whose
+// pointer chains do not resolve, e.g. at the main menu, are nil.
+type Stats struct {
+	Deaths      *int32   `json:"deaths"`
+	Kills       *int32   `json:"kills"`
+	GameSpeed   *float32 `json:"game_speed"`
+	PlayerSpeed *float32 `json:"player_speed"`
+}
+
+// Equal reports whether two readings hold the same values.
+func (s Stats) Equal(other Stats) bool {
+	return equalValue(s.Deaths, other.Deaths) &&
+		equalValue(s.Kills, other.Kills) &&
+		equalValue(s.GameSpeed, other.GameSpeed) &&
+		equalValue(s.PlayerSpeed, other.PlayerSpeed)
+}
+
+func equalValue[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (p *Patcher) ReadStats() Stats {
+	var stats Stats
+	if deaths, err := p.GetPlayerDeaths(); err == nil {
+		stats.Deaths = &deaths
+	}
+	if kills, err := p.GetTotalKills(); err == nil {
+		stats.Kills = &kills
+	}
+	if speed, err := p.GetGameSpeed(); err == nil {
+		stats.GameSpeed = &speed
+	}
+	if speed, err := p.GetPlayerSpeed(); err == nil {
+		stats.PlayerSpeed = &speed
+	}
+	return stats
+}
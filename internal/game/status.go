@@ -0,0 +1,356 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const DefaultStatusInterval = 2 * time.Second
+
+// LiveState is what a feature does in the game right now, as read from its
+// memory.
+type LiveState int
+
+const (
+	// LiveUnavailable means the memory the feature patches was not found,
+	// e.g. because a signature does not match this version of the game.
+	LiveUnavailable LiveState = iota
+	LiveVanilla
+	LiveApplied
+	// LiveDrifted means the game has overwritten some of the feature's bytes.
+	LiveDrifted
+	// LiveWaiting means the feature needs a loaded save to find its memory.
+	LiveWaiting
+	// LiveForeign means bytes the feature patches match neither its vanilla
+	// nor its patched bytes, or only some of them are patched, e.g. because
+	// another tool changed them.
+	LiveForeign
+)
+
+func (s LiveState) String() string {
+	switch s {
+	case LiveUnavailable:
+		return "unavailable"
+	case LiveVanilla:
+		return "vanilla"
+	case LiveApplied:
+		return "applied"
+	case LiveDrifted:
+		return "drifted"
+	case LiveWaiting:
+		return "waiting"
+	case LiveForeign:
+		return "foreign"
+	default:
+		return fmt.Sprintf("state_%d", int(s))
+	}
+}
+
+func (s LiveState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// LiveRegion is memory a feature patches or would patch.
+type LiveRegion struct {
+	Address int64    `json:"address"`
+	Bytes   HexBytes `json:"bytes"`
+	// Expected holds the bytes the patcher wrote, for applied features.
+	Expected HexBytes `json:"expected,omitempty"`
+	// Vanilla and Patched are the bytes of the definition patched here, for
+	// byte patches the patcher did not write. Vanilla is empty for
+	// definitions that do not check the original bytes.
+	Vanilla HexBytes `json:"vanilla,omitempty"`
+	Patched HexBytes `json:"patched,omitempty"`
+}
+
+// LiveStatus is the state of one feature in the game.
+type LiveStatus struct {
+	Feature string       `json:"feature"`
+	State   LiveState    `json:"state"`
+	Regions []LiveRegion `json:"regions,omitempty"`
+	// Reason explains why a feature is unavailable or waiting.
+	Reason string `json:"reason,omitempty"`
+}
+
+// CheckFeature reads a feature's state from game memory. The memory of a
+// feature the patcher has not applied is found by planning it with its
+// default parameters, as a dry run does, and its byte patches are compared
+// with their definitions, so a feature patched by an earlier run that left no
+// journal is reported as applied.
+func (p *Patcher) CheckFeature(feature Feature) LiveStatus {
+	var writes []trackedWrite
+	for _, w := range p.activeWrites() {
+		if w.feature == feature.ID() {
+			writes = append(writes, w)
+		}
+	}
+	if len(writes) > 0 {
+		return p.checkApplied(feature, writes)
+	}
+
+	status := LiveStatus{Feature: feature.ID()}
+	results, err := p.DryRun([]FeatureRequest{{Feature: feature, Params: DefaultParams(feature)}})
+	if err == nil && results[0].Failed() {
+		err = fmt.Errorf("%s", results[0].Error)
+	}
+	definitions := p.bytePatches(feature)
+	if err != nil {
+		// Planning refuses to write over bytes that are neither vanilla nor
+		// patched, so those are looked for directly.
+		if regions := p.foreignRegions(definitions); len(regions) > 0 {
+			status.Regions = regions
+			status.State, status.Reason = compareDefinitions(regions)
+			return status
+		}
+
+		status.State = LiveUnavailable
+		if requiresInGame(feature) {
+			status.State = LiveWaiting
+		}
+		status.Reason = err.Error()
+		return status
+	}
+
+	for _, write := range results[0].Writes {
+		// Caves do not exist until the feature is applied.
+		if write.Cave {
+			continue
+		}
+		region := LiveRegion{Address: write.Address, Bytes: write.Current}
+		for _, definition := range definitions {
+			if bytes.Equal(write.Intended, definition.Patched) {
+				region.Vanilla, region.Patched = definition.Vanilla, definition.Patched
+				break
+			}
+		}
+		status.Regions = append(status.Regions, region)
+	}
+	status.State, status.Reason = compareDefinitions(status.Regions)
+	return status
+}
+
+// bytePatches returns the definitions of the feature that write fixed bytes.
+// A byte patch's planned write is its patched bytes, which is how writes are
+// matched to definitions.
+func (p *Patcher) bytePatches(feature Feature) []PatchDefinition {
+	var definitions []PatchDefinition
+	for _, id := range p.patches.IDs() {
+		definition, err := p.patches.Get(id)
+		if err == nil && definition.Feature == feature.ID() && len(definition.Patched) > 0 {
+			definitions = append(definitions, definition)
+		}
+	}
+	return definitions
+}
+
+// foreignRegions finds the byte patches among definitions whose bytes are
+// neither vanilla nor patched.
+func (p *Patcher) foreignRegions(definitions []PatchDefinition) []LiveRegion {
+	var regions []LiveRegion
+	for _, definition := range definitions {
+		_, address, err := p.findPatch(definition.ID)
+		if err != nil {
+			continue
+		}
+		current, err := p.mem.ReadMemory(address, len(definition.Patched))
+		if err != nil {
+			continue
+		}
+		region := LiveRegion{Address: address, Bytes: current, Vanilla: definition.Vanilla, Patched: definition.Patched}
+		if state, _ := compareDefinitions([]LiveRegion{region}); state == LiveForeign {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// compareDefinitions tells from the regions of byte patches the patcher did
+// not write whether the feature is applied, vanilla or changed by something
+// else. Regions of other writes, such as values, say nothing either way.
+func compareDefinitions(regions []LiveRegion) (LiveState, string) {
+	var patched, vanilla, foreign int
+	for _, region := range regions {
+		switch {
+		case region.Patched == nil:
+		case bytes.Equal(region.Bytes, region.Patched):
+			patched++
+		case len(region.Vanilla) == 0 || bytes.Equal(region.Bytes, region.Vanilla):
+			vanilla++
+		default:
+			foreign++
+		}
+	}
+
+	switch {
+	case foreign > 0:
+		return LiveForeign, fmt.Sprintf("%d patched region(s) hold neither the vanilla nor the patched bytes", foreign)
+	case patched > 0 && vanilla > 0:
+		return LiveForeign, fmt.Sprintf("only %d of %d patched region(s) are patched", patched, patched+vanilla)
+	case patched > 0:
+		return LiveApplied, "patched outside this session"
+	default:
+		return LiveVanilla, ""
+	}
+}
+
+func (p *Patcher) checkApplied(feature Feature, writes []trackedWrite) LiveStatus {
+	status := LiveStatus{Feature: feature.ID(), State: LiveApplied}
+	for _, w := range writes {
+		address, err := p.address(w)
+		if err != nil {
+			// Values behind pointer chains are gone while the world loads
+			// and are re-applied by the watchdog once it is back.
+			if status.State == LiveApplied {
+				status.State = LiveWaiting
+			}
+			status.Reason = err.Error()
+			continue
+		}
+
+		actual, err := p.mem.ReadMemory(address, len(w.data))
+		if err != nil {
+			status.State = LiveUnavailable
+			status.Reason = fmt.Sprintf("failed to read 0x%X: %v", address, err)
+			continue
+		}
+		status.Regions = append(status.Regions, LiveRegion{Address: address, Bytes: actual, Expected: w.data})
+
+		if !bytes.Equal(actual, w.data) && status.State != LiveUnavailable {
+			status.State = LiveDrifted
+		}
+	}
+	return status
+}
+
+func requiresInGame(feature Feature) bool {
+	return HasRequirement(feature, RequiresInGame)
+}
+
+// StatusMonitor periodically checks every registered feature of the
+// session's game, as one session command per check.
+type StatusMonitor struct {
+	session  *Session
+	interval time.Duration
+
+	// patcher and cached are only used in session commands. cached holds the
+	// last full check of each feature patcher has not applied, with the game
+	// state it was made in. Signatures are only scanned for again after
+	// attaching or once the state changes; until then features that were
+	// found only have their bytes read again.
+	patcher *Patcher
+	cached  map[string]cachedStatus
+
+	updates  chan []LiveStatus
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewStatusMonitor(session *Session, interval time.Duration) *StatusMonitor {
+	return &StatusMonitor{
+		session:  session,
+		interval: interval,
+		cached:   make(map[string]cachedStatus),
+		updates:  make(chan []LiveStatus, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Updates returns the state of every feature after each check, in
+// registration order. Updates are dropped if nobody is reading, and the
+// channel is closed once the monitor stops.
+func (m *StatusMonitor) Updates() <-chan []LiveStatus {
+	return m.updates
+}
+
+func (m *StatusMonitor) Start() {
+	go m.run()
+}
+
+func (m *StatusMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *StatusMonitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	defer close(m.updates)
+
+	for {
+		if statuses, err := m.check(); err == nil {
+			select {
+			case m.updates <- statuses:
+			default:
+			}
+		}
+
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *StatusMonitor) check() ([]LiveStatus, error) {
+	features := Features()
+	statuses := make([]LiveStatus, len(features))
+	err := m.session.Do(func(p *Patcher) error {
+		if p != m.patcher {
+			m.patcher = p
+			m.cached = make(map[string]cachedStatus)
+		}
+		for i, feature := range features {
+			statuses[i] = m.checkFeature(feature)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// cachedStatus is a full check of a feature and the game state it was made
+// in.
+type cachedStatus struct {
+	status LiveStatus
+	state  GameState
+}
+
+func (m *StatusMonitor) checkFeature(feature Feature) LiveStatus {
+	// Applied features are checked through what the patcher wrote, which
+	// needs no scan.
+	for _, w := range m.patcher.activeWrites() {
+		if w.feature == feature.ID() {
+			return m.patcher.CheckFeature(feature)
+		}
+	}
+
+	if cached, exists := m.cached[feature.ID()]; exists && cached.state == m.patcher.gameState {
+		if cached.status.State == LiveUnavailable || cached.status.State == LiveWaiting {
+			return cached.status
+		}
+		if status, ok := m.recheck(feature, cached.status.Regions); ok {
+			return status
+		}
+	}
+
+	status := m.patcher.CheckFeature(feature)
+	m.cached[feature.ID()] = cachedStatus{status: status, state: m.patcher.gameState}
+	return status
+}
+
+// recheck reads the bytes of a feature that was found at its planned
+// addresses and compares them with its definitions again.
+func (m *StatusMonitor) recheck(feature Feature, regions []LiveRegion) (LiveStatus, bool) {
+	status := LiveStatus{Feature: feature.ID()}
+	for _, region := range regions {
+		current, err := m.patcher.mem.ReadMemory(region.Address, len(region.Bytes))
+		if err != nil {
+			return LiveStatus{}, false
+		}
+		region.Bytes = current
+		status.Regions = append(status.Regions, region)
+	}
+	status.State, status.Reason = compareDefinitions(status.Regions)
+	return status, true
+}
@@ -0,0 +1,153 @@
+package game
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestStatusMonitorCache(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	monitor := NewStatusMonitor(session, time.Hour)
+
+	states := func() map[string]LiveState {
+		t.Helper()
+		statuses, err := monitor.check()
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		states := make(map[string]LiveState)
+		for _, status := range statuses {
+			states[status.Feature] = status.State
+		}
+		return states
+	}
+	setState := func(state GameState) {
+		t.Helper()
+		if err := session.Do(func(p *Patcher) error {
+			p.gameState = state
+			return nil
+		}); err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+	}
+	expect := func(feature string, want LiveState) {
+		t.Helper()
+		if got := states()[feature]; got != want {
+			t.Errorf("%s = %v, want %v", feature, got, want)
+		}
+	}
+
+	// No save is loaded, and the auto loot signature does not match.
+	writeBytes(t, patcher.mem, fakePlayerSlot, make([]byte, 8))
+	writeBytes(t, patcher.mem, fakeAutoLoot, []byte{0xCC})
+	setState(StateMenu)
+	expect(FeatureAutoLoot, LiveUnavailable)
+	expect(FeaturePlayerSpeed, LiveWaiting)
+	expect(FeatureGameSpeed, LiveVanilla)
+
+	// Neither is scanned for again while the state stays the same.
+	writeBytes(t, patcher.mem, fakePlayerSlot, binary.LittleEndian.AppendUint64(nil, fakePlayer))
+	writeBytes(t, patcher.mem, fakeAutoLoot, []byte{0xC6})
+	expect(FeatureAutoLoot, LiveUnavailable)
+	expect(FeaturePlayerSpeed, LiveWaiting)
+
+	setState(StateInWorld)
+	expect(FeatureAutoLoot, LiveVanilla)
+	expect(FeaturePlayerSpeed, LiveVanilla)
+
+	// Vanilla features have their bytes read again without a scan, and
+	// applied ones are checked through what was written.
+	writeBytes(t, patcher.mem, fakeAutoLoot, []byte{0xCC})
+	expect(FeatureAutoLoot, LiveVanilla)
+	writeBytes(t, patcher.mem, fakeAutoLoot, []byte{0xC6})
+	if err := session.Apply(FeatureSetting{Feature: mustFeature(t, FeatureAutoLoot), Enabled: true}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	expect(FeatureAutoLoot, LiveApplied)
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, autoLootVanilla)
+	expect(FeatureAutoLoot, LiveDrifted)
+	if err := session.Revert(mustFeature(t, FeatureAutoLoot)); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	expect(FeatureAutoLoot, LiveVanilla)
+
+	// Attaching starts over.
+	second, _ := newFakeGame(t)
+	writeBytes(t, second.mem, fakeAutoLoot, []byte{0xCC})
+	if err := session.Attach(second); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	expect(FeatureAutoLoot, LiveUnavailable)
+}
+
+func TestCheckFeatureComparesDefinition(t *testing.T) {
+	patcher, _ := newFakeGame(t)
+
+	tests := []struct {
+		name    string
+		feature string
+		address int64
+		bytes   []byte
+		patched []byte
+		want    LiveState
+	}{
+		{"vanilla", FeatureAutoLoot, fakeAutoLoot + 18, autoLootVanilla, autoLootPatched, LiveVanilla},
+		// An earlier run patched it and left no journal, so the signature
+		// only matches with the patched bytes.
+		{"patched without a journal", FeatureAutoLoot, fakeAutoLoot + 18, autoLootPatched, autoLootPatched, LiveApplied},
+		{"wildcard vanilla", FeatureCameraReset, fakeCameraReset + 6, []byte{0x01}, []byte{0x00}, LiveVanilla},
+		{"wildcard patched", FeatureCameraReset, fakeCameraReset + 6, []byte{0x00}, []byte{0x00}, LiveApplied},
+		{"changed by something else", FeatureCameraReset, fakeCameraReset + 6, []byte{0x05}, []byte{0x00}, LiveForeign},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeBytes(t, patcher.mem, tt.address, tt.bytes)
+			status := patcher.CheckFeature(mustFeature(t, tt.feature))
+			if status.State != tt.want {
+				t.Fatalf("state = %v (%s), want %v", status.State, status.Reason, tt.want)
+			}
+			if len(status.Regions) != 1 || status.Regions[0].Address != tt.address ||
+				!bytes.Equal(status.Regions[0].Bytes, tt.bytes) || !bytes.Equal(status.Regions[0].Patched, tt.patched) {
+				t.Errorf("regions = %+v", status.Regions)
+			}
+		})
+	}
+
+	// Applying over the earlier run's patch works, and takes it over.
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, autoLootPatched)
+	if err := patcher.ApplyFeature(mustFeature(t, FeatureAutoLoot), nil); err != nil {
+		t.Fatalf("ApplyFeature over a patched game: %v", err)
+	}
+	if status := patcher.CheckFeature(mustFeature(t, FeatureAutoLoot)); status.State != LiveApplied || status.Regions[0].Expected == nil {
+		t.Errorf("status = %+v, want applied by the patcher", status)
+	}
+}
+
+func TestStatusMonitorRechecksDefinition(t *testing.T) {
+	session, patcher := newFakeSession(t)
+	monitor := NewStatusMonitor(session, time.Hour)
+
+	expect := func(want LiveState) {
+		t.Helper()
+		statuses, err := monitor.check()
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		for _, status := range statuses {
+			if status.Feature == FeatureAutoLoot && status.State != want {
+				t.Errorf("auto_loot = %v, want %v", status.State, want)
+			}
+		}
+	}
+
+	expect(LiveVanilla)
+	// The bytes are read again without a scan and compared each time.
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, autoLootPatched)
+	expect(LiveApplied)
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, []byte{0x90, 0x90})
+	expect(LiveForeign)
+	writeBytes(t, patcher.mem, fakeAutoLoot+18, autoLootVanilla)
+	expect(LiveVanilla)
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+const DefaultWatchdogInterval = 2 * time.Second
+
+// DriftEvent is published by the watchdog for every drifted region it finds.
+type DriftEvent struct {
+	Drift
+	Time   time.Time
+	Healed bool
+	Err    error
+}
+
+// Watchdog periodically verifies that applied patches are still in place and
+// optionally re-applies the ones the game has reverted. Each check runs as
+// one session command, so a feature reverted in between is never healed.
+type Watchdog struct {
+	session  *Session
+	interval time.Duration
+	autoHeal atomic.Bool
+
+	events   chan DriftEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewWatchdog(session *Session, interval time.Duration) *Watchdog {
+	return &Watchdog{
+		session:  session,
+		interval: interval,
+		events:   make(chan DriftEvent, 16),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (w *Watchdog) SetAutoHeal(enabled bool) {
+	w.autoHeal.Store(enabled)
+}
+
+// Events returns the drift events. Events are dropped if nobody is reading,
+// and the channel is closed once the watchdog stops.
+func (w *Watchdog) Events() <-chan DriftEvent {
+	return w.events
+}
+
+func (w *Watchdog) Start() {
+	go w.run()
+}
+
+func (w *Watchdog) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *Watchdog) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	defer close(w.events)
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *Watchdog) check() {
+	var events []DriftEvent
+	err := w.session.Do(func(p *Patcher) error {
+		drifts, err := p.VerifyPatches()
+		for _, drift := range drifts {
+			event := DriftEvent{Drift: drift, Time: time.Now()}
+
+			if w.autoHeal.Load() {
+				event.Err = p.HealDrift(drift)
+				event.Healed = event.Err == nil
+			}
+			events = append(events, event)
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrNotAttached) && !errors.Is(err, ErrSessionClosed) {
+		logger.Log.Debug("Patch verification failed", zap.Error(err))
+	}
+
+	for _, event := range events {
+		drift := event.Drift
+		logger.Log.Warn("Patch drifted",
+			zap.String("feature", drift.Feature),
+			zap.String("address", fmt.Sprintf("0x%X", drift.Address)),
+			zap.String("expected", fmt.Sprintf("% X", drift.Expected)),
+			zap.String("actual", fmt.Sprintf("% X", drift.Actual)),
+			zap.Bool("healed", event.Healed),
+			zap.Error(event.Err))
+
+		select {
+		case w.events <- event:
+		default:
+		}
+	}
+}
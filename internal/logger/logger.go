@@ -1,20 +1,248 @@
 package logger
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 var Log *zap.Logger
 
+// Output formats.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Destinations.
+const (
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputBoth   = "both"
+)
+
+// Defaults used for options that are not set anywhere.
+const (
+	DefaultLevel  = "info"
+	DefaultFormat = FormatConsole
+	DefaultOutput = OutputBoth
+)
+
+// Environment variables that override the config.
+const (
+	EnvLevel  = "SEKIRO_TWEAKER_LOG_LEVEL"
+	EnvFormat = "SEKIRO_TWEAKER_LOG_FORMAT"
+	EnvOutput = "SEKIRO_TWEAKER_LOG_OUTPUT"
+)
+
+// Options selects what is logged and where. Empty fields are unset and use
+// the defaults.
+type Options struct {
+	Level  string
+	Format string
+	Output string
+	// Quiet limits stderr to warnings and errors, so logs do not bury a
+	// command's output. The file still gets everything at Level.
+	Quiet bool
+}
+
+// Merge returns o with the fields set in over replacing its own.
+func (o Options) Merge(over Options) Options {
+	if over.Level != "" {
+		o.Level = over.Level
+	}
+	if over.Format != "" {
+		o.Format = over.Format
+	}
+	if over.Output != "" {
+		o.Output = over.Output
+	}
+	o.Quiet = o.Quiet || over.Quiet
+	return o
+}
+
+// EnvOptions returns the options set by environment variables.
+func EnvOptions() Options {
+	return Options{
+		Level:  os.Getenv(EnvLevel),
+		Format: os.Getenv(EnvFormat),
+		Output: os.Getenv(EnvOutput),
+	}
+}
+
+func ValidateLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	if _, err := zapcore.ParseLevel(level); err != nil {
+		return fmt.Errorf("unknown log level %q (use debug, info, warn or error)", level)
+	}
+	return nil
+}
+
+func ValidateFormat(format string) error {
+	switch format {
+	case "", FormatConsole, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q (use %s or %s)", format, FormatConsole, FormatJSON)
+}
+
+func ValidateOutput(output string) error {
+	switch output {
+	case "", OutputStderr, OutputFile, OutputBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown log output %q (use %s, %s or %s)", output, OutputStderr, OutputFile, OutputBoth)
+}
+
+// Path returns the log file, $XDG_STATE_HOME/sekiro-tweaker/sekiro-tweaker.log.
+func Path() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateDir, "sekiro-tweaker", "sekiro-tweaker.log"), nil
+}
+
+var (
+	mu sync.Mutex
+	// file is the log file the current Log writes to, closed when it is
+	// replaced.
+	file *RotatingFile
+	// level is shared by every core so SetLevel takes effect immediately.
+	level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+)
+
 func init() {
-	config := zap.NewDevelopmentConfig()
-	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	// Until the config is read only the environment applies. Nothing is
+	// written to the file yet, since a command may not want one.
+	opts := Options{Output: OutputStderr}.Merge(EnvOptions())
+	if err := Configure(opts); err != nil {
+		_ = Configure(Options{Output: OutputStderr})
+	}
+}
+
+// Configure replaces Log with a logger built from opts. Invalid options are
+// an error and leave Log unchanged. A log file that cannot be opened is
+// reported and logging continues on stderr.
+func Configure(opts Options) error {
+	opts = Options{Level: DefaultLevel, Format: DefaultFormat, Output: DefaultOutput}.Merge(opts)
+	for _, err := range []error{ValidateLevel(opts.Level), ValidateFormat(opts.Format), ValidateOutput(opts.Output)} {
+		if err != nil {
+			return err
+		}
+	}
+	parsed, _ := zapcore.ParseLevel(opts.Level)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	level.SetLevel(parsed)
+	cores := []zapcore.Core{
+		// The viewer shows the same lines whatever the format.
+		zapcore.NewCore(newEncoder(FormatConsole, false), Recent, level),
+	}
+
+	if opts.Output == OutputStderr || opts.Output == OutputBoth {
+		var stderrLevel zapcore.LevelEnabler = level
+		if opts.Quiet {
+			stderrLevel = zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return l >= zapcore.WarnLevel && level.Enabled(l)
+			})
+		}
+		cores = append(cores, zapcore.NewCore(newEncoder(opts.Format, true), zapcore.Lock(os.Stderr), stderrLevel))
+	}
+
+	var fileErr error
+	var newFile *RotatingFile
+	if opts.Output == OutputFile || opts.Output == OutputBoth {
+		path, err := Path()
+		if err == nil {
+			newFile, err = OpenRotatingFile(path, DefaultMaxSize, DefaultMaxBackups)
+		}
+		if err != nil {
+			fileErr = err
+		} else {
+			cores = append(cores, zapcore.NewCore(newEncoder(opts.Format, false), newFile, level))
+		}
+	}
+
+	Log = zap.New(zapcore.NewTee(cores...), zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+
+	if file != nil {
+		_ = file.Close()
+	}
+	file = newFile
+
+	if fileErr != nil {
+		Log.Warn("Log file unavailable", zap.Error(fileErr))
+	}
+	return nil
+}
 
-	var err error
-	Log, err = config.Build()
-	if err != nil {
-		panic(err)
+// SetLevel changes the level of the current Log.
+func SetLevel(name string) error {
+	if name == "" {
+		name = DefaultLevel
 	}
+	if err := ValidateLevel(name); err != nil {
+		return err
+	}
+	parsed, _ := zapcore.ParseLevel(name)
+	level.SetLevel(parsed)
+	return nil
+}
+
+// Level returns the name of the current level.
+func Level() string {
+	return level.Level().String()
+}
+
+func newEncoder(format string, color bool) zapcore.Encoder {
+	config := zap.NewDevelopmentEncoderConfig()
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	if format == FormatJSON {
+		config = zap.NewProductionEncoderConfig()
+		config.EncodeTime = zapcore.ISO8601TimeEncoder
+		return zapcore.NewJSONEncoder(config)
+	}
+
+	config.EncodeLevel = zapcore.CapitalLevelEncoder
+	if color && isTerminal(os.Stderr) {
+		config.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	return zapcore.NewConsoleEncoder(config)
+}
+
+// isTerminal reports whether f is a character device, so that colors are not
+// written to files and pipes.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Sync flushes the log file.
+func Sync() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if file != nil {
+		_ = file.Sync()
+	}
+}
+
+// trimLine removes the trailing newline of an encoded entry.
+func trimLine(line []byte) string {
+	return strings.TrimRight(string(line), "\n")
 }
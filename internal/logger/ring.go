@@ -0,0 +1,56 @@
+package logger
+
+import "sync"
+
+// RecentLines is how many log lines Recent keeps.
+const RecentLines = 1000
+
+// Recent keeps the latest log lines for the in-app viewer, whatever the
+// configured outputs.
+var Recent = NewRing(RecentLines)
+
+// Ring is a fixed size buffer of log lines. Every line gets a sequence
+// number, so a viewer can ask for the lines it has not shown yet.
+type Ring struct {
+	mu    sync.Mutex
+	lines []string
+	// next is the sequence number of the next line written.
+	next uint64
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{lines: make([]string, size)}
+}
+
+// Write adds an encoded entry. It is a zapcore.WriteSyncer.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next%uint64(len(r.lines))] = trimLine(p)
+	r.next++
+	return len(p), nil
+}
+
+func (r *Ring) Sync() error {
+	return nil
+}
+
+// Since returns the kept lines numbered seq or later and the number to pass
+// next time. Since(0) returns every kept line.
+func (r *Ring) Since(seq uint64) ([]string, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	oldest := uint64(0)
+	if r.next > uint64(len(r.lines)) {
+		oldest = r.next - uint64(len(r.lines))
+	}
+	seq = min(max(seq, oldest), r.next)
+
+	lines := make([]string, 0, r.next-seq)
+	for ; seq < r.next; seq++ {
+		lines = append(lines, r.lines[seq%uint64(len(r.lines))])
+	}
+	return lines, r.next
+}
@@ -0,0 +1,115 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Rotation limits of the log file.
+const (
+	DefaultMaxSize    = 5 << 20
+	DefaultMaxBackups = 3
+)
+
+// RotatingFile is an append-only file that is renamed to path.1 once it
+// reaches maxSize, shifting older files up to path.<maxBackups>.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if it would grow the file past maxSize.
+// Writes after Close are dropped, since loggers still in use may outlive
+// their file.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return len(p), nil
+	}
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxBackups > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
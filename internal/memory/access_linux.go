@@ -0,0 +1,80 @@
+//go:build linux
+// +build linux
+
+package memory
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CapSysPtrace is the capability that lifts Yama's ptrace restrictions.
+const CapSysPtrace = 19
+
+const ptraceScopePath = "/proc/sys/kernel/yama/ptrace_scope"
+
+// PtraceScope returns the Yama ptrace_scope setting, or -1 if Yama is not
+// enabled:
+//
+//	0  any process of the same user can be accessed
+//	1  only descendants, unless the tracer has CAP_SYS_PTRACE
+//	2  only tracers with CAP_SYS_PTRACE
+//	3  no process can be accessed
+func PtraceScope() (int, error) {
+	data, err := os.ReadFile(ptraceScopePath)
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	scope, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", ptraceScopePath, err)
+	}
+	return scope, nil
+}
+
+// HasCapability reports whether the current process has a capability in its
+// effective set.
+func HasCapability(capability uint) (bool, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !found {
+			continue
+		}
+
+		mask, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid CapEff: %v", err)
+		}
+		return mask&(1<<capability) != 0, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	return false, fmt.Errorf("CapEff not found in /proc/self/status")
+}
+
+// CheckProcMemWritable opens a process's mem file for writing, which is what
+// WriteMemory falls back to for read-only pages. Nothing is written.
+func CheckProcMemWritable(pid int) error {
+	memPath := fmt.Sprintf("/proc/%d/mem", pid)
+	f, err := os.OpenFile(memPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
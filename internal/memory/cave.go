@@ -2,21 +2,46 @@ package memory
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 )
 
 type PointerStyle int
 
 const (
+	// DWordRelative is a RIP-relative disp32 that ends its instruction, e.g.
+	// the memory operand of movss xmm1,[rip+disp32].
 	DWordRelative PointerStyle = iota
+	// QWordAbsolute is a 64-bit absolute pointer, e.g. a pointer stored in
+	// .data or the imm64 of mov rax,imm64.
+	QWordAbsolute
+	// DWordImmediate is an imm32 operand that holds the value itself, e.g.
+	// mov dword ptr [rbx+18],imm32. The data is written in place and no cave
+	// memory is allocated, so data must be exactly 4 bytes.
+	DWordImmediate
 )
 
+// Size returns how many bytes the pointer occupies at the pointer address.
+func (ps PointerStyle) Size() int {
+	if ps == QWordAbsolute {
+		return 8
+	}
+	return 4
+}
+
 type DataCave struct {
-	name           string
-	pointerAddress int64
-	caveAddress    int64
-	data           []byte
-	pointerStyle   PointerStyle
+	name            string
+	pointerAddress  int64
+	caveAddress     int64
+	capacity        int
+	data            []byte
+	pointerStyle    PointerStyle
+	originalPointer []byte
+	pointer         []byte
+	active          bool
+	refs            int
 }
 
 type CodeCave struct {
@@ -26,17 +51,26 @@ type CodeCave struct {
 	shellcode        []byte
 	overwriteLength  int
 	originalBytes    []byte
+	jump             []byte
 	active           bool
+	refs             int
 }
 
+// CaveManager owns the data and code caves of one game process. Caves are
+// reference counted by name: creating an existing cave takes another
+// reference and updates it in place, and the cave is only deactivated and its
+// memory freed once every reference has been released. All methods are safe
+// for concurrent use.
 type CaveManager struct {
-	memory      *ProcessMemory
+	memory      ReadWriter
 	baseAddress int64
-	caves       map[string]*DataCave
-	codeCaves   map[string]*CodeCave
+
+	mu        sync.Mutex
+	caves     map[string]*DataCave
+	codeCaves map[string]*CodeCave
 }
 
-func NewCaveManager(memory *ProcessMemory, baseAddress int64) *CaveManager {
+func NewCaveManager(memory ReadWriter, baseAddress int64) *CaveManager {
 	return &CaveManager{
 		memory:      memory,
 		baseAddress: baseAddress,
@@ -45,69 +79,240 @@ func NewCaveManager(memory *ProcessMemory, baseAddress int64) *CaveManager {
 	}
 }
 
+// CreateDataCave prepares a cave holding data and records the original bytes
+// at pointerAddress so that deactivation can restore them. If the cave already
+// exists at the same pointer, another reference is taken and its data is
+// updated in place.
 func (cm *CaveManager) CreateDataCave(name string, pointerAddress int64, data []byte, pointerStyle PointerStyle) error {
-	caveAddress, err := cm.memory.AllocateMemory(pointerAddress, len(data))
+	if pointerStyle == DWordImmediate && len(data) != 4 {
+		return fmt.Errorf("immediate data cave needs 4 bytes of data, got %d", len(data))
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cave, exists := cm.caves[name]; exists {
+		if cave.pointerAddress != pointerAddress || cave.pointerStyle != pointerStyle {
+			return fmt.Errorf("cave %s already exists at 0x%X", name, cave.pointerAddress)
+		}
+
+		if err := cm.updateDataCave(cave, data); err != nil {
+			return err
+		}
+		cave.refs++
+		return nil
+	}
+
+	originalPointer, err := cm.memory.ReadMemory(pointerAddress, pointerStyle.Size())
 	if err != nil {
-		return fmt.Errorf("failed to allocate memory: %v", err)
+		return fmt.Errorf("failed to read original pointer: %v", err)
 	}
 
-	cave := &DataCave{
-		name:           name,
-		pointerAddress: pointerAddress,
-		caveAddress:    caveAddress,
-		data:           data,
-		pointerStyle:   pointerStyle,
+	var caveAddress int64
+	if pointerStyle != DWordImmediate {
+		caveAddress, err = cm.memory.AllocateMemory(pointerAddress, len(data))
+		if err != nil {
+			return fmt.Errorf("failed to allocate memory: %v", err)
+		}
 	}
 
-	cm.caves[name] = cave
+	cm.caves[name] = &DataCave{
+		name:            name,
+		pointerAddress:  pointerAddress,
+		caveAddress:     caveAddress,
+		capacity:        len(data),
+		data:            data,
+		pointerStyle:    pointerStyle,
+		originalPointer: originalPointer,
+		refs:            1,
+	}
 	return nil
 }
 
-func (cm *CaveManager) ActivateDataCave(name string) error {
+// UpdateDataCave replaces the data of an existing cave without taking a new
+// reference. Active caves are rewritten immediately; the cave is only moved if
+// the new data does not fit in its allocation.
+func (cm *CaveManager) UpdateDataCave(name string, data []byte) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	cave, exists := cm.caves[name]
 	if !exists {
 		return fmt.Errorf("cave %s not found", name)
 	}
 
-	if err := cm.memory.WriteMemory(cave.caveAddress, cave.data); err != nil {
-		return fmt.Errorf("failed to write cave data: %v", err)
+	return cm.updateDataCave(cave, data)
+}
+
+func (cm *CaveManager) updateDataCave(cave *DataCave, data []byte) error {
+	if cave.pointerStyle == DWordImmediate && len(data) != 4 {
+		return fmt.Errorf("immediate data cave needs 4 bytes of data, got %d", len(data))
 	}
 
-	switch cave.pointerStyle {
-	case DWordRelative:
-		offset := int32(cave.caveAddress - (cave.pointerAddress + 4))
-		pointer := make([]byte, 4)
-		binary.LittleEndian.PutUint32(pointer, uint32(offset))
+	oldAddress, oldCapacity, oldData := cave.caveAddress, cave.capacity, cave.data
+	if cave.pointerStyle != DWordImmediate && len(data) > cave.capacity {
+		address, err := cm.memory.AllocateMemory(cave.pointerAddress, len(data))
+		if err != nil {
+			return fmt.Errorf("failed to allocate memory: %v", err)
+		}
+		cave.caveAddress, cave.capacity = address, len(data)
+	}
+
+	cave.data = data
 
-		if err := cm.memory.WriteMemory(cave.pointerAddress, pointer); err != nil {
-			return fmt.Errorf("failed to write pointer: %v", err)
+	if cave.active {
+		if err := cm.activateDataCave(cave); err != nil {
+			// Keep the cave on its old block, which the game still points at,
+			// and give back the new one.
+			if cave.caveAddress != oldAddress {
+				_ = cm.memory.FreeMemory(cave.caveAddress, cave.capacity)
+				_ = cm.memory.WriteMemory(cave.pointerAddress, cave.pointer)
+			}
+			cave.caveAddress, cave.capacity, cave.data = oldAddress, oldCapacity, oldData
+			return err
 		}
+	}
 
-	default:
-		return fmt.Errorf("unsupported pointer style")
+	if cave.caveAddress != oldAddress {
+		_ = cm.memory.FreeMemory(oldAddress, oldCapacity)
 	}
 
 	return nil
 }
 
+func (cm *CaveManager) ActivateDataCave(name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cave, exists := cm.caves[name]
+	if !exists {
+		return fmt.Errorf("cave %s not found", name)
+	}
+
+	return cm.activateDataCave(cave)
+}
+
+func (cm *CaveManager) activateDataCave(cave *DataCave) error {
+	if cave.pointerStyle != DWordImmediate {
+		if err := cm.memory.WriteMemory(cave.caveAddress, cave.data); err != nil {
+			return fmt.Errorf("failed to write cave data: %v", err)
+		}
+	}
+
+	pointer, err := cave.encodePointer()
+	if err != nil {
+		return err
+	}
+
+	if err := cm.memory.WriteMemory(cave.pointerAddress, pointer); err != nil {
+		return fmt.Errorf("failed to write pointer: %v", err)
+	}
+
+	cave.pointer = pointer
+	cave.active = true
+	return nil
+}
+
+// DeactivateDataCave restores the original bytes at the pointer address.
 func (cm *CaveManager) DeactivateDataCave(name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cave, exists := cm.caves[name]
+	if !exists {
+		return fmt.Errorf("cave %s not found", name)
+	}
+
+	return cm.deactivateDataCave(cave)
+}
+
+func (cm *CaveManager) deactivateDataCave(cave *DataCave) error {
+	if !cave.active {
+		return nil // Already deactivated
+	}
+
+	if err := cm.memory.WriteMemory(cave.pointerAddress, cave.originalPointer); err != nil {
+		return fmt.Errorf("failed to restore pointer: %v", err)
+	}
+
+	cave.active = false
+	return nil
+}
+
+// ReleaseDataCave drops a reference to a cave. The last release deactivates
+// the cave and frees its memory.
+func (cm *CaveManager) ReleaseDataCave(name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	cave, exists := cm.caves[name]
 	if !exists {
 		return fmt.Errorf("cave %s not found", name)
 	}
 
+	if cave.refs--; cave.refs > 0 {
+		return nil
+	}
+
+	return cm.destroyDataCave(cave)
+}
+
+func (cm *CaveManager) destroyDataCave(cave *DataCave) error {
+	if err := cm.deactivateDataCave(cave); err != nil {
+		return err
+	}
+
+	if cave.pointerStyle != DWordImmediate {
+		if err := cm.memory.FreeMemory(cave.caveAddress, cave.capacity); err != nil {
+			return fmt.Errorf("failed to free cave memory: %v", err)
+		}
+	}
+
+	delete(cm.caves, cave.name)
+	return nil
+}
+
+func (cave *DataCave) encodePointer() ([]byte, error) {
+	pointer := make([]byte, cave.pointerStyle.Size())
+
 	switch cave.pointerStyle {
 	case DWordRelative:
-		pointer := make([]byte, 4)
-		if err := cm.memory.WriteMemory(cave.pointerAddress, pointer); err != nil {
-			return fmt.Errorf("failed to clear pointer: %v", err)
+		offset := cave.caveAddress - (cave.pointerAddress + 4)
+		if offset < math.MinInt32 || offset > math.MaxInt32 {
+			return nil, fmt.Errorf("cave at 0x%X is out of rel32 range of 0x%X", cave.caveAddress, cave.pointerAddress)
 		}
+		binary.LittleEndian.PutUint32(pointer, uint32(int32(offset)))
+
+	case QWordAbsolute:
+		binary.LittleEndian.PutUint64(pointer, uint64(cave.caveAddress))
+
+	case DWordImmediate:
+		copy(pointer, cave.data)
 
 	default:
-		return fmt.Errorf("unsupported pointer style")
+		return nil, fmt.Errorf("unsupported pointer style %d", cave.pointerStyle)
 	}
 
-	return nil
+	return pointer, nil
+}
+
+// Assembler produces shellcode for a known cave address, which lets the code
+// use RIP-relative operands and jumps into the game. It is implemented by
+// asm.Builder.
+type Assembler interface {
+	Len() int
+	Assemble(base int64) ([]byte, error)
+}
+
+// rawShellcode is position-independent shellcode.
+type rawShellcode []byte
+
+func (r rawShellcode) Len() int {
+	return len(r)
+}
+
+func (r rawShellcode) Assemble(base int64) ([]byte, error) {
+	return r, nil
 }
 
 // CreateCodeCave creates a code cave for assembly injection
@@ -115,10 +320,29 @@ func (cm *CaveManager) DeactivateDataCave(name string) error {
 // overwriteLength: how many bytes to overwrite (must be >= 5 for JMP)
 // shellcode: assembly code to execute in the cave
 func (cm *CaveManager) CreateCodeCave(name string, injectionAddress int64, overwriteLength int, shellcode []byte) error {
+	return cm.CreateAssembledCodeCave(name, injectionAddress, overwriteLength, rawShellcode(shellcode))
+}
+
+// CreateAssembledCodeCave is like CreateCodeCave but assembles the shellcode
+// at the address the cave is allocated at.
+func (cm *CaveManager) CreateAssembledCodeCave(name string, injectionAddress int64, overwriteLength int, code Assembler) error {
 	if overwriteLength < 5 {
 		return fmt.Errorf("overwrite length must be at least 5 bytes for JMP instruction")
 	}
 
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	// Reading the "original" bytes again after activation would capture our
+	// own JMP, so an existing cave is reused instead of being rebuilt.
+	if cave, exists := cm.codeCaves[name]; exists {
+		if cave.injectionAddress != injectionAddress || cave.overwriteLength != overwriteLength {
+			return fmt.Errorf("code cave %s already exists at 0x%X", name, cave.injectionAddress)
+		}
+		cave.refs++
+		return nil
+	}
+
 	// Read original bytes that will be overwritten
 	originalBytes, err := cm.memory.ReadMemory(injectionAddress, overwriteLength)
 	if err != nil {
@@ -127,7 +351,7 @@ func (cm *CaveManager) CreateCodeCave(name string, injectionAddress int64, overw
 
 	// Calculate total cave size:
 	// shellcode + original instructions + JMP back (5 bytes)
-	caveSize := len(shellcode) + overwriteLength + 5
+	caveSize := code.Len() + overwriteLength + 5
 
 	// Allocate executable memory near the injection point
 	caveAddress, err := cm.memory.AllocateMemory(injectionAddress, caveSize)
@@ -135,6 +359,15 @@ func (cm *CaveManager) CreateCodeCave(name string, injectionAddress int64, overw
 		return fmt.Errorf("failed to allocate code cave: %v", err)
 	}
 
+	shellcode, err := code.Assemble(caveAddress)
+	if err == nil && len(shellcode) != code.Len() {
+		err = fmt.Errorf("assembled %d bytes, expected %d", len(shellcode), code.Len())
+	}
+	if err != nil {
+		_ = cm.memory.FreeMemory(caveAddress, caveSize)
+		return fmt.Errorf("failed to assemble code cave %s: %v", name, err)
+	}
+
 	// Build cave contents:
 	// 1. Shellcode
 	// 2. Original overwritten instructions
@@ -159,6 +392,7 @@ func (cm *CaveManager) CreateCodeCave(name string, injectionAddress int64, overw
 		overwriteLength:  overwriteLength,
 		originalBytes:    originalBytes,
 		active:           false,
+		refs:             1,
 	}
 
 	cm.codeCaves[name] = cave
@@ -167,6 +401,9 @@ func (cm *CaveManager) CreateCodeCave(name string, injectionAddress int64, overw
 
 // ActivateCodeCave writes the shellcode to the cave and redirects execution
 func (cm *CaveManager) ActivateCodeCave(name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	cave, exists := cm.codeCaves[name]
 	if !exists {
 		return fmt.Errorf("code cave %s not found", name)
@@ -195,17 +432,25 @@ func (cm *CaveManager) ActivateCodeCave(name string) error {
 		return fmt.Errorf("failed to write JMP to cave: %v", err)
 	}
 
+	cave.jump = jmpInstruction
 	cave.active = true
 	return nil
 }
 
 // DeactivateCodeCave restores original bytes at injection point
 func (cm *CaveManager) DeactivateCodeCave(name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	cave, exists := cm.codeCaves[name]
 	if !exists {
 		return fmt.Errorf("code cave %s not found", name)
 	}
 
+	return cm.deactivateCodeCave(cave)
+}
+
+func (cm *CaveManager) deactivateCodeCave(cave *CodeCave) error {
 	if !cave.active {
 		return nil // Already deactivated
 	}
@@ -219,8 +464,220 @@ func (cm *CaveManager) DeactivateCodeCave(name string) error {
 	return nil
 }
 
+// ReleaseCodeCave drops a reference to a code cave. The last release restores
+// the original instructions and frees the cave.
+func (cm *CaveManager) ReleaseCodeCave(name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cave, exists := cm.codeCaves[name]
+	if !exists {
+		return fmt.Errorf("code cave %s not found", name)
+	}
+
+	if cave.refs--; cave.refs > 0 {
+		return nil
+	}
+
+	return cm.destroyCodeCave(cave)
+}
+
+func (cm *CaveManager) destroyCodeCave(cave *CodeCave) error {
+	if err := cm.deactivateCodeCave(cave); err != nil {
+		return err
+	}
+
+	if err := cm.memory.FreeMemory(cave.caveAddress, len(cave.shellcode)); err != nil {
+		return fmt.Errorf("failed to free code cave memory: %v", err)
+	}
+
+	delete(cm.codeCaves, cave.name)
+	return nil
+}
+
+// Close deactivates every cave regardless of its reference count and frees all
+// cave memory. Caves that fail to restore are reported and dropped anyway, as
+// the manager is unusable afterwards.
+func (cm *CaveManager) Close() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var errs []error
+
+	for name, cave := range cm.caves {
+		if err := cm.destroyDataCave(cave); err != nil {
+			errs = append(errs, fmt.Errorf("cave %s: %v", name, err))
+		}
+	}
+
+	for name, cave := range cm.codeCaves {
+		if err := cm.destroyCodeCave(cave); err != nil {
+			errs = append(errs, fmt.Errorf("code cave %s: %v", name, err))
+		}
+	}
+
+	cm.caves = make(map[string]*DataCave)
+	cm.codeCaves = make(map[string]*CodeCave)
+
+	return errors.Join(errs...)
+}
+
+// DataCaveExists checks if a data cave with the given name exists
+func (cm *CaveManager) DataCaveExists(name string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	_, exists := cm.caves[name]
+	return exists
+}
+
 // CodeCaveExists checks if a code cave with the given name exists
 func (cm *CaveManager) CodeCaveExists(name string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
 	_, exists := cm.codeCaves[name]
 	return exists
 }
+
+// WrittenRegion is a range of game memory that must hold Data for a patch to
+// stay in effect.
+type WrittenRegion struct {
+	Owner   string
+	Address int64
+	Data    []byte
+}
+
+// ActiveRegions lists the cave contents and redirections of every active cave,
+// owned by the cave's name.
+func (cm *CaveManager) ActiveRegions() []WrittenRegion {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var regions []WrittenRegion
+
+	for name, cave := range cm.caves {
+		if !cave.active {
+			continue
+		}
+		if cave.pointerStyle != DWordImmediate {
+			regions = append(regions, WrittenRegion{Owner: name, Address: cave.caveAddress, Data: cave.data})
+		}
+		regions = append(regions, WrittenRegion{Owner: name, Address: cave.pointerAddress, Data: cave.pointer})
+	}
+
+	for name, cave := range cm.codeCaves {
+		if !cave.active {
+			continue
+		}
+		regions = append(regions,
+			WrittenRegion{Owner: name, Address: cave.caveAddress, Data: cave.shellcode},
+			WrittenRegion{Owner: name, Address: cave.injectionAddress, Data: cave.jump})
+	}
+
+	return regions
+}
+
+// CaveRecord is the persistent form of a cave, which lets a later tweaker
+// process take over caves that are still installed in the game.
+type CaveRecord struct {
+	Name string `json:"name"`
+	Code bool   `json:"code,omitempty"`
+	// Site is the pointer address of a data cave or the injection address of
+	// a code cave.
+	Site            int64        `json:"site"`
+	Address         int64        `json:"address"`
+	Capacity        int          `json:"capacity"`
+	PointerStyle    PointerStyle `json:"pointer_style,omitempty"`
+	OverwriteLength int          `json:"overwrite_length,omitempty"`
+	// Data is the cave contents: the data of a data cave or the full code of
+	// a code cave.
+	Data []byte `json:"data"`
+	// Original holds the bytes at Site before the cave was activated and
+	// Patch the bytes written there on activation.
+	Original []byte `json:"original"`
+	Patch    []byte `json:"patch,omitempty"`
+	Active   bool   `json:"active"`
+	Refs     int    `json:"refs"`
+}
+
+// Records returns every cave in persistent form.
+func (cm *CaveManager) Records() []CaveRecord {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	records := make([]CaveRecord, 0, len(cm.caves)+len(cm.codeCaves))
+	for _, cave := range cm.caves {
+		records = append(records, CaveRecord{
+			Name:         cave.name,
+			Site:         cave.pointerAddress,
+			Address:      cave.caveAddress,
+			Capacity:     cave.capacity,
+			PointerStyle: cave.pointerStyle,
+			Data:         cave.data,
+			Original:     cave.originalPointer,
+			Patch:        cave.pointer,
+			Active:       cave.active,
+			Refs:         cave.refs,
+		})
+	}
+	for _, cave := range cm.codeCaves {
+		records = append(records, CaveRecord{
+			Name:            cave.name,
+			Code:            true,
+			Site:            cave.injectionAddress,
+			Address:         cave.caveAddress,
+			Capacity:        len(cave.shellcode),
+			OverwriteLength: cave.overwriteLength,
+			Data:            cave.shellcode,
+			Original:        cave.originalBytes,
+			Patch:           cave.jump,
+			Active:          cave.active,
+			Refs:            cave.refs,
+		})
+	}
+	return records
+}
+
+// Restore adds caves from records without touching game memory. Records for
+// caves the manager already has are ignored.
+func (cm *CaveManager) Restore(records []CaveRecord) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for _, record := range records {
+		if record.Code {
+			if _, exists := cm.codeCaves[record.Name]; exists {
+				continue
+			}
+			cm.codeCaves[record.Name] = &CodeCave{
+				name:             record.Name,
+				injectionAddress: record.Site,
+				caveAddress:      record.Address,
+				shellcode:        record.Data,
+				overwriteLength:  record.OverwriteLength,
+				originalBytes:    record.Original,
+				jump:             record.Patch,
+				active:           record.Active,
+				refs:             record.Refs,
+			}
+			continue
+		}
+
+		if _, exists := cm.caves[record.Name]; exists {
+			continue
+		}
+		cm.caves[record.Name] = &DataCave{
+			name:            record.Name,
+			pointerAddress:  record.Site,
+			caveAddress:     record.Address,
+			capacity:        record.Capacity,
+			data:            record.Data,
+			pointerStyle:    record.PointerStyle,
+			originalPointer: record.Original,
+			pointer:         record.Patch,
+			active:          record.Active,
+			refs:            record.Refs,
+		}
+	}
+}
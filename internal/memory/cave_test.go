@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// failingWrites is an Overlay whose writes fail at or above failFrom.
+type failingWrites struct {
+	*Overlay
+	failFrom int64
+}
+
+func (m *failingWrites) WriteMemory(address int64, data []byte) error {
+	if m.failFrom != 0 && address >= m.failFrom {
+		return fmt.Errorf("0x%X is read-only", address)
+	}
+	return m.Overlay.WriteMemory(address, data)
+}
+
+func TestDataCaveFailedResize(t *testing.T) {
+	const pointerAddress = testHeap + 0x3000
+
+	tests := []struct {
+		name     string
+		failFrom int64
+	}{
+		// The data cannot be written to the new block.
+		{"data write", testHeap + 0x1010},
+		// The data is written but the game cannot be pointed at it.
+		{"pointer write", pointerAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := &failingWrites{Overlay: NewOverlay(&testMemory{data: make([]byte, 0x4000)})}
+			caves := NewCaveManager(mem, testHeap)
+
+			if err := caves.CreateDataCave("fov", pointerAddress, []byte{1, 2, 3, 4}, QWordAbsolute); err != nil {
+				t.Fatalf("CreateDataCave: %v", err)
+			}
+			if err := caves.ActivateDataCave("fov"); err != nil {
+				t.Fatalf("ActivateDataCave: %v", err)
+			}
+			cave := caves.caves["fov"]
+			oldAddress := cave.caveAddress
+
+			mem.failFrom = tt.failFrom
+			if err := caves.UpdateDataCave("fov", make([]byte, 0x20)); err == nil {
+				t.Fatal("UpdateDataCave succeeded")
+			}
+
+			if cave.caveAddress != oldAddress || cave.capacity != 4 || !bytes.Equal(cave.data, []byte{1, 2, 3, 4}) {
+				t.Errorf("cave = 0x%X+%d % X, want the old block", cave.caveAddress, cave.capacity, cave.data)
+			}
+			pointer, _ := mem.ReadMemory(pointerAddress, 8)
+			if got := int64(binary.LittleEndian.Uint64(pointer)); got != oldAddress {
+				t.Errorf("game pointer = 0x%X, want the old block at 0x%X", got, oldAddress)
+			}
+			free := mem.allocator.state().Free
+			if len(free) != 1 || free[0].Address == oldAddress || free[0].Size != 0x20 {
+				t.Errorf("free blocks = %+v, want the new block", free)
+			}
+
+			// Once writes work again, the resize reuses the freed block and
+			// gives back the old one.
+			mem.failFrom = 0
+			if err := caves.UpdateDataCave("fov", make([]byte, 0x20)); err != nil {
+				t.Fatalf("UpdateDataCave: %v", err)
+			}
+			if cave.caveAddress != free[0].Address {
+				t.Errorf("cave = 0x%X, want the freed block at 0x%X", cave.caveAddress, free[0].Address)
+			}
+			if free := mem.allocator.state().Free; len(free) != 1 || free[0].Address != oldAddress {
+				t.Errorf("free blocks = %+v, want the old block", free)
+			}
+		})
+	}
+}
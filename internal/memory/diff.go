@@ -0,0 +1,350 @@
+package memory
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type ValueType int
+
+const (
+	Uint8 ValueType = iota
+	Int16
+	Int32
+	Int64
+	Float32
+	Float64
+)
+
+var valueTypeNames = map[ValueType]string{
+	Uint8:   "u8",
+	Int16:   "i16",
+	Int32:   "i32",
+	Int64:   "i64",
+	Float32: "f32",
+	Float64: "f64",
+}
+
+func (t ValueType) String() string {
+	if name, exists := valueTypeNames[t]; exists {
+		return name
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
+}
+
+func (t ValueType) Size() int {
+	switch t {
+	case Uint8:
+		return 1
+	case Int16:
+		return 2
+	case Int32, Float32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func ParseValueType(name string) (ValueType, error) {
+	for t, n := range valueTypeNames {
+		if strings.EqualFold(n, name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown value type %q", name)
+}
+
+// decode converts a little-endian value to float64 for ordered comparisons.
+func (t ValueType) decode(data []byte) float64 {
+	switch t {
+	case Uint8:
+		return float64(data[0])
+	case Int16:
+		return float64(int16(binary.LittleEndian.Uint16(data)))
+	case Int32:
+		return float64(int32(binary.LittleEndian.Uint32(data)))
+	case Int64:
+		return float64(int64(binary.LittleEndian.Uint64(data)))
+	case Float32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data))
+	}
+}
+
+func (t ValueType) isFloat() bool {
+	return t == Float32 || t == Float64
+}
+
+type DiffCondition int
+
+const (
+	Changed DiffCondition = iota
+	Unchanged
+	Increased
+	Decreased
+	IncreasedBy
+	DecreasedBy
+)
+
+var diffConditionNames = map[DiffCondition]string{
+	Changed:     "changed",
+	Unchanged:   "unchanged",
+	Increased:   "increased",
+	Decreased:   "decreased",
+	IncreasedBy: "increased-by",
+	DecreasedBy: "decreased-by",
+}
+
+func (c DiffCondition) String() string {
+	if name, exists := diffConditionNames[c]; exists {
+		return name
+	}
+	return fmt.Sprintf("DiffCondition(%d)", int(c))
+}
+
+func ParseDiffCondition(name string) (DiffCondition, error) {
+	for c, n := range diffConditionNames {
+		if strings.EqualFold(n, name) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown diff condition %q", name)
+}
+
+const floatDeltaTolerance = 1e-4
+
+type DiffOptions struct {
+	Type      ValueType
+	Condition DiffCondition
+	// Delta is the expected step for IncreasedBy and DecreasedBy.
+	Delta float64
+	// Alignment of candidate addresses; defaults to the size of Type.
+	Alignment int
+	// Limit stops the diff after this many matches; zero means no limit.
+	Limit int
+}
+
+// DiffMatch is an address whose value satisfied the condition between every
+// pair of consecutive captures. Values holds the raw bytes from each capture.
+type DiffMatch struct {
+	Address int64
+	Values  [][]byte
+}
+
+type Interpretation struct {
+	Type  string
+	Value string
+}
+
+// Interpret renders raw little-endian bytes as every type of matching width.
+func Interpret(data []byte) []Interpretation {
+	switch len(data) {
+	case 1:
+		return []Interpretation{
+			{"u8", strconv.FormatUint(uint64(data[0]), 10)},
+			{"i8", strconv.FormatInt(int64(int8(data[0])), 10)},
+		}
+	case 2:
+		v := binary.LittleEndian.Uint16(data)
+		return []Interpretation{
+			{"u16", strconv.FormatUint(uint64(v), 10)},
+			{"i16", strconv.FormatInt(int64(int16(v)), 10)},
+		}
+	case 4:
+		v := binary.LittleEndian.Uint32(data)
+		return []Interpretation{
+			{"u32", strconv.FormatUint(uint64(v), 10)},
+			{"i32", strconv.FormatInt(int64(int32(v)), 10)},
+			{"f32", strconv.FormatFloat(float64(math.Float32frombits(v)), 'g', -1, 32)},
+		}
+	case 8:
+		v := binary.LittleEndian.Uint64(data)
+		return []Interpretation{
+			{"u64", strconv.FormatUint(v, 10)},
+			{"i64", strconv.FormatInt(int64(v), 10)},
+			{"f64", strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)},
+			{"ptr", fmt.Sprintf("0x%X", v)},
+		}
+	}
+	return nil
+}
+
+var errDiffLimit = errors.New("diff limit reached")
+
+const diffChunkSize = 256 << 10
+
+// DiffSnapshots compares two or more captures, typically taken with
+// WritableSnapshotFilter, and calls fn for every address that satisfies the
+// condition. Regions are streamed in lockstep so memory use is bounded by the
+// chunk size rather than the size of the captures.
+func DiffSnapshots(snapshots []*Snapshot, opts DiffOptions, fn func(DiffMatch) error) error {
+	if len(snapshots) < 2 {
+		return fmt.Errorf("need at least two captures to diff, got %d", len(snapshots))
+	}
+
+	if opts.Alignment <= 0 {
+		opts.Alignment = opts.Type.Size()
+	}
+
+	matches := 0
+	emit := func(match DiffMatch) error {
+		if err := fn(match); err != nil {
+			return err
+		}
+		matches++
+		if opts.Limit > 0 && matches >= opts.Limit {
+			return errDiffLimit
+		}
+		return nil
+	}
+
+	for _, region := range snapshots[0].Manifest.Regions {
+		regions := []SnapshotRegion{region}
+		start, end := region.Start, region.End
+
+		for _, s := range snapshots[1:] {
+			other := s.findRegion(region.Start)
+			if other == nil || other.Start != region.Start {
+				break
+			}
+			regions = append(regions, *other)
+			end = min(end, other.End)
+		}
+
+		if len(regions) != len(snapshots) || end <= start {
+			continue
+		}
+
+		if err := diffRegion(snapshots, regions, start, end, opts, emit); err != nil {
+			if errors.Is(err, errDiffLimit) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func diffRegion(snapshots []*Snapshot, regions []SnapshotRegion, start, end int64, opts DiffOptions, emit func(DiffMatch) error) error {
+	readers := make([]io.ReadCloser, len(snapshots))
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				_ = r.Close()
+			}
+		}
+	}()
+
+	for i, s := range snapshots {
+		r, err := s.OpenRegion(regions[i])
+		if err != nil {
+			return err
+		}
+		readers[i] = r
+	}
+
+	size := opts.Type.Size()
+	buffers := make([][]byte, len(readers))
+	for i := range buffers {
+		buffers[i] = make([]byte, diffChunkSize+size)
+	}
+
+	// base is the address of buffers[i][0]; filled is how many bytes are valid.
+	base, filled := start, 0
+	for base+int64(filled) < end {
+		n := int(min(int64(diffChunkSize), end-base-int64(filled)))
+		for i, r := range readers {
+			if _, err := io.ReadFull(r, buffers[i][filled:filled+n]); err != nil {
+				return fmt.Errorf("failed to read region 0x%X: %v", start, err)
+			}
+		}
+		filled += n
+
+		offset := 0
+		if rem := int(base % int64(opts.Alignment)); rem != 0 {
+			offset = opts.Alignment - rem
+		}
+
+		for ; offset+size <= filled; offset += opts.Alignment {
+			if !diffMatches(buffers, offset, size, opts) {
+				continue
+			}
+
+			match := DiffMatch{Address: base + int64(offset), Values: make([][]byte, len(buffers))}
+			for i, buf := range buffers {
+				match.Values[i] = append([]byte(nil), buf[offset:offset+size]...)
+			}
+			if err := emit(match); err != nil {
+				return err
+			}
+		}
+
+		// Carry the unprocessed tail over so values spanning chunks are seen.
+		consumed := min(offset, filled)
+		for i := range buffers {
+			copy(buffers[i], buffers[i][consumed:filled])
+		}
+		base += int64(consumed)
+		filled -= consumed
+	}
+
+	return nil
+}
+
+func diffMatches(buffers [][]byte, offset, size int, opts DiffOptions) bool {
+	for i := 1; i < len(buffers); i++ {
+		before := buffers[i-1][offset : offset+size]
+		after := buffers[i][offset : offset+size]
+
+		if !diffStepMatches(before, after, opts) {
+			return false
+		}
+	}
+	return true
+}
+
+func diffStepMatches(before, after []byte, opts DiffOptions) bool {
+	equal := string(before) == string(after)
+
+	switch opts.Condition {
+	case Changed:
+		return !equal
+	case Unchanged:
+		return equal
+	}
+
+	if equal {
+		return false
+	}
+
+	a, b := opts.Type.decode(before), opts.Type.decode(after)
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return false
+	}
+
+	switch opts.Condition {
+	case Increased:
+		return b > a
+	case Decreased:
+		return b < a
+	case IncreasedBy:
+		return deltaMatches(b-a, opts)
+	case DecreasedBy:
+		return deltaMatches(a-b, opts)
+	}
+	return false
+}
+
+func deltaMatches(delta float64, opts DiffOptions) bool {
+	if opts.Type.isFloat() {
+		return math.Abs(delta-opts.Delta) < floatDeltaTolerance
+	}
+	return delta == opts.Delta
+}
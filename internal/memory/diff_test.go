@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+const testHeap = 0x10000
+
+// testMemory is a single writable region.
+type testMemory struct {
+	data []byte
+}
+
+func (m *testMemory) ReadMemory(address int64, size int) ([]byte, error) {
+	offset := address - testHeap
+	if offset < 0 || offset+int64(size) > int64(len(m.data)) {
+		return nil, fmt.Errorf("0x%X is not mapped", address)
+	}
+	return append([]byte(nil), m.data[offset:offset+int64(size)]...), nil
+}
+
+func (m *testMemory) ParseMemoryMaps() ([]MemoryRegion, error) {
+	return []MemoryRegion{{Start: testHeap, End: testHeap + int64(len(m.data)), Permissions: "rw-p"}}, nil
+}
+
+func (m *testMemory) putInt32(offset int, value int32) {
+	binary.LittleEndian.PutUint32(m.data[offset:], uint32(value))
+}
+
+// writeCaptures captures the memory after each step, the way captures are
+// taken around in-game events.
+func writeCaptures(t *testing.T, steps ...func(*testMemory)) []*Snapshot {
+	t.Helper()
+
+	mem := &testMemory{data: make([]byte, 64)}
+	mem.putInt32(0, 10)
+	mem.putInt32(4, 10)
+	mem.putInt32(8, 10)
+	binary.LittleEndian.PutUint32(mem.data[12:], math.Float32bits(1.5))
+
+	var snapshots []*Snapshot
+	for i, step := range steps {
+		step(mem)
+
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("capture-%d.snap", i))
+		if err := WriteSnapshotFile(path, mem, SnapshotOptions{Filter: WritableSnapshotFilter}); err != nil {
+			t.Fatalf("WriteSnapshotFile: %v", err)
+		}
+		snapshot, err := OpenSnapshot(path)
+		if err != nil {
+			t.Fatalf("OpenSnapshot: %v", err)
+		}
+		t.Cleanup(func() { _ = snapshot.Close() })
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots
+}
+
+func diffAddresses(t *testing.T, snapshots []*Snapshot, opts DiffOptions) []int64 {
+	t.Helper()
+
+	var addresses []int64
+	err := DiffSnapshots(snapshots, opts, func(match DiffMatch) error {
+		if len(match.Values) != len(snapshots) {
+			t.Errorf("match at 0x%X has %d values, want %d", match.Address, len(match.Values), len(snapshots))
+		}
+		addresses = append(addresses, match.Address)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DiffSnapshots: %v", err)
+	}
+	return addresses
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	snapshots := writeCaptures(t,
+		func(*testMemory) {},
+		func(m *testMemory) {
+			m.putInt32(0, 11) // kills
+			m.putInt32(4, 7)  // health
+			binary.LittleEndian.PutUint32(m.data[12:], math.Float32bits(2))
+		},
+		func(m *testMemory) {
+			m.putInt32(0, 12)
+			m.putInt32(4, 2)
+			m.putInt32(8, 9)
+		},
+	)
+
+	tests := []struct {
+		name string
+		opts DiffOptions
+		want []int64
+	}{
+		{"changed", DiffOptions{Type: Int32, Condition: Changed}, []int64{testHeap, testHeap + 4}},
+		{"unchanged", DiffOptions{Type: Int32, Condition: Unchanged}, []int64{
+			testHeap + 16, testHeap + 20, testHeap + 24, testHeap + 28,
+			testHeap + 32, testHeap + 36, testHeap + 40, testHeap + 44,
+			testHeap + 48, testHeap + 52, testHeap + 56, testHeap + 60,
+		}},
+		{"increased", DiffOptions{Type: Int32, Condition: Increased}, []int64{testHeap}},
+		{"decreased", DiffOptions{Type: Int32, Condition: Decreased}, []int64{testHeap + 4}},
+		{"increased by", DiffOptions{Type: Int32, Condition: IncreasedBy, Delta: 1}, []int64{testHeap}},
+		{"decreased by", DiffOptions{Type: Int32, Condition: DecreasedBy, Delta: 3}, nil},
+		{"limit", DiffOptions{Type: Int32, Condition: Changed, Limit: 1}, []int64{testHeap}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffAddresses(t, snapshots, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %X, want %X", got, tt.want)
+			}
+		})
+	}
+
+	// Between the first two captures only, the float went from 1.5 to 2.
+	got := diffAddresses(t, snapshots[:2], DiffOptions{Type: Float32, Condition: IncreasedBy, Delta: 0.5})
+	if want := []int64{testHeap + 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("float matches = %X, want %X", got, want)
+	}
+}
+
+func TestDiffSnapshotsValues(t *testing.T) {
+	snapshots := writeCaptures(t,
+		func(*testMemory) {},
+		func(m *testMemory) { m.putInt32(0, -1) },
+	)
+
+	var matches []DiffMatch
+	err := DiffSnapshots(snapshots, DiffOptions{Type: Int32, Condition: Decreased}, func(match DiffMatch) error {
+		matches = append(matches, match)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DiffSnapshots: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches, want 1", len(matches))
+	}
+
+	want := [][]Interpretation{
+		{{"u32", "10"}, {"i32", "10"}, {"f32", "1.4e-44"}},
+		{{"u32", "4294967295"}, {"i32", "-1"}, {"f32", "NaN"}},
+	}
+	for i, value := range matches[0].Values {
+		if got := Interpret(value); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("Interpret(capture %d) = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestDiffSnapshotsNeedsTwo(t *testing.T) {
+	snapshots := writeCaptures(t, func(*testMemory) {})
+	if err := DiffSnapshots(snapshots, DiffOptions{Type: Int32}, func(DiffMatch) error { return nil }); err == nil {
+		t.Error("DiffSnapshots with one capture succeeded")
+	}
+}
+
+func TestInterpret(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []Interpretation
+	}{
+		{[]byte{0xFF}, []Interpretation{{"u8", "255"}, {"i8", "-1"}}},
+		{[]byte{0x00, 0x80}, []Interpretation{{"u16", "32768"}, {"i16", "-32768"}}},
+		{[]byte{0x00, 0x00, 0x80, 0x3F}, []Interpretation{{"u32", "1065353216"}, {"i32", "1065353216"}, {"f32", "1"}}},
+		{[]byte{0x00, 0x00, 0x00, 0x40, 0x01, 0x00, 0x00, 0x00}, []Interpretation{
+			{"u64", "5368709120"}, {"i64", "5368709120"}, {"f64", "2.6524947387e-314"}, {"ptr", "0x140000000"},
+		}},
+		{[]byte{1, 2, 3}, nil},
+	}
+	for _, tt := range tests {
+		if got := Interpret(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Interpret(% X) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
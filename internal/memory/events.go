@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/asm"
+)
+
+// Event rings live in cave memory and are shared between injected hooks and
+// the tweaker:
+//
+//	header   head (u64): number of records ever reserved, padded to 64 bytes
+//	records  capacity slots of EventRecordSize bytes
+//
+// A record is seq (u64), type (u32), reserved (u32), timestamp (u64) and
+// EventPayloadSlots payload values (u64). A hook reserves a slot with
+// lock xadd on head and writes seq = index+1 last, so a record is only
+// consumed once it is complete.
+const (
+	EventRecordSize   = 64
+	EventPayloadSlots = 5
+
+	eventHeaderSize    = 64
+	eventRecordShift   = 6
+	eventSeqOffset     = 0
+	eventTypeOffset    = 8
+	eventTimeOffset    = 16
+	eventPayloadOffset = 24
+)
+
+// Event is one record drained from an EventRing.
+type Event struct {
+	Seq  uint64
+	Type uint32
+	// TSC is the time stamp counter of the CPU the hook ran on. It orders
+	// events precisely but is not a wall clock time.
+	TSC     uint64
+	Payload [EventPayloadSlots]uint64
+}
+
+// EventRing is a ring buffer of fixed-size event records in the game's memory.
+type EventRing struct {
+	memory   ReadWriter
+	address  int64
+	capacity int
+
+	mu      sync.Mutex
+	tail    uint64
+	dropped uint64
+}
+
+// NewEventRing allocates a ring of capacity records near nearAddress.
+// Capacity must be a power of two.
+func NewEventRing(memory ReadWriter, nearAddress int64, capacity int) (*EventRing, error) {
+	if capacity <= 0 || capacity&(capacity-1) != 0 {
+		return nil, fmt.Errorf("event ring capacity %d is not a power of two", capacity)
+	}
+
+	size := eventHeaderSize + capacity*EventRecordSize
+	address, err := memory.AllocateMemory(nearAddress, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate event ring: %v", err)
+	}
+
+	// Allocations may be reused, so stale records must not look committed.
+	if err := memory.WriteMemory(address, make([]byte, size)); err != nil {
+		_ = memory.FreeMemory(address, size)
+		return nil, fmt.Errorf("failed to clear event ring: %v", err)
+	}
+
+	return &EventRing{
+		memory:   memory,
+		address:  address,
+		capacity: capacity,
+	}, nil
+}
+
+func (er *EventRing) Address() int64 {
+	return er.address
+}
+
+func (er *EventRing) size() int {
+	return eventHeaderSize + er.capacity*EventRecordSize
+}
+
+// Free releases the ring's memory. Every hook writing to it must have been
+// removed first.
+func (er *EventRing) Free() error {
+	return er.memory.FreeMemory(er.address, er.size())
+}
+
+// Dropped returns how many records were overwritten before they were drained.
+func (er *EventRing) Dropped() uint64 {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	return er.dropped
+}
+
+// Hook returns shellcode that appends an event of the given type to the ring,
+// with the values of up to EventPayloadSlots registers at the hook site as
+// payload. All registers and flags are preserved, so it can be placed in
+// front of any code cave's original instructions.
+func (er *EventRing) Hook(eventType uint32, payload ...asm.Reg) (*asm.Builder, error) {
+	if len(payload) > EventPayloadSlots {
+		return nil, fmt.Errorf("hook records at most %d registers, got %d", EventPayloadSlots, len(payload))
+	}
+
+	b := asm.New().Preserved(func(b *asm.Builder) {
+		// Reading the TSC and reserving a slot clobber volatile registers, so
+		// the payload is taken from the prologue's save area by LoadOriginal.
+		b.Rdtsc().
+			ShlImm(asm.RDX, 32).
+			Or(asm.RAX, asm.RDX).
+			Mov(asm.R10, asm.RAX)
+
+		b.MovImm(asm.R11, uint64(er.address)).
+			MovImm(asm.RCX, 1).
+			LockXadd(asm.Ptr(asm.R11, 0), asm.RCX).
+			Mov(asm.RAX, asm.RCX).
+			AndImm(asm.RAX, int32(er.capacity-1)).
+			ShlImm(asm.RAX, eventRecordShift).
+			Add(asm.RAX, asm.R11)
+
+		record := func(offset int) asm.Mem {
+			return asm.Ptr(asm.RAX, int32(eventHeaderSize+offset))
+		}
+
+		b.Mov32MemImm(record(eventTypeOffset), eventType).
+			MovStore(record(eventTimeOffset), asm.R10)
+
+		for i, r := range payload {
+			b.LoadOriginal(asm.RDX, r).
+				MovStore(record(eventPayloadOffset+i*8), asm.RDX)
+		}
+
+		b.AddImm(asm.RCX, 1).
+			MovStore(record(eventSeqOffset), asm.RCX)
+	})
+
+	return b, nil
+}
+
+// Drain returns the records committed since the last call, oldest first.
+// Records still being written are left for the next call.
+func (er *EventRing) Drain() ([]Event, error) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	head, err := ReadInt64(er.memory, er.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event ring head: %v", err)
+	}
+
+	end := uint64(head)
+	if end-er.tail > uint64(er.capacity) {
+		er.dropped += end - er.tail - uint64(er.capacity)
+		er.tail = end - uint64(er.capacity)
+	}
+	if end == er.tail {
+		return nil, nil
+	}
+
+	records, err := er.memory.ReadMemory(er.address+eventHeaderSize, er.capacity*EventRecordSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event ring: %v", err)
+	}
+
+	var events []Event
+	for ; er.tail < end; er.tail++ {
+		slot := int(er.tail&uint64(er.capacity-1)) * EventRecordSize
+		record := records[slot : slot+EventRecordSize]
+
+		seq := binary.LittleEndian.Uint64(record[eventSeqOffset:])
+		if seq < er.tail+1 {
+			break
+		}
+		if seq > er.tail+1 {
+			// A later lap has already reused the slot.
+			er.dropped++
+			continue
+		}
+
+		event := Event{
+			Seq:  seq,
+			Type: binary.LittleEndian.Uint32(record[eventTypeOffset:]),
+			TSC:  binary.LittleEndian.Uint64(record[eventTimeOffset:]),
+		}
+		for i := range event.Payload {
+			event.Payload[i] = binary.LittleEndian.Uint64(record[eventPayloadOffset+i*8:])
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,210 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/asm"
+)
+
+// newTestRing returns a ring of capacity records on top of testMemory.
+func newTestRing(t *testing.T, capacity int) (*EventRing, *Overlay) {
+	t.Helper()
+
+	mem := NewOverlay(&testMemory{data: make([]byte, 0x4000)})
+	ring, err := NewEventRing(mem, testHeap, capacity)
+	if err != nil {
+		t.Fatalf("NewEventRing: %v", err)
+	}
+	return ring, mem
+}
+
+// reserve sets the ring's head, as hooks do when they reserve slots.
+func reserve(t *testing.T, ring *EventRing, mem *Overlay, head uint64) {
+	t.Helper()
+	if err := mem.WriteMemory(ring.Address(), binary.LittleEndian.AppendUint64(nil, head)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commit writes the record a hook writes for the index-th reserved slot, with
+// the index as its payload.
+func commit(t *testing.T, ring *EventRing, mem *Overlay, index uint64, eventType uint32) {
+	t.Helper()
+
+	record := make([]byte, EventRecordSize)
+	binary.LittleEndian.PutUint64(record[eventSeqOffset:], index+1)
+	binary.LittleEndian.PutUint32(record[eventTypeOffset:], eventType)
+	binary.LittleEndian.PutUint64(record[eventTimeOffset:], 1000+index)
+	binary.LittleEndian.PutUint64(record[eventPayloadOffset:], index)
+
+	slot := int64(index&uint64(ring.capacity-1)) * EventRecordSize
+	if err := mem.WriteMemory(ring.Address()+eventHeaderSize+slot, record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func drainSeqs(t *testing.T, ring *EventRing) []uint64 {
+	t.Helper()
+
+	events, err := ring.Drain()
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	var seqs []uint64
+	for _, event := range events {
+		if event.Payload[0] != event.Seq-1 || event.TSC != 1000+event.Seq-1 {
+			t.Errorf("event %d = %+v, want its own record", event.Seq, event)
+		}
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
+
+func TestEventRingDrain(t *testing.T) {
+	ring, mem := newTestRing(t, 4)
+
+	expect := func(want []uint64, dropped uint64) {
+		t.Helper()
+		if got := drainSeqs(t, ring); !slices.Equal(got, want) {
+			t.Errorf("drained %v, want %v", got, want)
+		}
+		if got := ring.Dropped(); got != dropped {
+			t.Errorf("dropped = %d, want %d", got, dropped)
+		}
+	}
+
+	expect(nil, 0)
+
+	for index := range uint64(3) {
+		commit(t, ring, mem, index, 1)
+	}
+	reserve(t, ring, mem, 3)
+	expect([]uint64{1, 2, 3}, 0)
+	expect(nil, 0)
+
+	// The fourth slot is reserved but still being written, so the fifth
+	// waits for it.
+	reserve(t, ring, mem, 5)
+	commit(t, ring, mem, 4, 1)
+	expect(nil, 0)
+	commit(t, ring, mem, 3, 1)
+	expect([]uint64{4, 5}, 0)
+
+	// Ten more wrap the ring twice before it is drained, so only the last
+	// four are left.
+	for index := uint64(5); index < 15; index++ {
+		commit(t, ring, mem, index, 2)
+	}
+	reserve(t, ring, mem, 15)
+	expect([]uint64{12, 13, 14, 15}, 6)
+
+	// Between reading the head and the records, a later lap reused a slot.
+	commit(t, ring, mem, 16, 2)
+	commit(t, ring, mem, 19, 2)
+	reserve(t, ring, mem, 17)
+	expect([]uint64{17}, 7)
+}
+
+func TestEventRingValues(t *testing.T) {
+	ring, mem := newTestRing(t, 4)
+
+	record := make([]byte, EventRecordSize)
+	binary.LittleEndian.PutUint64(record[eventSeqOffset:], 1)
+	binary.LittleEndian.PutUint32(record[eventTypeOffset:], 7)
+	binary.LittleEndian.PutUint64(record[eventTimeOffset:], 0x1122334455)
+	for i := range EventPayloadSlots {
+		binary.LittleEndian.PutUint64(record[eventPayloadOffset+i*8:], uint64(0x100+i))
+	}
+	if err := mem.WriteMemory(ring.Address()+eventHeaderSize, record); err != nil {
+		t.Fatal(err)
+	}
+	reserve(t, ring, mem, 1)
+
+	events, err := ring.Drain()
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	want := Event{Seq: 1, Type: 7, TSC: 0x1122334455, Payload: [EventPayloadSlots]uint64{0x100, 0x101, 0x102, 0x103, 0x104}}
+	if len(events) != 1 || events[0] != want {
+		t.Errorf("events = %+v, want %+v", events, want)
+	}
+}
+
+func TestNewEventRingClearsMemory(t *testing.T) {
+	ring, mem := newTestRing(t, 4)
+	commit(t, ring, mem, 0, 1)
+	reserve(t, ring, mem, 1)
+	if err := ring.Free(); err != nil {
+		t.Fatalf("Free: %v", err)
+	}
+
+	// A ring reusing the allocation must not see the old record.
+	reused, err := NewEventRing(mem, testHeap, 4)
+	if err != nil {
+		t.Fatalf("NewEventRing: %v", err)
+	}
+	if reused.Address() != ring.Address() {
+		t.Fatalf("ring at 0x%X, want the freed block at 0x%X", reused.Address(), ring.Address())
+	}
+	if got := drainSeqs(t, reused); got != nil {
+		t.Errorf("drained %v from a new ring", got)
+	}
+
+	if _, err := NewEventRing(mem, testHeap, 6); err == nil {
+		t.Error("NewEventRing with a capacity of 6 succeeded")
+	}
+}
+
+// decodeTestHex parses bytes written like "48 8B 45 00".
+func decodeTestHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return data
+}
+
+// The layout was checked with objdump.
+func TestEventRingHook(t *testing.T) {
+	ring := &EventRing{address: 0x140010000, capacity: 256}
+	b, err := ring.Hook(2, asm.RCX, asm.RBX)
+	if err != nil {
+		t.Fatalf("Hook: %v", err)
+	}
+	got, err := b.Assemble(0x140020000)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+
+	want := decodeTestHex(t, ""+
+		"9C 50 51 52 41 50 41 51 41 52 41 53 48 81 EC 60 00 00 00"+ // save registers and flags
+		"0F 11 04 24 0F 11 4C 24 10 0F 11 54 24 20"+ // movups [rsp+i*10],xmm0-xmm5
+		"0F 11 5C 24 30 0F 11 64 24 40 0F 11 6C 24 50"+
+		"0F 31 48 C1 E2 20 48 09 D0 49 89 C2"+ // r10 = rdtsc
+		"49 BB 00 00 01 40 01 00 00 00"+ // mov r11,ring
+		"48 B9 01 00 00 00 00 00 00 00"+ // mov rcx,1
+		"F0 49 0F C1 0B"+ // lock xadd [r11],rcx
+		"48 89 C8 48 81 E0 FF 00 00 00 48 C1 E0 06 4C 01 D8"+ // rax = ring + (rcx & FF) << 6
+		"C7 40 48 02 00 00 00"+ // mov dword [rax+48],type
+		"4C 89 50 50"+ // mov [rax+50],r10
+		"48 8B 94 24 88 00 00 00 48 89 50 58"+ // mov [rax+58],original rcx
+		"48 89 DA 48 89 50 60"+ // mov [rax+60],rbx
+		"48 81 C1 01 00 00 00 48 89 48 40"+ // mov [rax+40],rcx+1
+		"0F 10 04 24 0F 10 4C 24 10 0F 10 54 24 20"+ // movups xmm0-xmm5,[rsp+i*10]
+		"0F 10 5C 24 30 0F 10 64 24 40 0F 10 6C 24 50"+
+		"48 81 C4 60 00 00 00 41 5B 41 5A 41 59 41 58 5A 59 58 9D") // restore registers and flags
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % X\nwant % X", got, want)
+	}
+
+	if _, err := ring.Hook(2, asm.RAX, asm.RCX, asm.RDX, asm.R8, asm.R9, asm.R10); err == nil {
+		t.Error("Hook with six payload registers succeeded")
+	}
+}
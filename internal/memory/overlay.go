@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+)
+
+// overlayPageSize is the granularity at which an Overlay copies memory it
+// writes to.
+const overlayPageSize = 0x1000
+
+// Overlay is a ReadWriter on top of a Reader that keeps writes and cave
+// allocations to itself. Over a Snapshot it stands in for the game, so
+// features can be applied, reverted and read back without one.
+type Overlay struct {
+	base Reader
+
+	mu sync.Mutex
+	// pages holds copies of the pages that were written to, by address.
+	pages     map[int64][]byte
+	allocator caveAllocator
+}
+
+func NewOverlay(base Reader) *Overlay {
+	return &Overlay{
+		base:  base,
+		pages: make(map[int64][]byte),
+	}
+}
+
+func (o *Overlay) ParseMemoryMaps() ([]MemoryRegion, error) {
+	return o.base.ParseMemoryMaps()
+}
+
+func (o *Overlay) ReadMemory(address int64, size int) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	buf := make([]byte, 0, size)
+	end := address + int64(size)
+	for cursor := address; cursor < end; {
+		page := cursor &^ (overlayPageSize - 1)
+		chunkEnd := min(end, page+overlayPageSize)
+
+		if data, written := o.pages[page]; written {
+			buf = append(buf, data[cursor-page:chunkEnd-page]...)
+		} else {
+			data, err := o.base.ReadMemory(cursor, int(chunkEnd-cursor))
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, data...)
+		}
+		cursor = chunkEnd
+	}
+	return buf, nil
+}
+
+func (o *Overlay) WriteMemory(address int64, data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	end := address + int64(len(data))
+	for cursor := address; cursor < end; {
+		page := cursor &^ (overlayPageSize - 1)
+		chunkEnd := min(end, page+overlayPageSize)
+
+		copied, written := o.pages[page]
+		if !written {
+			var err error
+			if copied, err = o.base.ReadMemory(page, overlayPageSize); err != nil {
+				return fmt.Errorf("address 0x%X is not mapped: %v", cursor, err)
+			}
+			o.pages[page] = copied
+		}
+		copy(copied[cursor-page:chunkEnd-page], data[cursor-address:chunkEnd-address])
+		cursor = chunkEnd
+	}
+	return nil
+}
+
+func (o *Overlay) AllocateMemory(nearAddress int64, size int) (int64, error) {
+	regions, err := o.base.ParseMemoryMaps()
+	if err != nil {
+		return 0, err
+	}
+	return o.allocator.allocate(regions, nearAddress, size)
+}
+
+func (o *Overlay) FreeMemory(address int64, size int) error {
+	o.allocator.release(address, size)
+	return nil
+}
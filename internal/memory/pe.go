@@ -7,42 +7,83 @@ import (
 )
 
 type PEParser struct {
-	memory      *ProcessMemory
+	memory      Reader
 	baseAddress int64
 }
 
-func NewPEParser(memory *ProcessMemory, baseAddress int64) *PEParser {
+func NewPEParser(memory Reader, baseAddress int64) *PEParser {
 	return &PEParser{
 		memory:      memory,
 		baseAddress: baseAddress,
 	}
 }
 
-func (pe *PEParser) FindSection(sectionName string) (int64, int, error) {
+// PEHeader is the part of a module's PE headers used to identify a build.
+type PEHeader struct {
+	Machine          uint16
+	NumberOfSections uint16
+	// TimeDateStamp is the link time, which differs between game builds.
+	TimeDateStamp uint32
+	SizeOfImage   uint32
+}
+
+// readCOFFHeader validates the DOS and PE signatures and returns the offset of
+// the PE signature and the 24 bytes of signature and COFF header.
+func (pe *PEParser) readCOFFHeader() (int64, []byte, error) {
 	dosHeader, err := pe.memory.ReadMemory(pe.baseAddress, 64)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read DOS header: %v", err)
+		return 0, nil, fmt.Errorf("failed to read DOS header: %v", err)
 	}
 
 	if dosHeader[0] != 0x4D || dosHeader[1] != 0x5A {
-		return 0, 0, fmt.Errorf("invalid DOS signature")
+		return 0, nil, fmt.Errorf("invalid DOS signature")
 	}
 
-	peHeaderOffset := binary.LittleEndian.Uint32(dosHeader[0x3C:])
+	peHeaderOffset := int64(binary.LittleEndian.Uint32(dosHeader[0x3C:]))
 
-	coffHeader, err := pe.memory.ReadMemory(pe.baseAddress+int64(peHeaderOffset), 24)
+	coffHeader, err := pe.memory.ReadMemory(pe.baseAddress+peHeaderOffset, 24)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read COFF header: %v", err)
+		return 0, nil, fmt.Errorf("failed to read COFF header: %v", err)
 	}
 
 	if coffHeader[0] != 0x50 || coffHeader[1] != 0x45 || coffHeader[2] != 0x00 || coffHeader[3] != 0x00 {
-		return 0, 0, fmt.Errorf("invalid PE signature")
+		return 0, nil, fmt.Errorf("invalid PE signature")
+	}
+
+	return peHeaderOffset, coffHeader, nil
+}
+
+// Header reads and validates the module's PE headers.
+func (pe *PEParser) Header() (PEHeader, error) {
+	peHeaderOffset, coffHeader, err := pe.readCOFFHeader()
+	if err != nil {
+		return PEHeader{}, err
+	}
+
+	// SizeOfImage is at the same offset in PE32 and PE32+ optional headers.
+	optionalHeader, err := pe.memory.ReadMemory(pe.baseAddress+peHeaderOffset+24, 60)
+	if err != nil {
+		return PEHeader{}, fmt.Errorf("failed to read optional header: %v", err)
+	}
+
+	return PEHeader{
+		Machine:          binary.LittleEndian.Uint16(coffHeader[4:]),
+		NumberOfSections: binary.LittleEndian.Uint16(coffHeader[6:]),
+		TimeDateStamp:    binary.LittleEndian.Uint32(coffHeader[8:]),
+		SizeOfImage:      binary.LittleEndian.Uint32(optionalHeader[56:]),
+	}, nil
+}
+
+func (pe *PEParser) FindSection(sectionName string) (int64, int, error) {
+	peHeaderOffset, coffHeader, err := pe.readCOFFHeader()
+	if err != nil {
+		return 0, 0, err
 	}
 
 	numberOfSections := binary.LittleEndian.Uint16(coffHeader[6:])
 	sizeOfOptionalHeader := binary.LittleEndian.Uint16(coffHeader[20:])
 
-	sectionHeadersOffset := pe.baseAddress + int64(peHeaderOffset) + 4 + 20 + int64(sizeOfOptionalHeader)
+	sectionHeadersOffset := pe.baseAddress + peHeaderOffset + 4 + 20 + int64(sizeOfOptionalHeader)
 
 	for i := uint16(0); i < numberOfSections; i++ {
 		sectionHeader, err := pe.memory.ReadMemory(sectionHeadersOffset+int64(i)*40, 40)
@@ -2,11 +2,15 @@ package memory
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"go.uber.org/zap"
@@ -14,19 +18,74 @@ import (
 	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
 )
 
+// ErrProcessGone is returned for a process that exited, including when its
+// pid now belongs to another process.
+var ErrProcessGone = errors.New("process exited")
+
 type ProcessMemory struct {
-	PID               int
-	allocationOffsets map[int64]int64
+	PID int
+	// startTime identifies the process, so a recycled pid is never written
+	// to. It is 0 if the start time could not be read.
+	startTime uint64
+	gone      atomic.Bool
+	allocator caveAllocator
+	callMu    sync.Mutex
+}
+
+// allocation is a block of cave memory handed out by AllocateMemory.
+type allocation struct {
+	address int64
+	size    int64
 }
 
 func NewProcessMemory(pid int) *ProcessMemory {
+	startTime, _ := ProcessStartTime(pid)
 	return &ProcessMemory{
-		PID:               pid,
-		allocationOffsets: make(map[int64]int64),
+		PID:       pid,
+		startTime: startTime,
 	}
 }
 
+// StartTime returns when the process started, in clock ticks since boot, as
+// read when pm was created.
+func (pm *ProcessMemory) StartTime() uint64 {
+	return pm.startTime
+}
+
+// Alive reports whether the process pm was created for still runs. Once it
+// returns false it always does, even if the pid is reused.
+func (pm *ProcessMemory) Alive() bool {
+	return pm.checkAlive() == nil
+}
+
+// checkAlive fails with ErrProcessGone once the process exited or its pid
+// belongs to a process started later.
+func (pm *ProcessMemory) checkAlive() error {
+	if pm.gone.Load() {
+		return ErrProcessGone
+	}
+	started, err := ProcessStartTime(pm.PID)
+	if err != nil || (pm.startTime != 0 && started != pm.startTime) {
+		pm.gone.Store(true)
+		logger.Log.Debug("Process is gone", zap.Int("pid", pm.PID))
+		return ErrProcessGone
+	}
+	return nil
+}
+
+// MarkGone makes every later access fail with ErrProcessGone, e.g. once an
+// ExitWatcher reported the exit.
+func (pm *ProcessMemory) MarkGone() {
+	pm.gone.Store(true)
+}
+
 func (pm *ProcessMemory) ReadMemory(address int64, size int) ([]byte, error) {
+	// Reads only check the flag; reading /proc for every read would slow
+	// down scans, and nothing is changed in a process that took the pid.
+	if pm.gone.Load() {
+		return nil, ErrProcessGone
+	}
+
 	buf := make([]byte, size)
 
 	local := syscall.Iovec{
@@ -62,6 +121,10 @@ func (pm *ProcessMemory) ReadMemory(address int64, size int) ([]byte, error) {
 }
 
 func (pm *ProcessMemory) WriteMemory(address int64, data []byte) error {
+	if err := pm.checkAlive(); err != nil {
+		return err
+	}
+
 	size := len(data)
 
 	local := syscall.Iovec{
@@ -122,10 +185,10 @@ func (pm *ProcessMemory) writeMemoryViaProcMem(address int64, data []byte) error
 }
 
 type MemoryRegion struct {
-	Start       int64
-	End         int64
-	Permissions string
-	Path        string
+	Start       int64  `json:"start"`
+	End         int64  `json:"end"`
+	Permissions string `json:"permissions"`
+	Path        string `json:"path"`
 }
 
 func (mr *MemoryRegion) IsReadable() bool {
@@ -192,98 +255,308 @@ func (pm *ProcessMemory) ParseMemoryMaps() ([]MemoryRegion, error) {
 }
 
 func (pm *ProcessMemory) GetModuleBaseAddress(moduleName string) (int64, error) {
+	return FindModuleBaseAddress(pm, moduleName)
+}
+
+func (pm *ProcessMemory) GetModuleSize(moduleName string) (int, error) {
+	return FindModuleSize(pm, moduleName)
+}
+
+func (pm *ProcessMemory) AllocateMemory(nearAddress int64, size int) (int64, error) {
 	regions, err := pm.ParseMemoryMaps()
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse maps: %v", err)
+		return 0, err
 	}
+	return pm.allocator.allocate(regions, nearAddress, size)
+}
 
-	searchSuffix := strings.ToLower(moduleName) + ".exe"
-	logger.Log.Debug("Searching for module",
-		zap.String("module", moduleName),
-		zap.String("suffix", searchSuffix),
-		zap.Int("regions", len(regions)))
+var errNoWritableRegion = errors.New("no suitable writable regions found")
 
-	for _, region := range regions {
-		if region.Path != "" && strings.HasSuffix(strings.ToLower(region.Path), searchSuffix) {
-			logger.Log.Info("Found module",
-				zap.String("module", moduleName),
-				zap.String("address", fmt.Sprintf("0x%X", region.Start)),
-				zap.String("path", region.Path))
-			return region.Start, nil
+// caveAllocator hands out cave memory from writable regions within reach of a
+// rel32 jump, and reuses freed blocks.
+type caveAllocator struct {
+	mu sync.Mutex
+	// offsets tracks how much of each region, by start address, has been
+	// handed out.
+	offsets map[int64]int64
+	free    []allocation
+}
+
+func (a *caveAllocator) allocate(regions []MemoryRegion, nearAddress int64, size int) (int64, error) {
+	minAddress := nearAddress - 0x70000000
+	maxAddress := nearAddress + 0x70000000
+	alignedSize := int64((size + 15) &^ 15)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, block := range a.free {
+		if block.size < alignedSize || block.address < minAddress || block.address >= maxAddress {
+			continue
+		}
+
+		if block.size == alignedSize {
+			a.free = append(a.free[:i], a.free[i+1:]...)
+		} else {
+			a.free[i] = allocation{address: block.address + alignedSize, size: block.size - alignedSize}
 		}
+		return block.address, nil
 	}
 
+	if a.offsets == nil {
+		a.offsets = make(map[int64]int64)
+	}
+	if address, ok := allocateFromRegions(regions, a.offsets, minAddress, maxAddress, alignedSize); ok {
+		return address, nil
+	}
+
+	return 0, errNoWritableRegion
+}
+
+// release returns a block so later allocations can reuse it.
+func (a *caveAllocator) release(address int64, size int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.free = append(a.free, allocation{address: address, size: int64((size + 15) &^ 15)})
+}
+
+func (a *caveAllocator) state() AllocatorState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state := AllocatorState{Offsets: make(map[int64]int64, len(a.offsets))}
+	for start, offset := range a.offsets {
+		state.Offsets[start] = offset
+	}
+	for _, block := range a.free {
+		state.Free = append(state.Free, FreeBlock{Address: block.address, Size: block.size})
+	}
+	return state
+}
+
+func (a *caveAllocator) restore(state AllocatorState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.offsets = make(map[int64]int64, len(state.Offsets))
+	for start, offset := range state.Offsets {
+		a.offsets[start] = offset
+	}
+	a.free = nil
+	for _, block := range state.Free {
+		a.free = append(a.free, allocation{address: block.Address, size: block.Size})
+	}
+}
+
+// allocateFromRegions carves size bytes out of the first writable,
+// non-executable region starting in [minAddress, maxAddress). offsets tracks
+// how much of each region has been handed out.
+func allocateFromRegions(regions []MemoryRegion, offsets map[int64]int64, minAddress, maxAddress, size int64) (int64, bool) {
 	for _, region := range regions {
-		if region.Path != "" && strings.Contains(strings.ToLower(region.Path), strings.ToLower(moduleName)) {
-			logger.Log.Info("Found module (contains match)",
-				zap.String("module", moduleName),
-				zap.String("address", fmt.Sprintf("0x%X", region.Start)),
-				zap.String("path", region.Path))
-			return region.Start, nil
+		if !region.IsWritable() ||
+			region.IsExecutable() ||
+			region.Start < minAddress ||
+			region.Start >= maxAddress {
+			continue
+		}
+
+		offset, exists := offsets[region.Start]
+		if !exists {
+			offset = 0x1000
 		}
+
+		// Skip regions that are already full rather than writing past them.
+		if region.Start+offset+size > region.End {
+			continue
+		}
+
+		offsets[region.Start] = offset + size
+		return region.Start + offset, true
 	}
 
-	return 0, fmt.Errorf("module %s not found (searched %d regions)", moduleName, len(regions))
+	return 0, false
 }
 
-func (pm *ProcessMemory) GetModuleSize(moduleName string) (int, error) {
-	baseAddress, err := pm.GetModuleBaseAddress(moduleName)
+// AllocatorState is the bookkeeping of ProcessMemory's cave allocator. It is
+// saved alongside caves so that a later tweaker process does not hand out
+// memory that is still in use.
+type AllocatorState struct {
+	// Offsets maps region start addresses to how much of each region has
+	// been handed out.
+	Offsets map[int64]int64 `json:"offsets"`
+	Free    []FreeBlock     `json:"free,omitempty"`
+}
+
+// FreeBlock is a freed allocation available for reuse.
+type FreeBlock struct {
+	Address int64 `json:"address"`
+	Size    int64 `json:"size"`
+}
+
+func (pm *ProcessMemory) AllocatorState() AllocatorState {
+	return pm.allocator.state()
+}
+
+// RestoreAllocatorState replaces the allocator's bookkeeping. It must be
+// called before the first allocation.
+func (pm *ProcessMemory) RestoreAllocatorState(state AllocatorState) {
+	pm.allocator.restore(state)
+}
+
+// FreeMemory returns a block from AllocateMemory so later allocations can
+// reuse it.
+func (pm *ProcessMemory) FreeMemory(address int64, size int) error {
+	pm.allocator.release(address, size)
+	return nil
+}
+
+// statFields returns the fields of /proc/<pid>/stat after the command name,
+// so fields[0] is field 3 (state) of proc(5).
+func statFields(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name may contain spaces and parentheses, so fields are
+	// counted from the last closing parenthesis.
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return nil, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	return fields, nil
+}
+
+// ProcessStartTime returns when a process started, in clock ticks since boot.
+// Together with the pid it identifies a process even after the pid is reused.
+func ProcessStartTime(pid int) (uint64, error) {
+	fields, err := statFields(pid)
 	if err != nil {
 		return 0, err
 	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
 
-	regions, err := pm.ParseMemoryMaps()
+// ParentPID returns the pid of a process's parent.
+func ParentPID(pid int) (int, error) {
+	fields, err := statFields(pid)
 	if err != nil {
 		return 0, err
 	}
+	return strconv.Atoi(fields[1])
+}
 
-	for _, region := range regions {
-		if region.IsExecutable() && region.Start >= baseAddress && region.Start < baseAddress+0x10000 {
-			return int(region.End - baseAddress), nil
+// IsDescendant reports whether pid is ancestor or one of its descendants.
+func IsDescendant(pid, ancestor int) bool {
+	for pid > 1 {
+		if pid == ancestor {
+			return true
 		}
-	}
 
-	return 0, fmt.Errorf("executable section not found for %s", moduleName)
+		parent, err := ParentPID(pid)
+		if err != nil {
+			return false
+		}
+		pid = parent
+	}
+	return pid == ancestor
 }
 
-func (pm *ProcessMemory) AllocateMemory(nearAddress int64, size int) (int64, error) {
-	regions, err := pm.ParseMemoryMaps()
+// clockTicks is USER_HZ, the unit of times in /proc/<pid>/stat. It is 100 on
+// every architecture Linux supports.
+const clockTicks = 100
+
+// ProcessStartedAt returns the wall clock time a process started.
+func ProcessStartedAt(pid int) (time.Time, error) {
+	ticks, err := ProcessStartTime(pid)
 	if err != nil {
-		return 0, err
+		return time.Time{}, err
 	}
 
-	minAddress := nearAddress - 0x70000000
-	maxAddress := nearAddress + 0x70000000
-
-	var candidates []MemoryRegion
-	for _, region := range regions {
-		if region.IsWritable() &&
-			!region.IsExecutable() &&
-			region.Start >= minAddress &&
-			region.Start < maxAddress &&
-			(region.End-region.Start) >= int64(size) {
-			candidates = append(candidates, region)
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, "btime "); found {
+			boot, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime in /proc/stat: %v", err)
+			}
+			started := time.Unix(boot, 0).Add(time.Duration(ticks) * time.Second / clockTicks)
+			return started, nil
 		}
 	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
 
-	if len(candidates) == 0 {
-		return 0, fmt.Errorf("no suitable writable regions found")
+// ProcessComm returns a process's command name.
+func ProcessComm(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-	region := candidates[0]
-	regionStart := region.Start
+// ProcessEnviron returns a process's environment at the time it started.
+func ProcessEnviron(pid int) (map[string]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, err
+	}
 
-	offset, exists := pm.allocationOffsets[regionStart]
-	if !exists {
-		offset = 0x1000
+	environ := make(map[string]string)
+	for _, entry := range strings.Split(string(data), "\x00") {
+		if key, value, found := strings.Cut(entry, "="); found {
+			environ[key] = value
+		}
 	}
+	return environ, nil
+}
 
-	allocationAddress := regionStart + offset
+type processMatch int
 
-	alignedSize := int64((size + 15) &^ 15)
-	pm.allocationOffsets[regionStart] = offset + alignedSize
+const (
+	matchNone processMatch = iota
+	matchComm
+	matchCmdline
+)
 
-	return allocationAddress, nil
+// ProcessMatches reports whether FindProcessByName would return pid.
+func ProcessMatches(pid int, name string) bool {
+	return matchProcess(pid, name) != matchNone
+}
+
+// matchProcess matches the command name of pid exactly, with or without the
+// .exe suffix, or else its command line.
+func matchProcess(pid int, name string) processMatch {
+	commData, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return matchNone
+	}
+
+	comm := strings.TrimSpace(string(commData))
+	if strings.EqualFold(comm, name) || strings.EqualFold(comm, name+".exe") {
+		logger.Log.Debug("Found exact process match",
+			zap.Int("pid", pid),
+			zap.String("comm", comm))
+		return matchComm
+	}
+
+	cmdlineData, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return matchNone
+	}
+	if strings.Contains(strings.ToLower(string(cmdlineData)), strings.ToLower(name)+".exe") {
+		return matchCmdline
+	}
+	return matchNone
 }
 
 func FindProcessByName(name string) ([]int, error) {
@@ -305,30 +578,10 @@ func FindProcessByName(name string) ([]int, error) {
 			continue
 		}
 
-		commPath := fmt.Sprintf("/proc/%d/comm", pid)
-		commData, err := os.ReadFile(commPath)
-		if err != nil {
-			continue
-		}
-
-		comm := strings.TrimSpace(string(commData))
-
-		if strings.EqualFold(comm, name) || strings.EqualFold(comm, name+".exe") {
-			logger.Log.Debug("Found exact process match",
-				zap.Int("pid", pid),
-				zap.String("comm", comm))
+		switch matchProcess(pid, name) {
+		case matchComm:
 			exactMatches = append(exactMatches, pid)
-			continue
-		}
-
-		cmdlinePath := fmt.Sprintf("/proc/%d/cmdline", pid)
-		cmdlineData, err := os.ReadFile(cmdlinePath)
-		if err != nil {
-			continue
-		}
-
-		cmdline := string(cmdlineData)
-		if strings.Contains(strings.ToLower(cmdline), strings.ToLower(name)+".exe") {
+		case matchCmdline:
 			cmdlineMatches = append(cmdlineMatches, pid)
 		}
 	}
@@ -351,55 +604,22 @@ func FindProcessByName(name string) ([]int, error) {
 }
 
 func (pm *ProcessMemory) ReadInt32(address int64) (int32, error) {
-	data, err := pm.ReadMemory(address, 4)
-	if err != nil {
-		return 0, err
-	}
-	return int32(data[0]) | int32(data[1])<<8 | int32(data[2])<<16 | int32(data[3])<<24, nil
+	return ReadInt32(pm, address)
 }
 
 func (pm *ProcessMemory) ReadInt64(address int64) (int64, error) {
-	data, err := pm.ReadMemory(address, 8)
-	if err != nil {
-		return 0, err
-	}
-	return int64(data[0]) | int64(data[1])<<8 | int64(data[2])<<16 | int64(data[3])<<24 |
-		int64(data[4])<<32 | int64(data[5])<<40 | int64(data[6])<<48 | int64(data[7])<<56, nil
+	return ReadInt64(pm, address)
 }
 
 func (pm *ProcessMemory) ReadFloat32(address int64) (float32, error) {
-	data, err := pm.ReadMemory(address, 4)
-	if err != nil {
-		return 0, err
-	}
-	bits := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
-	return *(*float32)(unsafe.Pointer(&bits)), nil
+	return ReadFloat32(pm, address)
 }
 
 func (pm *ProcessMemory) WriteFloat32(address int64, value float32) error {
-	bits := *(*uint32)(unsafe.Pointer(&value))
-	data := []byte{
-		byte(bits),
-		byte(bits >> 8),
-		byte(bits >> 16),
-		byte(bits >> 24),
-	}
-	return pm.WriteMemory(address, data)
+	return WriteFloat32(pm, address, value)
 }
 
 // DereferenceStaticPointer dereferences a static x64 pointer (RIP-relative addressing)
-// This reads the instruction at instructionAddr and calculates the final address
 func (pm *ProcessMemory) DereferenceStaticPointer(instructionAddr int64, instructionLength int) (int64, error) {
-	// Read the 4-byte offset (at instructionAddr + 3 for most mov instructions)
-	offsetData, err := pm.ReadMemory(instructionAddr+3, 4)
-	if err != nil {
-		return 0, err
-	}
-	offset := int32(offsetData[0]) | int32(offsetData[1])<<8 | int32(offsetData[2])<<16 | int32(offsetData[3])<<24
-
-	// RIP-relative: address = instructionAddr + instructionLength + offset
-	targetAddr := instructionAddr + int64(instructionLength) + int64(offset)
-
-	// Read the pointer at the target address
-	return pm.ReadInt64(targetAddr)
+	return DereferenceStaticPointer(pm, instructionAddr, instructionLength)
 }
@@ -0,0 +1,340 @@
+//go:build linux
+// +build linux
+
+package memory
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Process connector constants from linux/connector.h and linux/cn_proc.h.
+const (
+	netlinkConnector = 11
+	cnIdxProc        = 1
+	cnValProc        = 1
+
+	procCnMcastListen = 1
+	procCnMcastIgnore = 2
+
+	nlmsgHdrLen = 16
+	cnMsgLen    = 20
+	// procEventHdrLen covers what, cpu and timestamp_ns of struct proc_event.
+	procEventHdrLen = 16
+)
+
+// ProcEventKind is the kind of a process connector event.
+type ProcEventKind uint32
+
+const (
+	// ProcEventLost means events were dropped because the reader fell
+	// behind, so anything may have changed.
+	ProcEventLost ProcEventKind = 0
+	ProcEventFork ProcEventKind = 0x00000001
+	ProcEventExec ProcEventKind = 0x00000002
+	ProcEventComm ProcEventKind = 0x00000200
+	ProcEventExit ProcEventKind = 0x80000000
+)
+
+// ProcEvent is a process starting, changing its name or exiting. Other
+// kinds of events are not reported.
+type ProcEvent struct {
+	Kind ProcEventKind
+	// PID is the process (thread group) the event belongs to, and the child
+	// for forks.
+	PID int
+	// TID is the thread, which differs from PID for threads.
+	TID int
+	// Comm is the new name, for ProcEventComm.
+	Comm string
+}
+
+// ProcConnector receives fork, exec, comm and exit events of every process
+// from the kernel's netlink process connector. Subscribing needs
+// CAP_NET_ADMIN.
+type ProcConnector struct {
+	file   *os.File
+	events chan ProcEvent
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenProcConnector subscribes to process events.
+func OpenProcConnector() (*ProcConnector, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, netlinkConnector)
+	if err != nil {
+		return nil, fmt.Errorf("netlink connector: %v", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: cnIdxProc, Pid: 0}
+	if err := syscall.Bind(fd, addr); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("bind netlink connector: %v", err)
+	}
+	if err := sendProcControl(fd, procCnMcastListen); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("subscribe to process events: %v", err)
+	}
+
+	c := &ProcConnector{
+		// As for inotify, a non-blocking descriptor lets Close wake the
+		// reader.
+		file:   os.NewFile(uintptr(fd), "proc-connector"),
+		events: make(chan ProcEvent, 64),
+		stop:   make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+// Events returns the events. The channel is closed once the connector is
+// closed or fails.
+func (c *ProcConnector) Events() <-chan ProcEvent {
+	return c.events
+}
+
+func (c *ProcConnector) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		if raw, rawErr := c.file.SyscallConn(); rawErr == nil {
+			_ = raw.Control(func(fd uintptr) { _ = sendProcControl(int(fd), procCnMcastIgnore) })
+		}
+		err = c.file.Close()
+	})
+	return err
+}
+
+// sendProcControl sends a PROC_CN_MCAST_* operation to the connector.
+func sendProcControl(fd int, op uint32) error {
+	msg := make([]byte, nlmsgHdrLen+cnMsgLen+4)
+	order := binary.NativeEndian
+
+	order.PutUint32(msg[0:], uint32(len(msg)))
+	order.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	order.PutUint32(msg[12:], uint32(os.Getpid()))
+
+	cn := msg[nlmsgHdrLen:]
+	order.PutUint32(cn[0:], cnIdxProc)
+	order.PutUint32(cn[4:], cnValProc)
+	order.PutUint16(cn[16:], 4)
+	order.PutUint32(cn[cnMsgLen:], op)
+
+	return syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+func (c *ProcConnector) read() {
+	defer close(c.events)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.file.Read(buf)
+		if err != nil {
+			if errors.Is(err, syscall.ENOBUFS) && c.send(ProcEvent{Kind: ProcEventLost}) {
+				continue
+			}
+			return
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, message := range messages {
+			if event, ok := parseProcEvent(message.Data); ok && !c.send(event) {
+				return
+			}
+		}
+	}
+}
+
+// send delivers an event and returns false once the connector is closed.
+func (c *ProcConnector) send(event ProcEvent) bool {
+	select {
+	case c.events <- event:
+		return true
+	case <-c.stop:
+		return false
+	}
+}
+
+// parseProcEvent decodes the cn_msg carrying a struct proc_event.
+func parseProcEvent(data []byte) (ProcEvent, bool) {
+	if len(data) < cnMsgLen+procEventHdrLen+8 {
+		return ProcEvent{}, false
+	}
+	order := binary.NativeEndian
+	if order.Uint32(data[0:]) != cnIdxProc || order.Uint32(data[4:]) != cnValProc {
+		return ProcEvent{}, false
+	}
+
+	event := data[cnMsgLen:]
+	body := event[procEventHdrLen:]
+	switch kind := ProcEventKind(order.Uint32(event[0:])); kind {
+	case ProcEventFork:
+		// parent_pid, parent_tgid, child_pid, child_tgid
+		if len(body) < 16 {
+			return ProcEvent{}, false
+		}
+		return ProcEvent{Kind: kind, TID: int(order.Uint32(body[8:])), PID: int(order.Uint32(body[12:]))}, true
+	case ProcEventExec, ProcEventExit:
+		// process_pid, process_tgid, ...
+		return ProcEvent{Kind: kind, TID: int(order.Uint32(body[0:])), PID: int(order.Uint32(body[4:]))}, true
+	case ProcEventComm:
+		// process_pid, process_tgid, comm[16]
+		if len(body) < 24 {
+			return ProcEvent{}, false
+		}
+		comm := string(body[8:24])
+		if end := strings.IndexByte(comm, 0); end >= 0 {
+			comm = comm[:end]
+		}
+		return ProcEvent{Kind: kind, TID: int(order.Uint32(body[0:])), PID: int(order.Uint32(body[4:])), Comm: comm}, true
+	}
+	return ProcEvent{}, false
+}
+
+// sysPidfdOpen is pidfd_open(2), which has the same number on every
+// architecture.
+const sysPidfdOpen = 434
+
+// exitPollInterval is how often an ExitWatcher checks the process when
+// pidfds are not supported.
+const exitPollInterval = 250 * time.Millisecond
+
+// ExitWatcher reports when a process exits. It uses a pidfd, so the exit is
+// noticed immediately, and falls back to polling on kernels before 5.3.
+type ExitWatcher struct {
+	pid       int
+	startTime uint64
+	done      chan struct{}
+
+	// wake interrupts the poll on the pidfd when the watcher is closed.
+	wake      *os.File
+	wakeWrite *os.File
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// WatchExit watches the process pid that started at startTime, in clock ticks
+// since boot as returned by ProcessStartTime. A process that already exited,
+// or whose pid was reused, is reported at once.
+func WatchExit(pid int, startTime uint64) (*ExitWatcher, error) {
+	w := &ExitWatcher{
+		pid:       pid,
+		startTime: startTime,
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+
+	pidfd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno == syscall.ESRCH {
+		close(w.done)
+		return w, nil
+	}
+	if errno != 0 {
+		go w.poll()
+		return w, nil
+	}
+
+	// The pidfd refers to whatever process had the pid when it was opened,
+	// so it is checked to still be the one asked for.
+	if !w.running() {
+		_ = syscall.Close(int(pidfd))
+		close(w.done)
+		return w, nil
+	}
+
+	var err error
+	w.wake, w.wakeWrite, err = os.Pipe()
+	if err != nil {
+		_ = syscall.Close(int(pidfd))
+		return nil, err
+	}
+	go w.wait(int(pidfd))
+	return w, nil
+}
+
+// Done is closed once the process exited.
+func (w *ExitWatcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// Close stops watching. Done is not closed unless the process exited.
+func (w *ExitWatcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		if w.wakeWrite != nil {
+			_ = w.wakeWrite.Close()
+		}
+	})
+}
+
+func (w *ExitWatcher) running() bool {
+	started, err := ProcessStartTime(w.pid)
+	return err == nil && (w.startTime == 0 || started == w.startTime)
+}
+
+// pollFd is struct pollfd.
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const pollIn = 0x1
+
+func (w *ExitWatcher) wait(pidfd int) {
+	defer func() { _ = syscall.Close(pidfd) }()
+	defer func() { _ = w.wake.Close() }()
+
+	// A pidfd becomes readable when the process exits. The pipe becomes
+	// readable when its write end is closed by Close.
+	fds := []pollFd{
+		{fd: int32(pidfd), events: pollIn},
+		{fd: int32(w.wake.Fd()), events: pollIn},
+	}
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), 0, 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			// Without a working pidfd the process can still be polled.
+			w.poll()
+			return
+		}
+		if fds[1].revents != 0 {
+			return
+		}
+		if fds[0].revents != 0 {
+			close(w.done)
+			return
+		}
+	}
+}
+
+func (w *ExitWatcher) poll() {
+	ticker := time.NewTicker(exitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if !w.running() {
+			close(w.done)
+			return
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
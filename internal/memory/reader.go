@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// Reader is the read side of a process address space. It is implemented by
+// ProcessMemory for a live game and by Snapshot for offline replay.
+type Reader interface {
+	ReadMemory(address int64, size int) ([]byte, error)
+	ParseMemoryMaps() ([]MemoryRegion, error)
+}
+
+// ReadWriter is a Reader that can also modify the address space and hand out
+// cave memory.
+type ReadWriter interface {
+	Reader
+	WriteMemory(address int64, data []byte) error
+	AllocateMemory(nearAddress int64, size int) (int64, error)
+	FreeMemory(address int64, size int) error
+}
+
+func FindModuleBaseAddress(r Reader, moduleName string) (int64, error) {
+	regions, err := r.ParseMemoryMaps()
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse maps: %v", err)
+	}
+
+	searchSuffix := strings.ToLower(moduleName) + ".exe"
+	logger.Log.Debug("Searching for module",
+		zap.String("module", moduleName),
+		zap.String("suffix", searchSuffix),
+		zap.Int("regions", len(regions)))
+
+	for _, region := range regions {
+		if region.Path != "" && strings.HasSuffix(strings.ToLower(region.Path), searchSuffix) {
+			logger.Log.Debug("Found module",
+				zap.String("module", moduleName),
+				zap.String("address", fmt.Sprintf("0x%X", region.Start)),
+				zap.String("path", region.Path))
+			return region.Start, nil
+		}
+	}
+
+	for _, region := range regions {
+		if region.Path != "" && strings.Contains(strings.ToLower(region.Path), strings.ToLower(moduleName)) {
+			logger.Log.Debug("Found module (contains match)",
+				zap.String("module", moduleName),
+				zap.String("address", fmt.Sprintf("0x%X", region.Start)),
+				zap.String("path", region.Path))
+			return region.Start, nil
+		}
+	}
+
+	return 0, fmt.Errorf("module %s not found (searched %d regions)", moduleName, len(regions))
+}
+
+func FindModuleSize(r Reader, moduleName string) (int, error) {
+	baseAddress, err := FindModuleBaseAddress(r, moduleName)
+	if err != nil {
+		return 0, err
+	}
+
+	regions, err := r.ParseMemoryMaps()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, region := range regions {
+		if region.IsExecutable() && region.Start >= baseAddress && region.Start < baseAddress+0x10000 {
+			return int(region.End - baseAddress), nil
+		}
+	}
+
+	return 0, fmt.Errorf("executable section not found for %s", moduleName)
+}
+
+func ReadInt32(r Reader, address int64) (int32, error) {
+	data, err := r.ReadMemory(address, 4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(data)), nil
+}
+
+func ReadInt64(r Reader, address int64) (int64, error) {
+	data, err := r.ReadMemory(address, 8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(data)), nil
+}
+
+func ReadFloat32(r Reader, address int64) (float32, error) {
+	data, err := r.ReadMemory(address, 4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(data)), nil
+}
+
+func WriteFloat32(w ReadWriter, address int64, value float32) error {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, math.Float32bits(value))
+	return w.WriteMemory(address, data)
+}
+
+// DereferenceStaticPointer dereferences a static x64 pointer (RIP-relative addressing)
+// This reads the instruction at instructionAddr and calculates the final address
+func DereferenceStaticPointer(r Reader, instructionAddr int64, instructionLength int) (int64, error) {
+	// Read the 4-byte offset (at instructionAddr + 3 for most mov instructions)
+	offset, err := ReadInt32(r, instructionAddr+3)
+	if err != nil {
+		return 0, err
+	}
+
+	// RIP-relative: address = instructionAddr + instructionLength + offset
+	targetAddr := instructionAddr + int64(instructionLength) + int64(offset)
+
+	// Read the pointer at the target address
+	return ReadInt64(r, targetAddr)
+}
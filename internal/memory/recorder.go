@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"sync"
+)
+
+// RecordedWrite is a write a Recorder intercepted.
+type RecordedWrite struct {
+	Address int64
+	// Current is what the address held before the write, or nil if it could
+	// not be read or lies in planned cave memory.
+	Current  []byte
+	Intended []byte
+	// Cave is set for writes into memory the Recorder allocated.
+	Cave bool
+}
+
+// RecordedAllocation is cave memory a Recorder planned to hand out.
+type RecordedAllocation struct {
+	Address int64
+	Size    int
+}
+
+// Recorder is a ReadWriter that records writes and allocations instead of
+// performing them. Reads see the recorded writes, so code that patches and
+// then reads back behaves as it would against the live process.
+//
+// Allocations are planned with the same region selection as ProcessMemory,
+// starting from the state passed to RestoreAllocatorState.
+type Recorder struct {
+	reader Reader
+
+	mu          sync.Mutex
+	overlay     []RecordedWrite
+	writes      []RecordedWrite
+	allocations []RecordedAllocation
+	taken       int
+	offsets     map[int64]int64
+	regions     []MemoryRegion
+}
+
+func NewRecorder(reader Reader) *Recorder {
+	return &Recorder{
+		reader:  reader,
+		offsets: make(map[int64]int64),
+	}
+}
+
+func (r *Recorder) ParseMemoryMaps() ([]MemoryRegion, error) {
+	return r.reader.ParseMemoryMaps()
+}
+
+func (r *Recorder) ReadMemory(address int64, size int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.read(address, size)
+}
+
+func (r *Recorder) read(address int64, size int) ([]byte, error) {
+	data, err := r.reader.ReadMemory(address, size)
+	if err != nil {
+		if !r.planned(address, size) {
+			return nil, err
+		}
+		data = make([]byte, size)
+	}
+
+	for _, write := range r.overlay {
+		start := max(address, write.Address)
+		end := min(address+int64(size), write.Address+int64(len(write.Intended)))
+		if start < end {
+			copy(data[start-address:end-address], write.Intended[start-write.Address:])
+		}
+	}
+
+	return data, nil
+}
+
+// planned reports whether a range lies inside a planned allocation.
+func (r *Recorder) planned(address int64, size int) bool {
+	for _, allocation := range r.allocations {
+		if address >= allocation.Address && address+int64(size) <= allocation.Address+int64(allocation.Size) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recorder) WriteMemory(address int64, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cave := r.planned(address, len(data))
+	current, err := r.read(address, len(data))
+	if err != nil || cave {
+		current = nil
+	}
+
+	write := RecordedWrite{
+		Address:  address,
+		Current:  current,
+		Intended: append([]byte(nil), data...),
+		Cave:     cave,
+	}
+	r.overlay = append(r.overlay, write)
+	r.writes = append(r.writes, write)
+	return nil
+}
+
+func (r *Recorder) AllocateMemory(nearAddress int64, size int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.regions == nil {
+		regions, err := r.reader.ParseMemoryMaps()
+		if err != nil {
+			return 0, err
+		}
+		r.regions = regions
+	}
+
+	alignedSize := int64((size + 15) &^ 15)
+	address, ok := allocateFromRegions(r.regions, r.offsets, nearAddress-0x70000000, nearAddress+0x70000000, alignedSize)
+	if !ok {
+		return 0, errNoWritableRegion
+	}
+
+	r.allocations = append(r.allocations, RecordedAllocation{Address: address, Size: int(alignedSize)})
+	return address, nil
+}
+
+// AllocatorState returns the planned allocator bookkeeping.
+func (r *Recorder) AllocatorState() AllocatorState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := AllocatorState{Offsets: make(map[int64]int64, len(r.offsets))}
+	for start, offset := range r.offsets {
+		state.Offsets[start] = offset
+	}
+	return state
+}
+
+// RestoreAllocatorState continues planning from a live allocator's
+// bookkeeping, so planned caves do not overlap caves already in use. Free
+// blocks are ignored.
+func (r *Recorder) RestoreAllocatorState(state AllocatorState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.offsets = make(map[int64]int64, len(state.Offsets))
+	for start, offset := range state.Offsets {
+		r.offsets[start] = offset
+	}
+}
+
+// FreeMemory does nothing. Planned allocations are never reused, so every
+// cave in a plan gets its own address.
+func (r *Recorder) FreeMemory(address int64, size int) error {
+	return nil
+}
+
+// Take returns the writes and allocations recorded since the last call. Reads
+// keep seeing every write.
+func (r *Recorder) Take() ([]RecordedWrite, []RecordedAllocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writes := r.writes
+	r.writes = nil
+
+	var allocations []RecordedAllocation
+	if r.taken < len(r.allocations) {
+		allocations = append(allocations, r.allocations[r.taken:]...)
+		r.taken = len(r.allocations)
+	}
+	return writes, allocations
+}
@@ -0,0 +1,201 @@
+//go:build linux
+// +build linux
+
+package memory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const (
+	ptraceSeize     = 0x4206
+	ptraceInterrupt = 0x4207
+
+	// remoteCallTrapAddress is pushed as the return address of a remote call.
+	// Returning to it faults with RIP == 0, which the tracer intercepts before
+	// the game's own signal handlers run. vm.mmap_min_addr keeps it unmapped.
+	remoteCallTrapAddress = 0
+
+	// remoteCallStackSkip leaves room below the interrupted stack pointer so
+	// the hijacked frame never overlaps data the thread is still using.
+	remoteCallStackSkip = 0x200
+	shadowSpaceSize     = 0x20
+
+	RemoteCallTimeout = 5 * time.Second
+)
+
+// CallRemote calls a function in the game's main thread using the Windows x64
+// calling convention and returns the value left in RAX.
+func (pm *ProcessMemory) CallRemote(address int64, args ...uint64) (uint64, error) {
+	return pm.CallRemoteOnThread(pm.PID, address, args...)
+}
+
+// CallRemoteOnThread hijacks thread tid with ptrace, runs the function at
+// address with args in RCX, RDX, R8, R9 and on the stack after the shadow
+// space, and restores the thread's registers once the call returns.
+func (pm *ProcessMemory) CallRemoteOnThread(tid int, address int64, args ...uint64) (uint64, error) {
+	pm.callMu.Lock()
+	defer pm.callMu.Unlock()
+
+	// ptrace requests must come from the thread that attached.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := ptraceRaw(ptraceSeize, tid, 0, 0); err != nil {
+		return 0, fmt.Errorf("ptrace seize failed: %v", err)
+	}
+	defer func() { _ = syscall.PtraceDetach(tid) }()
+
+	// A traced thread keeps its id until it is detached, so the check cannot
+	// race with the pid being reused.
+	if err := pm.checkAlive(); err != nil {
+		return 0, err
+	}
+
+	if err := ptraceRaw(ptraceInterrupt, tid, 0, 0); err != nil {
+		return 0, fmt.Errorf("ptrace interrupt failed: %v", err)
+	}
+	if _, err := waitStopped(tid, RemoteCallTimeout); err != nil {
+		return 0, err
+	}
+
+	var saved syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(tid, &saved); err != nil {
+		return 0, fmt.Errorf("failed to read registers: %v", err)
+	}
+
+	regs, frame := remoteCallFrame(saved, address, args)
+	if err := pm.WriteMemory(int64(regs.Rsp), frame); err != nil {
+		return 0, fmt.Errorf("failed to write call frame: %v", err)
+	}
+
+	if err := syscall.PtraceSetRegs(tid, &regs); err != nil {
+		return 0, fmt.Errorf("failed to set registers: %v", err)
+	}
+
+	result, callErr := runRemoteCall(tid)
+
+	if err := syscall.PtraceSetRegs(tid, &saved); err != nil {
+		return 0, fmt.Errorf("failed to restore registers: %v", err)
+	}
+
+	return result, callErr
+}
+
+// remoteCallFrame returns the registers that start the call and the frame to
+// write at their RSP: the trap return address, the shadow space and the
+// arguments after the fourth.
+func remoteCallFrame(saved syscall.PtraceRegs, address int64, args []uint64) (syscall.PtraceRegs, []byte) {
+	regs := saved
+
+	var stackArgs []uint64
+	if len(args) > 4 {
+		stackArgs = args[4:]
+	}
+
+	// At function entry RSP+8 must be 16-byte aligned: [RSP] holds the return
+	// address, followed by the shadow space and any stack arguments.
+	frameSize := uint64(shadowSpaceSize + 8*len(stackArgs))
+	rsp := ((saved.Rsp-remoteCallStackSkip-frameSize)&^0xF - 8)
+
+	frame := make([]byte, 8+frameSize)
+	binary.LittleEndian.PutUint64(frame, remoteCallTrapAddress)
+	for i, arg := range stackArgs {
+		binary.LittleEndian.PutUint64(frame[8+shadowSpaceSize+8*i:], arg)
+	}
+
+	argRegs := []*uint64{&regs.Rcx, &regs.Rdx, &regs.R8, &regs.R9}
+	for i, arg := range args {
+		if i >= len(argRegs) {
+			break
+		}
+		*argRegs[i] = arg
+	}
+
+	regs.Rsp = rsp
+	regs.Rip = uint64(address)
+	regs.Rax = 0
+	// Prevent the kernel from restarting an interrupted syscall at our RIP.
+	regs.Orig_rax = ^uint64(0)
+
+	return regs, frame
+}
+
+// runRemoteCall resumes the hijacked thread until it returns into the trap
+// address, forwarding any unrelated signals the game receives meanwhile.
+func runRemoteCall(tid int) (uint64, error) {
+	deadline := time.Now().Add(RemoteCallTimeout)
+	signal := 0
+
+	for {
+		if err := syscall.PtraceCont(tid, signal); err != nil {
+			return 0, fmt.Errorf("ptrace cont failed: %v", err)
+		}
+
+		status, err := waitStopped(tid, time.Until(deadline))
+		if err != nil {
+			return 0, err
+		}
+
+		var regs syscall.PtraceRegs
+		if err := syscall.PtraceGetRegs(tid, &regs); err != nil {
+			return 0, fmt.Errorf("failed to read registers: %v", err)
+		}
+
+		switch sig := status.StopSignal(); {
+		case sig == syscall.SIGSEGV && regs.Rip == remoteCallTrapAddress:
+			return regs.Rax, nil
+		case sig == syscall.SIGSEGV:
+			return 0, fmt.Errorf("remote call crashed at 0x%X", regs.Rip)
+		case sig == syscall.SIGTRAP || sig == syscall.SIGSTOP:
+			signal = 0
+		default:
+			signal = int(sig)
+		}
+	}
+}
+
+// waitStopped polls until tid enters a ptrace stop. On timeout the thread is
+// interrupted so the caller can still restore its registers.
+func waitStopped(tid int, timeout time.Duration) (syscall.WaitStatus, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(tid, &status, syscall.WALL|syscall.WNOHANG, nil)
+		if err != nil {
+			return status, fmt.Errorf("wait failed: %v", err)
+		}
+
+		if pid == tid {
+			if status.Exited() || status.Signaled() {
+				return status, fmt.Errorf("thread %d exited during remote call", tid)
+			}
+			if status.Stopped() {
+				return status, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			_ = ptraceRaw(ptraceInterrupt, tid, 0, 0)
+			if _, err := syscall.Wait4(tid, &status, syscall.WALL, nil); err != nil {
+				return status, fmt.Errorf("wait failed: %v", err)
+			}
+			return status, fmt.Errorf("remote call timed out after %v", timeout)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func ptraceRaw(request, pid int, addr, data uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, uintptr(request), uintptr(pid), addr, data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+//go:build linux
+// +build linux
+
+package memory
+
+import (
+	"encoding/binary"
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/asm"
+)
+
+func TestRemoteCallFrame(t *testing.T) {
+	saved := syscall.PtraceRegs{Rsp: 0x7FFE1234567C, Rip: 0x401000, Rax: 0xDEAD, Rbx: 0xBEEF, Orig_rax: 35}
+
+	tests := []struct {
+		name string
+		args []uint64
+	}{
+		{"no arguments", nil},
+		{"register arguments", []uint64{1, 2, 3}},
+		{"stack arguments", []uint64{1, 2, 3, 4, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regs, frame := remoteCallFrame(saved, 0x140001000, tt.args)
+
+			if regs.Rip != 0x140001000 || regs.Rax != 0 || regs.Orig_rax != ^uint64(0) {
+				t.Errorf("rip, rax, orig_rax = 0x%X, 0x%X, 0x%X", regs.Rip, regs.Rax, regs.Orig_rax)
+			}
+			if regs.Rbx != saved.Rbx {
+				t.Errorf("rbx = 0x%X, want it untouched", regs.Rbx)
+			}
+			if (regs.Rsp+8)%16 != 0 {
+				t.Errorf("rsp = 0x%X, want rsp+8 16-byte aligned", regs.Rsp)
+			}
+			if regs.Rsp+uint64(len(frame)) > saved.Rsp-remoteCallStackSkip {
+				t.Errorf("frame 0x%X+0x%X overlaps the interrupted stack at 0x%X", regs.Rsp, len(frame), saved.Rsp)
+			}
+
+			want := []uint64{saved.Rcx, saved.Rdx, saved.R8, saved.R9}
+			copy(want, tt.args)
+			if got := []uint64{regs.Rcx, regs.Rdx, regs.R8, regs.R9}; !slices.Equal(got, want) {
+				t.Errorf("rcx, rdx, r8, r9 = %X, want %X", got, want)
+			}
+
+			if trap := binary.LittleEndian.Uint64(frame); trap != remoteCallTrapAddress {
+				t.Errorf("return address = 0x%X, want the trap", trap)
+			}
+			var stack []uint64
+			for offset := 8 + shadowSpaceSize; offset < len(frame); offset += 8 {
+				stack = append(stack, binary.LittleEndian.Uint64(frame[offset:]))
+			}
+			var wantStack []uint64
+			if len(tt.args) > 4 {
+				wantStack = tt.args[4:]
+			}
+			if !slices.Equal(stack, wantStack) {
+				t.Errorf("stack arguments = %X, want %X", stack, wantStack)
+			}
+		})
+	}
+}
+
+// Windows x64 functions written into the child.
+var (
+	// mov rax,rcx; add rax,rdx; add rax,r8; add rax,r9;
+	// add rax,[rsp+28]; add rax,[rsp+30]; ret
+	remoteSum = []byte{
+		0x48, 0x89, 0xC8, 0x48, 0x01, 0xD0, 0x4C, 0x01, 0xC0, 0x4C, 0x01, 0xC8,
+		0x48, 0x03, 0x44, 0x24, 0x28, 0x48, 0x03, 0x44, 0x24, 0x30, 0xC3,
+	}
+	// mov rax,rsp; and rax,F; ret
+	remoteStackAlignment = []byte{0x48, 0x89, 0xE0, 0x48, 0x83, 0xE0, 0x0F, 0xC3}
+	// xor eax,eax; mov rax,[rax]
+	remoteCrash = []byte{0x31, 0xC0, 0x48, 0x8B, 0x00}
+)
+
+// startRemoteCallChild starts a sleeping process and returns the address of
+// unused padding at the end of its code, where test functions are written.
+func startRemoteCallChild(t *testing.T) (*ProcessMemory, int64) {
+	t.Helper()
+
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start a child process: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	pm := NewProcessMemory(cmd.Process.Pid)
+
+	// Wait for the dynamic loader to map the executable.
+	var code MemoryRegion
+	for deadline := time.Now().Add(2 * time.Second); code.End == 0; {
+		regions, err := pm.ParseMemoryMaps()
+		if err != nil {
+			t.Fatalf("ParseMemoryMaps: %v", err)
+		}
+		for _, region := range regions {
+			if region.IsExecutable() && strings.HasSuffix(region.Path, "/sleep") {
+				code = region
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Skip("sleep has no executable mapping of its own")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return pm, code.End - 0x100
+}
+
+// writeRemoteFunctions writes functions 0x40 bytes apart from address and
+// returns where each starts.
+func writeRemoteFunctions(t *testing.T, pm *ProcessMemory, address int64, functions ...[]byte) []int64 {
+	t.Helper()
+
+	var addresses []int64
+	for _, function := range functions {
+		if err := pm.WriteMemory(address, function); err != nil {
+			t.Fatalf("WriteMemory(0x%X): %v", address, err)
+		}
+		addresses = append(addresses, address)
+		address += 0x40
+	}
+	return addresses
+}
+
+// skipUnlessTraceable skips the test if the first remote call failed because
+// ptrace is not permitted here.
+func skipUnlessTraceable(t *testing.T, err error) {
+	t.Helper()
+	if err != nil && strings.Contains(err.Error(), "ptrace seize") {
+		t.Skipf("ptrace is not permitted: %v", err)
+	}
+}
+
+// childSleeping reports whether the child is still asleep in its syscall,
+// which it only is if its registers were put back.
+func childSleeping(t *testing.T, pm *ProcessMemory) bool {
+	t.Helper()
+
+	time.Sleep(50 * time.Millisecond)
+	fields, err := statFields(pm.PID)
+	return err == nil && fields[0] == "S"
+}
+
+func TestCallRemote(t *testing.T) {
+	pm, padding := startRemoteCallChild(t)
+	functions := writeRemoteFunctions(t, pm, padding, remoteSum, remoteStackAlignment, remoteCrash)
+	sum, alignment, crash := functions[0], functions[1], functions[2]
+
+	result, err := pm.CallRemote(sum, 0x1, 0x10, 0x100, 0x1000, 0x10000, 0x100000)
+	skipUnlessTraceable(t, err)
+	if err != nil {
+		t.Fatalf("CallRemote(sum): %v", err)
+	}
+	if result != 0x111111 {
+		t.Errorf("sum = 0x%X, want 0x111111", result)
+	}
+	if !childSleeping(t, pm) {
+		t.Fatal("child is not asleep after the call")
+	}
+
+	if result, err := pm.CallRemote(alignment); err != nil || result != 8 {
+		t.Errorf("RSP mod 16 at entry = %d, %v, want 8", result, err)
+	}
+
+	if _, err := pm.CallRemote(crash); err == nil || !strings.Contains(err.Error(), "crashed") {
+		t.Errorf("CallRemote(crash) = %v, want a crash", err)
+	}
+	// The fault is not delivered, so the game survives a crashing call.
+	if !childSleeping(t, pm) {
+		t.Fatal("child did not survive a crashing call")
+	}
+	if result, err := pm.CallRemote(sum, 1, 1, 1, 1, 1, 1); err != nil || result != 6 {
+		t.Errorf("sum after a crash = %d, %v, want 6", result, err)
+	}
+}
+
+func TestCallRemoteGone(t *testing.T) {
+	pm, padding := startRemoteCallChild(t)
+	functions := writeRemoteFunctions(t, pm, padding, remoteSum)
+	pm.MarkGone()
+
+	if _, err := pm.CallRemote(functions[0]); !errors.Is(err, ErrProcessGone) {
+		t.Errorf("CallRemote = %v, want ErrProcessGone", err)
+	}
+}
+
+// TestEventRingHookRuns runs hook shellcode in the child, the way a hooked
+// game function would, and drains what it recorded.
+func TestEventRingHookRuns(t *testing.T) {
+	pm, padding := startRemoteCallChild(t)
+
+	ring, err := NewEventRing(pm, padding, 4)
+	if err != nil {
+		t.Skipf("no cave memory in the child: %v", err)
+	}
+	hook, err := ring.Hook(3, asm.RCX, asm.RDX, asm.R8, asm.R9)
+	if err != nil {
+		t.Fatalf("Hook: %v", err)
+	}
+	// mov rax,rcx; <hook>; ret. The hook must leave RAX alone.
+	code, err := hook.Assemble(padding + 3)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	code = append(append([]byte{0x48, 0x89, 0xC8}, code...), 0xC3)
+	function := writeRemoteFunctions(t, pm, padding, code)[0]
+
+	for i := range uint64(6) {
+		result, err := pm.CallRemote(function, 0x10+i, 0x20, 0x30, 0x40)
+		skipUnlessTraceable(t, err)
+		if err != nil || result != 0x10+i {
+			t.Fatalf("CallRemote(hook) = 0x%X, %v, want RCX back", result, err)
+		}
+	}
+
+	events, err := ring.Drain()
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	// The ring holds four, so the first two calls were overwritten.
+	if len(events) != 4 || ring.Dropped() != 2 {
+		t.Fatalf("drained %d events, dropped %d, want 4 and 2", len(events), ring.Dropped())
+	}
+	for i, event := range events {
+		want := [EventPayloadSlots]uint64{0x12 + uint64(i), 0x20, 0x30, 0x40}
+		if event.Seq != uint64(i)+3 || event.Type != 3 || event.Payload != want || event.TSC == 0 {
+			t.Errorf("event %d = %+v, want seq %d with payload %X", i, event, i+3, want)
+		}
+	}
+	if i := len(events) - 1; events[i].TSC < events[0].TSC {
+		t.Errorf("TSC went from %d to %d", events[0].TSC, events[i].TSC)
+	}
+}
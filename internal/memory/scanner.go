@@ -7,11 +7,11 @@ import (
 )
 
 type PatternScanner struct {
-	memory     *ProcessMemory
+	memory     Reader
 	moduleName string
 }
 
-func NewPatternScanner(memory *ProcessMemory, moduleName string) *PatternScanner {
+func NewPatternScanner(memory Reader, moduleName string) *PatternScanner {
 	return &PatternScanner{
 		memory:     memory,
 		moduleName: moduleName,
@@ -19,46 +19,72 @@ func NewPatternScanner(memory *ProcessMemory, moduleName string) *PatternScanner
 }
 
 func (ps *PatternScanner) FindPattern(pattern string) (int64, error) {
-	baseAddress, err := ps.memory.GetModuleBaseAddress(ps.moduleName)
+	matches, err := ps.FindPatternMatches(pattern, 1)
 	if err != nil {
 		return -1, err
 	}
+	return matches[0], nil
+}
 
-	moduleSize, err := ps.memory.GetModuleSize(ps.moduleName)
+// FindPatternMatches returns up to limit addresses in the module where the
+// pattern matches, or every match if limit is 0.
+func (ps *PatternScanner) FindPatternMatches(pattern string, limit int) ([]int64, error) {
+	baseAddress, err := FindModuleBaseAddress(ps.memory, ps.moduleName)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
-	moduleData, err := ps.memory.ReadMemory(baseAddress, moduleSize)
+	moduleSize, err := FindModuleSize(ps.memory, ps.moduleName)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
-	return ps.findPatternInData(pattern, moduleData, baseAddress)
+	return ps.FindPatternMatchesInRegion(pattern, baseAddress, moduleSize, limit)
 }
 
 func (ps *PatternScanner) FindPatternInRegion(pattern string, address int64, size int) (int64, error) {
-	data, err := ps.memory.ReadMemory(address, size)
+	matches, err := ps.FindPatternMatchesInRegion(pattern, address, size, 1)
 	if err != nil {
 		return -1, err
 	}
+	return matches[0], nil
+}
 
-	return ps.findPatternInData(pattern, data, address)
+func (ps *PatternScanner) FindPatternMatchesInRegion(pattern string, address int64, size int, limit int) ([]int64, error) {
+	data, err := ps.memory.ReadMemory(address, size)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := findPatternInData(pattern, data, address, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("pattern not found")
+	}
+	return matches, nil
 }
 
-func (ps *PatternScanner) findPatternInData(pattern string, data []byte, baseAddress int64) (int64, error) {
-	parts := strings.Split(pattern, " ")
+// ValidatePattern checks that a pattern is made of hex bytes and ?? wildcards
+// and has at least one fixed byte.
+func ValidatePattern(pattern string) error {
+	_, _, err := parsePattern(pattern)
+	return err
+}
+
+func parsePattern(pattern string) ([]byte, []bool, error) {
 	var bytes []byte
 	var mask []bool
 
-	for _, part := range parts {
+	for _, part := range strings.Fields(pattern) {
 		if part == "??" {
 			bytes = append(bytes, 0)
 			mask = append(mask, false)
 		} else {
 			b, err := hex.DecodeString(part)
-			if err != nil {
-				return -1, fmt.Errorf("invalid pattern: %v", err)
+			if err != nil || len(b) != 1 {
+				return nil, nil, fmt.Errorf("invalid pattern byte %q", part)
 			}
 			bytes = append(bytes, b[0])
 			mask = append(mask, true)
@@ -66,7 +92,21 @@ func (ps *PatternScanner) findPatternInData(pattern string, data []byte, baseAdd
 	}
 
 	if len(bytes) == 0 {
-		return -1, fmt.Errorf("empty pattern")
+		return nil, nil, fmt.Errorf("empty pattern")
+	}
+
+	for _, fixed := range mask {
+		if fixed {
+			return bytes, mask, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("pattern contains only wildcards")
+}
+
+func findPatternInData(pattern string, data []byte, baseAddress int64, limit int) ([]int64, error) {
+	bytes, mask, err := parsePattern(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
 	}
 
 	var matchIndices []int
@@ -79,14 +119,12 @@ func (ps *PatternScanner) findPatternInData(pattern string, data []byte, baseAdd
 		}
 	}
 
-	if len(matchBytes) == 0 {
-		return -1, fmt.Errorf("pattern contains only wildcards")
-	}
-
+	var matches []int64
 	dataLength := len(data) - len(bytes)
+	first := len(matchIndices) - 1
 
 	for offset := 0; offset < dataLength; offset++ {
-		if data[offset] != matchBytes[len(matchBytes)-1] {
+		if data[offset+matchIndices[first]] != matchBytes[first] {
 			continue
 		}
 
@@ -100,9 +138,12 @@ func (ps *PatternScanner) findPatternInData(pattern string, data []byte, baseAdd
 		}
 
 		if found {
-			return baseAddress + int64(offset), nil
+			matches = append(matches, baseAddress+int64(offset))
+			if limit > 0 && len(matches) >= limit {
+				break
+			}
 		}
 	}
 
-	return -1, fmt.Errorf("pattern not found")
+	return matches, nil
 }
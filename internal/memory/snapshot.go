@@ -0,0 +1,376 @@
+package memory
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/amadejkastelic/sekiro-tweaker/internal/logger"
+)
+
+// Snapshot files are zip archives with the following layout:
+//
+//	manifest.json         SnapshotManifest (module layout and region table)
+//	pe_header.bin         copy of the first PEHeaderSize bytes of the module
+//	regions/<start>.bin   raw contents of each captured region
+const (
+	SnapshotVersion       = 1
+	SnapshotMaxRegionSize = 256 << 20
+	PEHeaderSize          = 0x1000
+
+	snapshotManifestName = "manifest.json"
+	snapshotPEHeaderName = "pe_header.bin"
+	snapshotChunkSize    = 1 << 20
+)
+
+var ErrSnapshotReadOnly = errors.New("snapshot is read-only")
+
+type SnapshotRegion struct {
+	MemoryRegion
+	Entry string `json:"entry"`
+}
+
+type SnapshotManifest struct {
+	Version     int              `json:"version"`
+	CreatedAt   time.Time        `json:"created_at"`
+	PID         int              `json:"pid"`
+	ModuleName  string           `json:"module_name"`
+	ModuleBase  int64            `json:"module_base"`
+	ModuleSize  int              `json:"module_size"`
+	Regions     []SnapshotRegion `json:"regions"`
+	SkippedSize int64            `json:"skipped_size"`
+}
+
+type SnapshotOptions struct {
+	PID        int
+	ModuleName string
+	// Filter selects the regions to capture. DefaultSnapshotFilter is used when nil.
+	Filter func(region MemoryRegion) bool
+}
+
+// DefaultSnapshotFilter keeps readable anonymous memory and the game module
+// image, and drops shared libraries, kernel pages and oversized reservations.
+func DefaultSnapshotFilter(moduleName string) func(MemoryRegion) bool {
+	moduleName = strings.ToLower(moduleName)
+
+	return func(region MemoryRegion) bool {
+		if !region.IsReadable() || region.End-region.Start > SnapshotMaxRegionSize {
+			return false
+		}
+
+		switch {
+		case region.Path == "", region.Path == "[heap]", strings.HasPrefix(region.Path, "[stack"):
+			return true
+		case strings.HasPrefix(region.Path, "["):
+			return false
+		default:
+			return strings.Contains(strings.ToLower(region.Path), moduleName)
+		}
+	}
+}
+
+// WritableSnapshotFilter keeps only readable and writable anonymous memory,
+// which is where the game keeps its runtime state.
+func WritableSnapshotFilter(region MemoryRegion) bool {
+	return region.IsReadable() && region.IsWritable() &&
+		(region.Path == "" || region.Path == "[heap]") &&
+		region.End-region.Start <= SnapshotMaxRegionSize
+}
+
+// CaptureSnapshot dumps the selected regions of r, together with the module
+// layout and a copy of its PE header, into a snapshot archive written to w.
+func CaptureSnapshot(w io.Writer, r Reader, opts SnapshotOptions) error {
+	regions, err := r.ParseMemoryMaps()
+	if err != nil {
+		return fmt.Errorf("failed to parse maps: %v", err)
+	}
+
+	manifest := SnapshotManifest{
+		Version:    SnapshotVersion,
+		CreatedAt:  time.Now().UTC(),
+		PID:        opts.PID,
+		ModuleName: opts.ModuleName,
+	}
+
+	archive := zip.NewWriter(w)
+
+	if opts.ModuleName != "" {
+		if manifest.ModuleBase, err = FindModuleBaseAddress(r, opts.ModuleName); err != nil {
+			return err
+		}
+		if manifest.ModuleSize, err = FindModuleSize(r, opts.ModuleName); err != nil {
+			return err
+		}
+
+		header, err := r.ReadMemory(manifest.ModuleBase, PEHeaderSize)
+		if err != nil {
+			return fmt.Errorf("failed to read PE header: %v", err)
+		}
+		if err := writeSnapshotEntry(archive, snapshotPEHeaderName, header); err != nil {
+			return err
+		}
+	}
+
+	filter := opts.Filter
+	if filter == nil {
+		filter = DefaultSnapshotFilter(opts.ModuleName)
+	}
+
+	for _, region := range regions {
+		if !filter(region) {
+			continue
+		}
+
+		captured, err := captureRegion(archive, r, region)
+		if err != nil {
+			return err
+		}
+		if captured == nil {
+			manifest.SkippedSize += region.End - region.Start
+			continue
+		}
+
+		manifest.Regions = append(manifest.Regions, *captured)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeSnapshotEntry(archive, snapshotManifestName, data); err != nil {
+		return err
+	}
+
+	logger.Log.Info("Captured snapshot",
+		zap.Int("regions", len(manifest.Regions)),
+		zap.Int64("skipped_bytes", manifest.SkippedSize))
+
+	return archive.Close()
+}
+
+// captureRegion copies a region into the archive chunk by chunk. A region that
+// becomes unreadable part way through is truncated at the last good chunk, and
+// nil is returned if nothing could be read at all.
+func captureRegion(archive *zip.Writer, r Reader, region MemoryRegion) (*SnapshotRegion, error) {
+	var entry io.Writer
+	name := fmt.Sprintf("regions/%X.bin", region.Start)
+	end := region.Start
+
+	for end < region.End {
+		size := min(region.End-end, snapshotChunkSize)
+
+		chunk, err := r.ReadMemory(end, int(size))
+		if err != nil {
+			logger.Log.Debug("Stopping region capture at unreadable chunk",
+				zap.String("address", fmt.Sprintf("0x%X", end)),
+				zap.Error(err))
+			break
+		}
+
+		if entry == nil {
+			if entry, err = archive.Create(name); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := entry.Write(chunk); err != nil {
+			return nil, err
+		}
+
+		end += size
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	captured := &SnapshotRegion{MemoryRegion: region, Entry: name}
+	captured.End = end
+	return captured, nil
+}
+
+func writeSnapshotEntry(archive *zip.Writer, name string, data []byte) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = entry.Write(data)
+	return err
+}
+
+// WriteSnapshotFile captures a snapshot into a new file at path.
+func WriteSnapshotFile(path string, r Reader, opts SnapshotOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := CaptureSnapshot(f, r, opts); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
+
+// Snapshot replays a captured address space. It implements ReadWriter so the
+// scanner, PE parser and patcher can run against it; writes are rejected.
+type Snapshot struct {
+	Manifest SnapshotManifest
+
+	archive *zip.ReadCloser
+	entries map[string]*zip.File
+
+	mu     sync.Mutex
+	loaded map[string][]byte
+}
+
+func OpenSnapshot(path string) (*Snapshot, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+
+	s := &Snapshot{
+		archive: archive,
+		entries: make(map[string]*zip.File, len(archive.File)),
+		loaded:  make(map[string][]byte),
+	}
+	for _, f := range archive.File {
+		s.entries[f.Name] = f
+	}
+
+	data, err := s.readEntry(snapshotManifestName)
+	if err != nil {
+		_ = archive.Close()
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.Manifest); err != nil {
+		_ = archive.Close()
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if s.Manifest.Version != SnapshotVersion {
+		_ = archive.Close()
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Manifest.Version)
+	}
+
+	sort.Slice(s.Manifest.Regions, func(i, j int) bool {
+		return s.Manifest.Regions[i].Start < s.Manifest.Regions[j].Start
+	})
+
+	return s, nil
+}
+
+func (s *Snapshot) Close() error {
+	return s.archive.Close()
+}
+
+// PEHeader returns the copy of the module's PE header stored in the snapshot.
+func (s *Snapshot) PEHeader() ([]byte, error) {
+	return s.readEntry(snapshotPEHeaderName)
+}
+
+// OpenRegion streams the contents of a captured region without loading it.
+func (s *Snapshot) OpenRegion(region SnapshotRegion) (io.ReadCloser, error) {
+	f, exists := s.entries[region.Entry]
+	if !exists {
+		return nil, fmt.Errorf("snapshot entry %s not found", region.Entry)
+	}
+	return f.Open()
+}
+
+func (s *Snapshot) ParseMemoryMaps() ([]MemoryRegion, error) {
+	regions := make([]MemoryRegion, len(s.Manifest.Regions))
+	for i, region := range s.Manifest.Regions {
+		regions[i] = region.MemoryRegion
+	}
+	return regions, nil
+}
+
+func (s *Snapshot) ReadMemory(address int64, size int) ([]byte, error) {
+	buf := make([]byte, 0, size)
+	end := address + int64(size)
+
+	for cursor := address; cursor < end; {
+		region := s.findRegion(cursor)
+		if region == nil {
+			return nil, fmt.Errorf("address 0x%X not captured in snapshot", cursor)
+		}
+
+		data, err := s.regionData(region)
+		if err != nil {
+			return nil, err
+		}
+
+		chunkEnd := min(end, region.End)
+		buf = append(buf, data[cursor-region.Start:chunkEnd-region.Start]...)
+		cursor = chunkEnd
+	}
+
+	return buf, nil
+}
+
+func (s *Snapshot) WriteMemory(address int64, data []byte) error {
+	return ErrSnapshotReadOnly
+}
+
+func (s *Snapshot) AllocateMemory(nearAddress int64, size int) (int64, error) {
+	return 0, ErrSnapshotReadOnly
+}
+
+func (s *Snapshot) FreeMemory(address int64, size int) error {
+	return ErrSnapshotReadOnly
+}
+
+func (s *Snapshot) findRegion(address int64) *SnapshotRegion {
+	regions := s.Manifest.Regions
+	i := sort.Search(len(regions), func(i int) bool { return regions[i].End > address })
+	if i < len(regions) && regions[i].Start <= address {
+		return &regions[i]
+	}
+	return nil
+}
+
+func (s *Snapshot) regionData(region *SnapshotRegion) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data, exists := s.loaded[region.Entry]; exists {
+		return data, nil
+	}
+
+	data, err := s.readEntry(region.Entry)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != region.End-region.Start {
+		return nil, fmt.Errorf("snapshot entry %s is %d bytes, expected %d", region.Entry, len(data), region.End-region.Start)
+	}
+
+	s.loaded[region.Entry] = data
+	return data, nil
+}
+
+func (s *Snapshot) readEntry(name string) ([]byte, error) {
+	f, exists := s.entries[name]
+	if !exists {
+		return nil, fmt.Errorf("snapshot entry %s not found", name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRegion is a mapping whose contents can only be read below readableEnd.
+type fakeRegion struct {
+	MemoryRegion
+	data        []byte
+	readableEnd int64
+}
+
+// fakeAddressSpace is a process with several mappings, some of which fail to
+// read the way guard pages and freed memory do.
+type fakeAddressSpace struct {
+	regions []*fakeRegion
+}
+
+func (m *fakeAddressSpace) add(start, size int64, permissions, path string, readable int64) *fakeRegion {
+	region := &fakeRegion{
+		MemoryRegion: MemoryRegion{Start: start, End: start + size, Permissions: permissions, Path: path},
+		data:         make([]byte, size),
+		readableEnd:  start + readable,
+	}
+	for i := range region.data {
+		region.data[i] = byte(start>>12) + byte(i)
+	}
+	m.regions = append(m.regions, region)
+	return region
+}
+
+func (m *fakeAddressSpace) ReadMemory(address int64, size int) ([]byte, error) {
+	for _, region := range m.regions {
+		if address >= region.Start && address+int64(size) <= region.End {
+			if address+int64(size) > region.readableEnd {
+				return nil, fmt.Errorf("0x%X: input/output error", address)
+			}
+			offset := address - region.Start
+			return append([]byte(nil), region.data[offset:offset+int64(size)]...), nil
+		}
+	}
+	return nil, fmt.Errorf("0x%X is not mapped", address)
+}
+
+func (m *fakeAddressSpace) ParseMemoryMaps() ([]MemoryRegion, error) {
+	var regions []MemoryRegion
+	for _, region := range m.regions {
+		regions = append(regions, region.MemoryRegion)
+	}
+	return regions, nil
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	const (
+		moduleBase = 0x140000000
+		heap       = 0x10000
+		arena      = 0x200000
+		guard      = 0x600000
+	)
+
+	mem := &fakeAddressSpace{}
+	header := mem.add(moduleBase, PEHeaderSize, "r--p", "/games/Sekiro/sekiro.exe", PEHeaderSize)
+	mem.add(moduleBase+PEHeaderSize, 0x2000, "r-xp", "/games/Sekiro/sekiro.exe", 0x2000)
+	first := mem.add(heap, 0x1000, "rw-p", "[heap]", 0x1000)
+	second := mem.add(heap+0x1000, 0x1000, "rw-p", "", 0x1000)
+	// The second chunk of the arena fails to read, so it is cut short.
+	truncated := mem.add(arena, 2*snapshotChunkSize, "rw-p", "", snapshotChunkSize)
+	mem.add(guard, 0x1000, "rw-p", "", 0)
+	mem.add(0x7F0000000000, 0x1000, "r-xp", "/usr/lib/libc.so.6", 0x1000)
+
+	path := filepath.Join(t.TempDir(), "game.snap")
+	if err := WriteSnapshotFile(path, mem, SnapshotOptions{PID: 1234, ModuleName: "sekiro"}); err != nil {
+		t.Fatalf("WriteSnapshotFile: %v", err)
+	}
+	snapshot, err := OpenSnapshot(path)
+	if err != nil {
+		t.Fatalf("OpenSnapshot: %v", err)
+	}
+	defer func() { _ = snapshot.Close() }()
+
+	manifest := snapshot.Manifest
+	if manifest.PID != 1234 || manifest.ModuleBase != moduleBase || manifest.ModuleSize != 0x3000 {
+		t.Errorf("manifest pid, module = %d, 0x%X+0x%X", manifest.PID, manifest.ModuleBase, manifest.ModuleSize)
+	}
+	if manifest.SkippedSize != 0x1000 {
+		t.Errorf("skipped = 0x%X, want the unreadable guard region", manifest.SkippedSize)
+	}
+	regions, _ := snapshot.ParseMemoryMaps()
+	want := []MemoryRegion{
+		{Start: heap, End: heap + 0x1000, Permissions: "rw-p", Path: "[heap]"},
+		{Start: heap + 0x1000, End: heap + 0x2000, Permissions: "rw-p"},
+		{Start: arena, End: arena + snapshotChunkSize, Permissions: "rw-p"},
+		header.MemoryRegion,
+		{Start: moduleBase + PEHeaderSize, End: moduleBase + 0x3000, Permissions: "r-xp", Path: "/games/Sekiro/sekiro.exe"},
+	}
+	if len(regions) != len(want) {
+		t.Fatalf("regions = %+v, want %+v", regions, want)
+	}
+	for i := range want {
+		if regions[i] != want[i] {
+			t.Errorf("region %d = %+v, want %+v", i, regions[i], want[i])
+		}
+	}
+
+	if got, err := snapshot.PEHeader(); err != nil || !bytes.Equal(got, header.data) {
+		t.Errorf("PEHeader = %d bytes, %v, want the module's first page", len(got), err)
+	}
+	if base, err := FindModuleBaseAddress(snapshot, "sekiro"); err != nil || base != moduleBase {
+		t.Errorf("FindModuleBaseAddress = 0x%X, %v", base, err)
+	}
+
+	// A read across two adjacent regions is stitched together.
+	got, err := snapshot.ReadMemory(heap+0xFFC, 8)
+	if err != nil {
+		t.Fatalf("ReadMemory across regions: %v", err)
+	}
+	if want := append(append([]byte(nil), first.data[0xFFC:]...), second.data[:4]...); !bytes.Equal(got, want) {
+		t.Errorf("ReadMemory across regions = % X, want % X", got, want)
+	}
+
+	failing := []struct {
+		name    string
+		address int64
+		size    int
+	}{
+		{"past the truncated end", arena + snapshotChunkSize - 2, 4},
+		{"unreadable region", guard, 4},
+		{"filtered out library", 0x7F0000000000, 4},
+		{"past the last region", heap + 0x1FFE, 4},
+	}
+	for _, tt := range failing {
+		if _, err := snapshot.ReadMemory(tt.address, tt.size); err == nil {
+			t.Errorf("ReadMemory of %s succeeded", tt.name)
+		}
+	}
+	if got, err := snapshot.ReadMemory(arena+snapshotChunkSize-4, 4); err != nil || !bytes.Equal(got, truncated.data[snapshotChunkSize-4:snapshotChunkSize]) {
+		t.Errorf("ReadMemory at the truncated end = % X, %v", got, err)
+	}
+
+	if err := snapshot.WriteMemory(heap, []byte{1}); !errors.Is(err, ErrSnapshotReadOnly) {
+		t.Errorf("WriteMemory = %v, want ErrSnapshotReadOnly", err)
+	}
+	if _, err := snapshot.AllocateMemory(moduleBase, 0x1000); !errors.Is(err, ErrSnapshotReadOnly) {
+		t.Errorf("AllocateMemory = %v, want ErrSnapshotReadOnly", err)
+	}
+	if got, _ := snapshot.ReadMemory(heap, 4); !bytes.Equal(got, first.data[:4]) {
+		t.Errorf("memory after a rejected write = % X, want % X", got, first.data[:4])
+	}
+}
@@ -27,5 +27,8 @@ pkgs.buildGoModule {
 
   doCheck = false;
 
-  subPackages = [ "cmd/sekiro-tweaker" ];
+  subPackages = [
+    "cmd/sekiro-tweaker"
+    "cmd/sekiro-tweaker-cli"
+  ];
 }